@@ -0,0 +1,433 @@
+package lit
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BibEntry is an entry of a BibTeX file, e.g.
+//
+//	@book{halmos1960,
+//	  author = {Paul R. Halmos},
+//	  title = {Naive Set Theory},
+//	  publisher = {Van Nostrand},
+//	  year = 1960,
+//	}
+type BibEntry struct {
+	Type string // e.g., "book", in lower case
+	Key  string // e.g., "halmos1960"
+	// Fields are the fields, by name in lower case, e.g. "title", as
+	// written in TeX, less the braces or quotes around them, with the
+	// parts joined by # concatenated and the @string macros expanded.
+	Fields map[string]string
+	Span   Span
+}
+
+// Field returns the field name of e as text, rather than TeX: with
+// the braces dropped, and accents, dashes and the like as the runes
+// they are, e.g. "Gödel" for {G\"odel}.
+func (e *BibEntry) Field(name string) string {
+	return bibText(e.Fields[name])
+}
+
+// Bibliography are the entries of BibTeX files, by key.
+type Bibliography map[string]*BibEntry
+
+// bibMonths are the macros BibTeX defines for the months.
+var bibMonths = map[string]string{
+	"jan": "January", "feb": "February", "mar": "March", "apr": "April",
+	"may": "May", "jun": "June", "jul": "July", "aug": "August",
+	"sep": "September", "oct": "October", "nov": "November", "dec": "December",
+}
+
+// ParseBib parses BibTeX. It recovers from errors, as ParseLit does,
+// skipping to the next entry.
+func ParseBib(s string) (Bibliography, Diagnostics) {
+	p := &bibParser{src: newSource(s), s: s, macros: make(map[string]string)}
+	for k, v := range bibMonths {
+		p.macros[k] = v
+	}
+	b := make(Bibliography)
+	for {
+		// anything between entries is a comment
+		j := strings.IndexByte(p.s[p.i:], '@')
+		if j < 0 {
+			break
+		}
+		p.i += j
+		e, ok := p.entry()
+		if !ok {
+			continue
+		}
+		if o, dup := b[e.Key]; dup {
+			p.ds.add(SeverityWarning, e.Span, CodeBadBib, "the key %q is that of the entry at %s too", e.Key, o.Span.Start)
+			continue
+		}
+		b[e.Key] = e
+	}
+	return b, p.ds
+}
+
+// bibParser parses BibTeX.
+type bibParser struct {
+	src    *source
+	s      string
+	i      int
+	macros map[string]string // of @string, by name in lower case
+	ds     Diagnostics
+}
+
+func (p *bibParser) errorf(start int, format string, args ...interface{}) {
+	p.ds.add(SeverityError, p.src.span(start, p.i), CodeBadBib, format, args...)
+}
+
+func (p *bibParser) space() {
+	for p.i < len(p.s) && isSpaceByte(p.s[p.i]) {
+		p.i++
+	}
+}
+
+// name parses a type, key, field or macro name.
+func (p *bibParser) name() string {
+	start := p.i
+	for p.i < len(p.s) && !isSpaceByte(p.s[p.i]) && !strings.ContainsRune("{}(),=#@\"%", rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// entry parses the entry at p.i, which is an @. It reports whether
+// it is an entry, rather than a @string, @comment or @preamble, or
+// one that doesn't parse.
+func (p *bibParser) entry() (e *BibEntry, ok bool) {
+	start := p.i
+	p.i++ // @
+	typ := strings.ToLower(p.name())
+	p.space()
+	if typ == "" || p.i == len(p.s) || p.s[p.i] != '{' && p.s[p.i] != '(' {
+		p.errorf(start, "@%s has no { or (", typ)
+		return nil, false
+	}
+	close := byte('}')
+	if p.s[p.i] == '(' {
+		close = ')'
+	}
+	p.i++
+
+	switch typ {
+	case "comment", "preamble":
+		// skip to the matching close
+		p.i--
+		if typ == "comment" || close == '}' {
+			p.braced()
+		} else {
+			p.skipTo(close)
+		}
+		return nil, false
+	case "string":
+		p.space()
+		name := strings.ToLower(p.name())
+		p.space()
+		if name == "" || !p.consume('=') {
+			p.errorf(start, "@string has no name = value")
+			p.skipTo(close)
+			return nil, false
+		}
+		v, err := p.value()
+		if err != nil {
+			p.errorf(start, "@string{%s}: %v", name, err)
+			p.skipTo(close)
+			return nil, false
+		}
+		p.macros[name] = v
+		p.space()
+		if !p.consume(close) {
+			p.errorf(start, "@string{%s} is not closed", name)
+		}
+		return nil, false
+	}
+
+	p.space()
+	e = &BibEntry{Type: typ, Key: p.name(), Fields: make(map[string]string)}
+	p.space()
+	if e.Key == "" {
+		p.errorf(start, "@%s has no key", typ)
+		p.skipTo(close)
+		return nil, false
+	}
+	for {
+		p.space()
+		if p.consume(close) {
+			break
+		}
+		if !p.consume(',') && len(e.Fields) > 0 || p.i == len(p.s) {
+			p.errorf(start, "@%s{%s} is not closed", typ, e.Key)
+			return nil, false
+		}
+		p.space()
+		if p.consume(close) {
+			break // a trailing comma
+		}
+		fstart := p.i
+		name := strings.ToLower(p.name())
+		p.space()
+		if name == "" || !p.consume('=') {
+			p.errorf(fstart, "@%s{%s}: want a field, name = value", typ, e.Key)
+			p.skipTo(close)
+			return nil, false
+		}
+		v, err := p.value()
+		if err != nil {
+			p.errorf(fstart, "@%s{%s}: field %s: %v", typ, e.Key, name, err)
+			p.skipTo(close)
+			return nil, false
+		}
+		e.Fields[name] = v
+	}
+	e.Span = p.src.span(start, p.i)
+	return e, true
+}
+
+func (p *bibParser) consume(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// skipTo skips past the next c, or to the end.
+func (p *bibParser) skipTo(c byte) {
+	if j := strings.IndexByte(p.s[p.i:], c); j >= 0 {
+		p.i += j + 1
+	} else {
+		p.i = len(p.s)
+	}
+}
+
+// value parses a field's value: its parts, joined by #.
+func (p *bibParser) value() (string, error) {
+	var b strings.Builder
+	for {
+		p.space()
+		if p.i == len(p.s) {
+			return "", fmt.Errorf("no value")
+		}
+		switch c := p.s[p.i]; {
+		case c == '{':
+			v, ok := p.braced()
+			if !ok {
+				return "", fmt.Errorf("{ is not closed")
+			}
+			b.WriteString(v)
+		case c == '"':
+			start := p.i
+			p.i++
+			depth := 0
+			for ; p.i < len(p.s) && (p.s[p.i] != '"' || depth > 0); p.i++ {
+				switch p.s[p.i] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if p.i == len(p.s) {
+				return "", fmt.Errorf("\" is not closed")
+			}
+			b.WriteString(p.s[start+1 : p.i])
+			p.i++
+		case '0' <= c && c <= '9':
+			start := p.i
+			for p.i < len(p.s) && '0' <= p.s[p.i] && p.s[p.i] <= '9' {
+				p.i++
+			}
+			b.WriteString(p.s[start:p.i])
+		default:
+			name := p.name()
+			v, ok := p.macros[strings.ToLower(name)]
+			if !ok {
+				if name == "" {
+					return "", fmt.Errorf("unexpected %q", c)
+				}
+				return "", fmt.Errorf("undefined @string %s", name)
+			}
+			b.WriteString(v)
+		}
+		p.space()
+		if !p.consume('#') {
+			return b.String(), nil
+		}
+	}
+}
+
+// braced parses the braced text at p.i, returning it less the outer
+// braces.
+func (p *bibParser) braced() (string, bool) {
+	start := p.i
+	depth := 0
+	for ; p.i < len(p.s); p.i++ {
+		switch p.s[p.i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.i++
+				return p.s[start+1 : p.i-1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// bibAccents are the letters the TeX accents make, by accent, as pairs
+// of the letter and the accented one.
+var bibAccents = map[byte]string{
+	'"':  "aäeëiïoöuüyÿAÄEËIÏOÖUÜ",
+	'\'': "aáeéiíoóuúyýcćnńsśzźAÁEÉIÍOÓUÚCĆSŚZŹ",
+	'`':  "aàeèiìoòuùAÀEÈIÌOÒUÙ",
+	'^':  "aâeêiîoôuûAÂEÊIÎOÔUÛ",
+	'~':  "aãnñoõAÃNÑOÕ",
+	'c':  "cçCÇsşSŞ",
+	'v':  "cčsšzžrřCČSŠZŽRŘ",
+	'=':  "aāeēiīoōuūAĀEĒIĪOŌUŪ",
+}
+
+// bibSymbols are the runes of TeX commands without arguments.
+var bibSymbols = map[string]string{
+	"ss": "ß", "o": "ø", "O": "Ø", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"aa": "å", "AA": "Å", "l": "ł", "L": "Ł", "i": "ı", "S": "§", "P": "¶",
+}
+
+// bibText returns the TeX of a field as text.
+func bibText(s string) string {
+	s = strings.NewReplacer("---", "—", "--", "–", "``", "“", "''", "”").Replace(s)
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '{' || c == '}':
+		case c == '~':
+			b.WriteString("\u00a0")
+		case c == '\\' && i+1 < len(s):
+			i++
+			c = s[i]
+			_, isAccent := bibAccents[c]
+			if unicode.IsLetter(rune(c)) && (i+1 == len(s) || s[i+1] != '{' && s[i+1] != ' ') {
+				isAccent = false // e.g., \cite rather than \c{c}
+			}
+			if isAccent {
+				// e.g., \"o, \"{o}, {\"o} or \c c
+				j := i + 1
+				for j < len(s) && (s[j] == '{' || s[j] == ' ') {
+					j++
+				}
+				letter, size := utf8.DecodeRuneInString(s[j:])
+				if r, ok := bibAccent(c, letter); ok {
+					b.WriteRune(r)
+					i = j + size - 1
+				}
+				continue
+			}
+			j := i
+			for j < len(s) && ('a' <= s[j] && s[j] <= 'z' || 'A' <= s[j] && s[j] <= 'Z') {
+				j++
+			}
+			if j == i {
+				b.WriteByte(c) // e.g., \& or \%
+				continue
+			}
+			// any other command, e.g. \emph, is dropped, but not
+			// its argument
+			b.WriteString(bibSymbols[s[i:j]])
+			i = j - 1
+			for i+1 < len(s) && s[i+1] == ' ' {
+				i++
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// bibAccent returns letter with the accent of the command \accent.
+func bibAccent(accent byte, letter rune) (rune, bool) {
+	pairs := []rune(bibAccents[accent])
+	for k := 0; k+1 < len(pairs); k += 2 {
+		if pairs[k] == letter {
+			return pairs[k+1], true
+		}
+	}
+	return 0, false
+}
+
+// ReadBibliography reads the BibTeX files that the metadata of the tree
+// rooted at n names, e.g.
+//
+//	<yaml>
+//	bibliography: refs.bib
+//	citation-style: numeric
+//	</yaml>
+//
+// from fsys, returning their entries and the names of the files it read.
+// It reports the files that can't be read or don't parse, as errors, and
+// the keys of the citations that no entry has, as warnings.
+func ReadBibliography(fsys fs.FS, n *Node) (b Bibliography, files []string, ds Diagnostics) {
+	b = make(Bibliography)
+	m := readMetadata(n)
+	switch m.CitationStyle {
+	case "", citeAuthorYear, citeNumeric:
+	default:
+		ds.add(SeverityWarning, n.Span, CodeBadBib, "unknown citation-style %q; want %q or %q", m.CitationStyle, citeAuthorYear, citeNumeric)
+	}
+	for _, name := range m.Bibliography {
+		if path.Ext(name) == "" {
+			name += ".bib" // as \bibliography names it
+		}
+		name = path.Clean(name)
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			ds.add(SeverityError, n.Span, CodeBadBib, "reading bibliography: %v", err)
+			continue
+		}
+		files = append(files, name)
+		fb, fds := ParseBib(string(data))
+		for i := range fds {
+			fds[i].Span.File = name
+		}
+		ds = append(ds, fds...)
+		keys := make([]string, 0, len(fb))
+		for k := range fb {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e := fb[k]
+			e.Span.File = name
+			if o, dup := b[k]; dup {
+				ds.add(SeverityWarning, e.Span, CodeBadBib, "the key %q is that of the entry at %s:%s too", k, o.Span.File, o.Span.Start)
+				continue
+			}
+			b[k] = e
+		}
+	}
+
+	Walk(n, func(c *Node) WalkAction {
+		if c.Type != CiteNode {
+			return WalkContinue
+		}
+		for _, k := range citeKeys(c) {
+			if b[k] == nil {
+				ds.add(SeverityWarning, c.Span, CodeUnknownCite, "no bibliography entry has the key %q", k)
+			}
+		}
+		return WalkSkip
+	})
+	return b, files, ds
+}
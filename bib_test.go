@@ -0,0 +1,181 @@
+package lit_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nlandolfi/lit"
+)
+
+const bibRefs = `% the entries cited
+@string{vn = "Van Nostrand"}
+
+@book{halmos1960,
+  author = {Paul R. Halmos},
+  title = {Naive {Set} Theory},
+  publisher = vn # { Reinhold},
+  year = 1960,
+}
+
+@article(godel1931,
+  author = "Kurt G{\"o}del",
+  title = {{\"U}ber formal unentscheidbare S\"atze},
+  journal = {Monatshefte f{\"u}r Mathematik und Physik},
+  volume = 38,
+  pages = {173--198},
+  year = 1931
+)
+
+@comment{@book{ignored, title = {Ignored}}}
+`
+
+func TestParseBib(t *testing.T) {
+	b, ds := lit.ParseBib(bibRefs)
+	if len(ds) > 0 {
+		t.Fatalf("diagnostics: %v", ds)
+	}
+	if len(b) != 2 {
+		t.Errorf("entries = %d, want 2", len(b))
+	}
+	for _, c := range []struct{ key, field, want string }{
+		{"halmos1960", "title", "Naive Set Theory"},
+		{"halmos1960", "publisher", "Van Nostrand Reinhold"},
+		{"halmos1960", "year", "1960"},
+		{"godel1931", "author", "Kurt Gödel"},
+		{"godel1931", "title", "Über formal unentscheidbare Sätze"},
+		{"godel1931", "pages", "173–198"},
+	} {
+		e := b[c.key]
+		if e == nil {
+			t.Errorf("no entry %s", c.key)
+			continue
+		}
+		if got := e.Field(c.field); got != c.want {
+			t.Errorf("%s.Field(%q) = %q, want %q", c.key, c.field, got, c.want)
+		}
+	}
+	if e := b["godel1931"]; e != nil && (e.Type != "article" || e.Span.Start.Line != 11) {
+		t.Errorf("godel1931 is a %s at %s, want an article at line 11", e.Type, e.Span.Start)
+	}
+
+	for _, bad := range []string{
+		"@book{a, title = {Open",
+		"@book{a, title = nomacro}",
+		"@book{, title = {No key}}",
+		"@book{a, title = {A}}\n@book{a, title = {B}}",
+	} {
+		if _, ds := lit.ParseBib(bad); len(ds) != 1 || ds[0].Code != lit.CodeBadBib {
+			t.Errorf("ParseBib(%q) diagnostics = %v, want one bad-bib", bad, ds)
+		}
+	}
+}
+
+const citeDoc = `<yaml>
+bibliography: refs.bib
+citation-style: %s
+</yaml>
+
+¶ ⦊
+  ‖ See <cite key='halmos1960' loc='§3'/>, or <cite key='godel1931, nobody'/>. ⦉
+⦉
+`
+
+func TestReadBibliography(t *testing.T) {
+	fsys := fstest.MapFS{"refs.bib": {Data: []byte(bibRefs)}}
+	n, ds := lit.ParseLit(strings.Replace(citeDoc, "%s", "author-year", 1))
+	if len(ds) > 0 {
+		t.Fatalf("diagnostics: %v", ds)
+	}
+	b, files, ds := lit.ReadBibliography(fsys, n)
+	if len(b) != 2 || len(files) != 1 || files[0] != "refs.bib" {
+		t.Errorf("ReadBibliography = %d entries of %v, want 2 of refs.bib", len(b), files)
+	}
+	if len(ds) != 1 || ds[0].Code != lit.CodeUnknownCite || ds[0].Span.Start.Line != 7 {
+		t.Errorf("diagnostics = %v, want an unknown cite at line 7", ds)
+	}
+
+	var out strings.Builder
+	if err := lit.WriteTex(&out, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`See~\citep[§3]{halmos1960}, or~\citep{godel1931,nobody}.`,
+		"\\bibliographystyle{plainnat}\n\\bibliography{refs}\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteTex does not have %q:\n%s", want, out.String())
+		}
+	}
+
+	// which needs natbib, as WriteTexDocument loads it
+	out.Reset()
+	if err := lit.WriteTexDocument(&out, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\\usepackage[round]{natbib}\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("WriteTexDocument does not have %q:\n%s", want, out.String())
+	}
+	// without a bibliography too
+	out.Reset()
+	if err := lit.WriteTexDocument(&out, lit.Must(lit.ParseLit("‖ See <cite key='x'/>. ⦉")), lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\\usepackage[round]{natbib}\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("WriteTexDocument of a cite does not have %q:\n%s", want, out.String())
+	}
+
+	opts := *lit.DefaultWriteOpts
+	opts.Bibliography = b
+	out.Reset()
+	if err := lit.WriteHTML(&out, n, &opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`See <span class='cite'>(<a href='#cite-halmos1960'>Halmos 1960</a>, §3)</span>, or <span class='cite'>(<a href='#cite-godel1931'>Gödel 1931</a>; nobody)</span>.`,
+		// by author
+		`<ul class='references'>
+    <li id='cite-godel1931'>Kurt Gödel. 1931. “Über formal unentscheidbare Sätze”. <em>Monatshefte für Mathematik und Physik</em> 38: 173–198.</li>
+    <li id='cite-halmos1960'>Paul R. Halmos. 1960. <em>Naive Set Theory</em>. Van Nostrand Reinhold.</li>
+  </ul>`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteHTML does not have %q:\n%s", want, out.String())
+		}
+	}
+
+	n, _ = lit.ParseLit(strings.Replace(citeDoc, "%s", "numeric", 1))
+	out.Reset()
+	if err := lit.WriteHTML(&out, n, &opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`See <span class='cite'>[<a href='#cite-halmos1960'>1</a>, §3]</span>, or <span class='cite'>[<a href='#cite-godel1931'>2</a>, nobody]</span>.`,
+		// in the order cited
+		`<ol class='references'>
+    <li id='cite-halmos1960'>Paul R. Halmos. <em>Naive Set Theory</em>. Van Nostrand Reinhold. 1960.</li>
+    <li id='cite-godel1931'>`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteHTML does not have %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteHTMLReferenceEscapes(t *testing.T) {
+	b, ds := lit.ParseBib(`@book{att, author = {Barnes \& <Noble>}, title = {A & B}, year = {<1999>}}`)
+	if len(ds) > 0 {
+		t.Fatal(ds)
+	}
+	n := lit.Must(lit.ParseLit("<yaml>\nbibliography: refs.bib\n</yaml>\n¶ ⦊\n  ‖ See <cite key='att'/>. ⦉\n⦉"))
+	opts := *lit.DefaultWriteOpts
+	opts.Bibliography = b
+	var out strings.Builder
+	if err := lit.WriteHTML(&out, n, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := "<li id='cite-att'>Barnes &amp; &lt;Noble&gt;. &lt;1999&gt;. <em>A &amp; B</em>.</li>"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("WriteHTML does not have %q:\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,282 @@
+package lit
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The citation styles, as the citation-style of the metadata names them.
+const (
+	citeAuthorYear = "author-year" // e.g., (Halmos 1960, §3)
+	citeNumeric    = "numeric"     // e.g., [1, §3]
+)
+
+// citeKeys returns the keys of the citation n, which may cite more than
+// one entry, e.g. <cite key='halmos1960, kunen1980'/>.
+func citeKeys(n *Node) []string {
+	var keys []string
+	for _, k := range strings.Split(getAttr(n.Attr, "key"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// citation is the text of a citation, as the HTML writers write it.
+type citation struct {
+	open, close string
+	keys        []string
+	texts       []string // of each key
+	loc         string
+}
+
+// newCitation returns the citation n, in the style of ls, of the
+// entries of b; it writes the key of an entry b doesn't have.
+func newCitation(n *Node, ls *labels, b Bibliography) citation {
+	c := citation{open: "(", close: ")", keys: citeKeys(n), loc: getAttr(n.Attr, "loc")}
+	var nums map[string]int
+	if ls.citeStyle == citeNumeric {
+		c.open, c.close = "[", "]"
+		nums = citeNumbers(ls, b)
+	}
+	for _, k := range c.keys {
+		e := b[k]
+		switch {
+		case e == nil:
+			c.texts = append(c.texts, k)
+		case nums != nil:
+			c.texts = append(c.texts, fmt.Sprint(nums[k]))
+		default:
+			c.texts = append(c.texts, bibShortAuthors(e)+" "+bibYear(e))
+		}
+	}
+	return c
+}
+
+// String returns the citation as text.
+func (c citation) String() string {
+	sep := "; "
+	if c.open == "[" {
+		sep = ", "
+	}
+	s := c.open + strings.Join(c.texts, sep)
+	if c.loc != "" {
+		s += ", " + c.loc
+	}
+	return s + c.close
+}
+
+// citeNumbers numbers the entries of b that the tree of ls cites, from
+// 1, in the order it first cites them.
+func citeNumbers(ls *labels, b Bibliography) map[string]int {
+	nums := make(map[string]int)
+	for _, k := range ls.cites {
+		if b[k] != nil {
+			nums[k] = len(nums) + 1
+		}
+	}
+	return nums
+}
+
+// citedEntries returns the entries of b that the tree of ls cites, in
+// the order of its reference list: that of the numbers, if the style is
+// numeric, or by author and year.
+func citedEntries(ls *labels, b Bibliography) []*BibEntry {
+	var es []*BibEntry
+	for _, k := range ls.cites {
+		if e := b[k]; e != nil {
+			es = append(es, e)
+		}
+	}
+	if ls.citeStyle != citeNumeric {
+		sort.SliceStable(es, func(i, j int) bool {
+			ai, aj := bibSortKey(es[i]), bibSortKey(es[j])
+			if ai != aj {
+				return ai < aj
+			}
+			return bibYear(es[i]) < bibYear(es[j])
+		})
+	}
+	return es
+}
+
+// bibNames returns the names of a field of names, as BibTeX writes them:
+// separated by "and", each "First Last" or "Last, First".
+func bibNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, " and ") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// bibLastName returns the last name of a name, as bibNames returns it.
+func bibLastName(name string) string {
+	if i := strings.IndexByte(name, ','); i >= 0 {
+		return strings.TrimSpace(name[:i])
+	}
+	words := strings.Fields(name)
+	return words[len(words)-1]
+}
+
+// bibAuthors returns the authors of e, or, if it has none, its editors.
+func bibAuthors(e *BibEntry) []string {
+	if names := bibNames(e.Field("author")); len(names) > 0 {
+		return names
+	}
+	return bibNames(e.Field("editor"))
+}
+
+// bibShortAuthors returns the authors of e as a citation names them,
+// e.g. "Halmos", "Halmos and Givant", or "Halmos et al.", or its title
+// if it has no authors.
+func bibShortAuthors(e *BibEntry) string {
+	names := bibAuthors(e)
+	switch {
+	case len(names) == 0:
+		return e.Field("title")
+	case len(names) == 1:
+		return bibLastName(names[0])
+	case len(names) == 2 && names[1] != "others":
+		return bibLastName(names[0]) + " and " + bibLastName(names[1])
+	}
+	return bibLastName(names[0]) + " et al."
+}
+
+// bibSortKey returns the key of e to sort the reference list by.
+func bibSortKey(e *BibEntry) string {
+	names := bibAuthors(e)
+	if len(names) == 0 {
+		return strings.ToLower(e.Field("title"))
+	}
+	return strings.ToLower(bibLastName(names[0]) + " " + names[0])
+}
+
+// bibYear returns the year of e, or "n.d." if it has none.
+func bibYear(e *BibEntry) string {
+	if y := e.Field("year"); y != "" {
+		return y
+	}
+	return "n.d."
+}
+
+// writeHTMLReference writes the entry e of a reference list, e.g.
+//
+//	Paul R. Halmos. 1960. <em>Naive Set Theory</em>. Van Nostrand.
+//
+// putting the year after the authors if the style is author-year, or
+// last if it is numeric.
+func writeHTMLReference(w io.Writer, e *BibEntry, style string) {
+	var parts []string
+	add := func(s string) {
+		if s != "" {
+			parts = append(parts, strings.TrimRight(s, "."))
+		}
+	}
+	names := bibAuthors(e)
+	for i, name := range names {
+		names[i] = html.EscapeString(name)
+	}
+	switch {
+	case len(names) > 2:
+		add(strings.Join(names[:len(names)-1], ", ") + ", and " + names[len(names)-1])
+	default:
+		add(strings.Join(names, " and "))
+	}
+	if len(names) > 0 && e.Field("author") == "" {
+		parts[len(parts)-1] += ", ed."
+	}
+	if style != citeNumeric {
+		add(html.EscapeString(bibYear(e)))
+	}
+
+	title := html.EscapeString(e.Field("title"))
+	switch e.Type {
+	case "article", "inproceedings", "incollection", "inbook", "conference":
+		if title != "" {
+			parts = append(parts, "“"+title+"”")
+		}
+		in := html.EscapeString(e.Field("journal"))
+		if in == "" {
+			in = html.EscapeString(e.Field("booktitle"))
+		}
+		if in != "" {
+			in = "<em>" + in + "</em>"
+		}
+		if v := e.Field("volume"); v != "" {
+			in += " " + html.EscapeString(v)
+			if num := e.Field("number"); num != "" {
+				in += " (" + html.EscapeString(num) + ")"
+			}
+		}
+		if p := e.Field("pages"); p != "" {
+			in += ": " + html.EscapeString(p)
+		}
+		add(strings.TrimPrefix(in, " "))
+	default:
+		if title != "" {
+			add("<em>" + title + "</em>")
+		}
+	}
+	for _, f := range []string{"publisher", "school", "institution", "howpublished"} {
+		add(html.EscapeString(e.Field(f)))
+	}
+	if style == citeNumeric {
+		add(html.EscapeString(e.Field("year")))
+	}
+	s := strings.Join(parts, ". ") + "."
+	if doi := e.Field("doi"); doi != "" {
+		s += fmt.Sprintf(" <a href='https://doi.org/%s'>doi:%s</a>", html.EscapeString(doi), html.EscapeString(doi))
+	} else if url := e.Field("url"); url != "" {
+		s += fmt.Sprintf(" <a href='%s'>%s</a>", html.EscapeString(url), html.EscapeString(url))
+	}
+	io.WriteString(w, s)
+}
+
+// writeHTMLReferences writes the reference list of the entries of
+// opts.Bibliography that the tree of n cites, if it cites any.
+func writeHTMLReferences(w io.Writer, s *htmlWriteState, n *Node, opts *WriteOpts) {
+	if opts.Bibliography == nil {
+		return
+	}
+	ls := s.labelsOf(n)
+	es := citedEntries(ls, opts.Bibliography)
+	if len(es) == 0 {
+		return
+	}
+	list := "ul"
+	if ls.citeStyle == citeNumeric {
+		list = "ol"
+	}
+	fmt.Fprintf(w, "\n%s<section class='references'>", opts.Prefix)
+	fmt.Fprintf(w, "\n%s%s<h2>References</h2>", opts.Prefix, opts.Indent)
+	fmt.Fprintf(w, "\n%s%s<%s class='references'>", opts.Prefix, opts.Indent, list)
+	for _, e := range es {
+		fmt.Fprintf(w, "\n%s%s%s<li id='cite-%s'>", opts.Prefix, opts.Indent, opts.Indent, html.EscapeString(e.Key))
+		writeHTMLReference(w, e, ls.citeStyle)
+		fmt.Fprintf(w, "</li>")
+	}
+	fmt.Fprintf(w, "\n%s%s</%s>", opts.Prefix, opts.Indent, list)
+	fmt.Fprintf(w, "\n%s</section>", opts.Prefix)
+}
+
+// texBibliography returns the \bibliography of the tree rooted at n,
+// whose labels are ls, if it cites entries and its metadata names the
+// BibTeX files, for natbib; see WriteTexDocument.
+func texBibliography(n *Node, ls *labels) string {
+	m := readMetadata(n)
+	if len(m.Bibliography) == 0 || len(ls.cites) == 0 {
+		return ""
+	}
+	var names []string
+	for _, b := range m.Bibliography {
+		names = append(names, strings.TrimSuffix(b, ".bib"))
+	}
+	return "\n\\bibliographystyle{plainnat}\n\\bibliography{" + strings.Join(names, ",") + "}\n"
+}
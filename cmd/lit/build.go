@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nlandolfi/lit"
+)
+
+const buildUsage = `usage: lit build [flags] [dir]
+
+Build builds a book, as the manifest lit.yaml of dir, or of the nearest
+directory above it, says: it parses the chapters, in order, with the
+files they include, and writes the whole of them to each of the outputs,
+e.g., a TeX document, an HTML document and an EPUB.
+
+The manifest lists the chapters and the outputs, relative to its
+directory, along with the options to write with:
+
+	chapters:
+	  - ch01.lit
+	  - ch02.lit
+	outputs:
+	  - path: book.tex
+	    type: texdoc
+	  - path: book.html
+	    type: htmldoc
+	  - path: book.epub
+	format:
+	  width: 80
+	html:
+	  math: mathjax
+	  toc: true
+
+The type of an output, if unset, is by its extension, as for lit -out;
+html has the options of htmldoc, as the flags of lit name them. The
+flags override the manifest. If there are errors, it writes nothing.
+The BibTeX files the metadata of the chapters names, as its
+bibliography, are relative to the manifest's directory too.
+
+Flags:
+`
+
+// htmlFlags are the flags of lit that the html options of a manifest set.
+var htmlFlags = []string{"css", "math", "mathurl", "toc"}
+
+// buildMain runs lit build with args, returning the exit status.
+func buildMain(args []string) int {
+	flags := flag.NewFlagSet("lit build", flag.ExitOnError)
+	addLitFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), buildUsage)
+		flags.PrintDefaults()
+	}
+	parseLitFlags(flags, args)
+	dir := "."
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		dir = flags.Arg(0)
+	default:
+		flags.Usage()
+		return 2
+	}
+
+	c, root, err := findConfigDir(dir)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	if root == "" {
+		log.Printf("no %s in %s or above", configName, dir)
+		return 2
+	}
+	manifest := filepath.Join(root, configName)
+	if len(c.Chapters) == 0 || len(c.Outputs) == 0 {
+		log.Printf("%s: want chapters and outputs", manifest)
+		return 2
+	}
+	if err := setHTMLFlags(c); err != nil {
+		log.Printf("%s: %v", manifest, err)
+		return 2
+	}
+	opts, err := format.writeOpts(c)
+	if err != nil {
+		log.Printf("%s: %v", manifest, err)
+		return 2
+	}
+
+	book := &lit.Node{Type: lit.FragmentNode}
+	var errs bool
+	for _, ch := range c.Chapters {
+		n, files, ds := lit.ParseLitFile(os.DirFS(root), filepath.ToSlash(filepath.Clean(ch)))
+		printDiagnostics(os.Stderr, manifest, ds)
+		if len(files) == 0 || ds.HasErrors() {
+			errs = true
+			continue
+		}
+		for n.FirstChild != nil {
+			k := n.FirstChild
+			n.RemoveChild(k)
+			book.AppendChild(k)
+		}
+	}
+	if errs {
+		return 1
+	}
+	bib, _, ds := lit.ReadBibliography(os.DirFS(root), book)
+	printDiagnostics(os.Stderr, manifest, append(ds, checkRefs(book)...))
+	if ds.HasErrors() {
+		return 1
+	}
+	opts.Bibliography = bib
+
+	status := 0
+	for _, o := range c.Outputs {
+		mode := o.Type
+		if mode == "" {
+			mode = outputMode(o.Path)
+		}
+		out := filepath.Join(root, o.Path)
+		var b bytes.Buffer
+		// the input is the manifest, so as to find images in its directory
+		if err := write(&b, book, mode, manifest, opts); err != nil {
+			log.Printf("writing %s: %v", out, err)
+			status = 1
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		if err := writeFileAtomic(out, b.Bytes(), 0644); err != nil {
+			log.Printf("writing %s: %v", out, err)
+			status = 1
+			continue
+		}
+		log.Printf("wrote %s", out)
+	}
+	return status
+}
+
+// setHTMLFlags sets the htmlFlags to the html options of c, unless the
+// command line set them.
+func setHTMLFlags(c *config) error {
+	set := make(map[string]bool)
+	flag.CommandLine.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	known := make(map[string]bool)
+	for _, name := range htmlFlags {
+		known[name] = true
+	}
+	for name, val := range c.HTML {
+		if !known[name] {
+			return fmt.Errorf("unknown html option %q", name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.CommandLine.Set(name, val); err != nil {
+			return fmt.Errorf("html option %s: %v", name, err)
+		}
+	}
+	return nil
+}
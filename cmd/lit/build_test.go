@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMain(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// build builds the book of the files, returning the exit status and
+	// what it printed to stderr
+	build := func(files map[string]string) (dir string, status int, stderr string) {
+		t.Helper()
+		dir = t.TempDir()
+		for name, data := range files {
+			name = filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		errs, err := os.CreateTemp(t.TempDir(), "stderr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer errs.Close()
+		stdErr := os.Stderr
+		os.Stderr = errs
+		// from a subdirectory, to find the manifest above it
+		status = buildMain([]string{filepath.Join(dir, "chapters")})
+		os.Stderr = stdErr
+		bs, err := os.ReadFile(errs.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dir, status, string(bs)
+	}
+
+	manifest := "chapters:\n  - chapters/one.lit\n  - chapters/two.lit\noutputs:\n  - path: out/book.md\n  - path: book.txt\n    type: md\n"
+	dir, status, stderr := build(map[string]string{
+		"lit.yaml":             manifest,
+		"chapters/one.lit":     "¶ ⦊\n  ‖ One. ⦉\n⦉\n<include src='parts/a.lit'/>\n",
+		"chapters/parts/a.lit": "¶ ⦊\n  ‖ A. ⦉\n⦉\n",
+		"chapters/two.lit":     "¶ ⦊\n  ‖ Two. ⦉\n⦉\n",
+	})
+	if status != 0 {
+		t.Fatalf("status %d, stderr:\n%s", status, stderr)
+	}
+	for _, out := range []string{"out/book.md", "book.txt"} {
+		bs, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(out)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if want := "One.\n\nA.\n\nTwo.\n"; string(bs) != want {
+			t.Errorf("%s is %q, want %q", out, bs, want)
+		}
+	}
+
+	// a chapter with errors fails the build, which writes nothing
+	dir, status, stderr = build(map[string]string{
+		"lit.yaml":         manifest,
+		"chapters/one.lit": "<include src='missing.lit'/>\n",
+		"chapters/two.lit": "¶ ⦊\n  ‖ Two. ⦉\n⦉\n",
+	})
+	if status != 1 {
+		t.Errorf("with a missing include, status %d, want 1", status)
+	}
+	if want := filepath.Join("chapters", "one.lit") + ":1:1: error: open chapters/missing.lit"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr does not have %q:\n%s", want, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "book.txt")); !os.IsNotExist(err) {
+		t.Errorf("with errors, it wrote book.txt (%v)", err)
+	}
+}
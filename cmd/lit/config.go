@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nlandolfi/lit"
+	"gopkg.in/yaml.v3"
+)
+
+// configName is the name of a project's config file.
+const configName = "lit.yaml"
+
+// config is a project's config, e.g.
+//
+//	format:
+//	  width: 80
+//	  indent: tab
+//	  semantic: false
+//
+// It is also the manifest of a book, which lit build builds, e.g.
+//
+//	chapters:
+//	  - front.lit
+//	  - ch01.lit
+//	  - ch02.lit
+//	outputs:
+//	  - path: book.tex
+//	    type: texdoc
+//	  - path: book.html
+//	    type: htmldoc
+//	  - path: book.epub
+//	html:
+//	  math: mathjax
+//
+// The chapters and outputs are relative to the directory of the config;
+// the type of an output, if unset, is by its extension, as for lit -out.
+type config struct {
+	Format struct {
+		Width    int    `yaml:"width"`
+		Indent   string `yaml:"indent"`
+		Semantic bool   `yaml:"semantic"`
+	} `yaml:"format"`
+
+	Chapters []string `yaml:"chapters"`
+	Outputs  []struct {
+		Path string `yaml:"path"`
+		Type string `yaml:"type"`
+	} `yaml:"outputs"`
+	// HTML are the options of htmldoc, as the flags of lit name them,
+	// which override them.
+	HTML map[string]string `yaml:"html"`
+}
+
+// findConfig reads the config file in dir or the nearest directory
+// above it. If there is none, it returns the zero config.
+func findConfig(dir string) (*config, error) {
+	c, _, err := findConfigDir(dir)
+	return c, err
+}
+
+// findConfigDir is findConfig, but returns the directory of the config
+// file too, or "" if there is none.
+func findConfigDir(dir string) (*config, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		bs, err := os.ReadFile(filepath.Join(dir, configName))
+		if err == nil {
+			c := new(config)
+			if err := yaml.Unmarshal(bs, c); err != nil {
+				return nil, "", fmt.Errorf("%s: %v", filepath.Join(dir, configName), err)
+			}
+			return c, dir, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return new(config), "", nil
+		}
+		dir = parent
+	}
+}
+
+// formatFlags are the flags of the options to write with, which
+// override those of the config.
+type formatFlags struct {
+	fs       *flag.FlagSet
+	width    int
+	indent   string
+	semantic bool
+}
+
+func addFormatFlags(fs *flag.FlagSet) *formatFlags {
+	f := &formatFlags{fs: fs}
+	fs.IntVar(&f.width, "width", 0, "the width to wrap to; 0 is the default, -1 never wraps")
+	fs.StringVar(&f.indent, "indent", "", "the indent, tab or a number of spaces; if unset two spaces")
+	fs.BoolVar(&f.semantic, "semantic", false, "whether to write semantic line breaks: each sentence on a line of its own, never wrapped")
+	return f
+}
+
+// writeOpts returns the options to write with: those of the flags set,
+// else those of c, else the defaults.
+func (f *formatFlags) writeOpts(c *config) (*lit.WriteOpts, error) {
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	opts := *lit.DefaultWriteOpts
+	opts.Width = c.Format.Width
+	if set["width"] {
+		opts.Width = f.width
+	}
+	opts.SemanticLineBreaks = c.Format.Semantic
+	if set["semantic"] {
+		opts.SemanticLineBreaks = f.semantic
+	}
+	ind := c.Format.Indent
+	if set["indent"] {
+		ind = f.indent
+	}
+	if ind != "" {
+		s, err := parseIndent(ind)
+		if err != nil {
+			return nil, err
+		}
+		opts.Indent = s
+	}
+	return &opts, nil
+}
+
+// parseIndent returns the indent s names: "tab", or a number of spaces.
+func parseIndent(s string) (string, error) {
+	if s == "tab" || s == "\t" {
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("bad indent %q; want tab or a number of spaces", s)
+	}
+	return strings.Repeat(" ", n), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffContext is the number of lines around the changes of a hunk.
+const diffContext = 3
+
+// diffLine is a line of a diff: kind is ' ', '-' or '+'.
+type diffLine struct {
+	kind byte
+	text string // with its newline, unless it is the last
+}
+
+// unifiedDiff returns the diff of a, the file named aName, and b, the
+// file named bName, in the unified format of diff -u; or "" if they
+// are equal.
+func unifiedDiff(aName, bName, a, b string) string {
+	ls := diffLines(a, b)
+
+	var out strings.Builder
+	aLine, bLine := 1, 1 // of ls[i]
+	for i := 0; i < len(ls); {
+		if ls[i].kind == ' ' {
+			aLine, bLine = aLine+1, bLine+1
+			i++
+			continue
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+
+		// the hunk goes from start to end, joining the changes
+		// that are at most twice the context apart
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ls) {
+			if ls[end].kind != ' ' {
+				end++
+				continue
+			}
+			j := end
+			for j < len(ls) && ls[j].kind == ' ' {
+				j++
+			}
+			if j == len(ls) || j-end > 2*diffContext {
+				end += min(j-end, diffContext)
+				break
+			}
+			end = j
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		var aCount, bCount int
+		for _, l := range ls[start:end] {
+			if l.kind != '+' {
+				aCount++
+			}
+			if l.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, l := range ls[start:end] {
+			out.WriteByte(l.kind)
+			out.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		for ; i < end; i++ {
+			if ls[i].kind != '+' {
+				aLine++
+			}
+			if ls[i].kind != '-' {
+				bLine++
+			}
+		}
+	}
+	return out.String()
+}
+
+// hunkRange returns the range of count lines at start, as a hunk's
+// header has it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// diffLines returns the lines of a and b, as the shortest diff of
+// them has them.
+func diffLines(a, b string) []diffLine {
+	// diff the lines as runes, one for each distinct line, as the
+	// line mode of diffmatchpatch garbles lines past the 10th
+	var lines []string
+	runes := make(map[string]rune)
+	toRunes := func(s string) []rune {
+		var rs []rune
+		for _, l := range strings.SplitAfter(s, "\n") {
+			if l == "" {
+				continue
+			}
+			r, ok := runes[l]
+			if !ok {
+				r = rune(len(lines))
+				if r >= 0xD800 {
+					r += 0x800 // past the surrogates, which aren't runes
+				}
+				runes[l] = r
+				lines = append(lines, l)
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	}
+	line := func(r rune) string {
+		if r >= 0xE000 {
+			r -= 0x800
+		}
+		return lines[r]
+	}
+	ar, br := toRunes(a), toRunes(b)
+	diffs := diffmatchpatch.New().DiffMainRunes(ar, br, false)
+
+	var ls []diffLine
+	for _, d := range diffs {
+		kind := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			kind = '-'
+		case diffmatchpatch.DiffInsert:
+			kind = '+'
+		}
+		for _, r := range d.Text {
+			ls = append(ls, diffLine{kind, line(r)})
+		}
+	}
+	return ls
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/nlandolfi/lit"
+)
+
+const fmtUsage = `usage: lit fmt [flags] [path ...]
+
+Fmt formats LitTex as WriteLit writes it. Without paths, it formats stdin.
+A directory's .lit files are formatted, recursively. Without -l, -d or -w,
+it prints the formatted files.
+
+It exits 1 if a file, other than those -w rewrites, is not formatted,
+and 2 on an error, such as a file that doesn't parse. It never writes
+a formatting that parses to other than the file's content.
+
+Flags:
+`
+
+// fmtMain runs lit fmt with args, returning the exit status.
+func fmtMain(args []string) int {
+	flags := flag.NewFlagSet("lit fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "list the files whose formatting differs")
+	diff := flags.Bool("d", false, "print the diffs to the formatting")
+	write := flags.Bool("w", false, "write the formatting to the files, rather than stdout")
+	format := addFormatFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), fmtUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	f := &formatter{
+		list: *list, diff: *diff, write: *write,
+		format: format,
+		out:    os.Stdout, errs: os.Stderr,
+	}
+	if flags.NArg() == 0 {
+		if f.write {
+			fmt.Fprintln(os.Stderr, "lit fmt: cannot use -w with stdin")
+			return 2
+		}
+		bs, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lit fmt: %v\n", err)
+			return 2
+		}
+		c, err := findConfig(".")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "lit fmt: %v\n", err)
+			return 2
+		}
+		f.file("<standard input>", bs, 0, c)
+		return f.status
+	}
+	for _, p := range flags.Args() {
+		fi, err := os.Stat(p)
+		if err != nil {
+			f.errorf("%v", err)
+			continue
+		}
+		if !fi.IsDir() {
+			f.path(p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				f.errorf("%v", err)
+				return nil
+			}
+			if !d.IsDir() && filepath.Ext(p) == ".lit" {
+				f.path(p)
+			}
+			return nil
+		})
+		if err != nil {
+			f.errorf("%v", err)
+		}
+	}
+	return f.status
+}
+
+// formatter formats files, as lit fmt's flags say.
+type formatter struct {
+	list, diff, write bool
+	format            *formatFlags
+	out, errs         io.Writer
+	status            int // the exit status
+}
+
+func (f *formatter) errorf(msg string, args ...interface{}) {
+	fmt.Fprintf(f.errs, "lit fmt: "+msg+"\n", args...)
+	f.status = 2
+}
+
+func (f *formatter) path(p string) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		f.errorf("%v", err)
+		return
+	}
+	bs, err := os.ReadFile(p)
+	if err != nil {
+		f.errorf("%v", err)
+		return
+	}
+	c, err := findConfig(filepath.Dir(p))
+	if err != nil {
+		f.errorf("%v", err)
+		return
+	}
+	f.file(p, bs, fi.Mode().Perm(), c)
+}
+
+// file formats the source bs of the file named name, as c says.
+func (f *formatter) file(name string, bs []byte, perm fs.FileMode, c *config) {
+	opts, err := f.format.writeOpts(c)
+	if err != nil {
+		f.errorf("%v", err)
+		return
+	}
+
+	n, ds := lit.ParseLit(string(bs))
+	if ds.HasErrors() {
+		printDiagnostics(f.errs, name, ds)
+		f.status = 2
+		return
+	}
+	var b bytes.Buffer
+	if err := lit.WriteLit(&b, n, opts); err != nil {
+		f.errorf("%s: %v", name, err)
+		return
+	}
+	formatted := b.Bytes()
+	// a bug of WriteLit mustn't cost the file
+	if m, ds := lit.ParseLit(string(formatted)); ds.HasErrors() || !lit.Equal(n, m) {
+		f.errorf("%s: the formatting differs in content; not formatting it", name)
+		return
+	}
+
+	if bytes.Equal(bs, formatted) {
+		if !f.list && !f.diff && !f.write {
+			f.out.Write(formatted)
+		}
+		return
+	}
+	if f.list {
+		fmt.Fprintln(f.out, name)
+	}
+	if f.diff {
+		io.WriteString(f.out, unifiedDiff(name+".orig", name, string(bs), string(formatted)))
+	}
+	if f.write {
+		if err := writeFileAtomic(name, formatted, perm); err != nil {
+			f.errorf("%v", err)
+		}
+		return
+	}
+	if !f.list && !f.diff {
+		f.out.Write(formatted)
+	}
+	if f.status == 0 {
+		f.status = 1
+	}
+}
+
+// writeFileAtomic writes bs to the file named name, by way of a
+// temporary file in its directory, so that it is never half written.
+func writeFileAtomic(name string, bs []byte, perm fs.FileMode) error {
+	t, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name()) // in case of an error
+	if _, err := t.Write(bs); err != nil {
+		t.Close()
+		return err
+	}
+	if err := t.Chmod(perm); err != nil {
+		t.Close()
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+	return os.Rename(t.Name(), name)
+}
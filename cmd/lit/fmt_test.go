@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	// numbers returns the lines from to to, with prefix
+	numbers := func(prefix string, from, to int) string {
+		var b strings.Builder
+		for i := from; i <= to; i++ {
+			b.WriteString(prefix + strconv.Itoa(i) + "\n")
+		}
+		return b.String()
+	}
+
+	for _, c := range []struct {
+		name, a, b, want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"one hunk",
+			numbers("", 1, 10),
+			numbers("", 1, 4) + "five\n" + numbers("", 6, 10),
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n" + numbers(" ", 2, 4) + "-5\n+five\n" + numbers(" ", 6, 8),
+		},
+		{
+			// 3 and 10 are but twice the context apart, 10 and 19 further
+			"merged hunks",
+			numbers("", 1, 20),
+			numbers("", 1, 2) + "three\n" + numbers("", 4, 9) + "ten\n" + numbers("", 11, 18) + "nineteen\n20\n",
+			"--- a\n+++ b\n@@ -1,13 +1,13 @@\n" +
+				numbers(" ", 1, 2) + "-3\n+three\n" + numbers(" ", 4, 9) + "-10\n+ten\n" + numbers(" ", 11, 13) +
+				"@@ -16,5 +16,5 @@\n" + numbers(" ", 16, 18) + "-19\n+nineteen\n 20\n",
+		},
+		{
+			"no newline",
+			"a\nb", "a\nc",
+			"--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{"from empty", "", "x\ny\n", "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"},
+		{"to empty", "x\n", "", "--- a\n+++ b\n@@ -1 +0,0 @@\n-x\n"},
+	} {
+		if got := unifiedDiff("a", "b", c.a, c.b); got != c.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.lit")
+	if err := os.WriteFile(name, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(name, []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(name)
+	if err != nil || string(bs) != "new" {
+		t.Errorf("read %q, %v; want new", bs, err)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode %v, %v; want 0600", fi.Mode().Perm(), err)
+	}
+	// and the temporary file is gone
+	if es, _ := os.ReadDir(dir); len(es) != 1 {
+		t.Errorf("%d files in the directory, want 1", len(es))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "none", "a.lit"), nil, 0o644); err == nil {
+		t.Error("writing in a directory that doesn't exist: no error")
+	}
+}
+
+// runFmt runs fmtMain with args, returning its exit status and what it
+// printed to stdout and stderr.
+func runFmt(t *testing.T, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	defer errs.Close()
+	oldOut, oldErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, errs
+	status = fmtMain(args)
+	os.Stdout, os.Stderr = oldOut, oldErr
+
+	read := func(f *os.File) string {
+		f.Seek(0, io.SeekStart)
+		bs, _ := io.ReadAll(f)
+		return string(bs)
+	}
+	return status, read(out), read(errs)
+}
+
+func TestFmtMain(t *testing.T) {
+	dir := t.TempDir()
+	var b bytes.Buffer
+	if err := lit.WriteLit(&b, lit.Must(lit.ParseLit("¶ ⦊ ‖ A pack of wolves. ⦉ ⦉")), lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	formatted := b.String()
+	write := func(name, s string) string {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	good := write("good.lit", formatted)
+	bad := write("sub/bad.lit", "¶ ⦊ ‖ A pack of wolves. ⦉ ⦉")
+	write("sub/notes.txt", "not LitTex ❲")
+
+	if status, out, _ := runFmt(t, "-l", good); status != 0 || out != "" {
+		t.Errorf("lit fmt -l of a formatted file: %d, %q; want 0 and nothing", status, out)
+	}
+	// a directory's .lit files, recursively
+	if status, out, _ := runFmt(t, "-l", dir); status != 1 || out != bad+"\n" {
+		t.Errorf("lit fmt -l of the directory: %d, %q; want 1 and %s", status, out, bad)
+	}
+	if status, out, _ := runFmt(t, "-d", bad); status != 1 || !strings.HasPrefix(out, "--- "+bad+".orig\n+++ "+bad+"\n@@ ") {
+		t.Errorf("lit fmt -d: %d, %q; want 1 and a diff", status, out)
+	}
+	if status, out, _ := runFmt(t, bad); status != 1 || out != formatted {
+		t.Errorf("lit fmt: %d, %q; want 1 and %q", status, out, formatted)
+	}
+	if status, _, _ := runFmt(t, "-w", dir); status != 0 {
+		t.Errorf("lit fmt -w: %d, want 0", status)
+	}
+	if bs, _ := os.ReadFile(bad); string(bs) != formatted {
+		t.Errorf("after lit fmt -w, the file is %q, want %q", bs, formatted)
+	}
+
+	data := write("data.lit", "<json>{\"a\": [1, 2]}</json>\n")
+	if status, _, errs := runFmt(t, "-w", data); status != 0 {
+		t.Errorf("lit fmt -w of <json>: %d, %q; want 0", status, errs)
+	}
+	if bs, _ := os.ReadFile(data); !strings.HasSuffix(string(bs), "</json>") {
+		t.Errorf("after lit fmt -w, the <json> is %q", bs)
+	} else if status, _, errs := runFmt(t, "-l", data); status != 0 {
+		t.Errorf("lit fmt -l of the <json> it wrote: %d, %q; want 0", status, errs)
+	}
+
+	broken := write("broken.lit", "‖ ❲never closed ⦉")
+	if status, _, errs := runFmt(t, "-l", good, broken); status != 2 || !strings.Contains(errs, broken+":") {
+		t.Errorf("lit fmt of a file that doesn't parse: %d, %q; want 2 and its diagnostics", status, errs)
+	}
+	if status, _, errs := runFmt(t, filepath.Join(dir, "missing.lit")); status != 2 || errs == "" {
+		t.Errorf("lit fmt of a missing file: %d, %q; want 2 and an error", status, errs)
+	}
+}
+
+func TestFormatFlagsWriteOpts(t *testing.T) {
+	c := new(config)
+	c.Format.Width = 40
+	c.Format.Indent = "tab"
+	c.Format.Semantic = true
+
+	for _, tc := range []struct {
+		args     []string
+		width    int
+		indent   string
+		semantic bool
+	}{
+		{nil, 40, "\t", true},
+		{[]string{"-width", "60"}, 60, "\t", true},
+		{[]string{"-width", "0"}, 0, "\t", true}, // set, if to the default
+		{[]string{"-indent", "4", "-semantic=false"}, 40, "    ", false},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addFormatFlags(fs)
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := f.writeOpts(c)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.args, err)
+		}
+		if opts.Width != tc.width || opts.Indent != tc.indent || opts.SemanticLineBreaks != tc.semantic {
+			t.Errorf("%v: width %d, indent %q, semantic %v; want %d, %q, %v",
+				tc.args, opts.Width, opts.Indent, opts.SemanticLineBreaks, tc.width, tc.indent, tc.semantic)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := addFormatFlags(fs)
+	fs.Parse([]string{"-indent", "wide"})
+	if _, err := f.writeOpts(c); err == nil {
+		t.Error("-indent wide: no error")
+	}
+}
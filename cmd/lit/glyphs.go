@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nlandolfi/lit"
+)
+
+const glyphsUsage = `usage: lit glyphs [query ...]
+
+Glyphs prints the glyphs of LitTex, with the names to type them by,
+such as \in for ∈, as the language server completes them. With queries,
+it prints only the glyphs whose name, glyph or description has each,
+e.g. lit glyphs mathcal, or lit glyphs list.
+`
+
+// glyphsMain runs lit glyphs with args, returning the exit status.
+func glyphsMain(args []string) int {
+	flags := flag.NewFlagSet("lit glyphs", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), glyphsUsage) }
+	flags.Parse(args)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	found := false
+	for _, g := range lit.Glyphs {
+		s := g.Name + " " + g.Glyph + g.Close + " " + g.Description
+		match := true
+		for _, q := range flags.Args() {
+			match = match && strings.Contains(strings.ToLower(s), strings.ToLower(q))
+		}
+		if !match {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "%s\t%s%s\t%s\n", g.Name, g.Glyph, g.Close, g.Description)
+	}
+	w.Flush()
+	if !found {
+		return 1
+	}
+	return 0
+}
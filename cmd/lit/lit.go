@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"text/template"
 
@@ -15,11 +16,16 @@ import (
 )
 
 var inmode = flag.String("i", "", "the type of the input file")
-var in = flag.String("in", "", "in file, required")
-var outmode = flag.String("o", "", "the type of the output file {debug|lit|tex|html|slides|tmpl}")
+var in = flag.String("in", "", "in file, required unless -i is set; - or unset reads stdin")
+var outmode = flag.String("o", "", "the type of the output file {debug|lit|tex|texdoc|html|htmldoc|md|epub|tei|json|pandoc|slides|tmpl}")
 var out = flag.String("out", "", "out file, if unset writes to stdout")
 var tmpl = flag.String("tmpl", "text.tmpl", "in case -o tmpl, the template file to execute")
+var css = flag.String("css", "", "in case -o htmldoc, the stylesheet to link; if unset embeds the default")
+var math = flag.String("math", lit.DefaultHTMLDocumentOpts.Math, "in case -o htmldoc, what typesets math {katex|mathjax|none}")
+var mathurl = flag.String("mathurl", "", "in case -o htmldoc, the directory of a local KaTeX or MathJax; if unset uses a CDN")
+var toc = flag.Bool("toc", true, "in case -o htmldoc, whether to write a table of contents")
 var v = flag.Bool("v", false, "whether to print the version; exits after printing info")
+var format = addFormatFlags(flag.CommandLine)
 
 // Set using link flags; e.g., -X main.Version=...
 var (
@@ -30,6 +36,22 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(fmtMain(os.Args[2:]))
+		case "lsp":
+			os.Exit(lspMain(os.Args[2:]))
+		case "glyphs":
+			os.Exit(glyphsMain(os.Args[2:]))
+		case "watch":
+			os.Exit(watchMain(os.Args[2:]))
+		case "serve":
+			os.Exit(serveMain(os.Args[2:]))
+		case "build":
+			os.Exit(buildMain(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 
 	if *v {
@@ -38,58 +60,28 @@ func main() {
 	}
 
 	if *in == "" {
-		fmt.Printf("lit -in <filename>\n")
-		return
+		if *inmode == "" {
+			fmt.Printf("lit -in <filename>\n")
+			return
+		}
+		*in = "-" // e.g., pandoc -t json | lit -i pandoc
 	}
 
 	if *inmode == "" {
-		switch path.Ext(*in) {
-		case ".lit":
-			*inmode = "lit"
-		case ".tex":
-			*inmode = "tex"
-		case ".html":
-			*inmode = "html"
-		case ".csv":
-			*inmode = "csv"
-		default:
-			*inmode = "lit"
-		}
+		*inmode = inputMode(*in)
 	}
 
-	bs, err := os.ReadFile(*in)
+	n, bib, _, ds, err := parseFile(*inmode, *in)
 	if err != nil {
-		log.Fatalf("reading: %v", err)
+		log.Fatal(err)
 	}
-
-	var n *lit.Node
-	switch *inmode {
-	case "html":
-		n, err = lit.ParseHTML(string(bs))
-	case "tex":
-		n, err = lit.ParseTex(string(bs))
-	case "lit":
-		n, err = lit.ParseLit(string(bs))
-	case "csv":
-		n, err = lit.ParseCSV(string(bs))
-	default:
-		log.Fatalf("unknown input type: %q", *inmode)
-	}
-	if err != nil {
-		log.Fatalf("parsing: %v", err)
+	printDiagnostics(os.Stderr, *in, ds)
+	if ds.HasErrors() {
+		os.Exit(1)
 	}
 
 	if *outmode == "" && *out != "" {
-		switch path.Ext(*out) {
-		case ".lit":
-			*outmode = "lit"
-		case ".tex":
-			*outmode = "tex"
-		case ".html":
-			*outmode = "html"
-		default:
-			*outmode = "lit"
-		}
+		*outmode = outputMode(*out)
 	}
 
 	var w = os.Stdout
@@ -103,61 +95,281 @@ func main() {
 		defer f.Close()
 	}
 
-	var opts = lit.DefaultWriteOpts
-	switch *outmode {
+	opts, err := inputWriteOpts(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Bibliography = bib
+	if err := write(w, n, *outmode, *in, opts); err != nil {
+		log.Fatalf("writing: %v", err)
+	}
+}
+
+// litFlags are the flags of lit that the commands that write, such
+// as lit watch, take too.
+var litFlags = []string{"i", "tmpl", "css", "math", "mathurl", "toc", "width", "indent", "semantic"}
+
+// addLitFlags adds the litFlags to fs.
+func addLitFlags(fs *flag.FlagSet) {
+	for _, name := range litFlags {
+		f := flag.CommandLine.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+}
+
+// parseLitFlags parses args with fs, to which addLitFlags added the
+// litFlags, marking those set as set on lit too, so that the config
+// doesn't override them.
+func parseLitFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		if flag.CommandLine.Lookup(f.Name) != nil {
+			flag.CommandLine.Set(f.Name, f.Value.String())
+		}
+	})
+}
+
+// inputMode returns the type of the input file in, by its extension.
+func inputMode(in string) string {
+	switch path.Ext(in) {
+	case ".lit":
+		return "lit"
+	case ".tex":
+		return "tex"
+	case ".html":
+		return "html"
+	case ".csv":
+		return "csv"
+	case ".json":
+		return "json"
+	case ".md":
+		return "md"
+	case ".xml", ".tei":
+		return "tei"
+	default:
+		return "lit"
+	}
+}
+
+// outputMode returns the type of the output file out, by its extension.
+func outputMode(out string) string {
+	switch path.Ext(out) {
+	case ".lit":
+		return "lit"
+	case ".tex":
+		return "tex"
+	case ".html":
+		return "html"
+	case ".md":
+		return "md"
+	case ".epub":
+		return "epub"
+	case ".xml", ".tei":
+		return "tei"
+	default:
+		return "lit"
+	}
+}
+
+// parse parses bs, the source of the input type mode.
+func parse(mode string, bs []byte) (*lit.Node, lit.Diagnostics, error) {
+	var n *lit.Node
+	var ds lit.Diagnostics
+	switch mode {
+	case "html":
+		n, ds = lit.ParseHTML(string(bs))
+	case "tex":
+		n, ds = lit.ParseTex(string(bs))
+	case "lit":
+		n, ds = lit.ParseLit(string(bs))
+	case "csv":
+		n, ds = lit.ParseCSV(string(bs))
+	case "json":
+		n, ds = lit.ParseJSON(string(bs))
+	case "pandoc":
+		n, ds = lit.ParsePandoc(string(bs))
+	case "md":
+		n, ds = lit.ParseMarkdown(string(bs))
+	case "tei":
+		n, ds = lit.ParseTEI(string(bs))
+	default:
+		return nil, nil, fmt.Errorf("unknown input type: %q", mode)
+	}
+	return n, ds, nil
+}
+
+// parseFile parses the input file in, of the input type mode, or stdin
+// if in is -. LitTex files include others, relative to in's directory,
+// as are the BibTeX files of bib, that its metadata names; files are the
+// names of those it read.
+func parseFile(mode, in string) (n *lit.Node, bib lit.Bibliography, files []string, ds lit.Diagnostics, err error) {
+	dir := "."
+	if in != "-" {
+		dir = filepath.Dir(in)
+	}
+	if mode == "lit" && in != "-" {
+		var names []string
+		n, names, ds = lit.ParseLitFile(os.DirFS(dir), filepath.Base(in))
+		if len(names) == 0 { // in itself can't be read
+			return nil, nil, nil, nil, fmt.Errorf("reading: %s", ds[0].Message)
+		}
+		for _, name := range names {
+			files = append(files, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+	} else {
+		var bs []byte
+		if in == "-" {
+			bs, err = io.ReadAll(os.Stdin)
+		} else {
+			bs, err = os.ReadFile(in)
+			files = []string{in}
+		}
+		if err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("reading: %v", err)
+		}
+		n, ds, err = parse(mode, bs)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	bib, names, bds := lit.ReadBibliography(os.DirFS(dir), n)
+	for _, name := range names {
+		files = append(files, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	ds = append(ds, bds...)
+	return n, bib, files, append(ds, checkRefs(n)...), nil
+}
+
+// checkRefs returns the diagnostics of n's refs, as lit.ResolveRefs
+// reports them.
+func checkRefs(n *lit.Node) lit.Diagnostics {
+	_, ds := lit.ResolveRefs(n)
+	return ds
+}
+
+// inputWriteOpts returns the options to write the input file in with,
+// as the flags and the config of its directory say.
+func inputWriteOpts(in string) (*lit.WriteOpts, error) {
+	dir := "."
+	if in != "-" {
+		dir = path.Dir(in)
+	}
+	c, err := findConfig(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %v", err)
+	}
+	return format.writeOpts(c)
+}
+
+// write writes n, parsed from the input file in, as the output type mode.
+func write(w io.Writer, n *lit.Node, mode, in string, opts *lit.WriteOpts) error {
+	switch mode {
 	case "debug":
-		lit.WriteDebug(w, n, opts)
+		return lit.WriteDebug(w, n, opts)
 	case "", "lit":
-		if err := lit.WriteLit(w, n, opts); err != nil {
-			log.Fatal(err)
-		}
+		return lit.WriteLit(w, n, opts)
 	case "tex":
-		lit.WriteTex(w, n, opts)
+		return lit.WriteTex(w, n, opts)
+	case "texdoc":
+		return lit.WriteTexDocument(w, n, opts)
 	case "html":
-		lit.WriteHTMLInBody(w, n, opts)
+		return lit.WriteHTMLInBody(w, n, opts)
+	case "htmldoc":
+		dopts := &lit.HTMLDocumentOpts{Stylesheet: *css, Math: *math, MathURL: *mathurl, TOC: *toc}
+		if dopts.Math == "none" {
+			dopts.Math = ""
+		}
+		return lit.WriteHTMLDocument(w, n, opts, dopts)
+	case "md":
+		return lit.WriteMarkdown(w, n, opts)
+	case "json":
+		return lit.WriteJSON(w, n, opts)
+	case "pandoc":
+		return lit.WritePandoc(w, n, opts)
+	case "tei":
+		return lit.WriteTEI(w, n, opts)
+	case "epub":
+		// images are relative to the input
+		dir := "."
+		if in != "-" {
+			dir = path.Dir(in)
+		}
+		return lit.WriteEPUB(w, n, os.DirFS(dir), opts)
 	case "slides":
-		execute(w, slidesTemplate, n)
+		return execute(w, slidesTemplate, n, opts)
 	case "tmpl":
 		bs, err := os.ReadFile(*tmpl)
 		if err != nil {
-			log.Fatalf("reading template file: %v", err)
+			return fmt.Errorf("reading template file: %v", err)
 		}
-		execute(w, string(bs), n)
+		return execute(w, string(bs), n, opts)
 	default:
-		log.Fatalf("unknown output type: %q", *outmode)
+		return fmt.Errorf("unknown output type: %q", mode)
 	}
 }
 
-func execute(w io.Writer, t string, n *lit.Node) {
+// printDiagnostics prints ds, as file:line:col: severity: message.
+// The file of a diagnostic whose span names one, as those of
+// lit.ParseLitFile do, is that file of file's directory.
+func printDiagnostics(w io.Writer, file string, ds lit.Diagnostics) {
+	for _, d := range ds {
+		file := file
+		if d.Span.File != "" {
+			file = filepath.Join(filepath.Dir(file), filepath.FromSlash(d.Span.File))
+		}
+		if d.Span.IsValid() {
+			fmt.Fprintf(w, "%s:%s\n", file, d)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", file, d)
+		}
+	}
+}
+
+// execute executes the template t on n. Its functions write with opts,
+// such as its width and bibliography, but at the prefix and indent the
+// template gives, or, for lit, at no prefix.
+func execute(w io.Writer, t string, n *lit.Node, opts *lit.WriteOpts) error {
+	// layout returns opts at the prefix and indent given
+	layout := func(prefix, indent string) *lit.WriteOpts {
+		o := *opts
+		o.Prefix, o.Indent = prefix, indent
+		return &o
+	}
 	// Create a template, add the function map, and parse the text.
 	tmpl, err := template.New("").Funcs(
 		template.FuncMap{
-			"tex": func(n *lit.Node) string {
+			"tex": func(n *lit.Node) (string, error) {
 				var b bytes.Buffer
-				lit.WriteTex(&b, n, &lit.WriteOpts{Prefix: "    ", Indent: ""})
-				return b.String()
+				err := lit.WriteTex(&b, n, layout("    ", ""))
+				return b.String(), err
 			},
-			"texpi": func(n *lit.Node, pr, in string) string {
+			"texpi": func(n *lit.Node, pr, in string) (string, error) {
 				var b bytes.Buffer
-				lit.WriteTex(&b, n, &lit.WriteOpts{Prefix: pr, Indent: in})
-				return b.String()
+				err := lit.WriteTex(&b, n, layout(pr, in))
+				return b.String(), err
 			},
-			"lit": func(n *lit.Node) string {
+			"select": lit.Select,
+			// the first tokens of a list item, wrapped to the width of opts
+			"firstTokenString": func(n *lit.Node) string {
+				return n.FirstTokenStringOpts(opts)
+			},
+			"lit": func(n *lit.Node) (string, error) {
 				var b bytes.Buffer
-				lit.WriteLit(&b, n, &lit.WriteOpts{Prefix: "", Indent: "  "})
-				return b.String()
+				err := lit.WriteLit(&b, n, lit.NoPrefix(opts))
+				return b.String(), err
 			},
 		},
 	).Parse(t)
 	if err != nil {
-		log.Fatalf("template parsing: %s", err)
+		return fmt.Errorf("template parsing: %s", err)
 	}
 
 	// Run the template to verify the output.
-	err = tmpl.Execute(w, n)
-	if err != nil {
-		log.Fatalf("template execution: %s", err)
+	if err := tmpl.Execute(w, n); err != nil {
+		return fmt.Errorf("template execution: %s", err)
 	}
+	return nil
 }
 
 const slidesTemplate = `
@@ -172,15 +384,15 @@ const slidesTemplate = `
      otherwise it breaks
 		 */}}
     \titleslide
-    { {{ $tslide.FirstTokenString }} }
+    { {{ firstTokenString $tslide }} }
     {{- range $tslide.FirstListNode.Kids -}}
-      { {{  .FirstTokenString }} }
+      { {{ firstTokenString . }} }
     {{- end }}
   {{ end }}
 
   {{- range $slide := slice . 1 -}}
 {{ if $slide.IsListItem }}
-\slide{ {{ $slide.FirstTokenString }} }{
+\slide{ {{ firstTokenString $slide }} }{
   {{ range $slide.KidsExcludingTokens }}
 
   {{- texpi . "  " "  " -}}
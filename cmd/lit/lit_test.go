@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestExecuteOpts(t *testing.T) {
+	n := lit.Must(lit.ParseLit("¶ ⦊\n  ‖ a pack of wolves ⦉\n⦉\n"))
+	opts := &lit.WriteOpts{Prefix: "> ", Indent: "\t", Width: 10}
+	for _, c := range []struct {
+		tmpl, want string
+	}{
+		{`{{ tex (index .Kids 0) }}`, "a pack\nof wolves\n"},
+		{`{{ texpi (index .Kids 0) "" "" }}`, "a pack\nof wolves\n"},
+		{`{{ lit . }}`, "¶ ⦊\n\t‖ a\n\t\tpack\n\t\tof\n\t\twolves ⦉\n⦉"},
+	} {
+		var b bytes.Buffer
+		if err := execute(&b, c.tmpl, n, opts); err != nil {
+			t.Errorf("%s: %v", c.tmpl, err)
+			continue
+		}
+		if got := b.String(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.tmpl, got, c.want)
+		}
+	}
+}
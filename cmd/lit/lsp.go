@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/nlandolfi/lit"
+)
+
+const lspUsage = `usage: lit lsp [flags]
+
+Lsp runs a language server for LitTex, speaking the Language Server
+Protocol over stdin and stdout, as an editor launches it. It formats
+as lit fmt does, with the options of the flags, else of lit.yaml.
+
+Flags:
+`
+
+// lspMain runs lit lsp with args, returning the exit status.
+func lspMain(args []string) int {
+	flags := flag.NewFlagSet("lit lsp", flag.ExitOnError)
+	format := addFormatFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), lspUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	s := &lspServer{
+		in:     bufio.NewReader(os.Stdin),
+		out:    os.Stdout,
+		format: format,
+		docs:   make(map[string]*lspDocument),
+	}
+	if err := s.serve(); err != nil {
+		log.Printf("lit lsp: %v", err)
+		return 1
+	}
+	if !s.shutdown {
+		return 1 // exited without shutting down, as the spec says
+	}
+	return 0
+}
+
+// lspServer is a language server, of one client.
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	format   *formatFlags
+	docs     map[string]*lspDocument // by URI
+	shutdown bool
+}
+
+// lspMessage is a JSON-RPC request or notification; notifications
+// have no ID.
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// lspError is the error of a response.
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+// the codes of lspErrors
+const (
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+	lspRequestFailed  = -32803
+)
+
+// serve handles the messages of the client until it exits.
+func (s *lspServer) serve() error {
+	for {
+		m, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(m)
+		if m.ID == nil {
+			if err != nil {
+				log.Printf("lit lsp: %s: %v", m.Method, err)
+			}
+			continue
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": m.ID}
+		var le *lspError
+		switch {
+		case err == nil:
+			resp["result"] = result
+		case errors.As(err, &le):
+			resp["error"] = le
+		default:
+			resp["error"] = &lspError{Code: lspRequestFailed, Message: err.Error()}
+		}
+		if err := s.write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads a message, framed by a Content-Length header.
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(k, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %q", v)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without a Content-Length")
+	}
+	bs := make([]byte, length)
+	if _, err := io.ReadFull(s.in, bs); err != nil {
+		return nil, err
+	}
+	m := new(lspMessage)
+	if err := json.Unmarshal(bs, m); err != nil {
+		return nil, fmt.Errorf("bad message: %v", err)
+	}
+	return m, nil
+}
+
+// write writes v, as a message framed by a Content-Length header.
+func (s *lspServer) write(v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(bs), bs)
+	return err
+}
+
+func (s *lspServer) notify(method string, params interface{}) error {
+	return s.write(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// handle handles m, returning the result if it is a request.
+func (s *lspServer) handle(m *lspMessage) (interface{}, error) {
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		Position lspPosition `json:"position"`
+		Range    lspRange    `json:"range"`
+	}
+	if len(m.Params) > 0 {
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+	}
+	uri := p.TextDocument.URI
+
+	switch m.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":                1, // the full text
+				"documentFormattingProvider":      true,
+				"documentRangeFormattingProvider": true,
+				"documentSymbolProvider":          true,
+				"foldingRangeProvider":            true,
+				"definitionProvider":              true,
+				"hoverProvider":                   true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{`\`},
+				},
+			},
+			"serverInfo": map[string]string{"name": "lit", "version": Version},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		return nil, s.open(uri, p.TextDocument.Text)
+	case "textDocument/didChange":
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.open(uri, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return nil, s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri": uri, "diagnostics": []lspDiagnostic{},
+		})
+	}
+
+	if m.ID == nil {
+		return nil, nil // e.g., initialized or $/cancelRequest
+	}
+	var d *lspDocument
+	if strings.HasPrefix(m.Method, "textDocument/") {
+		if d = s.docs[uri]; d == nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: "unknown document " + uri}
+		}
+	}
+	switch m.Method {
+	case "textDocument/formatting":
+		return s.formatting(d, nil)
+	case "textDocument/rangeFormatting":
+		return s.formatting(d, &p.Range)
+	case "textDocument/documentSymbol":
+		return d.symbols(), nil
+	case "textDocument/foldingRange":
+		return d.foldingRanges(), nil
+	case "textDocument/definition":
+		return d.definition(p.Position), nil
+	case "textDocument/hover":
+		return d.hover(p.Position), nil
+	case "textDocument/completion":
+		return d.completion(p.Position), nil
+	}
+	return nil, &lspError{Code: lspMethodNotFound, Message: "unsupported method " + m.Method}
+}
+
+// open parses the text of the document at uri, and publishes its
+// diagnostics.
+func (s *lspServer) open(uri, text string) error {
+	d := newLSPDocument(uri, text)
+	s.docs[uri] = d
+	ds := []lspDiagnostic{}
+	for _, x := range d.ds {
+		if x.Code == lit.CodeUnresolvedInclude && d.path() != "" {
+			continue // of a file, lit resolves it
+		}
+		ds = append(ds, lspDiagnostic{
+			Range:    d.span(x.Span),
+			Severity: int(x.Severity), // they match
+			Code:     x.Code,
+			Source:   "lit",
+			Message:  x.Message,
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri": uri, "diagnostics": ds,
+	})
+}
+
+// formatting returns the edits that format d, as WriteLit writes it;
+// if r is not nil, only those of the lines r overlaps.
+func (s *lspServer) formatting(d *lspDocument, r *lspRange) ([]lspTextEdit, error) {
+	edits := []lspTextEdit{}
+	if d.ds.HasErrors() {
+		return edits, nil // the diagnostics say why
+	}
+	dir := "."
+	if p := d.path(); p != "" {
+		dir = filepath.Dir(p)
+	}
+	c, err := findConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := s.format.writeOpts(c)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := lit.WriteLit(&b, d.root, opts); err != nil {
+		return nil, err
+	}
+
+	start, end := 0, len(d.text)
+	if r != nil {
+		start, end = d.offset(r.Start), d.offset(r.End)
+	}
+	ls := diffLines(d.text, b.String())
+	offset := 0 // of ls[i] in d.text
+	for i := 0; i < len(ls); {
+		if ls[i].kind == ' ' {
+			offset += len(ls[i].text)
+			i++
+			continue
+		}
+		from, to := offset, offset
+		var inserted strings.Builder
+		for ; i < len(ls) && ls[i].kind != ' '; i++ {
+			if ls[i].kind == '-' {
+				to += len(ls[i].text)
+			} else {
+				inserted.WriteString(ls[i].text)
+			}
+		}
+		offset = to
+		if from <= end && to >= start {
+			edits = append(edits, lspTextEdit{
+				Range:   lspRange{d.position(from), d.position(to)},
+				NewText: inserted.String(),
+			})
+		}
+	}
+	return edits, nil
+}
+
+// lspDocument is a document the client has open.
+type lspDocument struct {
+	uri   string
+	text  string
+	lines []int // the offset at which each line starts
+	root  *lit.Node
+	ds    lit.Diagnostics
+}
+
+func newLSPDocument(uri, text string) *lspDocument {
+	d := &lspDocument{uri: uri, text: text, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	d.root, d.ds = lit.ParseLit(text)
+	return d
+}
+
+// path returns the path of the file of d, or "" if it is not a file.
+func (d *lspDocument) path() string {
+	u, err := url.Parse(d.uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// position returns the position of the offset into d. Positions count
+// UTF-16 code units, as the protocol has them by default.
+func (d *lspDocument) position(offset int) lspPosition {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	var char int
+	for _, r := range d.text[d.lines[line]:offset] {
+		char += utf16.RuneLen(r)
+	}
+	return lspPosition{Line: line, Character: char}
+}
+
+// offset returns the offset into d of p, the inverse of position.
+func (d *lspDocument) offset(p lspPosition) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	i := d.lines[p.Line]
+	for char := 0; char < p.Character && i < len(d.text) && d.text[i] != '\n'; {
+		r, size := utf8.DecodeRuneInString(d.text[i:])
+		char += utf16.RuneLen(r)
+		i += size
+	}
+	return i
+}
+
+// span returns the range of s, or an empty one at the start if s is invalid.
+func (d *lspDocument) span(s lit.Span) lspRange {
+	if !s.IsValid() {
+		return lspRange{}
+	}
+	return lspRange{d.position(s.Start.Offset), d.position(s.End.Offset)}
+}
+
+// symbols returns the outline of d: its sections, with those of a lower
+// level nested in them.
+func (d *lspDocument) symbols() []lspDocumentSymbol {
+	var ss []*lit.Node
+	lit.Walk(d.root, func(n *lit.Node) lit.WalkAction {
+		if n.Type == lit.SectionNode && n.Span.IsValid() {
+			ss = append(ss, n)
+		}
+		return lit.WalkContinue
+	})
+	return d.sectionSymbols(ss, len(d.text))
+}
+
+// sectionSymbols returns the symbols of the sections ss,
+// the last of which ends at end.
+func (d *lspDocument) sectionSymbols(ss []*lit.Node, end int) []lspDocumentSymbol {
+	syms := []lspDocumentSymbol{}
+	for len(ss) > 0 {
+		level := ss[0].SectionLevel()
+		k := 1
+		for k < len(ss) && ss[k].SectionLevel() > level {
+			k++
+		}
+		e := end
+		if k < len(ss) {
+			e = ss[k].Span.Start.Offset
+		}
+		syms = append(syms, lspDocumentSymbol{
+			Name:           d.sectionTitle(ss[0]),
+			Kind:           lspSymbolString,
+			Range:          lspRange{d.position(ss[0].Span.Start.Offset), d.position(e)},
+			SelectionRange: d.span(ss[0].Span),
+			Children:       d.sectionSymbols(ss[1:k], e),
+		})
+		ss = ss[k:]
+	}
+	return syms
+}
+
+// sectionTitle returns the title of section n, as its source has it.
+func (d *lspDocument) sectionTitle(n *lit.Node) string {
+	s := d.text[n.Span.Start.Offset:n.Span.End.Offset]
+	s = strings.TrimLeft(s, "#§")
+	s = strings.TrimSuffix(s, "⦉")
+	if s = strings.Join(strings.Fields(s), " "); s == "" {
+		return "§"
+	}
+	return s
+}
+
+// foldingRanges returns the ranges of the elements of d that span
+// lines, such as ¶ ⦊ … ⦉, less the line of the ⦉ or end tag, if that
+// is all it has.
+func (d *lspDocument) foldingRanges() []lspFoldingRange {
+	rs := []lspFoldingRange{}
+	lit.Walk(d.root, func(n *lit.Node) lit.WalkAction {
+		switch n.Type {
+		case lit.FragmentNode:
+			return lit.WalkContinue
+		case lit.TokenNode, lit.RunNode:
+			return lit.WalkSkip
+		}
+		if !n.Span.IsValid() {
+			return lit.WalkContinue
+		}
+		start, end := n.Span.Start.Line-1, n.Span.End.Line-1
+		last := strings.TrimSpace(d.text[d.lines[end]:n.Span.End.Offset])
+		if strings.HasPrefix(last, "⦉") || strings.HasPrefix(last, "</") {
+			end--
+		}
+		if end > start {
+			r := lspFoldingRange{StartLine: start, EndLine: end}
+			if n.Type == lit.CommentNode {
+				r.Kind = "comment"
+			}
+			rs = append(rs, r)
+		}
+		return lit.WalkContinue
+	})
+	return rs
+}
+
+// lspRefPattern matches a reference to an id, such as <ref to='id'/>,
+// \eqref{id} in math, or <a href='#id'>.
+var lspRefPattern = regexp.MustCompile(`<ref\s+to=['"]([^'"]*)['"]|\\(?:eq|c|auto)?ref\{([^}]*)\}|href=['"]#([^'"]*)['"]`)
+
+// definition returns the location of the element whose id the
+// reference at p refers to, or nil if there is none.
+func (d *lspDocument) definition(p lspPosition) *lspLocation {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return nil
+	}
+	offset := d.offset(p)
+	start := d.lines[p.Line]
+	end := len(d.text)
+	if p.Line+1 < len(d.lines) {
+		end = d.lines[p.Line+1]
+	}
+	var id string
+	for _, m := range lspRefPattern.FindAllStringSubmatchIndex(d.text[start:end], -1) {
+		if start+m[0] <= offset && offset <= start+m[1] {
+			for g := 2; g < len(m); g += 2 {
+				if m[g] >= 0 {
+					id = d.text[start+m[g] : start+m[g+1]]
+				}
+			}
+		}
+	}
+	if id == "" {
+		return nil
+	}
+	refs, _ := lit.ResolveRefs(d.root) // which has the ids of sections too
+	def := refs[id].Node
+	if def == nil {
+		lit.Walk(d.root, func(n *lit.Node) lit.WalkAction {
+			for _, a := range n.Attr {
+				if a.Key == "id" && a.Val == id {
+					def = n
+					return lit.WalkStop
+				}
+			}
+			return lit.WalkContinue
+		})
+	}
+	if def == nil || !def.Span.IsValid() {
+		return nil
+	}
+	return &lspLocation{URI: d.uri, Range: d.span(def.Span)}
+}
+
+// hover returns the name of the glyph at p, or just before it, such as
+// the LaTeX of one of math; or nil if it is not one of Glyphs.
+func (d *lspDocument) hover(p lspPosition) *lspHover {
+	offset := d.offset(p)
+	for _, i := range []int{offset, offset - 1} {
+		if i < 0 || i >= len(d.text) {
+			continue
+		}
+		for i > 0 && !utf8.RuneStart(d.text[i]) {
+			i--
+		}
+		_, size := utf8.DecodeRuneInString(d.text[i:])
+		g, ok := lit.GlyphOf(d.text[i : i+size])
+		if !ok {
+			continue
+		}
+		return &lspHover{
+			Contents: lspMarkupContent{Kind: "markdown", Value: fmt.Sprintf("%s%s `%s`\n\n%s", g.Glyph, g.Close, g.Name, g.Description)},
+			Range:    &lspRange{d.position(i), d.position(i + size)},
+		}
+	}
+	return nil
+}
+
+// completion returns the glyphs whose names start with the name
+// before p, such as \sub, to replace it with.
+func (d *lspDocument) completion(p lspPosition) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	end := d.offset(p)
+	line := d.lines[d.position(end).Line]
+	i := strings.LastIndexByte(d.text[line:end], '\\')
+	if i < 0 {
+		return items
+	}
+	start := line + i
+	name := d.text[start:end]
+	if strings.ContainsAny(name, " \t") {
+		return items
+	}
+	r := lspRange{d.position(start), d.position(end)}
+	for _, g := range lit.GlyphsWithPrefix(name) {
+		item := lspCompletionItem{
+			Label:         g.Name,
+			Kind:          lspCompletionText,
+			Detail:        g.Glyph + g.Close,
+			Documentation: g.Description,
+			FilterText:    g.Name,
+			TextEdit:      lspTextEdit{Range: r, NewText: g.Glyph},
+		}
+		if g.Close != "" {
+			item.InsertTextFormat = lspSnippet
+			item.TextEdit.NewText = lspSnippetEscaper.Replace(g.Glyph) + "$0" + lspSnippetEscaper.Replace(g.Close)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// lspSnippetEscaper escapes text for a snippet, in which $, } and \
+// are otherwise its syntax.
+var lspSnippetEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// lspSymbolString is the kind of symbol of a section, as Markdown
+// headers have.
+const lspSymbolString = 15
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children"`
+}
+
+type lspFoldingRange struct {
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+	Range    *lspRange        `json:"range,omitempty"`
+}
+
+// the kinds of completion items and their insert text
+const (
+	lspCompletionText = 1
+	lspSnippet        = 2
+)
+
+type lspCompletionItem struct {
+	Label            string      `json:"label"`
+	Kind             int         `json:"kind"`
+	Detail           string      `json:"detail"`
+	Documentation    string      `json:"documentation,omitempty"`
+	FilterText       string      `json:"filterText"`
+	InsertTextFormat int         `json:"insertTextFormat,omitempty"`
+	TextEdit         lspTextEdit `json:"textEdit"`
+}
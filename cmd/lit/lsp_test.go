@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 𝒜 is astral: four bytes of UTF-8, two code units of UTF-16
+const lspText = "¶ ⦊ ‖ 𝒜 is a set. ⦉ ⦉\n‖ b\n"
+
+func TestLSPDocumentPosition(t *testing.T) {
+	d := newLSPDocument("untitled:a.lit", lspText)
+	line1 := strings.IndexByte(lspText, '\n') + 1
+	for _, c := range []struct {
+		offset int
+		p      lspPosition
+	}{
+		{0, lspPosition{0, 0}},
+		{2, lspPosition{0, 1}},  // after ¶, of two bytes
+		{11, lspPosition{0, 6}}, // before 𝒜
+		{15, lspPosition{0, 8}}, // after it
+		{16, lspPosition{0, 9}},
+		{line1 - 1, lspPosition{0, 22}}, // the newline
+		{line1, lspPosition{1, 0}},
+		{line1 + 4, lspPosition{1, 2}}, // after ‖ b
+		{len(lspText), lspPosition{2, 0}},
+	} {
+		if got := d.position(c.offset); got != c.p {
+			t.Errorf("position(%d) = %v, want %v", c.offset, got, c.p)
+		}
+		if got := d.offset(c.p); got != c.offset {
+			t.Errorf("offset(%v) = %d, want %d", c.p, got, c.offset)
+		}
+	}
+
+	for _, c := range []struct {
+		p      lspPosition
+		offset int
+	}{
+		{lspPosition{0, 7}, 15},                // amid the surrogates of 𝒜, so after it
+		{lspPosition{0, 99}, line1 - 1},        // past the end of the line
+		{lspPosition{-1, 0}, 0},                // before the first line
+		{lspPosition{9, 0}, len(lspText)},      // after the last
+		{lspPosition{1, 99}, len(lspText) - 1}, // before the last newline
+	} {
+		if got := d.offset(c.p); got != c.offset {
+			t.Errorf("offset(%v) = %d, want %d", c.p, got, c.offset)
+		}
+	}
+	if got, want := d.position(len(lspText)+5), (lspPosition{2, 0}); got != want {
+		t.Errorf("position past the end = %v, want %v", got, want)
+	}
+}
+
+func TestLSPDocumentSymbols(t *testing.T) {
+	d := newLSPDocument("untitled:a.lit", "#§ One ⦉\n¶ ⦊ ‖ 𝒜 is a set. ⦉ ⦉\n#§§ Two ⦉\n#§§§ Three ⦉\n#§§ Four ⦉\n#§ Five ⦉\n")
+	r := func(l0, c0, l1, c1 int) lspRange { return lspRange{lspPosition{l0, c0}, lspPosition{l1, c1}} }
+	sym := func(name string, rng, sel lspRange, children ...lspDocumentSymbol) lspDocumentSymbol {
+		if children == nil {
+			children = []lspDocumentSymbol{}
+		}
+		return lspDocumentSymbol{Name: name, Kind: lspSymbolString, Range: rng, SelectionRange: sel, Children: children}
+	}
+	// each section ends where the next of its level or above starts
+	want := []lspDocumentSymbol{
+		sym("One", r(0, 0, 5, 0), r(0, 0, 0, 8),
+			sym("Two", r(2, 0, 4, 0), r(2, 0, 2, 9),
+				sym("Three", r(3, 0, 4, 0), r(3, 0, 3, 12))),
+			sym("Four", r(4, 0, 5, 0), r(4, 0, 4, 10))),
+		sym("Five", r(5, 0, 6, 0), r(5, 0, 5, 9)),
+	}
+	if got := d.symbols(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestLSPDocumentMethods(t *testing.T) {
+	d := newLSPDocument("file:///tmp/a.lit", "#§ One ⦉\n¶ ⦊ ‖ See <ref to='thm'/> and 𝒜. ⦉ ⦉\n<statement id='thm'>\n¶ ⦊ ‖ A statement. ⦉ ⦉\n</statement>\n¶ ⦊ ‖ \\sub ⦉ ⦉\n")
+	if len(d.ds) > 0 {
+		t.Fatal(d.ds)
+	}
+	for _, c := range []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"path", d.path(), filepath.FromSlash("/tmp/a.lit")},
+		{"path of an untitled document", newLSPDocument("untitled:a.lit", "").path(), ""},
+		{
+			"definition in the ref",
+			d.definition(lspPosition{1, 14}),
+			&lspLocation{URI: d.uri, Range: lspRange{lspPosition{2, 0}, lspPosition{4, 12}}},
+		},
+		{"definition outside a ref", d.definition(lspPosition{1, 6}), (*lspLocation)(nil)},
+		{"definition past the last line", d.definition(lspPosition{9, 0}), (*lspLocation)(nil)},
+		{
+			// but that of the ¶ on one line is not
+			"folding ranges",
+			d.foldingRanges(),
+			[]lspFoldingRange{{StartLine: 2, EndLine: 3}},
+		},
+		{"hover of nothing", d.hover(lspPosition{1, 8}), (*lspHover)(nil)},
+		{
+			"hover after 𝒜",
+			d.hover(lspPosition{1, 36}).Range,
+			&lspRange{lspPosition{1, 36}, lspPosition{1, 37}},
+		},
+		{"completion of nothing", d.completion(lspPosition{1, 4}), []lspCompletionItem{}},
+	} {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, c.got, c.want)
+		}
+	}
+
+	h := d.hover(lspPosition{0, 2})
+	if h == nil || !strings.Contains(h.Contents.Value, "`\\section`") {
+		t.Errorf("hover of §: got %+v", h)
+	}
+	items := d.completion(lspPosition{5, 10})
+	if len(items) == 0 {
+		t.Fatal("completion of \\sub: no items")
+	}
+	for _, it := range items {
+		if !strings.HasPrefix(it.Label, `\sub`) || it.TextEdit.Range != (lspRange{lspPosition{5, 6}, lspPosition{5, 10}}) {
+			t.Errorf("completion of \\sub: %+v", it)
+		}
+	}
+}
+
+func TestLSPCompletionSnippet(t *testing.T) {
+	d := newLSPDocument("file:///tmp/a.lit", "¶ ⦊ ‖ \\textbf ⦉ ⦉\n")
+	items := d.completion(lspPosition{0, 13})
+	if len(items) != 1 || items[0].InsertTextFormat != lspSnippet || items[0].TextEdit.NewText != "«$0»" {
+		t.Errorf("completion of \\textbf: %+v", items)
+	}
+	if got, want := lspSnippetEscaper.Replace(`$x} \y`), `\$x\} \\y`; got != want {
+		t.Errorf("escaped %q, want %q", got, want)
+	}
+}
+
+func TestLSPRangeFormatting(t *testing.T) {
+	s := &lspServer{format: addFormatFlags(flag.NewFlagSet("lit lsp", flag.ContinueOnError))}
+	d := newLSPDocument("untitled:a.lit", "¶ ⦊\n‖ A pack of wolves. ⦉ ⦉\n\n¶ ⦊ ‖ 𝒜 pack of wolves. ⦉ ⦉\n¶ ⦊ ‖ Another. ⦉ ⦉\n")
+	first := lspTextEdit{
+		Range:   lspRange{lspPosition{1, 0}, lspPosition{2, 0}},
+		NewText: "  ‖ A pack of wolves. ⦉\n⦉\n",
+	}
+	second := lspTextEdit{
+		Range:   lspRange{lspPosition{3, 0}, lspPosition{5, 0}},
+		NewText: "¶ ⦊\n  ‖ 𝒜 pack of wolves. ⦉\n⦉\n\n¶ ⦊\n  ‖ Another. ⦉\n⦉",
+	}
+	for _, c := range []struct {
+		name string
+		r    *lspRange
+		want []lspTextEdit
+	}{
+		{"the document", nil, []lspTextEdit{first, second}},
+		{"the first line, unchanged", &lspRange{lspPosition{0, 0}, lspPosition{0, 3}}, []lspTextEdit{}},
+		{"the second", &lspRange{lspPosition{1, 2}, lspPosition{1, 2}}, []lspTextEdit{first}},
+		{"the last", &lspRange{lspPosition{4, 0}, lspPosition{4, 3}}, []lspTextEdit{second}},
+		{"all", &lspRange{lspPosition{0, 0}, lspPosition{5, 0}}, []lspTextEdit{first, second}},
+	} {
+		got, err := s.formatting(d, c.r)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got\n%+v\nwant\n%+v", c.name, got, c.want)
+		}
+	}
+
+	// nothing, if it doesn't parse
+	d = newLSPDocument("untitled:a.lit", "‖ ❲never closed ⦉")
+	if got, err := s.formatting(d, nil); err != nil || len(got) != 0 {
+		t.Errorf("formatting a document that doesn't parse: %v, %v", got, err)
+	}
+}
+
+func TestLSPServer(t *testing.T) {
+	uri := "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "a.lit"))
+	var in bytes.Buffer
+	send := func(m string) { fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m) }
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"initialized","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + uri + `","text":"¶ ⦊ ‖ 𝒜. ⦉"}}}`)
+	send(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"` + uri + `"},"contentChanges":[{"text":"¶ ⦊ ‖ 𝒜. ⦉ ⦉"}]}}`)
+	send(`{"jsonrpc":"2.0","id":2,"method":"textDocument/formatting","params":{"textDocument":{"uri":"` + uri + `"}}}`)
+	send(`{"jsonrpc":"2.0","id":3,"method":"textDocument/formatting","params":{"textDocument":{"uri":"untitled:b.lit"}}}`)
+	send(`{"jsonrpc":"2.0","id":"four","method":"workspace/symbol","params":{}}`)
+	send(`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`)
+	send(`{"jsonrpc":"2.0","method":"exit"}`)
+
+	var out bytes.Buffer
+	s := &lspServer{
+		in:     bufio.NewReader(&in),
+		out:    &out,
+		format: addFormatFlags(flag.NewFlagSet("lit lsp", flag.ContinueOnError)),
+		docs:   make(map[string]*lspDocument),
+	}
+	if err := s.serve(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.shutdown {
+		t.Error("not shut down")
+	}
+
+	// the messages written, less their jsonrpc fields
+	var got []string
+	r := bufio.NewReader(&out)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("bad header %q", line)
+		}
+		r.ReadString('\n')
+		bs := make([]byte, n)
+		if _, err := io.ReadFull(r, bs); err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(bs, &m); err != nil {
+			t.Fatal(err)
+		}
+		delete(m, "jsonrpc")
+		if string(m["id"]) == "1" {
+			m["result"] = json.RawMessage(`"…"`) // the capabilities
+		}
+		bs, _ = json.Marshal(m)
+		got = append(got, string(bs))
+	}
+	want := []string{
+		`{"id":1,"result":"…"}`,
+		`{"method":"textDocument/publishDiagnostics","params":{"diagnostics":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":11}},"severity":2,"code":"unclosed","source":"lit","message":"¶ is not closed"}],"uri":"` + uri + `"}}`,
+		`{"method":"textDocument/publishDiagnostics","params":{"diagnostics":[],"uri":"` + uri + `"}}`,
+		`{"id":2,"result":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":13}},"newText":"¶ ⦊\n  ‖ 𝒜. ⦉\n⦉"}]}`,
+		`{"error":{"code":-32602,"message":"unknown document untitled:b.lit"},"id":3}`,
+		`{"error":{"code":-32601,"message":"unsupported method workspace/symbol"},"id":"four"}`,
+		`{"id":5,"result":null}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d:\ngot  %s\nwant %s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlandolfi/lit"
+)
+
+const serveUsage = `usage: lit serve [flags] file
+
+Serve serves a preview of the file, as lit -o htmldoc writes it, on
+localhost. Whenever the file or a file it includes changes, the page
+patches the paragraphs that changed, by way of server-sent events; if
+the file has errors, it shows them over the page. The other files of
+the file's directory, such as its images, are served too.
+
+Flags:
+`
+
+// serveMain runs lit serve with args, returning the exit status.
+func serveMain(args []string) int {
+	flags := flag.NewFlagSet("lit serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "the address to serve on")
+	interval := flags.Duration("interval", 500*time.Millisecond, "how often to poll the file")
+	addLitFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), serveUsage)
+		flags.PrintDefaults()
+	}
+	parseLitFlags(flags, args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	s := &previewServer{
+		in:      flags.Arg(0),
+		inmode:  *inmode,
+		write:   write,
+		errs:    os.Stderr,
+		preview: preview{Shell: emptyPage},
+		subs:    make(map[chan struct{}]bool),
+	}
+	if s.inmode == "" {
+		s.inmode = inputMode(s.in)
+	}
+	s.build()
+	go func() {
+		for {
+			time.Sleep(*interval)
+			if s.files.changed() {
+				s.build()
+			}
+		}
+	}()
+
+	log.Printf("serving %s on http://%s", s.in, *addr)
+	if err := http.ListenAndServe(*addr, s.handler()); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// previewServer serves the preview of a file.
+type previewServer struct {
+	in, inmode string
+	files      fileStates // those the last build read
+	// write writes the page; it is write, but for tests.
+	write func(w io.Writer, n *lit.Node, mode, in string, opts *lit.WriteOpts) error
+	errs  io.Writer // where the diagnostics go
+
+	mu      sync.Mutex
+	preview preview
+	subs    map[chan struct{}]bool // those of the event streams
+}
+
+// preview is a build of the page, as the events send it.
+type preview struct {
+	// Shell is the page, less what is in its <main>, which is Main.
+	// If the shell changes, the page reloads.
+	Shell string `json:"shell"`
+	Main  string `json:"main"`
+	// Errors are those of the file, if it has any, in which case the
+	// rest is of the last build without.
+	Errors string `json:"errors,omitempty"`
+}
+
+// emptyPage is the shell of the preview until a build without errors.
+const emptyPage = "<!DOCTYPE html>\n<html>\n<body>\n<main></main>\n</body>\n</html>\n"
+
+// handler serves the page at /, its events at /lit/events, and the
+// other files of the file's directory.
+func (s *previewServer) handler() http.Handler {
+	files := http.FileServer(http.Dir(filepath.Dir(s.in)))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			s.servePage(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/lit/events", s.serveEvents)
+	return mux
+}
+
+// build builds the preview, and tells the event streams.
+func (s *previewServer) build() {
+	s.files = fileStates{s.in: statFile(s.in)}
+	p, ok := s.render()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !ok {
+		s.preview.Errors = p.Errors
+	} else {
+		s.preview = p
+	}
+	for c := range s.subs {
+		select {
+		case c <- struct{}{}:
+		default: // it has yet to send the last
+		}
+	}
+}
+
+// render renders the file; ok is whether it could, without errors.
+// A panic of the parsers or writers is an error too, so that the
+// server outlives it.
+func (s *previewServer) render() (p preview, ok bool) {
+	fail := func(format string, args ...interface{}) (preview, bool) {
+		p.Errors = fmt.Sprintf(format, args...)
+		log.Print(p.Errors)
+		return p, false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s", debug.Stack())
+			p, ok = fail("panic: %v", r)
+		}
+	}()
+	n, bib, files, ds, err := parseFile(s.inmode, s.in)
+	for _, f := range files {
+		s.files[f] = statFile(f)
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+	printDiagnostics(s.errs, s.in, ds)
+	if ds.HasErrors() {
+		var b strings.Builder
+		printDiagnostics(&b, filepath.Base(s.in), ds)
+		return preview{Errors: strings.TrimSpace(b.String())}, false
+	}
+	opts, err := inputWriteOpts(s.in)
+	if err != nil {
+		return fail("%v", err)
+	}
+	opts.Bibliography = bib
+	var b bytes.Buffer
+	if err := s.write(&b, n, "htmldoc", s.in, opts); err != nil {
+		return fail("writing: %v", err)
+	}
+
+	page := b.String()
+	i, j := strings.Index(page, "<main>"), strings.LastIndex(page, "</main>")
+	if i < 0 || j < i {
+		return fail("writing: the page has no <main>")
+	}
+	i += len("<main>")
+	log.Printf("built %s", s.in)
+	return preview{Shell: page[:i] + page[j:], Main: page[i:j]}, true
+}
+
+// servePage serves the page, with the script that keeps it current.
+func (s *previewServer) servePage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	p := s.preview
+	s.mu.Unlock()
+
+	i := strings.Index(p.Shell, "</main>")
+	page := p.Shell[:i] + p.Main + p.Shell[i:]
+	if i = strings.LastIndex(page, "</body>"); i < 0 {
+		i = len(page)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, page[:i]+"<script>\n"+previewScript+"</script>\n"+page[i:])
+}
+
+// serveEvents serves a stream of the previews, from the current one on.
+func (s *previewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan struct{}, 1)
+	c <- struct{}{} // the current one
+	s.mu.Lock()
+	s.subs[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, c)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c:
+		}
+		s.mu.Lock()
+		bs, err := json.Marshal(s.preview)
+		s.mu.Unlock()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: preview\ndata: %s\n\n", bs); err != nil {
+			return
+		}
+		f.Flush()
+	}
+}
+
+// previewScript keeps the page current, from the previews of
+// /lit/events: it replaces the elements of <main> that changed, and
+// typesets their math again, or shows the errors over the page.
+const previewScript = `(function () {
+  var shell = null, parts = null;
+  var overlay = document.createElement("pre");
+  overlay.id = "lit-errors";
+  overlay.style.cssText = "display: none; position: fixed; inset: 0; margin: 0; padding: 2em; " +
+    "overflow: auto; z-index: 1000; background: rgba(24, 0, 0, 0.9); color: #fcc; " +
+    "font: 14px/1.5 Menlo, Consolas, monospace; white-space: pre-wrap;";
+  document.body.appendChild(overlay);
+
+  function typeset(el) {
+    if (window.renderMathInElement) {
+      renderMathInElement(el, {
+        delimiters: [
+          {left: "\\[", right: "\\]", display: true},
+          {left: "\\begin{equation}", right: "\\end{equation}", display: true},
+          {left: "$", right: "$", display: false},
+        ],
+        throwOnError: false
+      });
+    } else if (window.MathJax && MathJax.typesetPromise) {
+      MathJax.typesetPromise([el]);
+    }
+  }
+
+  function patch(html) {
+    var main = document.querySelector("main");
+    var t = document.createElement("template");
+    t.innerHTML = html;
+    var news = Array.prototype.slice.call(t.content.children);
+    var olds = Array.prototype.slice.call(main.children);
+    var sources = news.map(function (el) { return el.outerHTML; });
+    news.forEach(function (el, i) {
+      if (parts && parts[i] === sources[i] && olds[i]) {
+        return; // unchanged
+      }
+      if (olds[i]) {
+        main.replaceChild(el, olds[i]);
+      } else {
+        main.appendChild(el);
+      }
+      typeset(el);
+    });
+    olds.slice(news.length).forEach(function (el) { main.removeChild(el); });
+    parts = sources;
+  }
+
+  var events = new EventSource("/lit/events");
+  events.addEventListener("preview", function (e) {
+    var p = JSON.parse(e.data);
+    overlay.textContent = p.errors || "";
+    overlay.style.display = p.errors ? "block" : "none";
+    if (shell !== null && p.shell !== shell) {
+      location.reload();
+      return;
+    }
+    if (shell === null) {
+      // the page is this preview, but typeset
+      var t = document.createElement("template");
+      t.innerHTML = p.main;
+      parts = Array.prototype.map.call(t.content.children, function (el) { return el.outerHTML; });
+    } else {
+      patch(p.main);
+    }
+    shell = p.shell;
+  });
+})();
+`
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestPreviewServer(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.lit")
+	writeFile := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("in.lit", "¶ ⦊\n  ‖ One. ⦉\n⦉\n")
+	writeFile("image.txt", "an image\n")
+
+	s := &previewServer{
+		in: in, inmode: "lit",
+		write:   write,
+		errs:    io.Discard,
+		preview: preview{Shell: emptyPage},
+		subs:    make(map[chan struct{}]bool),
+	}
+	s.build()
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s", path, resp.Status)
+		}
+		return string(bs)
+	}
+	page := get("/")
+	for _, want := range []string{"<main>", "One.", "</main>", "<script>\n" + previewScript + "</script>\n</body>"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("the page does not have %q:\n%s", want, page)
+		}
+	}
+	if got := get("/image.txt"); got != "an image\n" {
+		t.Errorf("/image.txt is %q, want the file", got)
+	}
+
+	resp, err := http.Get(srv.URL + "/lit/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	// next returns the next preview of the events
+	next := func() preview {
+		t.Helper()
+		var data string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				break
+			}
+			if strings.HasPrefix(line, "data: ") {
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		var p preview
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	if p := next(); !strings.Contains(p.Main, "One.") || p.Errors != "" {
+		t.Errorf("the first event is %+v, want the page", p)
+	}
+
+	// a rebuild with errors keeps the last page, and shows them
+	writeFile("in.lit", "¶ ⦊\n  ‖ Two. ⦉\n⦉\n<include src='missing.lit'/>\n")
+	s.build()
+	if p := next(); !strings.Contains(p.Main, "One.") || !strings.Contains(p.Errors, "missing.lit") {
+		t.Errorf("the event of the errors is %+v, want the last page and the errors", p)
+	}
+	if page := get("/"); !strings.Contains(page, "One.") {
+		t.Errorf("the page is not the last without errors:\n%s", page)
+	}
+
+	// a rebuild that panics is an error too
+	writeFile("in.lit", "¶ ⦊\n  ‖ Three. ⦉\n⦉\n")
+	s.write = func(io.Writer, *lit.Node, string, string, *lit.WriteOpts) error { panic("oops") }
+	s.build()
+	if p := next(); !strings.Contains(p.Main, "One.") || p.Errors != "panic: oops" {
+		t.Errorf("the event of the panic is %+v, want the last page and the panic", p)
+	}
+
+	// and the next build without recovers
+	s.write = write
+	s.build()
+	if p := next(); !strings.Contains(p.Main, "Three.") || p.Errors != "" {
+		t.Errorf("the event of the fix is %+v, want the page", p)
+	}
+}
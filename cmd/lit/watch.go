@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const watchUsage = `usage: lit watch [flags] -in file -out file [-out file ...]
+
+Watch builds the outputs from the input, as lit does, and builds them
+again whenever the input or a file it includes changes, until
+interrupted. It polls the files, so it works on any filesystem, and
+builds once a change has held for a poll, so as not to build a file
+half written. It prints the diagnostics, but goes on; if there are
+errors, it leaves the outputs as they were.
+
+With -cmd, it runs the command with sh after each build that changes
+an output, e.g. lit watch -in book.lit -out book.tex -cmd 'pdflatex book.tex'.
+
+Flags:
+`
+
+// outputsFlag is a flag that may be given more than once.
+type outputsFlag []string
+
+func (o *outputsFlag) String() string { return strings.Join(*o, ",") }
+
+func (o *outputsFlag) Set(s string) error {
+	*o = append(*o, s)
+	return nil
+}
+
+// watchMain runs lit watch with args, returning the exit status.
+func watchMain(args []string) int {
+	flags := flag.NewFlagSet("lit watch", flag.ExitOnError)
+	w := &watcher{errs: os.Stderr}
+	flags.StringVar(&w.in, "in", "", "the input file")
+	flags.Var(&w.outs, "out", "an output file; may be given more than once")
+	flags.StringVar(&w.outmode, "o", "", "the type of the output files; if unset, by their extensions")
+	flags.StringVar(&w.cmd, "cmd", "", "the command to run after each build that changes an output")
+	interval := flags.Duration("interval", 500*time.Millisecond, "how often to poll the files")
+	addLitFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), watchUsage)
+		flags.PrintDefaults()
+	}
+	parseLitFlags(flags, args)
+	if w.in == "" || len(w.outs) == 0 || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	w.inmode = *inmode
+	if w.inmode == "" {
+		w.inmode = inputMode(w.in)
+	}
+
+	w.build()
+	for {
+		time.Sleep(*interval)
+		w.poll()
+	}
+}
+
+// watcher builds outputs from an input whenever the files it read change.
+type watcher struct {
+	in, inmode   string
+	outs         outputsFlag
+	outmode, cmd string
+	errs         io.Writer  // where the diagnostics go
+	files        fileStates // those the last build read
+	pending      fileStates // their states at the last poll, if changed
+}
+
+// fileState is what polling compares of a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func statFile(name string) fileState {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: fi.ModTime(), size: fi.Size(), exists: true}
+}
+
+// fileStates are the states of files, by name.
+type fileStates map[string]fileState
+
+// changed reports whether a file has changed since its state was fs's.
+func (fs fileStates) changed() bool {
+	for name, s := range fs {
+		if statFile(name) != s {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the states of the files of fs now.
+func (fs fileStates) current() fileStates {
+	now := make(fileStates, len(fs))
+	for name := range fs {
+		now[name] = statFile(name)
+	}
+	return now
+}
+
+// equal reports whether fs and other are the same states of the same files.
+func (fs fileStates) equal(other fileStates) bool {
+	if len(fs) != len(other) {
+		return false
+	}
+	for name, s := range fs {
+		if o, ok := other[name]; !ok || o != s {
+			return false
+		}
+	}
+	return true
+}
+
+// poll builds the outputs if the files the last build read have changed
+// and have stayed as they were at the last poll; it reports whether it
+// built.
+func (w *watcher) poll() bool {
+	if !w.files.changed() {
+		w.pending = nil
+		return false
+	}
+	if now := w.files.current(); !now.equal(w.pending) {
+		w.pending = now // wait for it to settle
+		return false
+	}
+	w.pending = nil
+	w.build()
+	return true
+}
+
+// build builds the outputs, and runs the command if any changed.
+func (w *watcher) build() {
+	start := time.Now()
+	w.files = fileStates{w.in: statFile(w.in)}
+
+	n, bib, files, ds, err := parseFile(w.inmode, w.in)
+	for _, f := range files {
+		w.files[f] = statFile(f)
+	}
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	printDiagnostics(w.errs, w.in, ds)
+	if ds.HasErrors() {
+		log.Printf("%s has errors; not building", w.in)
+		return
+	}
+	opts, err := inputWriteOpts(w.in)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	opts.Bibliography = bib
+
+	var changed []string
+	for _, out := range w.outs {
+		mode := w.outmode
+		if mode == "" {
+			mode = outputMode(out)
+		}
+		var b bytes.Buffer
+		if err := write(&b, n, mode, w.in, opts); err != nil {
+			log.Printf("writing %s: %v", out, err)
+			continue
+		}
+		if old, err := os.ReadFile(out); err == nil && bytes.Equal(old, b.Bytes()) {
+			continue
+		}
+		if err := writeFileAtomic(out, b.Bytes(), 0644); err != nil {
+			log.Printf("writing %s: %v", out, err)
+			continue
+		}
+		changed = append(changed, out)
+	}
+	if len(changed) == 0 {
+		log.Printf("built %s; no output changed (%v)", w.in, time.Since(start).Round(time.Millisecond))
+		return
+	}
+	log.Printf("built %s (%v)", strings.Join(changed, ", "), time.Since(start).Round(time.Millisecond))
+
+	if w.cmd != "" {
+		c := exec.Command("sh", "-c", w.cmd)
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		if err := c.Run(); err != nil {
+			log.Printf("%s: %v", w.cmd, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	in, part, out := filepath.Join(dir, "in.lit"), filepath.Join(dir, "part.lit"), filepath.Join(dir, "out.md")
+	ran := filepath.Join(dir, "ran")
+	mtime := time.Now()
+	// writeFile writes a file anew, a second later than the last, as
+	// the modification times of some filesystems are coarse
+	writeFile := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Second)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantOut := func(want string) {
+		t.Helper()
+		bs, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != want {
+			t.Errorf("%s is %q, want %q", out, bs, want)
+		}
+	}
+	writeFile(in, "¶ ⦊\n  ‖ One. ⦉\n⦉\n<include src='part.lit'/>\n")
+	writeFile(part, "¶ ⦊\n  ‖ Two. ⦉\n⦉\n")
+
+	var errs bytes.Buffer
+	w := &watcher{
+		in: in, inmode: "lit", outs: outputsFlag{out},
+		cmd:  "echo >> '" + ran + "'",
+		errs: &errs,
+	}
+	w.build()
+	wantOut("One.\n\nTwo.\n")
+	if w.poll() {
+		t.Error("built without a change")
+	}
+
+	// a change of an included file, which holds for a poll
+	writeFile(part, "¶ ⦊\n  ‖ Three. ⦉\n⦉\n")
+	if w.poll() {
+		t.Error("built before the change held for a poll")
+	}
+	if !w.poll() {
+		t.Error("did not build after the change held")
+	}
+	wantOut("One.\n\nThree.\n")
+	if w.poll() {
+		t.Error("built again without a change")
+	}
+
+	// a change that goes on changing
+	writeFile(in, "¶ ⦊\n  ‖ Four. ⦉\n⦉\n")
+	w.poll()
+	writeFile(in, "¶ ⦊\n  ‖ Five. ⦉\n⦉\n")
+	if w.poll() {
+		t.Error("built while the input was changing")
+	}
+	if !w.poll() {
+		t.Error("did not build after the change held")
+	}
+	wantOut("Five.\n")
+
+	// errors leave the output as it was
+	writeFile(in, "¶ ⦊\n  ‖ Six. ⦉\n⦉\n<include src='missing.lit'/>\n")
+	w.poll()
+	if !w.poll() {
+		t.Error("did not build after the change held")
+	}
+	wantOut("Five.\n")
+	if !strings.Contains(errs.String(), "in.lit:") {
+		t.Errorf("the diagnostics are %q, want those of in.lit", errs.String())
+	}
+	if !strings.Contains(logs.String(), "has errors; not building") {
+		t.Errorf("the log does not say the input has errors:\n%s", logs.String())
+	}
+
+	// the command ran after each build that changed the output
+	bs, err := os.ReadFile(ran)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(bs), "\n"); got != 3 {
+		t.Errorf("the command ran %d times, want 3", got)
+	}
+}
@@ -0,0 +1,115 @@
+package lit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is how bad a Diagnostic is.
+//
+// The values match those of the Language Server Protocol.
+type Severity int
+
+const (
+	SeverityError Severity = iota + 1
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		panic(fmt.Sprintf("unknown severity: %d", s))
+	}
+}
+
+// Diagnostic codes, one per kind of problem.
+const (
+	CodeUnrecognizedRune  = "unrecognized-rune"  // a rune the lexer can't classify
+	CodeUnclosed          = "unclosed"           // an element, comment, tag or ❲ is never closed
+	CodeMismatchedClose   = "mismatched-close"   // a ⦉ or end tag closes an outer element
+	CodeStrayClose        = "stray-close"        // a ⦉ or end tag that closes nothing
+	CodeUnknownLittype    = "unknown-littype"    // a div with an unknown data-littype
+	CodeBadJSON           = "bad-json"           // a JSON node that doesn't parse
+	CodeBadYAML           = "bad-yaml"           // a YAML node that doesn't parse
+	CodeBadHTML           = "bad-html"           // HTML that doesn't parse
+	CodeBadCSV            = "bad-csv"            // CSV that doesn't parse
+	CodeBadTable          = "bad-table"          // a table whose align isn't l, c or r for each of its columns
+	CodeUnexpectedText    = "unexpected-text"    // text where lit doesn't expect any
+	CodeDroppedComment    = "dropped-comment"    // a TeX comment that ParseTex dropped
+	CodeBadPandoc         = "bad-pandoc"         // pandoc JSON that doesn't parse, or that lit can't represent
+	CodeBadMarkdown       = "bad-markdown"       // Markdown that lit can't represent, such as an undefined footnote
+	CodeBadTEI            = "bad-tei"            // TEI that doesn't parse, or an element lit can't represent
+	CodeBadInclude        = "bad-include"        // an include of a file that can't be read, that includes itself, or that isn't among blocks
+	CodeUnresolvedInclude = "unresolved-include" // an include ParseLit leaves, having no file path to resolve it by
+	CodeDanglingRef       = "dangling-ref"       // a ref to an id that no element has
+	CodeDuplicateID       = "duplicate-id"       // an id that more than one element has
+	CodeBadBib            = "bad-bib"            // BibTeX that doesn't parse, or a key of more than one entry
+	CodeUnknownCite       = "unknown-cite"       // a citation of a key that no bibliography entry has
+)
+
+// Diagnostic is a problem found while parsing.
+type Diagnostic struct {
+	Severity Severity
+	Span     Span   // Where the problem is; may be invalid if unknown.
+	Code     string // One of the Code constants.
+	Message  string
+}
+
+// String formats d as "line:col: severity: message".
+func (d Diagnostic) String() string {
+	if !d.Span.IsValid() {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Span.Start, d.Severity, d.Message)
+}
+
+// Diagnostics is a list of Diagnostic, in the order they were found.
+type Diagnostics []Diagnostic
+
+// HasErrors reports whether any of ds is an error.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the errors among ds as an error, or nil if there are none.
+func (ds Diagnostics) Err() error {
+	var errs Diagnostics
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (ds Diagnostics) Error() string {
+	var lines = make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds *Diagnostics) add(sev Severity, span Span, code string, format string, args ...interface{}) {
+	*ds = append(*ds, Diagnostic{
+		Severity: sev,
+		Span:     span,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
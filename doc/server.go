@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -41,35 +43,68 @@ func litHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var n *lit.Node
+	var ds lit.Diagnostics
 	switch lr.InMode {
 	case "html":
-		n, err = lit.ParseHTML(lr.In)
+		n, ds = lit.ParseHTML(lr.In)
 	case "tex":
-		n, err = lit.ParseTex(lr.In)
+		n, ds = lit.ParseTex(lr.In)
 	case "lit":
-		n, err = lit.ParseLit(lr.In)
+		n, ds = lit.ParseLit(lr.In)
 	case "csv":
-		n, err = lit.ParseCSV(lr.In)
+		n, ds = lit.ParseCSV(lr.In)
+	case "json":
+		n, ds = lit.ParseJSON(lr.In)
+	case "pandoc":
+		n, ds = lit.ParsePandoc(lr.In)
+	case "md":
+		n, ds = lit.ParseMarkdown(lr.In)
+	case "tei":
+		n, ds = lit.ParseTEI(lr.In)
 	default:
 		http.Error(w, fmt.Sprintf("unknown input type: %q", lr.InMode), http.StatusBadRequest)
+		return
 	}
-	if err != nil {
-		http.Error(w, fmt.Sprintf("parsing: %v", err), http.StatusInternalServerError)
+	if err := ds.Err(); err != nil {
+		http.Error(w, fmt.Sprintf("parsing:\n%v", err), http.StatusBadRequest)
+		return
 	}
 
 	var opts = lit.DefaultWriteOpts
+	// buffer the output, so that an error can still set the status
+	var b bytes.Buffer
 	switch lr.OutMode {
 	case "debug":
-		lit.WriteDebug(w, n, opts)
+		err = lit.WriteDebug(&b, n, opts)
 	case "", "lit":
-		if err := lit.WriteLit(w, n, opts); err != nil {
-			log.Fatal(err)
-		}
+		err = lit.WriteLit(&b, n, opts)
 	case "tex":
-		lit.WriteTex(w, n, opts)
+		err = lit.WriteTex(&b, n, opts)
+	case "texdoc":
+		err = lit.WriteTexDocument(&b, n, opts)
 	case "html":
-		lit.WriteHTMLInBody(w, n, opts)
+		err = lit.WriteHTMLInBody(&b, n, opts)
+	case "htmldoc":
+		err = lit.WriteHTMLDocument(&b, n, opts, nil)
+	case "md":
+		err = lit.WriteMarkdown(&b, n, opts)
+	case "json":
+		err = lit.WriteJSON(&b, n, opts)
+	case "pandoc":
+		err = lit.WritePandoc(&b, n, opts)
+	case "tei":
+		err = lit.WriteTEI(&b, n, opts)
 	default:
 		http.Error(w, fmt.Sprintf("unknown output type: %q", lr.OutMode), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, lit.ErrUnsupportedNode) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, fmt.Sprintf("writing: %v", err), status)
+		return
 	}
+	w.Write(b.Bytes())
 }
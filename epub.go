@@ -0,0 +1,508 @@
+package lit
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// WriteEPUB writes the tree rooted at n as an EPUB 3 book.
+//
+// Each level-1 section starts a chapter, an XHTML document of the HTML
+// that WriteHTML writes, and the navigation document lists the sections
+// of all of them. Footnotes become EPUB footnotes, which readers show
+// as popups; the reference list, if opts has a Bibliography, is the
+// last chapter. The title and authors come from the document's JSON or
+// YAML metadata, as do its language, identifier and dates.
+// The same document makes the same book: its identifier defaults to
+// one of its hash, and its modified time to its date, or else that of
+// SOURCE_DATE_EPOCH, or else 1980-01-01.
+//
+// The images are read from assets by their src, which must be a path
+// in it; assets may be nil if there are none.
+func WriteEPUB(w io.Writer, n *Node, assets fs.FS, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteEPUB", n)
+
+	b := &epubBook{
+		meta:    readMetadata(n),
+		ids:     make(map[string]bool),
+		images:  make(map[string]bool),
+		assets:  assets,
+		headers: make(map[string]bool),
+	}
+	b.fillMetadata(n)
+	chapters := epubChapters(n)
+	b.files = make(map[*Node]string)
+	for i, nodes := range chapters {
+		for _, c := range nodes {
+			b.files[c] = epubChapterName(i)
+		}
+	}
+	b.references = epubChapterName(len(chapters))
+	for _, nodes := range chapters {
+		if err := b.addChapter(nodes, opts); err != nil {
+			return err
+		}
+	}
+	var refs bytes.Buffer
+	writeHTMLReferences(&refs, nil, n, opts)
+	if refs.Len() > 0 {
+		if err := b.addXHTML(&epubChapter{name: b.references}, &refs); err != nil {
+			return err
+		}
+	}
+	if b.meta.Title == "" {
+		b.meta.Title = "Untitled"
+		for _, h := range b.headings {
+			if h.text != "" {
+				b.meta.Title = h.text
+				break
+			}
+		}
+	}
+
+	zw := zip.NewWriter(ew)
+	// the mimetype comes first, and uncompressed, so that it can be
+	// read at a fixed offset
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: b.modified})
+	if err != nil {
+		return err
+	}
+	f.Write([]byte("application/epub+zip"))
+
+	add := func(name string, data []byte) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: b.modified})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	}
+	if err := add("META-INF/container.xml", []byte(epubContainer)); err != nil {
+		return err
+	}
+	if err := add("EPUB/package.opf", b.packageDocument()); err != nil {
+		return err
+	}
+	if err := add("EPUB/nav.xhtml", b.navDocument()); err != nil {
+		return err
+	}
+	for _, ch := range b.chapters {
+		if err := add("EPUB/"+ch.name, ch.data); err != nil {
+			return err
+		}
+	}
+	for _, src := range b.imageOrder {
+		bs, err := fs.ReadFile(b.assets, src)
+		if err != nil {
+			return err
+		}
+		if err := add("EPUB/"+src, bs); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="EPUB/package.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// epubMediaTypes are the image types EPUB readers must support, by extension.
+var epubMediaTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+type epubBook struct {
+	meta       metadata
+	modified   time.Time
+	chapters   []*epubChapter
+	headings   []epubHeading
+	ids        map[string]bool  // the heading ids assigned, across chapters
+	headers    map[string]bool  // for writeHTML's header ids, across chapters
+	files      map[*Node]string // the chapter of each node of n's top level, for refs
+	references string           // the chapter of the reference list, if any
+	assets     fs.FS
+	images     map[string]bool
+	imageOrder []string
+}
+
+type epubChapter struct {
+	name  string // e.g., "chapter-1.xhtml"
+	title string
+	data  []byte
+}
+
+// epubHeading is an entry of the navigation document.
+type epubHeading struct {
+	level int
+	href  string
+	text  string
+}
+
+// epubChapters splits the nodes of n into chapters, before each level-1
+// section. Nodes that show nothing, such as the metadata, go with the
+// chapter after them.
+func epubChapters(n *Node) [][]*Node {
+	if n.Type != FragmentNode {
+		return [][]*Node{{n}}
+	}
+	var chapters [][]*Node
+	var cur []*Node
+	var shows bool
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == SectionNode && c.SectionLevel() != "2" && c.SectionLevel() != "3" && shows {
+			chapters = append(chapters, cur)
+			cur, shows = nil, false
+		}
+		cur = append(cur, c)
+		switch c.Type {
+		case JSONNode, YAMLNode, CommentNode, TexOnlyNode:
+		default:
+			shows = true
+		}
+	}
+	if len(cur) > 0 {
+		chapters = append(chapters, cur)
+	}
+	return chapters
+}
+
+// epubChapterName returns the name of the i'th chapter, from 0.
+func epubChapterName(i int) string {
+	return fmt.Sprintf("chapter-%d.xhtml", i+1)
+}
+
+func (b *epubBook) addChapter(nodes []*Node, opts *WriteOpts) error {
+	ch := &epubChapter{name: epubChapterName(len(b.chapters))}
+
+	var body bytes.Buffer
+	s := &htmlWriteState{headerIDsAssigned: b.headers, files: b.files, referencesFile: b.references}
+	for _, c := range nodes {
+		if c.Type == JSONNode || c.Type == YAMLNode {
+			continue // the metadata is in the package document
+		}
+		if err := writeHTML(HTMLVal, s, &body, c, opts); err != nil {
+			return err
+		}
+		var err error
+		Walk(c, func(img *Node) WalkAction {
+			if img.Type == ImageNode {
+				err = b.addImage(img)
+			}
+			if err != nil {
+				return WalkStop
+			}
+			return WalkContinue
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// footnotes may have footnotes, which s.footnotes grows by
+	for i := 0; i < len(s.footnotes); i++ {
+		fmt.Fprintf(&body, "\n<aside epub:type='footnote' id='footnote-%d'>", i+1)
+		for c := s.footnotes[i].FirstChild; c != nil; c = c.NextSibling {
+			if err := writeHTML(HTMLVal, s, &body, c, Indented(opts)); err != nil {
+				return err
+			}
+		}
+		body.WriteString("</aside>")
+	}
+	return b.addXHTML(ch, &body)
+}
+
+// addXHTML adds the chapter ch of the HTML body.
+func (b *epubBook) addXHTML(ch *epubChapter, body *bytes.Buffer) error {
+	// WriteHTML writes HTML, but EPUB wants XHTML,
+	// so parse it and write it back as XML
+	ctx := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	ns, err := html.ParseFragment(body, ctx)
+	if err != nil {
+		return err
+	}
+	var xhtml bytes.Buffer
+	for _, hn := range ns {
+		b.fixXHTML(ch, hn)
+		if err := html.Render(&xhtml, hn); err != nil {
+			return err
+		}
+	}
+	if ch.title == "" {
+		ch.title = b.meta.Title
+		if ch.title == "" {
+			ch.title = fmt.Sprintf("Chapter %d", len(b.chapters)+1)
+		}
+		// the chapter is still in the navigation document
+		b.headings = append(b.headings, epubHeading{level: 1, href: ch.name, text: ch.title})
+	}
+
+	var doc bytes.Buffer
+	b.writeXHTMLStart(&doc, ch.title)
+	doc.Write(xhtml.Bytes())
+	doc.WriteString("\n</body>\n</html>\n")
+	ch.data = doc.Bytes()
+	b.chapters = append(b.chapters, ch)
+	return nil
+}
+
+// fixXHTML makes the HTML of a chapter what EPUB expects: it marks the
+// footnote references, gives images the alt they need, makes the ids,
+// and the links to them, XML IDs, and gives the headings ids, which it
+// records for the navigation document.
+func (b *epubBook) fixXHTML(ch *epubChapter, n *html.Node) {
+	if n.Type == html.ElementNode {
+		if id := htmlAttr(n, "id"); id != "" {
+			setHTMLAttr(n, "id", xmlID(id))
+		}
+		switch n.DataAtom {
+		case atom.A:
+			for _, a := range n.Attr {
+				if a.Key == "class" && strings.Contains(a.Val, "lit-footnote-a") {
+					n.Attr = append(n.Attr, html.Attribute{Key: "epub:type", Val: "noteref"})
+					break
+				}
+			}
+			// a link in the book, to a chapter's id
+			if href := htmlAttr(n, "href"); strings.Contains(href, "#") {
+				file, frag, _ := strings.Cut(href, "#")
+				if !strings.Contains(file, ":") {
+					setHTMLAttr(n, "href", file+"#"+xmlID(frag))
+				}
+			}
+		case atom.Img:
+			setHTMLAttr(n, "alt", htmlAttr(n, "alt"))
+		case atom.H1, atom.H2, atom.H3:
+			id := htmlAttr(n, "id")
+			if id == "" || b.ids[id] {
+				id = fmt.Sprintf("section-%d", len(b.headings)+1)
+				setHTMLAttr(n, "id", id)
+			}
+			b.ids[id] = true
+			text := strings.Join(strings.Fields(htmlText(n)), " ")
+			if ch.title == "" {
+				ch.title = text
+			}
+			b.headings = append(b.headings, epubHeading{
+				level: int(n.Data[1] - '0'),
+				href:  ch.name + "#" + id,
+				text:  text,
+			})
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.fixXHTML(ch, c)
+	}
+}
+
+// xmlID returns id as an XML ID, which is an NCName: a letter or _
+// first, and then letters, digits, combining marks, ., - and _. Other
+// runes become _, and a name that would start with a digit, . or -
+// starts with _.
+func xmlID(id string) string {
+	var b strings.Builder
+	for i, r := range id {
+		ok := unicode.IsLetter(r) || r == '_'
+		switch {
+		case i > 0:
+			ok = ok || unicode.IsDigit(r) || r == '.' || r == '-' || unicode.In(r, unicode.Mn, unicode.Mc)
+		case unicode.IsDigit(r) || r == '.' || r == '-':
+			b.WriteByte('_')
+			ok = true
+		}
+		if !ok {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func (b *epubBook) addImage(n *Node) error {
+	src := getAttr(n.Attr, "src")
+	if b.images[src] {
+		return nil
+	}
+	_, ok := epubMediaTypes[strings.ToLower(path.Ext(src))]
+	switch {
+	case !fs.ValidPath(src):
+		return &WriteError{Op: "WriteEPUB", Type: n.Type, Span: n.Span, Err: fmt.Errorf("image %q is not a relative path", src)}
+	case !ok:
+		return &WriteError{Op: "WriteEPUB", Type: n.Type, Span: n.Span, Err: fmt.Errorf("image %q is not a GIF, JPEG, PNG, SVG or WebP", src)}
+	case b.assets == nil:
+		return &WriteError{Op: "WriteEPUB", Type: n.Type, Span: n.Span, Err: fmt.Errorf("image %q: no assets to read it from", src)}
+	}
+	if _, err := fs.Stat(b.assets, src); err != nil {
+		return &WriteError{Op: "WriteEPUB", Type: n.Type, Span: n.Span, Err: err}
+	}
+	b.images[src] = true
+	b.imageOrder = append(b.imageOrder, src)
+	return nil
+}
+
+// fillMetadata fills in what EPUB requires and the metadata didn't say,
+// but for the title, which may come from the first heading.
+func (b *epubBook) fillMetadata(n *Node) {
+	m := &b.meta
+	if m.Language == "" {
+		m.Language = "en"
+	}
+	if m.Identifier == "" {
+		// the same document, the same book
+		h := n.Hash()
+		h[6] = h[6]&0x0f | 0x50
+		h[8] = h[8]&0x3f | 0x80
+		m.Identifier = fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+	}
+	b.modified = epubModified(m)
+	m.Modified = b.modified.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// epubModified returns when the book was last modified, so that the same
+// document makes the same book: the time of its modified metadata, or
+// else its date, or else that of SOURCE_DATE_EPOCH, as reproducible
+// builds set it, or else the earliest a zip file can record.
+func epubModified(m *metadata) time.Time {
+	for _, s := range []string{m.Modified, m.Date} {
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t
+			}
+		}
+	}
+	if sec, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (b *epubBook) writeXHTMLStart(w io.Writer, title string) {
+	lang := html.EscapeString(b.meta.Language)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%s" lang="%s">
+<head>
+<meta charset="utf-8"/>
+<title>%s</title>
+</head>
+<body>
+`, lang, lang, html.EscapeString(title))
+}
+
+func (b *epubBook) packageDocument() []byte {
+	var w bytes.Buffer
+	m := b.meta
+	fmt.Fprintf(&w, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="%s">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+`, html.EscapeString(m.Language), html.EscapeString(m.Identifier), html.EscapeString(m.Title))
+	for _, a := range m.Authors {
+		fmt.Fprintf(&w, "    <dc:creator>%s</dc:creator>\n", html.EscapeString(a))
+	}
+	fmt.Fprintf(&w, "    <dc:language>%s</dc:language>\n", html.EscapeString(m.Language))
+	if m.Date != "" {
+		fmt.Fprintf(&w, "    <dc:date>%s</dc:date>\n", html.EscapeString(m.Date))
+	}
+	fmt.Fprintf(&w, "    <meta property=\"dcterms:modified\">%s</meta>\n", m.Modified)
+	w.WriteString("  </metadata>\n  <manifest>\n")
+	w.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	for i, ch := range b.chapters {
+		fmt.Fprintf(&w, "    <item id=\"chapter-%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, ch.name)
+	}
+	for i, src := range b.imageOrder {
+		mt := epubMediaTypes[strings.ToLower(path.Ext(src))]
+		fmt.Fprintf(&w, "    <item id=\"image-%d\" href=\"%s\" media-type=\"%s\"/>\n", i+1, html.EscapeString(src), mt)
+	}
+	w.WriteString("  </manifest>\n  <spine>\n")
+	for i := range b.chapters {
+		fmt.Fprintf(&w, "    <itemref idref=\"chapter-%d\"/>\n", i+1)
+	}
+	w.WriteString("  </spine>\n</package>\n")
+	return w.Bytes()
+}
+
+// navDocument writes the table of contents, nesting the sections.
+func (b *epubBook) navDocument() []byte {
+	var w bytes.Buffer
+	b.writeXHTMLStart(&w, b.meta.Title)
+	w.WriteString("<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n")
+	var open int // the lists open
+	for _, h := range b.headings {
+		level := h.level
+		if level > open+1 {
+			level = open + 1 // a level-3 section right in a level-1 one
+		}
+		if level > open {
+			w.WriteString("<ol>")
+			open++
+		} else {
+			w.WriteString("</li>\n")
+			for ; open > level; open-- {
+				w.WriteString("</ol></li>\n")
+			}
+		}
+		fmt.Fprintf(&w, "<li><a href=\"%s\">%s</a>", html.EscapeString(h.href), html.EscapeString(h.text))
+	}
+	for ; open > 0; open-- {
+		w.WriteString("</li></ol>\n")
+	}
+	w.WriteString("</nav>\n</body>\n</html>\n")
+	return w.Bytes()
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setHTMLAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// htmlText returns the text in n.
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(htmlText(c))
+	}
+	return b.String()
+}
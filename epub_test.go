@@ -0,0 +1,149 @@
+package lit_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nlandolfi/lit"
+)
+
+const epubBook = `<yaml>
+title: Naive Set Theory
+author: Paul R. Halmos
+modified: 2024-01-02
+</yaml>
+¶ ⦊
+  ‖ A preface. ⦉
+⦉
+#§ Sets ⦉
+¶ ⦊
+  ‖ A pack of wolves.
+    † ⦊
+      ‖ See the end. ⦉
+    ⦉⦉
+⦉
+#§§ Classes ⦉
+<img src='figs/wolf.png'/>
+#§ Extension ⦉
+¶ ⦊
+  ‖ Equal. ⦉
+⦉`
+
+func TestWriteEPUB(t *testing.T) {
+	n := lit.Must(lit.ParseLit(epubBook))
+	assets := fstest.MapFS{"figs/wolf.png": {Data: []byte("PNG")}}
+	var b bytes.Buffer
+	if err := lit.WriteEPUB(&b, n, assets, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := zr.File[0]; f.Name != "mimetype" || f.Method != zip.Store {
+		t.Errorf("first file is %s, method %d; want mimetype, stored", f.Name, f.Method)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(bs)
+		if strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") {
+			d := xml.NewDecoder(bytes.NewReader(bs))
+			for {
+				if _, err := d.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("%s is not well-formed: %v", f.Name, err)
+				}
+			}
+		}
+	}
+
+	for name, want := range map[string][]string{
+		"EPUB/package.opf": {
+			"<dc:title>Naive Set Theory</dc:title>",
+			"<dc:creator>Paul R. Halmos</dc:creator>",
+			`<meta property="dcterms:modified">2024-01-02T00:00:00Z</meta>`,
+			`<item id="image-1" href="figs/wolf.png" media-type="image/png"/>`,
+			`<itemref idref="chapter-3"/>`,
+		},
+		"EPUB/nav.xhtml": {
+			`<li><a href="chapter-2.xhtml#Sets">Sets</a><ol><li><a href="chapter-2.xhtml#Classes">Classes</a></li>`,
+			`<li><a href="chapter-3.xhtml#Extension">Extension</a></li></ol>`,
+		},
+		"EPUB/chapter-1.xhtml": {"A preface."},
+		"EPUB/chapter-2.xhtml": {
+			`epub:type="noteref"`,
+			`<aside epub:type="footnote" id="footnote-1">`,
+			`<img src="figs/wolf.png" alt=""/>`,
+		},
+		"EPUB/figs/wolf.png": {"PNG"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(files[name], w) {
+				t.Errorf("%s does not have %s:\n%s", name, w, files[name])
+			}
+		}
+	}
+}
+
+func TestWriteEPUBMissingImage(t *testing.T) {
+	n := lit.Must(lit.ParseLit("<img src='missing.png'/>"))
+	err := lit.WriteEPUB(io.Discard, n, fstest.MapFS{}, lit.DefaultWriteOpts)
+	var we *lit.WriteError
+	if !errors.As(err, &we) || we.Type != lit.ImageNode || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want an image's not-exist WriteError", err)
+	}
+}
+
+func TestWriteEPUBReproducible(t *testing.T) {
+	n := lit.Must(lit.ParseLit("#§ 1 Naive “Set” Theory ⦉\n<statement id='1 ext'>\n  ¶ ⦊\n    ‖ Equal. ⦉\n  ⦉\n</statement>\n¶ ⦊\n  ‖ See <ref to='1 ext'/>. ⦉\n⦉"))
+	var a, b bytes.Buffer
+	if err := lit.WriteEPUB(&a, n, nil, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if err := lit.WriteEPUB(&b, n, nil, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("two EPUBs of the same document differ")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(a.Bytes()), int64(a.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "EPUB/chapter-1.xhtml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := io.ReadAll(rc)
+		rc.Close()
+		// NCNames, and the ref to one
+		for _, want := range []string{`<h1 id="_1_Naive___Set___Theory"`, `id="_1_ext"`, `href="chapter-1.xhtml#_1_ext"`} {
+			if !strings.Contains(string(bs), want) {
+				t.Errorf("chapter-1.xhtml does not have %s:\n%s", want, bs)
+			}
+		}
+	}
+}
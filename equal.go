@@ -0,0 +1,178 @@
+package lit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"reflect"
+	"sort"
+)
+
+// Equal reports whether the trees rooted at a and b have the same content.
+//
+// It ignores where the nodes came from (Span), and the implicit spaces
+// that don't change the text: those at the start or end of a run of
+// tokens, and those next to another space. As elsewhere, an empty
+// attribute is the same as a missing one, and the order of attributes
+// doesn't matter. Of a JSON or YAML node whose payload parsed, it
+// compares the payload, not its source.
+func Equal(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type ||
+		a.DataAtom != b.DataAtom ||
+		significantData(a) != significantData(b) ||
+		a.IsComment != b.IsComment {
+		return false
+	}
+	if (a.Token == nil) != (b.Token == nil) {
+		return false
+	}
+	if a.Token != nil && !tokensEqual(a.Token, b.Token) {
+		return false
+	}
+	if !reflect.DeepEqual(a.JSON, b.JSON) || !reflect.DeepEqual(a.YAML, b.YAML) {
+		return false
+	}
+	if !reflect.DeepEqual(significantAttr(a.Attr), significantAttr(b.Attr)) {
+		return false
+	}
+
+	as, bs := significantKids(a), significantKids(b)
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if !Equal(as[i], bs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func tokensEqual(a, b *Token) bool {
+	return a.Type == b.Type && a.Value == b.Value && a.Implicit == b.Implicit
+}
+
+// Hash returns a hash of the content of the subtree rooted at n.
+//
+// Trees that are Equal have the same hash, so it is suitable as a key
+// for caching, e.g., the rendered output of a paragraph. It is stable
+// across runs and versions of the program.
+func (n *Node) Hash() [sha256.Size]byte {
+	h := sha256.New()
+	hashNode(h, n)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func hashNode(h hash.Hash, n *Node) {
+	hashInt(h, int(n.Type))
+	hashString(h, n.DataAtom.String())
+	hashString(h, significantData(n))
+	hashBool(h, n.IsComment)
+
+	hashBool(h, n.Token != nil)
+	if n.Token != nil {
+		hashInt(h, int(n.Token.Type))
+		hashString(h, n.Token.Value)
+		hashBool(h, n.Token.Implicit)
+	}
+
+	// fmt prints maps sorted by key
+	hashBool(h, n.JSON != nil)
+	if n.JSON != nil {
+		hashString(h, fmt.Sprintf("%v", n.JSON))
+	}
+	hashBool(h, n.YAML != nil)
+	if n.YAML != nil {
+		hashString(h, fmt.Sprintf("%v", n.YAML))
+	}
+
+	as := significantAttr(n.Attr)
+	hashInt(h, len(as))
+	for _, a := range as {
+		hashString(h, a.Namespace)
+		hashString(h, a.Key)
+		hashString(h, a.Val)
+	}
+
+	ks := significantKids(n)
+	hashInt(h, len(ks))
+	for _, c := range ks {
+		hashNode(h, c)
+	}
+}
+
+func hashInt(h hash.Hash, i int) {
+	var b [binary.MaxVarintLen64]byte
+	h.Write(b[:binary.PutVarint(b[:], int64(i))])
+}
+
+func hashBool(h hash.Hash, v bool) {
+	if v {
+		hashInt(h, 1)
+	} else {
+		hashInt(h, 0)
+	}
+}
+
+// hashString writes the length first, so that
+// the boundaries between strings are part of the hash.
+func hashString(h hash.Hash, s string) {
+	hashInt(h, len(s))
+	h.Write([]byte(s))
+}
+
+// significantData returns the Data of n, or "" if it is the source of
+// the JSON or YAML of n, which WriteLit writes anew.
+func significantData(n *Node) string {
+	if n.JSON != nil || n.YAML != nil {
+		return ""
+	}
+	return n.Data
+}
+
+// significantAttr returns the non-empty attributes, sorted.
+func significantAttr(as []Attribute) []Attribute {
+	var out []Attribute
+	for _, a := range as {
+		if a.Val != "" {
+			out = append(out, a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Key != out[j].Key {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Namespace < out[j].Namespace
+	})
+	return out
+}
+
+// significantKids returns the children of n, less the implicit
+// spaces that don't change the text; see Equal.
+func significantKids(n *Node) (ks []*Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isImplicitSpace(c) {
+			if len(ks) == 0 || !isWordLike(ks[len(ks)-1]) || !isWordLike(c.NextSibling) {
+				continue
+			}
+		}
+		ks = append(ks, c)
+	}
+	return ks
+}
+
+func isImplicitSpace(n *Node) bool {
+	return n.Type == TokenNode && n.Token.Implicit && isSpace(n.Token)
+}
+
+// isWordLike reports whether n is a token other than a space, or a ref
+// or cite, which are written in the text as words are.
+func isWordLike(n *Node) bool {
+	return n != nil && (n.Type == TokenNode && !isSpace(n.Token) || isInlineRef(n))
+}
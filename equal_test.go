@@ -0,0 +1,78 @@
+package lit_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestClone(t *testing.T) {
+	n, ds := lit.ParseLit("¶ ⦊\n  ‖ A † ⦊ ‖ note ⦉ b. ⦉\n⦉\n<json>{\"a\": [1, 2]}</json>")
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := n.Clone()
+	if c.Parent != nil || c.PrevSibling != nil || c.NextSibling != nil {
+		t.Fatal("clone is attached")
+	}
+	if !lit.Equal(n, c) {
+		t.Fatal("clone isn't equal to the original")
+	}
+
+	// the clone can be inserted elsewhere, and shares nothing
+	p := lit.Find(c, lit.ParagraphNode).Clone()
+	c.AppendChild(p)
+	lit.Find(c, lit.RunNode).FirstChild.Token.Value = "Z"
+	lit.Find(c, lit.JSONNode).JSON["a"].([]interface{})[0] = 3.0
+
+	var a, b bytes.Buffer
+	if err := lit.WriteLit(&a, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if err := lit.WriteLit(&b, c, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if a.String() == b.String() {
+		t.Error("changing the clone changed the original")
+	}
+	if got := lit.Find(n, lit.JSONNode).JSON["a"].([]interface{})[0]; got != 1.0 {
+		t.Errorf("changing the clone's JSON changed the original's to %v", got)
+	}
+}
+
+func TestEqualAndHash(t *testing.T) {
+	var cases = []struct {
+		a, b  string
+		equal bool
+	}{
+		{"‖ A b. ⦉", "‖ A b. ⦉", true},
+		{"‖ A b. ⦉", "‖  A\n    b.  ⦉", true},
+		{"‖ A b. ⦉", "‖ A  b. ⦉", true},
+		{"‖ A b. ⦉", "‖ Ab. ⦉", false},
+		{"‖ A b. ⦉", "‖ A c. ⦉", false},
+		{"‖ A ␣ b. ⦉", "‖ A␣b. ⦉", true},
+		{"‖ A ␣ b. ⦉", "‖ A b. ⦉", false},
+		{"<statement type='theorem'>\n  ‖ A ⦉\n</statement>", "<statement type='theorem' id=''>\n  ‖ A ⦉\n</statement>", true},
+		{"<statement type='theorem'>\n  ‖ A ⦉\n</statement>", "<statement type='lemma'>\n  ‖ A ⦉\n</statement>", false},
+		{"¶ ⦊\n  ‖ A ⦉\n⦉", "⁝ ⦊\n  ‣ A ⦉\n⦉", false},
+	}
+
+	for _, c := range cases {
+		a, ds := lit.ParseLit(c.a)
+		if err := ds.Err(); err != nil {
+			t.Fatal(err)
+		}
+		b, ds := lit.ParseLit(c.b)
+		if err := ds.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got := lit.Equal(a, b); got != c.equal {
+			t.Errorf("Equal(%q, %q) = %t, want %t", c.a, c.b, got, c.equal)
+		}
+		if got := a.Hash() == b.Hash(); got != c.equal {
+			t.Errorf("Hash(%q) == Hash(%q) is %t, want %t", c.a, c.b, got, c.equal)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lit
+
+import (
+	"sort"
+	"strings"
+)
+
+// Glyph is a glyph of LitTex, with a name to type it by: for those of
+// math, the LaTeX it is written as, e.g. \in for ∈; for those of the
+// markup, a name such as \pilcrow for ¶, or \textit for ‹ and ›.
+type Glyph struct {
+	Name  string // e.g., `\in`
+	Glyph string // e.g., "∈"
+	// Close is the glyph that closes Glyph, for those in pairs,
+	// e.g., "›" for "‹".
+	Close       string
+	Description string
+}
+
+// Glyphs are the glyphs of LitTex, sorted by name: the inverse of
+// LatexMathReplacements, and the glyphs of the markup.
+var Glyphs = glyphs()
+
+// markupGlyphs are the glyphs of the markup, which Val escapes, and
+// those of inline styles, which Tex writes as commands.
+var markupGlyphs = []Glyph{
+	{Name: `\pilcrow`, Glyph: "¶", Description: "paragraph, ¶ ⦊ … ⦉"},
+	{Name: `\run`, Glyph: "‖", Description: "run, such as a sentence, ‖ … ⦉"},
+	{Name: `\footnote`, Glyph: "†", Description: "footnote, † ⦊ … ⦉"},
+	{Name: `\displaymath`, Glyph: "◇", Description: "display math, ◇ ⦊ … ⦉"},
+	{Name: `\itemize`, Glyph: "⁝", Description: "unordered list, ⁝ ⦊ … ⦉"},
+	{Name: `\enumerate`, Glyph: "𝍫", Description: "ordered list, 𝍫 ⦊ … ⦉"},
+	{Name: `\item`, Glyph: "‣", Description: "list item, ‣ … ⦉"},
+	{Name: `\section`, Glyph: "§", Description: "section, § … ⦉; §§ and §§§ for levels 2 and 3, #§ if numbered"},
+	{Name: `\table`, Glyph: "▦", Description: "table, ▦ lcr ⦊ … ⦉, its columns aligned left, center and right"},
+	{Name: `\headrow`, Glyph: "═", Description: "row of the head of a table, ═ ‖ … ⦉ ‖ … ⦉ ⦉"},
+	{Name: `\row`, Glyph: "─", Description: "row of the body of a table, ─ ‖ … ⦉ ‖ … ⦉ ⦉"},
+	{Name: `\open`, Glyph: "⦊", Description: "opens a paragraph, footnote, display math, list or table"},
+	{Name: `\close`, Glyph: "⦉", Description: "closes an element opened by a glyph"},
+	{Name: `\textit`, Glyph: "‹", Close: "›", Description: "italic"},
+	{Name: `\textbf`, Glyph: "«", Close: "»", Description: "bold"},
+	{Name: `\textsc`, Glyph: "⸤", Close: "⸥", Description: "small caps"},
+	{Name: `\term`, Glyph: "❬", Close: "❭", Description: "term"},
+	{Name: `\texttt`, Glyph: "⁅", Close: "⁆", Description: "typewriter"},
+	{Name: `\opaque`, Glyph: string(OpaqueOpenRune), Close: string(OpaqueCloseRune), Description: "opaque, written as it is"},
+	{Name: `\newline`, Glyph: "᜶", Description: "line break"},
+	{Name: `\space`, Glyph: "␣", Description: "space, which is not a break"},
+	{Name: `\noindent`, Glyph: "↤", Description: "no indent"},
+}
+
+func glyphs() []Glyph {
+	gs := append([]Glyph(nil), markupGlyphs...)
+	for r, tex := range LatexMathReplacements {
+		tex = strings.TrimSpace(tex)
+		if !strings.HasPrefix(tex, `\`) {
+			continue // e.g., 1/2 for ½
+		}
+		gs = append(gs, Glyph{Name: tex, Glyph: string(r), Description: "math"})
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		if gs[i].Name != gs[j].Name {
+			return gs[i].Name < gs[j].Name
+		}
+		return gs[i].Glyph < gs[j].Glyph
+	})
+	return gs
+}
+
+// GlyphsWithPrefix returns the glyphs whose names start with prefix,
+// e.g., ⊂, ⊆ and ⊊ for `\sub`.
+func GlyphsWithPrefix(prefix string) []Glyph {
+	i := sort.Search(len(Glyphs), func(i int) bool { return Glyphs[i].Name >= prefix })
+	j := i
+	for j < len(Glyphs) && strings.HasPrefix(Glyphs[j].Name, prefix) {
+		j++
+	}
+	return Glyphs[i:j]
+}
+
+// GlyphOf returns the glyph g, or one of a pair, such as › of ‹ ›.
+func GlyphOf(g string) (Glyph, bool) {
+	for _, x := range Glyphs {
+		if x.Glyph == g || x.Close != "" && x.Close == g {
+			return x, true
+		}
+	}
+	return Glyph{}, false
+}
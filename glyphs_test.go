@@ -0,0 +1,47 @@
+package lit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestGlyphs(t *testing.T) {
+	for name, want := range map[string]string{
+		`\in`:          "∈",
+		`\subseteq`:    "⊆",
+		`\mathcal{A}`:  "𝒜",
+		`\textit`:      "‹›",
+		`\pilcrow`:     "¶",
+		`\close`:       "⦉",
+		`\varnothing`:  "∅",
+		`\mathbfsf{D}`: "𝗗",
+	} {
+		gs := lit.GlyphsWithPrefix(name)
+		if len(gs) == 0 || gs[0].Name != name || gs[0].Glyph+gs[0].Close != want {
+			t.Errorf("GlyphsWithPrefix(%q) = %v, want %s first", name, gs, want)
+		}
+	}
+
+	// every glyph of LaTeX math has a name
+	for r, tex := range lit.LatexMathReplacements {
+		if !strings.HasPrefix(tex, `\`) {
+			continue
+		}
+		if g, ok := lit.GlyphOf(string(r)); !ok || g.Name != strings.TrimSpace(tex) {
+			t.Errorf("GlyphOf(%q) = %v, %t; want %s", r, g, ok, tex)
+		}
+	}
+
+	var names []string
+	for _, g := range lit.GlyphsWithPrefix(`\sub`) {
+		names = append(names, g.Name)
+	}
+	if got, want := strings.Join(names, " "), `\subset \subseteq \subsetneq`; got != want {
+		t.Errorf(`GlyphsWithPrefix("\sub") = %s, want %s`, got, want)
+	}
+	if g, ok := lit.GlyphOf("›"); !ok || g.Name != `\textit` {
+		t.Errorf("GlyphOf(›) = %v, %t; want \\textit", g, ok)
+	}
+}
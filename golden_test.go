@@ -13,10 +13,12 @@ func TestGolden(t *testing.T) {
 		file       string
 		golden     string
 		goldenHTML string
+		goldenMD   string
 	}{
 		{
-			file:   "./examples/halmos/halmos.lit",
-			golden: "./examples/halmos/halmos_golden.lit",
+			file:     "./examples/halmos/halmos.lit",
+			golden:   "./examples/halmos/halmos_golden.lit",
+			goldenMD: "./examples/halmos/halmos_golden.md",
 		},
 		{
 			file:   "./examples/kierkegaard/kierkegaard.lit",
@@ -44,14 +46,16 @@ func TestGolden(t *testing.T) {
 		}
 		t.Logf(c.file)
 
-		n, err := lit.ParseLit(string(bs))
-		if err != nil {
+		n, ds := lit.ParseLit(string(bs))
+		if err := ds.Err(); err != nil {
 			t.Fatal(err)
 		}
 
 		var b bytes.Buffer
 
-		lit.WriteLit(&b, n, &lit.WriteOpts{Prefix: "", Indent: "  "})
+		if err := lit.WriteLit(&b, n, &lit.WriteOpts{Prefix: "", Indent: "  "}); err != nil {
+			t.Fatal(err)
+		}
 
 		s := b.String()
 
@@ -71,7 +75,9 @@ func TestGolden(t *testing.T) {
 			}
 
 			var b bytes.Buffer
-			lit.WriteHTMLInBody(&b, n, &lit.WriteOpts{Prefix: "", Indent: "  "})
+			if err := lit.WriteHTMLInBody(&b, n, &lit.WriteOpts{Prefix: "", Indent: "  "}); err != nil {
+				t.Fatal(err)
+			}
 
 			if want, got := string(bs), b.String(); got != want {
 				t.Fatalf("%q doesn't match goldenHTML\n diff the result of lit on %q \nagainst %q", c.file, c.file, c.goldenHTML)
@@ -79,5 +85,21 @@ func TestGolden(t *testing.T) {
 
 		}
 
+		if c.goldenMD != "" {
+			bs, err := os.ReadFile(c.goldenMD)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			if err := lit.WriteMarkdown(&b, n, &lit.WriteOpts{Prefix: "", Indent: "  "}); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := string(bs), b.String(); got != want {
+				t.Fatalf("%q doesn't match goldenMD\n diff the result of lit -o md on %q \nagainst %q", c.file, c.file, c.goldenMD)
+			}
+		}
+
 	}
 }
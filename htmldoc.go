@@ -0,0 +1,261 @@
+package lit
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// HTMLDocumentOpts are the options of WriteHTMLDocument.
+type HTMLDocumentOpts struct {
+	// Stylesheet is the href of the stylesheet to link, e.g. "style.css";
+	// if empty, the default stylesheet is embedded.
+	Stylesheet string
+
+	// Math is what typesets the math: "katex", "mathjax", or "" for nothing.
+	Math string
+	// MathURL is the directory of the KaTeX or MathJax distribution,
+	// e.g. "katex" for a local copy. If empty, it is that of a CDN.
+	MathURL string
+
+	// TOC is whether to write a table of contents, if there are sections.
+	TOC bool
+}
+
+var DefaultHTMLDocumentOpts = &HTMLDocumentOpts{
+	Math: "katex",
+	TOC:  true,
+}
+
+// the distributions of the CDN, if MathURL is empty
+const (
+	katexURL   = "https://cdn.jsdelivr.net/npm/katex@0.16.9/dist"
+	mathjaxURL = "https://cdn.jsdelivr.net/npm/mathjax@3/es5"
+)
+
+// WriteHTMLDocument writes the tree rooted at n as a full HTML page: with
+// a <head> holding the title from its metadata, a stylesheet and what
+// typesets its math, and a table of contents of its sections before the
+// body. If dopts is nil, it is DefaultHTMLDocumentOpts.
+func WriteHTMLDocument(w io.Writer, n *Node, opts *WriteOpts, dopts *HTMLDocumentOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteHTMLDocument", n)
+	w = ew
+	if dopts == nil {
+		dopts = DefaultHTMLDocumentOpts
+	}
+
+	// the body first, for the ids of the headers
+	var body bytes.Buffer
+	s := &htmlWriteState{headerIDsAssigned: make(map[string]bool)}
+	bodyOpts := Indented(NoPrefix(opts))
+	nodes := []*Node{n}
+	if n.Type == FragmentNode {
+		nodes = nil
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			nodes = append(nodes, c)
+		}
+	}
+	for _, c := range nodes {
+		if c.Type == JSONNode || c.Type == YAMLNode {
+			continue // the metadata is in the head
+		}
+		if err := writeHTML(HTMLVal, s, &body, c, bodyOpts); err != nil {
+			return err
+		}
+	}
+	if err := writeHTMLFootnotes(&body, s, bodyOpts); err != nil {
+		return err
+	}
+	writeHTMLReferences(&body, s, n, bodyOpts)
+
+	m := readMetadata(n)
+	title := m.Title
+	if title == "" && len(s.headers) > 0 {
+		title = headerText(s.headers[0].n, Val)
+	}
+	if title == "" {
+		title = "Untitled"
+	}
+
+	w.Write([]byte("<!DOCTYPE html>\n"))
+	if m.Language != "" {
+		fmt.Fprintf(w, "<html lang=\"%s\">\n", html.EscapeString(m.Language))
+	} else {
+		w.Write([]byte("<html>\n"))
+	}
+	w.Write([]byte("<head>\n"))
+	w.Write([]byte(opts.Indent + "<meta charset=\"utf-8\"/>\n"))
+	w.Write([]byte(opts.Indent + "<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\"/>\n"))
+	fmt.Fprintf(w, "%s<title>%s</title>\n", opts.Indent, html.EscapeString(title))
+	if len(m.Authors) > 0 {
+		fmt.Fprintf(w, "%s<meta name=\"author\" content=\"%s\"/>\n", opts.Indent, html.EscapeString(strings.Join(m.Authors, ", ")))
+	}
+	if dopts.Stylesheet != "" {
+		fmt.Fprintf(w, "%s<link rel=\"stylesheet\" href=\"%s\"/>\n", opts.Indent, html.EscapeString(dopts.Stylesheet))
+	} else {
+		w.Write([]byte(opts.Indent + "<style>\n" + defaultHTMLStyle + opts.Indent + "</style>\n"))
+	}
+	if err := writeMathInclude(w, dopts, opts.Indent); err != nil {
+		return err
+	}
+	w.Write([]byte("</head>\n<body>\n"))
+
+	if m.Title != "" {
+		w.Write([]byte("<header>\n"))
+		fmt.Fprintf(w, "%s<h1 class='title'>%s</h1>\n", opts.Indent, html.EscapeString(m.Title))
+		for _, a := range m.Authors {
+			fmt.Fprintf(w, "%s<p class='author'>%s</p>\n", opts.Indent, html.EscapeString(a))
+		}
+		if m.Date != "" {
+			fmt.Fprintf(w, "%s<p class='date'>%s</p>\n", opts.Indent, html.EscapeString(m.Date))
+		}
+		w.Write([]byte("</header>\n"))
+	}
+	if dopts.TOC && len(s.headers) > 0 {
+		writeTOC(w, s.headers, opts.Indent)
+	}
+	w.Write([]byte("<main>\n"))
+	w.Write(bytes.Trim(body.Bytes(), "\n"))
+	w.Write([]byte("\n</main>\n</body>\n</html>\n"))
+	return nil
+}
+
+// writeMathInclude writes the stylesheets and scripts of dopts.Math.
+// Both KaTeX and MathJax typeset the $…$, \[…\] and equation environments
+// writeHTML writes.
+func writeMathInclude(w io.Writer, dopts *HTMLDocumentOpts, indent string) error {
+	url := strings.TrimSuffix(dopts.MathURL, "/")
+	switch dopts.Math {
+	case "":
+	case "katex":
+		if url == "" {
+			url = katexURL
+		}
+		url = html.EscapeString(url)
+		fmt.Fprintf(w, "%s<link rel=\"stylesheet\" href=\"%s/katex.min.css\"/>\n", indent, url)
+		fmt.Fprintf(w, "%s<script defer src=\"%s/katex.min.js\"></script>\n", indent, url)
+		fmt.Fprintf(w, "%s<script defer src=\"%s/contrib/auto-render.min.js\" onload=\"renderMathInElement(document.body, {\n", indent, url)
+		fmt.Fprintf(w, "%s%s delimiters: [\n", indent, indent)
+		for _, d := range []string{
+			`{left: '\\[', right: '\\]', display: true}`,
+			`{left: '\\begin{equation}', right: '\\end{equation}', display: true}`,
+			`{left: '$', right: '$', display: false}`,
+		} {
+			fmt.Fprintf(w, "%s%s%s%s,\n", indent, indent, indent, d)
+		}
+		fmt.Fprintf(w, "%s%s ],\n", indent, indent)
+		fmt.Fprintf(w, "%s%s throwOnError: false\n", indent, indent)
+		fmt.Fprintf(w, "%s%s})\"></script>\n", indent, indent)
+	case "mathjax":
+		if url == "" {
+			url = mathjaxURL
+		}
+		fmt.Fprintf(w, "%s<script>\n", indent)
+		fmt.Fprintf(w, "%s%sMathJax = {tex: {inlineMath: [['$', '$']], tags: 'ams'}};\n", indent, indent)
+		fmt.Fprintf(w, "%s</script>\n", indent)
+		fmt.Fprintf(w, "%s<script defer src=\"%s/tex-chtml.js\"></script>\n", indent, html.EscapeString(url))
+	default:
+		return fmt.Errorf("unknown math typesetter %q; want katex or mathjax", dopts.Math)
+	}
+	return nil
+}
+
+// writeTOC writes a nav of nested lists of links to the headers.
+// A level skipped, as in a subsubsection right after a section, nests
+// only one deeper.
+func writeTOC(w io.Writer, headers []htmlHeader, indent string) {
+	w.Write([]byte("<nav class='toc'>\n"))
+	depth := 0 // the lists open
+	line := func(s string) {
+		w.Write([]byte(strings.Repeat(indent, depth) + s + "\n"))
+	}
+	for _, h := range headers {
+		level := 1
+		switch h.n.SectionLevel() {
+		case "2":
+			level = 2
+		case "3":
+			level = 3
+		}
+		switch {
+		case depth == 0 || level > depth:
+			depth++
+			line("<ol>")
+		default:
+			line(indent + "</li>")
+			for depth > level {
+				line("</ol>")
+				depth--
+				line(indent + "</li>")
+			}
+		}
+		line(fmt.Sprintf("%s<li><a href='#%s'>%s</a>", indent, html.EscapeString(h.id), headerText(h.n, HTMLVal)))
+	}
+	for ; depth > 0; depth-- {
+		line(indent + "</li>")
+		line("</ol>")
+	}
+	w.Write([]byte("</nav>\n"))
+}
+
+// headerText returns the text of the tokens of section n, by val.
+func headerText(n *Node, val tokenStringer) string {
+	var ts []*Token
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == TokenNode {
+			ts = append(ts, c.Token)
+		}
+	}
+	lines, _ := lineBlocks(ts, val, new(WriteOpts), true, maxWidth) // not in math, so no error
+	return strings.Join(lines, " ")
+}
+
+// defaultHTMLStyle is the stylesheet of WriteHTMLDocument, if it links none.
+const defaultHTMLStyle = `    body {
+      max-width: 38em;
+      margin: 2em auto;
+      padding: 0 1em;
+      font-family: Georgia, "Times New Roman", serif;
+      font-size: 1.1em;
+      line-height: 1.5;
+      color: #222;
+      background: #fff;
+    }
+    header { text-align: center; margin-bottom: 2em; }
+    header .title { margin-bottom: 0.25em; }
+    header .author, header .date { margin: 0.25em 0; }
+    nav.toc { margin-bottom: 2em; }
+    nav.toc ol { list-style: none; padding-left: 1.5em; }
+    nav.toc > ol { padding-left: 0; }
+    a { color: #1a4f8b; }
+    .smallcaps { font-variant: small-caps; }
+    .term { font-style: italic; }
+    .typewriter, code, pre { font-family: Menlo, Consolas, monospace; font-size: 0.9em; }
+    pre { overflow-x: auto; }
+    blockquote { margin: 1em 2em; }
+    img { max-width: 100%; }
+    table { border-collapse: collapse; margin: 1em auto; }
+    thead { border-top: 2px solid; border-bottom: 1px solid; }
+    tbody { border-bottom: 2px solid; }
+    th, td { padding: 0.2em 0.6em; }
+    div[text]::before { content: attr(text) ". "; font-weight: bold; }
+    div[data-number]::before { content: attr(class) " " attr(data-number) ". "; font-weight: bold; text-transform: capitalize; }
+    div[data-number][text]::before { content: attr(class) " " attr(data-number) " (" attr(text) "). "; }
+    h1[data-number]::before, h2[data-number]::before, h3[data-number]::before { content: attr(data-number) " "; }
+    a.ref, .cite a { text-decoration: none; }
+    figure { margin: 1em auto; text-align: center; }
+    figcaption { margin-top: 0.5em; font-size: 0.9em; }
+    .lit-figure-number { font-weight: bold; margin-right: 0.3em; }
+    ul.references { list-style: none; padding-left: 2em; text-indent: -2em; }
+    .proof::before { content: "Proof. "; font-style: italic; }
+    .proof::after { content: " ∎"; }
+    .lit-footnote-sup a { text-decoration: none; }
+    ol.footnotes { font-size: 0.9em; }
+    @media (prefers-color-scheme: dark) {
+      body { color: #ddd; background: #111; }
+      a { color: #8ab4f8; }
+    }
+`
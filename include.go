@@ -0,0 +1,119 @@
+package lit
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ParseLitFile parses the LitTex file name of fsys, as ParseLit does,
+// along with the files it includes.
+//
+// An <include src='…'/> is replaced by the children of the file src
+// names, which is relative to the including file, and which may include
+// others in turn; an include that can't be read, or that would include a
+// file within itself, is reported, and dropped, as is one that isn't
+// among blocks, such as one in a run. The spans of the nodes, tokens and
+// diagnostics name the files they came from.
+//
+// Files are the names of the files it read, name first.
+func ParseLitFile(fsys fs.FS, name string) (n *Node, files []string, ds Diagnostics) {
+	in := &includer{fsys: fsys}
+	n = in.parse(name, Span{})
+	return n, in.files, in.ds
+}
+
+// includer parses files, expanding their includes.
+type includer struct {
+	fsys  fs.FS
+	open  []string // the files being parsed, the includer of each first
+	files []string
+	ds    Diagnostics
+}
+
+// parse parses name, included at span, or returns nil if it can't.
+func (in *includer) parse(name string, span Span) *Node {
+	for i, o := range in.open {
+		if o == name {
+			cycle := append(in.open[i:len(in.open):len(in.open)], name)
+			in.ds.add(SeverityError, span, CodeBadInclude, "include cycle: %s", strings.Join(cycle, " → "))
+			return nil
+		}
+	}
+	bs, err := fs.ReadFile(in.fsys, name)
+	if err != nil {
+		in.ds.add(SeverityError, span, CodeBadInclude, "%v", err)
+		return nil
+	}
+	in.files = append(in.files, name)
+	in.open = append(in.open, name)
+	defer func() { in.open = in.open[:len(in.open)-1] }()
+
+	n, ds := ParseLit(string(bs))
+	for _, d := range ds {
+		if d.Code == CodeUnresolvedInclude {
+			continue // as it is resolved below
+		}
+		d.Span.File = name
+		in.ds = append(in.ds, d)
+	}
+	var includes []*Node
+	Walk(n, func(c *Node) WalkAction {
+		c.Span.File = name
+		if c.Token != nil {
+			c.Token.Span.File = name
+		}
+		if c.Type == IncludeNode {
+			includes = append(includes, c)
+		}
+		return WalkContinue
+	})
+
+	for _, c := range includes {
+		src := getAttr(c.Attr, "src")
+		file := path.Join(path.Dir(name), src)
+		var inc *Node
+		switch {
+		case !isBlockParent(c.Parent):
+			// ParseLit reported it
+		case src == "" || !fs.ValidPath(file):
+			in.ds.add(SeverityError, c.Span, CodeBadInclude, "invalid include src: %q", src)
+		default:
+			inc = in.parse(file, c.Span)
+		}
+		for inc != nil && inc.FirstChild != nil {
+			k := inc.FirstChild
+			inc.RemoveChild(k)
+			c.Parent.InsertBefore(k, c)
+		}
+		c.Parent.RemoveChild(c)
+	}
+	return n
+}
+
+// checkIncludes reports the includes of the tree n, which ParseLit
+// can't resolve, having no file path, and which are errors if not
+// among blocks, as the children of the included file are.
+func checkIncludes(n *Node, ds *Diagnostics) {
+	Walk(n, func(c *Node) WalkAction {
+		if c.Type != IncludeNode {
+			return WalkContinue
+		}
+		if !isBlockParent(c.Parent) {
+			ds.add(SeverityError, c.Span, CodeBadInclude, "include in a %s, rather than among blocks", c.Parent.Type)
+			return WalkSkip
+		}
+		ds.add(SeverityWarning, c.Span, CodeUnresolvedInclude, "include requires a file path; see ParseLitFile")
+		return WalkSkip
+	})
+}
+
+// isBlockParent reports whether the children of n are blocks, such as
+// paragraphs, among which an include may be.
+func isBlockParent(n *Node) bool {
+	switch n.Type {
+	case FragmentNode, DivNode, StatementNode, ProofNode, QuoteNode, CenterAlignNode, RightAlignNode, OpaqueNode:
+		return true
+	}
+	return false
+}
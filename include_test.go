@@ -0,0 +1,98 @@
+package lit_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestParseLitFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"book.lit":    {Data: []byte("§ Book ⦉\n\n<include src='ch/one.lit'/>\n\n¶ ⦊\n  ‖ The end. ⦉\n⦉\n")},
+		"ch/one.lit":  {Data: []byte("¶ ⦊\n  ‖ One. ⦉\n⦉\n\n<include src='two.lit'/>\n")},
+		"ch/two.lit":  {Data: []byte("¶ ⦊\n  ‖ Two. ⦉\n⦉\n")},
+		"cycle.lit":   {Data: []byte("<include src='ch/back.lit'/>\n")},
+		"ch/back.lit": {Data: []byte("¶ ⦊\n  ‖ Back. ⦉\n⦉\n\n<include src='../cycle.lit'/>\n")},
+		"missing.lit": {Data: []byte("<include src='nope.lit'/>\n")},
+	}
+
+	n, files, ds := lit.ParseLitFile(fsys, "book.lit")
+	if len(ds) > 0 {
+		t.Fatalf("diagnostics: %v", ds)
+	}
+	if want := []string{"book.lit", "ch/one.lit", "ch/two.lit"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	want, _ := lit.ParseLit("§ Book ⦉\n\n¶ ⦊\n  ‖ One. ⦉\n⦉\n\n¶ ⦊\n  ‖ Two. ⦉\n⦉\n\n¶ ⦊\n  ‖ The end. ⦉\n⦉\n")
+	if !lit.Equal(n, want) {
+		t.Errorf("ParseLitFile(book.lit) = %s, want %s", writeLit(t, n), writeLit(t, want))
+	}
+	var spans []string
+	lit.Walk(n, func(c *lit.Node) lit.WalkAction {
+		if c.Type == lit.ParagraphNode {
+			spans = append(spans, c.Span.File+":"+c.Span.String())
+		}
+		return lit.WalkContinue
+	})
+	if got, want := strings.Join(spans, " "), "ch/one.lit:1:1-3:2 ch/two.lit:1:1-3:2 book.lit:5:1-7:2"; got != want {
+		t.Errorf("spans = %s, want %s", got, want)
+	}
+
+	_, _, ds = lit.ParseLitFile(fsys, "cycle.lit")
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadInclude || ds[0].Span.File != "ch/back.lit" ||
+		ds[0].Message != "include cycle: cycle.lit → ch/back.lit → cycle.lit" {
+		t.Errorf("ParseLitFile(cycle.lit) diagnostics = %v, want an include cycle in ch/back.lit", ds)
+	}
+
+	_, _, ds = lit.ParseLitFile(fsys, "missing.lit")
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadInclude || ds[0].Span.File != "missing.lit" || ds[0].Span.Start.Line != 1 {
+		t.Errorf("ParseLitFile(missing.lit) diagnostics = %v, want a bad include at missing.lit:1", ds)
+	}
+
+	// an include is kept by WriteLit
+	n, _ = lit.ParseLit("<include src='ch03.lit'/>\n")
+	if got, want := writeLit(t, n), "<include src='ch03.lit'/>"; strings.TrimSpace(got) != want {
+		t.Errorf("WriteLit = %q, want %q", got, want)
+	}
+}
+
+func writeLit(t *testing.T, n *lit.Node) string {
+	t.Helper()
+	var b strings.Builder
+	if err := lit.WriteLit(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestParseLitIncludes(t *testing.T) {
+	// ParseLit can't resolve an include, so it warns of it
+	_, ds := lit.ParseLit("<include src='ch03.lit'/>\n")
+	if len(ds) != 1 || ds[0].Code != lit.CodeUnresolvedInclude || ds[0].Severity != lit.SeverityWarning {
+		t.Errorf("ParseLit diagnostics = %v, want an unresolved include", ds)
+	}
+
+	// nor can anything splice one into a run
+	src := "¶ ⦊\n  ‖ See <include src='two.lit'/> here. ⦉\n⦉\n"
+	_, ds = lit.ParseLit(src)
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadInclude || ds[0].Severity != lit.SeverityError || ds[0].Span.Start.Line != 2 {
+		t.Errorf("ParseLit diagnostics = %v, want a bad include at line 2", ds)
+	}
+	fsys := fstest.MapFS{
+		"one.lit": {Data: []byte(src)},
+		"two.lit": {Data: []byte("¶ ⦊\n  ‖ Two. ⦉\n⦉\n")},
+	}
+	n, files, ds := lit.ParseLitFile(fsys, "one.lit")
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadInclude || ds[0].Span.File != "one.lit" {
+		t.Errorf("ParseLitFile diagnostics = %v, want a bad include in one.lit", ds)
+	}
+	if want := []string{"one.lit"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	if lit.Find(n, lit.IncludeNode) != nil || lit.Find(n, lit.ParagraphNode).FirstChild.NextSibling != nil {
+		t.Errorf("ParseLitFile(one.lit) = %s, want the include dropped", writeLit(t, n))
+	}
+}
@@ -0,0 +1,203 @@
+package lit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+)
+
+// jsonNode is the JSON form of a Node, written by WriteJSON and read by
+// ParseJSON. It keeps everything WriteLit needs, so that a tree round
+// trips through it without change.
+type jsonNode struct {
+	Type      string          `json:"type"`
+	Atom      string          `json:"atom,omitempty"`
+	Data      string          `json:"data,omitempty"`
+	Attr      []jsonAttr      `json:"attr,omitempty"`
+	Token     *jsonToken      `json:"token,omitempty"`
+	JSON      json.RawMessage `json:"json,omitempty"`
+	YAML      *string         `json:"yaml,omitempty"` // as YAML, since it may have keys JSON can't
+	IsComment bool            `json:"comment,omitempty"`
+	Span      *Span           `json:"span,omitempty"`
+	Kids      []*jsonNode     `json:"kids,omitempty"`
+}
+
+type jsonAttr struct {
+	Namespace string `json:"ns,omitempty"`
+	Key       string `json:"key"`
+	Val       string `json:"val"`
+}
+
+type jsonToken struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Implicit bool   `json:"implicit,omitempty"`
+	Span     *Span  `json:"span,omitempty"`
+}
+
+var tokenTypesByName = func() map[string]TokenType {
+	m := make(map[string]TokenType)
+	for t := ErrorToken; t <= OpaqueToken; t++ {
+		m[t.String()] = t
+	}
+	return m
+}()
+
+// WriteJSON writes the tree rooted at n as JSON.
+//
+// Each node is an object with its "type", as written by NodeType.String,
+// and, if they are set, its "atom", "data", "attr", "token", "json",
+// "yaml", "comment", "span" and "kids". ParseJSON reads it back.
+func WriteJSON(w io.Writer, n *Node, opts *WriteOpts) error {
+	jn, err := toJSONNode(n)
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.SetIndent(opts.Prefix, opts.Indent)
+	if err := e.Encode(jn); err != nil {
+		return &WriteError{Op: "WriteJSON", Type: n.Type, Span: n.Span, Err: err}
+	}
+	return nil
+}
+
+func toJSONNode(n *Node) (*jsonNode, error) {
+	jn := &jsonNode{
+		Type:      n.Type.String(),
+		Atom:      n.DataAtom.String(),
+		Data:      n.Data,
+		IsComment: n.IsComment,
+	}
+	if n.Span.IsValid() {
+		s := n.Span
+		jn.Span = &s
+	}
+	for _, a := range n.Attr {
+		jn.Attr = append(jn.Attr, jsonAttr{Namespace: a.Namespace, Key: a.Key, Val: a.Val})
+	}
+	if t := n.Token; t != nil {
+		jn.Token = &jsonToken{
+			Type:     t.Type.String(),
+			Value:    t.Value,
+			Implicit: t.Implicit,
+		}
+		if t.Span.IsValid() {
+			s := t.Span
+			jn.Token.Span = &s
+		}
+	}
+	if n.JSON != nil {
+		bs, err := json.Marshal(n.JSON)
+		if err != nil {
+			return nil, &WriteError{Op: "WriteJSON", Type: n.Type, Span: n.Span, Err: err}
+		}
+		jn.JSON = bs
+	}
+	if n.YAML != nil {
+		bs, err := yaml.Marshal(n.YAML)
+		if err != nil {
+			return nil, &WriteError{Op: "WriteJSON", Type: n.Type, Span: n.Span, Err: err}
+		}
+		s := string(bs)
+		jn.YAML = &s
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		jc, err := toJSONNode(c)
+		if err != nil {
+			return nil, err
+		}
+		jn.Kids = append(jn.Kids, jc)
+	}
+	return jn, nil
+}
+
+// ParseJSON parses the JSON written by WriteJSON.
+//
+// The spans of the nodes are those recorded in the JSON,
+// relative to the source the tree was first parsed from.
+func ParseJSON(s string) (*Node, Diagnostics) {
+	var ds Diagnostics
+	var jn jsonNode
+	if err := json.Unmarshal([]byte(s), &jn); err != nil {
+		var span Span
+		var se *json.SyntaxError
+		if errors.As(err, &se) && se.Offset > 0 {
+			// the offset is just past the bad byte
+			span = newSource(s).span(int(se.Offset)-1, int(se.Offset))
+		}
+		ds.add(SeverityError, span, CodeBadJSON, "json: %v", err)
+		return &Node{Type: FragmentNode}, ds
+	}
+	n := fromJSONNode(&jn, &ds)
+	if n == nil {
+		n = &Node{Type: FragmentNode}
+	}
+	return n, ds
+}
+
+// fromJSONNode returns the node of jn, or nil if it can't be one,
+// as a token node without a token can't, which it reports in ds.
+func fromJSONNode(jn *jsonNode, ds *Diagnostics) *Node {
+	n := &Node{
+		Data:      jn.Data,
+		IsComment: jn.IsComment,
+	}
+	if jn.Span != nil {
+		n.Span = *jn.Span
+	}
+
+	t, ok := nodeTypesByName[jn.Type]
+	if !ok {
+		ds.add(SeverityError, n.Span, CodeBadJSON, "unknown node type: %q", jn.Type)
+		t = DivNode
+	}
+	if t == TokenNode && jn.Token == nil {
+		ds.add(SeverityError, n.Span, CodeBadJSON, "token node without a token")
+		return nil
+	}
+	n.Type = t
+
+	if jn.Atom != "" {
+		n.DataAtom = atom.Lookup([]byte(jn.Atom))
+		if n.DataAtom == 0 {
+			ds.add(SeverityError, n.Span, CodeBadJSON, "unknown atom: %q", jn.Atom)
+		}
+	}
+	for _, a := range jn.Attr {
+		n.Attr = append(n.Attr, Attribute{Namespace: a.Namespace, Key: a.Key, Val: a.Val})
+	}
+	if jt := jn.Token; jt != nil {
+		tt, ok := tokenTypesByName[jt.Type]
+		if !ok {
+			ds.add(SeverityError, n.Span, CodeBadJSON, "unknown token type: %q", jt.Type)
+		}
+		n.Token = &Token{Type: tt, Value: jt.Value, Implicit: jt.Implicit}
+		if jt.Span != nil {
+			n.Token.Span = *jt.Span
+		}
+	}
+	if jn.JSON != nil {
+		if err := json.Unmarshal(jn.JSON, &n.JSON); err != nil {
+			n.JSON = nil
+			ds.add(SeverityError, n.Span, CodeBadJSON, "json: %v", err)
+		}
+	}
+	if jn.YAML != nil {
+		n.YAML = make(map[interface{}]interface{})
+		if err := yaml.Unmarshal([]byte(*jn.YAML), &n.YAML); err != nil {
+			n.YAML = nil
+			ds.add(SeverityError, n.Span, CodeBadYAML, "%v", err)
+		}
+	}
+
+	for _, jc := range jn.Kids {
+		if c := fromJSONNode(jc, ds); c != nil {
+			n.AppendChild(c)
+		}
+	}
+	return n
+}
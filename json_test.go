@@ -0,0 +1,174 @@
+package lit_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("./examples/*/*.lit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "./test.lit")
+
+	for _, file := range files {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, ds := lit.ParseLit(string(bs))
+		if err := ds.Err(); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		var j bytes.Buffer
+		if err := lit.WriteJSON(&j, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		m, ds := lit.ParseJSON(j.String())
+		if err := ds.Err(); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		var want, got bytes.Buffer
+		if err := lit.WriteLit(&want, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if err := lit.WriteLit(&got, m, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%s: WriteLit differs after a round trip through JSON", file)
+		}
+		if !lit.Equal(n, m) {
+			t.Errorf("%s: tree differs after a round trip through JSON", file)
+		}
+		if n.Hash() != m.Hash() {
+			t.Errorf("%s: hash differs after a round trip through JSON", file)
+		}
+	}
+}
+
+func TestJSONPayloads(t *testing.T) {
+	n, ds := lit.ParseLit("<!--yaml\n1: one\nb: [2, 3]\n-->\n<json>{\"a\": {\"b\": [1.5, null]}}</json>\n‖ x ␣ y ⦉")
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var j bytes.Buffer
+	if err := lit.WriteJSON(&j, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	m, ds := lit.ParseJSON(j.String())
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	y := lit.Find(m, lit.YAMLNode)
+	if !y.IsComment || y.YAML[1] != "one" {
+		t.Errorf("got yaml %v, comment %t", y.YAML, y.IsComment)
+	}
+	var implicit, explicit int
+	lit.Walk(m, func(c *lit.Node) lit.WalkAction {
+		if c.Type == lit.TokenNode && c.Token.Value == "␣" {
+			if c.Token.Implicit {
+				implicit++
+			} else {
+				explicit++
+			}
+		}
+		return lit.WalkContinue
+	})
+	if implicit != 1 || explicit != 1 {
+		t.Errorf("got %d implicit and %d explicit spaces, want 1 of each", implicit, explicit)
+	}
+	if r := lit.Find(m, lit.RunNode); r.Span.Start.String() != "6:1" {
+		t.Errorf("got run at %s, want 6:1", r.Span.Start)
+	}
+	if !lit.Equal(n, m) {
+		t.Error("tree differs after a round trip through JSON")
+	}
+}
+
+func TestParseJSONDiagnostics(t *testing.T) {
+	_, ds := lit.ParseJSON("{\"type\": \"fragment\",\n \"kids\": [}")
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadJSON || ds[0].Span.Start.String() != "2:11" {
+		t.Errorf("got %v, want one %s at 2:11", ds, lit.CodeBadJSON)
+	}
+	_, ds = lit.ParseJSON(`{"type": "nope"}`)
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadJSON {
+		t.Errorf("got %v, want one %s", ds, lit.CodeBadJSON)
+	}
+}
+
+func TestParseJSONTokenWithoutToken(t *testing.T) {
+	n, ds := lit.ParseJSON(`{"type": "fragment", "kids": [{"type": "¶", "kids": [{"type": "‖", "kids": [
+		{"type": "token"},
+		{"type": "token", "token": {"type": "word", "value": "wolves"}}
+	]}]}]}`)
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadJSON || ds[0].Severity != lit.SeverityError {
+		t.Errorf("got %v, want one error %s", ds, lit.CodeBadJSON)
+	}
+	// the node is dropped, so that the writers don't meet it
+	n.Hash()
+	for name, write := range map[string]func(*bytes.Buffer) error{
+		"WriteLit":      func(b *bytes.Buffer) error { return lit.WriteLit(b, n, lit.DefaultWriteOpts) },
+		"WriteHTML":     func(b *bytes.Buffer) error { return lit.WriteHTML(b, n, lit.DefaultWriteOpts) },
+		"WriteTex":      func(b *bytes.Buffer) error { return lit.WriteTex(b, n, lit.DefaultWriteOpts) },
+		"WriteMarkdown": func(b *bytes.Buffer) error { return lit.WriteMarkdown(b, n, lit.DefaultWriteOpts) },
+		"WritePandoc":   func(b *bytes.Buffer) error { return lit.WritePandoc(b, n, lit.DefaultWriteOpts) },
+		"WriteTEI":      func(b *bytes.Buffer) error { return lit.WriteTEI(b, n, lit.DefaultWriteOpts) },
+		"WriteJSON":     func(b *bytes.Buffer) error { return lit.WriteJSON(b, n, lit.DefaultWriteOpts) },
+	} {
+		var b bytes.Buffer
+		if err := write(&b); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !bytes.Contains(b.Bytes(), []byte("wolves")) {
+			t.Errorf("%s: %q lacks the word after the dropped token", name, b.String())
+		}
+	}
+}
+
+func TestWriteLitPayloadForms(t *testing.T) {
+	for _, src := range []string{
+		"<json>{\"a\": [1, 2]}</json>",
+		"<!--json\n{\"a\": [1, 2]}\n-->",
+		"<yaml>\na: [1, 2]\n</yaml>",
+		"<!--yaml\na: [1, 2]\n-->",
+	} {
+		n, ds := lit.ParseLit(src)
+		if err := ds.Err(); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		var j bytes.Buffer
+		if err := lit.WriteJSON(&j, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatal(err)
+		}
+		m, ds := lit.ParseJSON(j.String())
+		if err := ds.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		var want, got bytes.Buffer
+		if err := lit.WriteLit(&want, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatal(err)
+		}
+		if err := lit.WriteLit(&got, m, lit.DefaultWriteOpts); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("%q: WriteLit differs after a round trip through JSON:\n%s\nwant\n%s", src, got.String(), want.String())
+		}
+		// and it is of the same form
+		o, ds := lit.ParseLit(want.String())
+		if err := ds.Err(); err != nil {
+			t.Errorf("%q: WriteLit wrote %q: %v", src, want.String(), err)
+		} else if !lit.Equal(n, o) {
+			t.Errorf("%q: WriteLit wrote %q, a different tree", src, want.String())
+		}
+	}
+}
@@ -0,0 +1,1864 @@
+package lit
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/atom"
+)
+
+// WriteMarkdown writes the tree rooted at n as CommonMark.
+//
+// Footnotes are written as [^n] and defined at the end, and display
+// math as $$ blocks, as most Markdown renderers with math expect.
+// CommonMark has no mark for an unnumbered heading, so headings are
+// numbered, or not, as the renderer numbers them.
+// Nodes Markdown has no equivalent for, such as TeX-only blocks and
+// JSON and YAML payloads, are left out.
+func WriteMarkdown(w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteMarkdown", n)
+	w = ew
+
+	s := new(markdownWriteState)
+	w.Write([]byte(opts.Prefix))
+	if err := writeMarkdown(s, w, n, opts); err != nil {
+		return err
+	}
+
+	// footnotes may have footnotes, so the list can grow
+	for i := 0; i < len(s.footnotes); i++ {
+		fmt.Fprintf(w, "\n\n%s[^%d]: ", opts.Prefix, i+1)
+		fopts := withPrefix(opts, opts.Prefix+"    ")
+		if err := writeMarkdownParagraph(s, w, s.footnotes[i], fopts); err != nil {
+			return err
+		}
+	}
+	w.Write([]byte("\n"))
+	return nil
+}
+
+type markdownWriteState struct {
+	footnotes []*Node
+	labels    *labels // of the tree, once one is needed
+}
+
+// labelsOf returns the labels of the tree n is in.
+func (s *markdownWriteState) labelsOf(n *Node) *labels {
+	if s.labels == nil {
+		s.labels = labelsOf(n)
+	}
+	return s.labels
+}
+
+// writeMarkdownParagraph writes the children of the paragraph or
+// footnote n: its runs as lines of one Markdown paragraph.
+func writeMarkdownParagraph(s *markdownWriteState, w io.Writer, n *Node, opts *WriteOpts) error {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.PrevSibling != nil {
+			if isMarkdownLine(c) && isMarkdownLine(c.PrevSibling) {
+				w.Write([]byte("\n" + opts.Prefix))
+			} else {
+				w.Write([]byte(markdownBlockSep(opts)))
+			}
+		}
+		if err := writeMarkdown(s, w, c, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMarkdown writes n. Like writeLines, it leaves the prefix of
+// the first line to the caller, and writes it on every other line.
+func writeMarkdown(s *markdownWriteState, w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteMarkdown", n)
+	w = ew
+
+	switch n.Type {
+	case FragmentNode, DivNode, CenterAlignNode, RightAlignNode, SubequationsNode:
+		return writeMarkdownBlocks(s, w, n.FirstChild, opts)
+	case ParagraphNode:
+		return writeMarkdownParagraph(s, w, n, opts)
+	case RunNode:
+		return writeMarkdownMixed(s, w, n, opts)
+	case ListItemNode:
+		if n.Parent != nil && n.Parent.Type == ListNode {
+			return writeMarkdownMixed(s, w, n, opts)
+		}
+		// an item outside a list, as slides have
+		w.Write([]byte("- "))
+		return writeMarkdownMixed(s, w, n, withPrefix(opts, opts.Prefix+"  "))
+	case ListNode:
+		for i, c := 0, n.FirstChild; c != nil; i, c = i+1, c.NextSibling {
+			if i > 0 {
+				w.Write([]byte("\n" + opts.Prefix))
+			}
+			marker := "- "
+			if getAttr(n.Attr, "list-type") == "ordered" {
+				marker = strconv.Itoa(i+1) + ". "
+			}
+			w.Write([]byte(marker))
+			iopts := withPrefix(opts, opts.Prefix+strings.Repeat(" ", len(marker)))
+			if err := writeMarkdown(s, w, c, iopts); err != nil {
+				return err
+			}
+		}
+	case SectionNode:
+		level, err := strconv.Atoi(n.SectionLevel())
+		if err != nil {
+			level = 1
+		}
+		text, err := markdownOneLine(s, n.FirstChild, opts)
+		if err != nil {
+			return err
+		}
+		w.Write([]byte(strings.Repeat("#", level) + " " + text))
+	case DisplayMathNode, EquationNode:
+		w.Write([]byte("$$"))
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			w.Write([]byte("\n" + opts.Prefix))
+			if c.Type != RunNode {
+				if err := writeMarkdown(s, w, c, opts); err != nil {
+					return err
+				}
+				continue
+			}
+			block, _ := tokenBlockStartingAt(c.FirstChild)
+			lines, err := lineBlocks(block, Tex, InMath(opts), true, markdownWidth(opts))
+			if err != nil {
+				return err
+			}
+			writeLines(w, lines, opts.Prefix, false)
+		}
+		w.Write([]byte("\n" + opts.Prefix + "$$"))
+	case QuoteNode:
+		w.Write([]byte("> "))
+		qopts := withPrefix(opts, opts.Prefix+"> ")
+		return writeMarkdownBlocks(s, w, n.FirstChild, qopts)
+	case StatementNode:
+		// Markdown has no theorems, so write what LaTeX would, e.g.,
+		// "Theorem (Cantor)." in bold, then the statement itself
+		t := getAttr(n.Attr, "type")
+		if t == "" {
+			t = "statement"
+		}
+		head := strings.ToUpper(t[:1]) + t[1:]
+		if text := getAttr(n.Attr, "text"); text != "" {
+			head += " (" + text + ")"
+		}
+		w.Write([]byte("**" + head + ".**"))
+		if n.FirstChild != nil {
+			w.Write([]byte(markdownBlockSep(opts)))
+		}
+		return writeMarkdownBlocks(s, w, n.FirstChild, opts)
+	case FigureNode:
+		return writeMarkdownBlocks(s, w, n.FirstChild, opts)
+	case CaptionNode:
+		// as LaTeX numbers it, e.g. "Figure 1." in bold, then the caption
+		text, err := markdownOneLine(s, n.FirstChild, opts)
+		if err != nil {
+			return err
+		}
+		if l, ok := s.labelsOf(n).nodes[n.Parent]; ok && n.Parent.Type == FigureNode {
+			text = "**Figure " + l.Number + ".** " + text
+		}
+		w.Write([]byte(text))
+	case ProofNode:
+		w.Write([]byte("*Proof.*"))
+		if n.FirstChild != nil {
+			w.Write([]byte(markdownBlockSep(opts)))
+		}
+		return writeMarkdownBlocks(s, w, n.FirstChild, opts)
+	case FootnoteNode:
+		s.footnotes = append(s.footnotes, n)
+		fmt.Fprintf(w, "[^%d]", len(s.footnotes))
+	case LinkNode:
+		text, err := markdownOneLine(s, n.FirstChild, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "[%s](%s)", text, markdownURL(getAttr(n.Attr, "href")))
+	case RefNode:
+		// of a paragraph, rather than a run
+		w.Write([]byte(markdownRef(s, n)))
+	case CiteNode:
+		w.Write([]byte(markdownCite(n)))
+	case ImageNode:
+		alt := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(getAttr(n.Attr, "alt"))
+		fmt.Fprintf(w, "![%s](%s)", alt, markdownURL(getAttr(n.Attr, "src")))
+	case TableNode:
+		return writeMarkdownTable(s, w, n, opts)
+	case CodeNode, PreNode:
+		text, err := codeText(n, NoPrefix(DefaultWriteOpts))
+		if err != nil {
+			return err
+		}
+		fence := "```"
+		for strings.Contains(text, fence) {
+			fence += "`"
+		}
+		w.Write([]byte(fence))
+		for _, line := range strings.Split(text, "\n") {
+			w.Write([]byte("\n" + opts.Prefix + line))
+		}
+		w.Write([]byte("\n" + opts.Prefix + fence))
+	case CommentNode:
+		w.Write([]byte("<!--" + n.Data + "-->"))
+	case OpaqueNode:
+		if n.DataAtom == atom.Hr {
+			w.Write([]byte("---"))
+		}
+	case TextNode:
+		w.Write([]byte(n.Data))
+	case TexOnlyNode, JSONNode, YAMLNode:
+	default:
+		return ErrUnsupportedNode
+	}
+	return nil
+}
+
+// writeMarkdownBlocks writes c and its next siblings as Markdown blocks,
+// with blank lines between them.
+func writeMarkdownBlocks(s *markdownWriteState, w io.Writer, c *Node, opts *WriteOpts) error {
+	var wrote bool
+	for ; c != nil; c = c.NextSibling {
+		if isMarkdownless(c) {
+			continue
+		}
+		if wrote {
+			w.Write([]byte(markdownBlockSep(opts)))
+		}
+		if err := writeMarkdown(s, w, c, opts); err != nil {
+			return err
+		}
+		wrote = true
+	}
+	return nil
+}
+
+// writeMarkdownMixed writes the tokens and inline nodes of a run
+// or list item, wrapped as lineBlocks wraps them, and any blocks
+// among them, such as display math or a nested list.
+func writeMarkdownMixed(s *markdownWriteState, w io.Writer, n *Node, opts *WriteOpts) error {
+	for c := n.FirstChild; c != nil; {
+		if c.PrevSibling != nil {
+			if isMarkdownLine(c) || c.Type == ListNode {
+				w.Write([]byte("\n" + opts.Prefix))
+			} else {
+				w.Write([]byte(markdownBlockSep(opts)))
+			}
+		}
+		if !isMarkdownInline(c) {
+			if err := writeMarkdown(s, w, c, opts); err != nil {
+				return err
+			}
+			c = c.NextSibling
+			continue
+		}
+
+		var ts []*Token
+		var err error
+		ts, c, err = markdownTokens(s, ts, c, opts)
+		if err != nil {
+			return err
+		}
+		lines, err := lineBlocks(ts, MarkdownVal, opts, true, markdownWidth(opts))
+		if err != nil {
+			return err
+		}
+		writeLines(w, markdownLines(lines), opts.Prefix, false)
+	}
+	return nil
+}
+
+// markdownTokens appends the tokens from c on to ts, up to the first
+// node that isn't inline, which it returns. An inline node becomes
+// an opaque token of its Markdown, so that lineBlocks keeps it whole.
+func markdownTokens(s *markdownWriteState, ts []*Token, c *Node, opts *WriteOpts) ([]*Token, *Node, error) {
+	for ; c != nil && isMarkdownInline(c); c = c.NextSibling {
+		if c.Type == RunNode && len(ts) > 0 {
+			break // a run starts a line of its own
+		}
+		switch c.Type {
+		case TokenNode:
+			ts = append(ts, c.Token)
+		case RunNode:
+			var err error
+			var rest *Node
+			ts, rest, err = markdownTokens(s, ts, c.FirstChild, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			if rest != nil {
+				return nil, nil, &WriteError{Op: "WriteMarkdown", Type: rest.Type, Span: rest.Span, Err: ErrUnsupportedNode}
+			}
+		case RefNode:
+			// the spaces around it are tokens of their own
+			ts = append(ts, &Token{Type: OpaqueToken, Value: markdownRef(s, c)})
+		case CiteNode:
+			ts = append(ts, &Token{Type: OpaqueToken, Value: markdownCite(c)})
+		default:
+			// a footnote's mark goes right after the text it is on
+			if c.Type == FootnoteNode && len(ts) > 0 && isSpace(ts[len(ts)-1]) {
+				ts = ts[:len(ts)-1]
+			}
+			var b bytes.Buffer
+			if err := writeMarkdown(s, &b, c, opts); err != nil {
+				return nil, nil, err
+			}
+			ts = append(ts, &Token{Type: OpaqueToken, Value: b.String()})
+			if c.Type == FootnoteNode && spaceAfterNote(c) {
+				ts = append(ts, &Token{Type: SymbolToken, Value: "␣", Implicit: true})
+			}
+		}
+	}
+	return ts, c, nil
+}
+
+// markdownRef returns the link of the ref n, to the id it refers to.
+func markdownRef(s *markdownWriteState, n *Node) string {
+	to := getAttr(n.Attr, "to")
+	return fmt.Sprintf("[%s](%s)", refText(s.labelsOf(n).ids[to]), markdownURL("#"+to))
+}
+
+// markdownOneLine writes c and its next siblings, which must be inline,
+// on one line, as for a heading or the text of a link.
+func markdownOneLine(s *markdownWriteState, c *Node, opts *WriteOpts) (string, error) {
+	ts, rest, err := markdownTokens(s, nil, c, opts)
+	if err != nil {
+		return "", err
+	}
+	if rest != nil {
+		return "", &WriteError{Op: "WriteMarkdown", Type: rest.Type, Span: rest.Span, Err: ErrUnsupportedNode}
+	}
+	lines, err := lineBlocks(ts, MarkdownVal, NoPrefix(opts), true, int(^uint(0)>>1))
+	return strings.Join(lines, " "), err
+}
+
+// writeMarkdownTable writes n as a pipe table, as GitHub has them.
+// Markdown tables need a header, so the first row is one if the
+// table has no head.
+func writeMarkdownTable(s *markdownWriteState, w io.Writer, n *Node, opts *WriteOpts) error {
+	var rows [][]string
+	var headRows int
+	addRow := func(r *Node) error {
+		var cells []string
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			text, err := markdownCell(s, c, opts)
+			if err != nil {
+				return err
+			}
+			cells = append(cells, text)
+		}
+		rows = append(rows, cells)
+		return nil
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case TableHeadNode, TableBodyNode:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if err := addRow(r); err != nil {
+					return err
+				}
+				if c.Type == TableHeadNode {
+					headRows++
+				}
+			}
+		case TableRowNode:
+			if err := addRow(c); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var cols int
+	for _, r := range rows {
+		if len(r) > cols {
+			cols = len(r)
+		}
+	}
+	var aligns []string
+	for _, r := range tableAligns(n) {
+		switch r {
+		case 'l':
+			aligns = append(aligns, ":--")
+		case 'c':
+			aligns = append(aligns, ":-:")
+		case 'r':
+			aligns = append(aligns, "--:")
+		}
+	}
+	for len(aligns) < cols {
+		aligns = append(aligns, "---")
+	}
+
+	writeRow := func(cells []string) {
+		for len(cells) < cols {
+			cells = append(cells, "")
+		}
+		w.Write([]byte("| " + strings.Join(cells, " | ") + " |"))
+	}
+	// a head of more than one row can't be, so the others join the body
+	writeRow(rows[0])
+	w.Write([]byte("\n" + opts.Prefix))
+	writeRow(aligns[:cols])
+	for _, r := range rows[1:] {
+		w.Write([]byte("\n" + opts.Prefix))
+		writeRow(r)
+	}
+	return nil
+}
+
+// markdownCell writes the runs, or paragraphs of runs, of a table
+// cell on one line, since a pipe table's cells can't have more.
+func markdownCell(s *markdownWriteState, c *Node, opts *WriteOpts) (string, error) {
+	var parts []string
+	for k := c.FirstChild; k != nil; k = k.NextSibling {
+		if isMarkdownless(k) {
+			continue
+		}
+		first := k
+		if k.Type == ParagraphNode {
+			first = k.FirstChild
+		}
+		text, err := markdownOneLine(s, first, opts)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, text)
+	}
+	return strings.ReplaceAll(strings.Join(parts, " "), "|", "\\|"), nil
+}
+
+// MarkdownVal is the tokenStringer of WriteMarkdown.
+func MarkdownVal(t *Token, inMath bool) string {
+	if inMath {
+		return Tex(t, inMath)
+	}
+	if t.Type == OpaqueToken {
+		return t.Value
+	}
+	if isSpace(t) {
+		return " "
+	}
+	switch t.Value {
+	case "‹", "›", "❬", "❭", "⧼", "⧽":
+		return "*"
+	case "«", "»", "❮", "❯":
+		return "**"
+	case "⁅", "⁆":
+		return "`"
+	case "⸤", "⸥", "↦", "↤":
+		return ""
+	case "᜶":
+		return "<br />"
+	case "＆":
+		return "&"
+	}
+	if t.Type == PunctuationToken && strings.ContainsAny(t.Value, "\\`*_[]<>#|~") {
+		var b strings.Builder
+		for _, r := range t.Value {
+			if strings.ContainsRune("\\`*_[]<>#|~", r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+	return t.Value
+}
+
+// markdownLineStart matches the start of a line that Markdown would
+// take for a list item, a heading or a quote.
+var markdownLineStart = regexp.MustCompile(`^([-+*]|[0-9]+[.)])( |$)`)
+
+// markdownLines escapes the starts of lines that wrapping made look
+// like the start of a block, e.g., "- b" in "a - b".
+func markdownLines(lines []string) []string {
+	for i, l := range lines {
+		if m := markdownLineStart.FindStringSubmatchIndex(l); m != nil {
+			end := m[3] - 1
+			lines[i] = l[:end] + "\\" + l[end:]
+		}
+	}
+	return lines
+}
+
+func markdownWidth(opts *WriteOpts) int {
+	return opts.width() - utf8.RuneCountInString(opts.Prefix)
+}
+
+// markdownBlockSep is what goes between blocks: a blank line, which,
+// in a quote, needs its >.
+func markdownBlockSep(opts *WriteOpts) string {
+	return "\n" + strings.TrimRightFunc(opts.Prefix, unicode.IsSpace) + "\n" + opts.Prefix
+}
+
+// markdownURL escapes the characters that would end a link destination.
+func markdownURL(u string) string {
+	if strings.ContainsAny(u, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(u) + ">"
+	}
+	return u
+}
+
+// markdownCite returns the citation n as pandoc's Markdown writes it,
+// e.g. [@halmos1960; @kunen1980, §3].
+func markdownCite(n *Node) string {
+	var keys []string
+	for _, k := range citeKeys(n) {
+		keys = append(keys, "@"+k)
+	}
+	s := strings.Join(keys, "; ")
+	if loc := getAttr(n.Attr, "loc"); loc != "" {
+		s += ", " + loc
+	}
+	return "[" + s + "]"
+}
+
+func isMarkdownInline(n *Node) bool {
+	switch n.Type {
+	case TokenNode, RunNode, FootnoteNode, LinkNode, ImageNode, TextNode, RefNode, CiteNode:
+		return true
+	}
+	return false
+}
+
+// isMarkdownLine reports whether n goes on its own line of the
+// Markdown paragraph it's in, rather than in a block of its own.
+func isMarkdownLine(n *Node) bool {
+	switch n.Type {
+	case RunNode, DisplayMathNode, EquationNode:
+		return true
+	}
+	return isMarkdownInline(n)
+}
+
+// isMarkdownless reports whether n writes nothing in Markdown.
+func isMarkdownless(n *Node) bool {
+	switch n.Type {
+	case TexOnlyNode, JSONNode, YAMLNode:
+		return true
+	case OpaqueNode:
+		return n.DataAtom != atom.Hr
+	}
+	return false
+}
+
+// ParseMarkdown parses CommonMark, with the extensions drafts commonly
+// use: footnotes, [^id] with [^id]: definitions, math, $…$ inline
+// and $$…$$ for display, and pipe tables, as GitHub has them.
+//
+// Paragraphs become ¶ with a run per sentence, and straight quotes and
+// dashes become typographic ones, so that WriteLit gives canonical LitTex.
+// HTML blocks are parsed as LitTex, which is a superset of HTML.
+func ParseMarkdown(s string) (*Node, Diagnostics) {
+	p := &mdParser{
+		src:   newSource(s),
+		links: make(map[string]string),
+		notes: make(map[string]*Node),
+	}
+	root := &Node{Type: FragmentNode, Span: p.src.span(0, len(s))}
+
+	var lines []mdLine
+	for off := 0; off <= len(s); {
+		end := strings.IndexByte(s[off:], '\n')
+		if end < 0 {
+			if off < len(s) {
+				lines = append(lines, mdLine{s: strings.TrimSuffix(s[off:], "\r"), off: off})
+			}
+			break
+		}
+		lines = append(lines, mdLine{s: strings.TrimSuffix(s[off:off+end], "\r"), off: off})
+		off += end + 1
+	}
+
+	// inlines wait for the blocks, since links and footnotes
+	// may be used before they are defined
+	p.blocks(lines, root)
+	for i := 0; i < len(p.pending); i++ {
+		p.inlines(p.pending[i])
+	}
+	p.resolveNotes()
+	return root, p.ds
+}
+
+// mdLine is a line of Markdown, less its newline and the markers
+// of the blocks it is in, such as the > of a quote.
+type mdLine struct {
+	s   string
+	off int // the source offset of s[0]
+}
+
+func (l mdLine) blank() bool {
+	return strings.TrimSpace(l.s) == ""
+}
+
+// indent returns the columns of l's indentation, counting
+// a tab to the next multiple of 4, and the bytes it takes.
+func (l mdLine) indent() (cols, n int) {
+	for n < len(l.s) {
+		switch l.s[n] {
+		case ' ':
+			cols++
+		case '\t':
+			cols += 4 - cols%4
+		default:
+			return cols, n
+		}
+		n++
+	}
+	return cols, n
+}
+
+// unindent drops up to cols columns of l's indentation.
+func (l mdLine) unindent(cols int) mdLine {
+	var c, n int
+	for n < len(l.s) && c < cols && (l.s[n] == ' ' || l.s[n] == '\t') {
+		if l.s[n] == '\t' {
+			c += 4 - c%4
+		} else {
+			c++
+		}
+		n++
+	}
+	return mdLine{s: l.s[n:], off: l.off + n}
+}
+
+func (l mdLine) trim() mdLine {
+	_, n := l.indent()
+	return mdLine{s: strings.TrimRight(l.s[n:], " \t"), off: l.off + n}
+}
+
+type mdParser struct {
+	src     *source
+	ds      Diagnostics
+	pending []*Node            // runs of inline text, to parse after the blocks
+	text    map[*Node][]mdLine // the lines of each pending run
+	links   map[string]string  // link reference definitions, by label
+	notes   map[string]*Node   // footnote definitions, by label
+	refs    []*Node            // footnote references, with their labels as Data
+}
+
+var (
+	mdATXHeading  = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+|$)`)
+	mdSetext      = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	mdFence       = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	mdThematic    = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdQuote       = regexp.MustCompile(`^ {0,3}> ?`)
+	mdListItem    = regexp.MustCompile(`^( {0,3})([-+*]|[0-9]{1,9}[.)])([ \t]+|$)`)
+	mdMath        = regexp.MustCompile(`^ {0,3}\$\$`)
+	mdHTML        = regexp.MustCompile(`^ {0,3}<(?:[a-zA-Z/]|!--)`)
+	mdFootnoteDef = regexp.MustCompile(`^ {0,3}\[\^([^\]\s]+)\]:[ \t]?`)
+	mdLinkDef     = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+(?:"[^"]*"|'[^']*'|\([^)]*\)))?[ \t]*$`)
+	mdUnnumbered  = regexp.MustCompile(`[ \t]*\{(?:-|\.unnumbered)\}$`)
+	mdTableDelim  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// startsBlock reports whether l starts a block that interrupts a paragraph.
+func startsBlock(l mdLine) bool {
+	return mdATXHeading.MatchString(l.s) || mdFence.MatchString(l.s) ||
+		mdThematic.MatchString(l.s) || mdQuote.MatchString(l.s) ||
+		mdListItem.MatchString(l.s) || mdMath.MatchString(l.s) ||
+		mdHTML.MatchString(l.s) || mdFootnoteDef.MatchString(l.s)
+}
+
+func (p *mdParser) span(lines []mdLine) Span {
+	last := lines[len(lines)-1]
+	return p.src.span(lines[0].off, last.off+len(last.s))
+}
+
+// addText adds a run of the lines to parent, to parse once all blocks are.
+func (p *mdParser) addText(lines []mdLine, parent *Node) {
+	r := &Node{Type: RunNode, Span: p.span(lines)}
+	if p.text == nil {
+		p.text = make(map[*Node][]mdLine)
+	}
+	p.text[r] = lines
+	p.pending = append(p.pending, r)
+	parent.AppendChild(r)
+}
+
+// blocks adds the blocks of lines to parent.
+//
+// Paragraphs become ¶, except in list items and footnotes,
+// which hold their runs directly.
+func (p *mdParser) blocks(lines []mdLine, parent *Node) {
+	// para is the paragraph that display math and text go on in;
+	// a blank line or another block ends it
+	var para *Node
+	container := func(span Span) *Node {
+		if parent.Type == ListItemNode || parent.Type == FootnoteNode {
+			return parent
+		}
+		if para == nil {
+			para = &Node{Type: ParagraphNode, Span: span}
+			parent.AppendChild(para)
+		}
+		para.Span.End = span.End
+		return para
+	}
+
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		if l.blank() {
+			para = nil
+			i++
+			continue
+		}
+
+		if cols, _ := l.indent(); cols >= 4 {
+			// indented code
+			j := i
+			for j < len(lines) && (lines[j].blank() || func() bool { c, _ := lines[j].indent(); return c >= 4 }()) {
+				j++
+			}
+			for j > i && lines[j-1].blank() {
+				j--
+			}
+			var code []string
+			for _, cl := range lines[i:j] {
+				code = append(code, cl.unindent(4).s)
+			}
+			parent.AppendChild(p.code(strings.Join(code, "\n"), p.span(lines[i:j])))
+			para = nil
+			i = j
+			continue
+		}
+
+		switch {
+		case mdFence.MatchString(l.s):
+			i = p.fencedCode(lines, i, parent)
+			para = nil
+		case mdMath.MatchString(l.s):
+			var math *Node
+			math, i = p.displayMathBlock(lines, i)
+			container(math.Span).AppendChild(math)
+		case mdATXHeading.MatchString(l.s):
+			m := mdATXHeading.FindStringSubmatchIndex(l.s)
+			text := mdLine{s: l.s[m[1]:], off: l.off + m[1]}.trim()
+			// a closing sequence of #s, if any
+			if t := strings.TrimRight(text.s, "#"); t == "" || strings.HasSuffix(t, " ") || strings.HasSuffix(t, "\t") {
+				text.s = strings.TrimRight(t, " \t")
+			}
+			parent.AppendChild(p.heading(m[3]-m[2], text, p.span([]mdLine{l})))
+			para = nil
+			i++
+		case mdThematic.MatchString(l.s):
+			parent.AppendChild(&Node{Type: OpaqueNode, DataAtom: atom.Hr, Span: p.span([]mdLine{l})})
+			para = nil
+			i++
+		case mdQuote.MatchString(l.s):
+			var inner []mdLine
+			j := i
+			for ; j < len(lines); j++ {
+				if m := mdQuote.FindStringIndex(lines[j].s); m != nil {
+					inner = append(inner, mdLine{s: lines[j].s[m[1]:], off: lines[j].off + m[1]})
+					continue
+				}
+				// a lazy continuation of the quote's paragraph
+				if lines[j].blank() || startsBlock(lines[j]) || len(inner) == 0 || inner[len(inner)-1].blank() {
+					break
+				}
+				inner = append(inner, lines[j])
+			}
+			q := &Node{Type: QuoteNode, Span: p.span(lines[i:j])}
+			p.blocks(inner, q)
+			parent.AppendChild(q)
+			para = nil
+			i = j
+		case mdListItem.MatchString(l.s):
+			i = p.list(lines, i, parent)
+			para = nil
+		case mdFootnoteDef.MatchString(l.s):
+			i = p.footnoteDef(lines, i)
+			para = nil
+		case para == nil && mdLinkDef.MatchString(l.s):
+			m := mdLinkDef.FindStringSubmatch(l.s)
+			label := mdLabel(m[1])
+			if _, ok := p.links[label]; !ok {
+				p.links[label] = m[2]
+			}
+			i++
+		case para == nil && isMdTable(lines[i:]):
+			i = p.table(lines, i, parent)
+		case mdHTML.MatchString(l.s):
+			j := i
+			for j < len(lines) && !lines[j].blank() {
+				j++
+			}
+			p.html(lines[i:j], parent)
+			para = nil
+			i = j
+		default:
+			j := i + 1
+			for j < len(lines) && !lines[j].blank() {
+				if mdSetext.MatchString(lines[j].s) {
+					break
+				}
+				// display math may go on in the paragraph, as in LaTeX
+				if startsBlock(lines[j]) && !mdLazyItem(lines[j]) && !mdMath.MatchString(lines[j].s) {
+					break
+				}
+				j++
+			}
+			if j < len(lines) && mdSetext.MatchString(lines[j].s) && para == nil {
+				level := 1
+				if strings.TrimSpace(lines[j].s)[0] == '-' {
+					level = 2
+				}
+				var text []string
+				for _, tl := range lines[i:j] {
+					text = append(text, tl.trim().s)
+				}
+				// a heading is one line; join its lines, less their offsets
+				h := mdLine{s: strings.Join(text, " "), off: lines[i].trim().off}
+				parent.AppendChild(p.heading(level, h, p.span(lines[i:j+1])))
+				i = j + 1
+				continue
+			}
+			p.addText(lines[i:j], container(p.span(lines[i:j])))
+			i = j
+		}
+	}
+}
+
+// mdLazyItem reports whether l, which starts a list item, can't
+// interrupt a paragraph: an empty item, or one not numbered 1.
+func mdLazyItem(l mdLine) bool {
+	m := mdListItem.FindStringSubmatch(l.s)
+	if m == nil {
+		return false
+	}
+	if strings.TrimSpace(l.s[len(m[0]):]) == "" {
+		return true
+	}
+	marker := m[2]
+	return marker[0] >= '0' && marker[0] <= '9' && marker[:len(marker)-1] != "1"
+}
+
+func (p *mdParser) heading(level int, text mdLine, span Span) *Node {
+	n := &Node{Type: SectionNode, Span: span}
+	numbered := true
+	if m := mdUnnumbered.FindStringIndex(text.s); m != nil {
+		text.s = text.s[:m[0]]
+		numbered = false
+	}
+	if level > 3 {
+		level = 3
+	}
+	n.setAttr("section-level", strconv.Itoa(level))
+	n.setAttr("section-numbered", strconv.FormatBool(numbered))
+	if text.s != "" {
+		p.addText([]mdLine{text}, n)
+	}
+	return n
+}
+
+// code makes a code node, holding the text as is.
+func (p *mdParser) code(text string, span Span) *Node {
+	n := &Node{Type: CodeNode, Span: span}
+	r := &Node{Type: RunNode, Span: span}
+	r.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: OpaqueToken, Value: text, Span: span}, Span: span})
+	n.AppendChild(r)
+	return n
+}
+
+func (p *mdParser) fencedCode(lines []mdLine, i int, parent *Node) int {
+	l := lines[i]
+	m := mdFence.FindStringSubmatch(l.s)
+	fence := m[1]
+	indent, _ := l.indent()
+
+	var code []string
+	j := i + 1
+	for ; j < len(lines); j++ {
+		t := strings.TrimSpace(lines[j].s)
+		if strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+			break
+		}
+		code = append(code, lines[j].unindent(indent).s)
+	}
+	if j == len(lines) {
+		p.ds.add(SeverityWarning, p.span([]mdLine{l}), CodeUnclosed, "code fence %s is not closed", fence)
+		j--
+	}
+	parent.AppendChild(p.code(strings.Join(code, "\n"), p.span(lines[i:j+1])))
+	return j + 1
+}
+
+// displayMathBlock parses the $$ block starting at lines[i],
+// and returns it and the index of the line after it.
+// isMdTable reports whether lines start with a pipe table, as GitHub
+// has them: a row of cells, and a row of as many delimiters.
+func isMdTable(lines []mdLine) bool {
+	return len(lines) > 1 && strings.Contains(lines[0].s, "|") && mdTableDelim.MatchString(lines[1].s) &&
+		len(mdCells(lines[0])) == len(mdCells(lines[1]))
+}
+
+// table adds the pipe table at lines[i] to parent, and returns the
+// index of the line after it. Its first row is its head, and its cells
+// are a run each.
+func (p *mdParser) table(lines []mdLine, i int, parent *Node) int {
+	head := mdCells(lines[i])
+	var align strings.Builder
+	aligned := false
+	for _, d := range mdCells(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(d.s, ":") && strings.HasSuffix(d.s, ":"):
+			align.WriteByte('c')
+		case strings.HasSuffix(d.s, ":"):
+			align.WriteByte('r')
+		default:
+			align.WriteByte('l')
+		}
+		aligned = aligned || strings.Contains(d.s, ":")
+	}
+
+	j := i + 2
+	for j < len(lines) && !lines[j].blank() && !startsBlock(lines[j]) {
+		j++
+	}
+	n := &Node{Type: TableNode, Span: p.span(lines[i:j])}
+	if aligned {
+		n.setAttr("align", align.String())
+	}
+	row := func(section *Node, l mdLine, cellType NodeType) {
+		tr := &Node{Type: TableRowNode, Span: p.span([]mdLine{l})}
+		cells := mdCells(l)
+		for k := range head {
+			c := &Node{Type: cellType, Span: tr.Span}
+			if k < len(cells) && cells[k].s != "" {
+				c.Span = p.span(cells[k : k+1])
+				p.addText(cells[k:k+1], c)
+			}
+			tr.AppendChild(c)
+		}
+		section.AppendChild(tr)
+	}
+	thead := &Node{Type: TableHeadNode, Span: p.span(lines[i : i+2])}
+	row(thead, lines[i], THNode)
+	n.AppendChild(thead)
+	if j > i+2 {
+		tbody := &Node{Type: TableBodyNode, Span: p.span(lines[i+2 : j])}
+		for _, l := range lines[i+2 : j] {
+			row(tbody, l, TDNode)
+		}
+		n.AppendChild(tbody)
+	}
+	parent.AppendChild(n)
+	return j
+}
+
+// mdCells splits the row of a pipe table l into its cells, less their
+// spaces and the pipes at the ends of the row. A pipe escaped with a
+// backslash is a cell's text.
+func mdCells(l mdLine) []mdLine {
+	l = l.trim()
+	s := l.s
+	start, end := 0, len(s)
+	if strings.HasPrefix(s, "|") {
+		start = 1
+	}
+	if end > start && s[end-1] == '|' && (end < 2 || s[end-2] != '\\') {
+		end--
+	}
+	var cells []mdLine
+	for i := start; i <= end; i++ {
+		if i < end-1 && s[i] == '\\' {
+			i++
+			continue
+		}
+		if i == end || s[i] == '|' {
+			cell := mdLine{s: s[start:i], off: l.off + start}.trim()
+			cells = append(cells, cell)
+			start = i + 1
+		}
+	}
+	return cells
+}
+
+func (p *mdParser) displayMathBlock(lines []mdLine, i int) (*Node, int) {
+	first := lines[i].trim()
+	first.s, first.off = first.s[2:], first.off+2
+
+	var body []mdLine
+	j := i
+	for l := first; ; {
+		if k := strings.Index(l.s, "$$"); k >= 0 {
+			body = append(body, mdLine{s: l.s[:k], off: l.off})
+			break
+		}
+		body = append(body, l)
+		j++
+		if j == len(lines) {
+			p.ds.add(SeverityWarning, p.span([]mdLine{lines[i]}), CodeUnclosed, "$$ is not closed")
+			j--
+			break
+		}
+		l = lines[j]
+	}
+	return p.displayMath(body, p.span(lines[i:j+1])), j + 1
+}
+
+// displayMath makes a ◇ of the lines, with a run for each.
+func (p *mdParser) displayMath(body []mdLine, span Span) *Node {
+	n := &Node{Type: DisplayMathNode, Span: span}
+	for _, l := range body {
+		l = l.trim()
+		if l.s == "" {
+			continue
+		}
+		var t text
+		p.mathText(&t, l.s, mdOffsets(l))
+		m := append(t.m, l.off+len(l.s))
+		ts, ds := lex(string(t.b), p.src, m)
+		p.ds = append(p.ds, ds...)
+		r := &Node{Type: RunNode, Span: p.span([]mdLine{l})}
+		for _, tok := range ts {
+			r.AppendChild(&Node{Type: TokenNode, Token: tok, Span: tok.Span})
+		}
+		n.AppendChild(r)
+	}
+	return n
+}
+
+// mathText adds the TeX s to t, with the macros lit has runes for,
+// such as \in, as the runes; om maps s to the source.
+func (p *mdParser) mathText(t *text, s string, om []int) {
+	for i := 0; i < len(s); {
+		if s[i] == '\\' {
+			if m := pandocMacroAt(s, i); m != "" {
+				t.add(string(pandocMathRunes[m]), om[i])
+				i += len(m)
+				continue
+			}
+		}
+		t.b = append(t.b, s[i])
+		t.m = append(t.m, om[i])
+		i++
+	}
+}
+
+func mdOffsets(l mdLine) []int {
+	om := make([]int, len(l.s))
+	for i := range om {
+		om[i] = l.off + i
+	}
+	return om
+}
+
+// list parses the list starting at lines[i], and returns the index
+// of the line after it.
+func (p *mdParser) list(lines []mdLine, i int, parent *Node) int {
+	list := &Node{Type: ListNode}
+	first := mdListItem.FindStringSubmatch(lines[i].s)
+	// the kind of marker: the bullet, or the delimiter after the number
+	kind := first[2][len(first[2])-1:]
+	if kind == "." || kind == ")" {
+		list.setAttr("list-type", "ordered")
+	} else {
+		list.setAttr("list-type", "unordered")
+	}
+
+	start := i
+	for i < len(lines) {
+		m := mdListItem.FindStringSubmatchIndex(lines[i].s)
+		if m == nil || lines[i].s[m[5]-1:m[5]] != kind {
+			break
+		}
+		l := lines[i]
+		// the column the item's content starts at
+		width := m[6]
+		spaces := m[7] - m[6]
+		if spaces > 4 || m[7] == len(l.s) {
+			spaces = 1
+		}
+		width += spaces
+		content := mdLine{s: "", off: l.off + len(l.s)}
+		if width <= len(l.s) {
+			content = mdLine{s: l.s[width:], off: l.off + width}
+		}
+
+		item := []mdLine{content}
+		j := i + 1
+		for ; j < len(lines); j++ {
+			lj := lines[j]
+			if lj.blank() {
+				item = append(item, lj)
+				continue
+			}
+			if cols, _ := lj.indent(); cols >= width {
+				item = append(item, lj.unindent(width))
+				continue
+			}
+			// a lazy continuation of the item's paragraph
+			if prev := item[len(item)-1]; !prev.blank() && !startsBlock(lj) {
+				item = append(item, lj)
+				continue
+			}
+			break
+		}
+		for len(item) > 1 && item[len(item)-1].blank() {
+			item = item[:len(item)-1]
+		}
+
+		n := &Node{Type: ListItemNode, Span: p.span(lines[i:j])}
+		p.blocks(item, n)
+		list.AppendChild(n)
+
+		// blank lines may separate items
+		i = j
+		k := j
+		for k < len(lines) && lines[k].blank() {
+			k++
+		}
+		if k < len(lines) && mdListItem.MatchString(lines[k].s) {
+			i = k
+		}
+	}
+	end := i
+	for end > start && lines[end-1].blank() {
+		end--
+	}
+	list.Span = p.span(lines[start:end])
+	parent.AppendChild(list)
+	return i
+}
+
+// footnoteDef parses the footnote definition starting at lines[i],
+// and returns the index of the line after it.
+func (p *mdParser) footnoteDef(lines []mdLine, i int) int {
+	l := lines[i]
+	m := mdFootnoteDef.FindStringSubmatchIndex(l.s)
+	label := l.s[m[2]:m[3]]
+	body := []mdLine{{s: l.s[m[1]:], off: l.off + m[1]}}
+
+	j := i + 1
+	for ; j < len(lines); j++ {
+		lj := lines[j]
+		if lj.blank() {
+			body = append(body, lj)
+			continue
+		}
+		if cols, _ := lj.indent(); cols >= 4 {
+			body = append(body, lj.unindent(4))
+			continue
+		}
+		if prev := body[len(body)-1]; !prev.blank() && !startsBlock(lj) {
+			body = append(body, lj)
+			continue
+		}
+		break
+	}
+	for len(body) > 1 && body[len(body)-1].blank() {
+		body = body[:len(body)-1]
+	}
+
+	n := &Node{Type: FootnoteNode, Span: p.span(lines[i:j])}
+	p.blocks(body, n)
+	if _, ok := p.notes[label]; ok {
+		p.ds.add(SeverityWarning, n.Span, CodeBadMarkdown, "footnote [^%s] is defined more than once", label)
+	} else {
+		p.notes[label] = n
+	}
+	return j
+}
+
+// html parses an HTML block as LitTex.
+func (p *mdParser) html(lines []mdLine, parent *Node) {
+	var b strings.Builder
+	var om offsetMap
+	for i, l := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+			om = append(om, lines[i-1].off+len(lines[i-1].s))
+		}
+		b.WriteString(l.s)
+		om = append(om, mdOffsets(l)...)
+	}
+	last := lines[len(lines)-1]
+	om = append(om, last.off+len(last.s))
+
+	n, ds := ParseLit(b.String())
+	// the spans are relative to the block; make them the source's
+	for _, d := range ds {
+		if d.Span.IsValid() {
+			d.Span = p.src.span(om.start(d.Span.Start.Offset), om.end(p.src, d.Span.End.Offset))
+		}
+		p.ds = append(p.ds, d)
+	}
+	Walk(n, func(c *Node) WalkAction {
+		if c.Span.IsValid() {
+			c.Span = p.src.span(om.start(c.Span.Start.Offset), om.end(p.src, c.Span.End.Offset))
+		}
+		if c.Token != nil && c.Token.Span.IsValid() {
+			c.Token.Span = c.Span
+		}
+		return WalkContinue
+	})
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		parent.AppendChild(c)
+		c = next
+	}
+}
+
+// mdLabel normalizes a link label, as CommonMark matches them.
+func mdLabel(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// inlines parses the text of the pending run r, and puts the result
+// in r's place: a run per sentence in paragraphs and footnotes, a run
+// in table cells, and tokens directly in sections and the first
+// paragraph of a list item.
+func (p *mdParser) inlines(r *Node) {
+	lines := p.text[r]
+	delete(p.text, r)
+
+	// join the lines, less their indentation and trailing spaces, except
+	// for the two that make a hard line break
+	var s strings.Builder
+	var om []int
+	for i, l := range lines {
+		_, n := l.indent()
+		l = mdLine{s: l.s[n:], off: l.off + n}
+		if i == len(lines)-1 {
+			l.s = strings.TrimRight(l.s, " \t")
+		} else if t := strings.TrimRight(l.s, " \t"); len(l.s)-len(t) == 1 || strings.HasSuffix(l.s, "\t") {
+			l.s = t
+		}
+		if i > 0 {
+			s.WriteByte('\n')
+			om = append(om, lines[i-1].off+len(lines[i-1].s))
+		}
+		s.WriteString(l.s)
+		om = append(om, mdOffsets(l)...)
+	}
+	last := lines[len(lines)-1]
+	om = append(om, last.off+len(last.s))
+
+	nodes := p.assemble(p.scan(s.String(), om))
+
+	parent := r.Parent
+	if parent.Type == THNode || parent.Type == TDNode {
+		// a cell is a line, so one run
+		parent.InsertBefore(p.run(nodes), r)
+	} else if parent.Type == SectionNode || parent.Type == ListItemNode && parent.FirstChild == r {
+		for _, n := range nodes {
+			parent.InsertBefore(n, r)
+		}
+	} else {
+		for _, n := range splitSentences(nodes) {
+			parent.InsertBefore(n, r)
+		}
+	}
+	parent.RemoveChild(r)
+}
+
+// mdItem is a piece of inline Markdown: text, a node,
+// or a run of * or _, which may open or close emphasis.
+type mdItem struct {
+	text text
+	node *Node
+
+	delim             byte
+	n, orig           int // the delimiters left, and the number at first
+	off               int // the source offset of the run
+	canOpen, canClose bool
+	before, after     string // the glyphs the run became, around what's left of it
+}
+
+// scan splits the inline Markdown s into items; om maps s to the
+// source, and has an extra entry for the end of s.
+func (p *mdParser) scan(s string, om []int) []*mdItem {
+	var items []*mdItem
+	cur := &mdItem{}
+	flush := func() {
+		if len(cur.text.b) > 0 {
+			items = append(items, cur)
+		}
+		cur = &mdItem{}
+	}
+	copyByte := func(i int) {
+		cur.text.b = append(cur.text.b, s[i])
+		cur.text.m = append(cur.text.m, om[i])
+	}
+	prevRune := func(i int) rune {
+		if i == 0 {
+			return ' '
+		}
+		r, _ := utf8.DecodeLastRuneInString(s[:i])
+		return r
+	}
+	nextRune := func(i int) rune {
+		if i >= len(s) {
+			return ' '
+		}
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		return r
+	}
+
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			cur.text.add("᜶", om[i])
+			i += 2
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(mdPunct, s[i+1]) >= 0:
+			copyByte(i + 1)
+			i += 2
+		case c == '\n':
+			// a hard break, if the line ended in two spaces
+			if k := len(cur.text.b); k >= 2 && cur.text.b[k-1] == ' ' && cur.text.b[k-2] == ' ' {
+				cur.text.b, cur.text.m = cur.text.b[:k-2], cur.text.m[:k-2]
+				cur.text.add("᜶", om[i])
+			} else {
+				cur.text.add(" ", om[i])
+			}
+			i++
+		case c == '`':
+			n := mdRun(s, i, '`')
+			end := mdClosingBackticks(s, i+n, n)
+			if end < 0 {
+				for k := 0; k < n; k++ {
+					copyByte(i + k)
+				}
+				i += n
+				continue
+			}
+			from, to := i+n, end
+			code := s[from:to]
+			if len(code) > 1 && (code[0] == ' ' || code[0] == '\n') && (code[len(code)-1] == ' ' || code[len(code)-1] == '\n') && strings.TrimSpace(code) != "" {
+				from, to = from+1, to-1
+			}
+			cur.text.add("⁅", om[i])
+			for k := from; k < to; k++ {
+				if s[k] == '\n' {
+					cur.text.add(" ", om[k])
+				} else {
+					copyByte(k)
+				}
+			}
+			cur.text.add("⁆", om[end])
+			i = end + n
+		case c == '$' && strings.HasPrefix(s[i:], "$$"):
+			end := strings.Index(s[i+2:], "$$")
+			if end < 0 {
+				copyByte(i)
+				copyByte(i + 1)
+				i += 2
+				continue
+			}
+			end += i + 2
+			var body []mdLine
+			start := i + 2
+			for k := start; k <= end; k++ {
+				if k == end || s[k] == '\n' {
+					body = append(body, mdLine{s: s[start:k], off: om[start]})
+					start = k + 1
+				}
+			}
+			flush()
+			items = append(items, &mdItem{node: p.displayMath(body, p.src.span(om[i], om[end+1]+1))})
+			i = end + 2
+		case c == '$':
+			end := mdClosingDollar(s, i)
+			if end < 0 {
+				copyByte(i)
+				i++
+				continue
+			}
+			cur.text.add("$", om[i])
+			p.mathText(&cur.text, s[i+1:end], om[i+1:end])
+			cur.text.add("$", om[end])
+			i = end + 1
+		case c == '*' || c == '_':
+			n := mdRun(s, i, c)
+			before, after := prevRune(i), nextRune(i+n)
+			left := !unicode.IsSpace(after) && (!mdIsPunct(after) || unicode.IsSpace(before) || mdIsPunct(before))
+			right := !unicode.IsSpace(before) && (!mdIsPunct(before) || unicode.IsSpace(after) || mdIsPunct(after))
+			d := &mdItem{delim: c, n: n, orig: n, off: om[i], canOpen: left, canClose: right}
+			if c == '_' {
+				d.canOpen = left && (!right || mdIsPunct(before))
+				d.canClose = right && (!left || mdIsPunct(after))
+			}
+			flush()
+			items = append(items, d)
+			i += n
+		case c == '[' && strings.HasPrefix(s[i:], "[^"):
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 || strings.ContainsAny(s[i+2:i+end], " \t\n") {
+				copyByte(i)
+				i++
+				continue
+			}
+			end += i
+			ref := &Node{Type: FootnoteNode, Data: s[i+2 : end], Span: p.src.span(om[i], om[end]+1)}
+			p.refs = append(p.refs, ref)
+			flush()
+			items = append(items, &mdItem{node: ref})
+			i = end + 1
+		case c == '[' || c == '!' && strings.HasPrefix(s[i:], "!["):
+			n, next := p.link(s, om, i)
+			if n == nil {
+				copyByte(i)
+				i++
+				continue
+			}
+			flush()
+			items = append(items, &mdItem{node: n})
+			i = next
+		case c == '<':
+			if m := mdAutolink.FindStringSubmatchIndex(s[i:]); m != nil {
+				url := s[i+m[2] : i+m[3]]
+				n := &Node{Type: LinkNode, Span: p.src.span(om[i], om[i+m[1]-1]+1)}
+				if !strings.Contains(url, ":") {
+					n.setAttr("href", "mailto:"+url)
+				} else {
+					n.setAttr("href", url)
+				}
+				n.AppendChild(p.run(p.scanText(s, om, i+m[2], i+m[3])))
+				flush()
+				items = append(items, &mdItem{node: n})
+				i += m[1]
+				continue
+			}
+			if m := mdBreakTag.FindStringIndex(s[i:]); m != nil {
+				cur.text.add("᜶", om[i])
+				i += m[1]
+				continue
+			}
+			copyByte(i)
+			i++
+		case c == '&':
+			if m := mdEntity.FindStringIndex(s[i:]); m != nil {
+				if u := html.UnescapeString(s[i : i+m[1]]); u != s[i:i+m[1]] {
+					cur.text.add(u, om[i])
+					i += m[1]
+					continue
+				}
+			}
+			copyByte(i)
+			i++
+		case c == '"':
+			if mdOpensQuote(prevRune(i)) {
+				cur.text.add("“", om[i])
+			} else {
+				cur.text.add("”", om[i])
+			}
+			i++
+		case c == '\'':
+			if mdOpensQuote(prevRune(i)) {
+				cur.text.add("‘", om[i])
+			} else {
+				cur.text.add("’", om[i])
+			}
+			i++
+		case strings.HasPrefix(s[i:], "---"):
+			cur.text.add("—", om[i])
+			i += 3
+		case strings.HasPrefix(s[i:], "--"):
+			cur.text.add("–", om[i])
+			i += 2
+		case strings.HasPrefix(s[i:], "..."):
+			cur.text.add("…", om[i])
+			i += 3
+		default:
+			copyByte(i)
+			i++
+		}
+	}
+	flush()
+	mdEmphasis(items)
+	return items
+}
+
+const mdPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+var (
+	mdAutolink = regexp.MustCompile(`^<((?:[a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^\s<>]*)|(?:[a-zA-Z0-9.!#$%&'*+/=?^_{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*))>`)
+	mdBreakTag = regexp.MustCompile(`^<br\s*/?>`)
+	mdEntity   = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+)
+
+func mdIsPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// mdOpensQuote reports whether a quote after r opens a quotation.
+func mdOpensQuote(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("([{“‘—–", r)
+}
+
+// mdRun returns the length of the run of c at s[i].
+func mdRun(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// mdClosingBackticks returns the index of the run of exactly n
+// backticks from i on, or -1 if there is none.
+func mdClosingBackticks(s string, i, n int) int {
+	for i < len(s) {
+		k := strings.IndexByte(s[i:], '`')
+		if k < 0 {
+			return -1
+		}
+		i += k
+		if m := mdRun(s, i, '`'); m == n {
+			return i
+		} else {
+			i += m
+		}
+	}
+	return -1
+}
+
+// mdClosingDollar returns the index of the $ that closes the math
+// opened at s[i], or -1 if there is none. As in pandoc, the opening $
+// must have a non-space after it, and the closing one a non-space
+// before it and no digit after it.
+func mdClosingDollar(s string, i int) int {
+	if i+1 >= len(s) || s[i+1] == ' ' || s[i+1] == '\n' {
+		return -1
+	}
+	for k := i + 1; k < len(s); k++ {
+		switch s[k] {
+		case '\\':
+			k++
+		case '$':
+			if s[k-1] != ' ' && s[k-1] != '\n' && (k+1 == len(s) || s[k+1] < '0' || s[k+1] > '9') {
+				return k
+			}
+		}
+	}
+	return -1
+}
+
+// link parses the link or image at s[i], if there is one, and
+// returns it and the index after it.
+func (p *mdParser) link(s string, om []int, i int) (*Node, int) {
+	image := s[i] == '!'
+	open := i
+	if image {
+		open++
+	}
+
+	// the matching ], skipping code spans
+	depth := 0
+	close := -1
+	for k := open; k < len(s) && close < 0; k++ {
+		switch s[k] {
+		case '\\':
+			k++
+		case '`':
+			n := mdRun(s, k, '`')
+			if end := mdClosingBackticks(s, k+n, n); end >= 0 {
+				k = end + n - 1
+			} else {
+				k += n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				close = k
+			}
+		}
+	}
+	if close < 0 {
+		return nil, 0
+	}
+
+	var dest string
+	next := close + 1
+	switch {
+	case next < len(s) && s[next] == '(':
+		k := next + 1
+		for k < len(s) && (s[k] == ' ' || s[k] == '\n') {
+			k++
+		}
+		if k < len(s) && s[k] == '<' {
+			end := strings.IndexByte(s[k:], '>')
+			if end < 0 {
+				return nil, 0
+			}
+			dest = s[k+1 : k+end]
+			k += end + 1
+		} else {
+			start, depth := k, 0
+			for ; k < len(s) && s[k] != ' ' && s[k] != '\n'; k++ {
+				if s[k] == '(' {
+					depth++
+				} else if s[k] == ')' {
+					if depth == 0 {
+						break
+					}
+					depth--
+				}
+			}
+			dest = s[start:k]
+		}
+		// a title, which lit has no use for
+		for k < len(s) && (s[k] == ' ' || s[k] == '\n') {
+			k++
+		}
+		if k < len(s) && (s[k] == '"' || s[k] == '\'' || s[k] == '(') {
+			q := s[k]
+			if q == '(' {
+				q = ')'
+			}
+			end := strings.IndexByte(s[k+1:], q)
+			if end < 0 {
+				return nil, 0
+			}
+			k += end + 2
+			for k < len(s) && (s[k] == ' ' || s[k] == '\n') {
+				k++
+			}
+		}
+		if k >= len(s) || s[k] != ')' {
+			return nil, 0
+		}
+		next = k + 1
+	case next < len(s) && s[next] == '[':
+		end := strings.IndexByte(s[next:], ']')
+		if end < 0 {
+			return nil, 0
+		}
+		label := s[next+1 : next+end]
+		if label == "" {
+			label = s[open+1 : close]
+		}
+		var ok bool
+		if dest, ok = p.links[mdLabel(label)]; !ok {
+			return nil, 0
+		}
+		next += end + 1
+	default:
+		var ok bool
+		if dest, ok = p.links[mdLabel(s[open+1:close])]; !ok {
+			return nil, 0
+		}
+	}
+
+	span := p.src.span(om[i], om[next-1]+1)
+	if image {
+		n := &Node{Type: ImageNode, Span: span}
+		n.setAttr("src", dest)
+		if alt := mdAlt(s[open+1 : close]); alt != "" {
+			n.setAttr("alt", alt)
+		}
+		return n, next
+	}
+	n := &Node{Type: LinkNode, Span: span}
+	n.setAttr("href", dest)
+	n.AppendChild(p.run(p.scanText(s, om, open+1, close)))
+	return n, next
+}
+
+// mdAlt returns the alt text of an image, its description less
+// the backslashes of its escapes and its line breaks.
+func mdAlt(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.IndexByte(mdPunct, s[i+1]) >= 0:
+			i++
+			b.WriteByte(s[i])
+		case s[i] == '\n':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// scanText scans s[i:j], as the text of a link.
+func (p *mdParser) scanText(s string, om []int, i, j int) []*Node {
+	return p.assemble(p.scan(s[i:j], om[i:j+1]))
+}
+
+// run wraps nodes in a run.
+func (p *mdParser) run(nodes []*Node) *Node {
+	r := &Node{Type: RunNode}
+	for _, n := range nodes {
+		r.AppendChild(n)
+	}
+	if len(nodes) > 0 {
+		r.Span = Span{Start: nodes[0].Span.Start, End: nodes[len(nodes)-1].Span.End}
+	}
+	return r
+}
+
+// mdEmphasis matches the runs of * and _ in items, as CommonMark does,
+// making * and _ ‹ ›, and ** and __ « ».
+func mdEmphasis(items []*mdItem) {
+	for k, closer := range items {
+		if closer.delim == 0 || !closer.canClose {
+			continue
+		}
+		for closer.n > 0 {
+			o := -1
+			for j := k - 1; j >= 0; j-- {
+				opener := items[j]
+				if opener.delim != closer.delim || !opener.canOpen || opener.n == 0 {
+					continue
+				}
+				// the rule of 3
+				if (opener.canClose || closer.canOpen) && (opener.orig+closer.orig)%3 == 0 &&
+					!(opener.orig%3 == 0 && closer.orig%3 == 0) {
+					continue
+				}
+				o = j
+				break
+			}
+			if o < 0 {
+				break
+			}
+			opener := items[o]
+			openGlyph, closeGlyph := "‹", "›"
+			use := 1
+			if opener.n >= 2 && closer.n >= 2 {
+				openGlyph, closeGlyph = "«", "»"
+				use = 2
+			}
+			opener.n -= use
+			closer.n -= use
+			opener.after = openGlyph + opener.after
+			closer.before += closeGlyph
+			// the delimiters between can no longer match
+			for _, d := range items[o+1 : k] {
+				if d.delim != 0 {
+					d.canOpen, d.canClose = false, false
+				}
+			}
+		}
+	}
+}
+
+// assemble lexes the text of the items into tokens, among the nodes.
+//
+// The spaces next to links and images are made explicit, since lit
+// drops those next to tags.
+func (p *mdParser) assemble(items []*mdItem) []*Node {
+	var nodes []*Node
+	var t text
+	var spaceAfterNode bool
+	flush := func(beforeNode bool) {
+		if len(t.b) == 0 {
+			return
+		}
+		s := string(t.b)
+		m := append(t.m, t.m[len(t.m)-1]+1)
+		ts, ds := lex(s, p.src, m)
+		p.ds = append(p.ds, ds...)
+		if spaceAfterNode && len(ts) > 0 && isSpaceByte(s[0]) {
+			nodes = append(nodes, mdSpace(ts[0].Span))
+		}
+		for _, tok := range ts {
+			nodes = append(nodes, &Node{Type: TokenNode, Token: tok, Span: tok.Span})
+		}
+		if beforeNode && len(ts) > 0 && isSpaceByte(s[len(s)-1]) {
+			nodes = append(nodes, mdSpace(Span{}))
+		}
+		t = text{}
+	}
+
+	for _, it := range items {
+		switch {
+		case it.node != nil:
+			isTag := it.node.Type == LinkNode || it.node.Type == ImageNode
+			if isTag {
+				flush(true)
+			} else {
+				// the space before a footnote's mark is not wanted
+				if it.node.Type == FootnoteNode {
+					for len(t.b) > 0 && isSpaceByte(t.b[len(t.b)-1]) {
+						t.b, t.m = t.b[:len(t.b)-1], t.m[:len(t.m)-1]
+					}
+				}
+				flush(false)
+			}
+			nodes = append(nodes, it.node)
+			spaceAfterNode = isTag
+		case it.delim != 0:
+			t.add(it.before, it.off)
+			for k := 0; k < it.n; k++ {
+				t.add(string(it.delim), it.off)
+			}
+			t.add(it.after, it.off)
+		default:
+			t.b = append(t.b, it.text.b...)
+			t.m = append(t.m, it.text.m...)
+		}
+	}
+	flush(false)
+
+	// an explicit space needs a token on each side
+	for len(nodes) > 0 && nodes[len(nodes)-1].Type == TokenNode && isSpace(nodes[len(nodes)-1].Token) {
+		nodes = nodes[:len(nodes)-1]
+	}
+	return nodes
+}
+
+func mdSpace(span Span) *Node {
+	return &Node{Type: TokenNode, Token: &Token{Type: SymbolToken, Value: "␣", Span: span}, Span: span}
+}
+
+// resolveNotes puts the definition of each footnote in its references.
+func (p *mdParser) resolveNotes() {
+	used := make(map[string]bool)
+	for _, ref := range p.refs {
+		label := ref.Data
+		ref.Data = ""
+		def, ok := p.notes[label]
+		if !ok || isAncestor(def, ref) {
+			if !ok {
+				p.ds.add(SeverityWarning, ref.Span, CodeBadMarkdown, "footnote [^%s] is not defined", label)
+			} else {
+				p.ds.add(SeverityWarning, ref.Span, CodeBadMarkdown, "footnote [^%s] refers to itself", label)
+			}
+			ref.Parent.RemoveChild(ref)
+			continue
+		}
+		if used[label] {
+			def = def.Clone()
+		}
+		used[label] = true
+		for c := def.FirstChild; c != nil; {
+			next := c.NextSibling
+			def.RemoveChild(c)
+			ref.AppendChild(c)
+			c = next
+		}
+	}
+}
+
+func isAncestor(a, n *Node) bool {
+	for ; n != nil; n = n.Parent {
+		if n == a {
+			return true
+		}
+	}
+	return false
+}
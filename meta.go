@@ -0,0 +1,99 @@
+package lit
+
+import (
+	"fmt"
+	"time"
+)
+
+// metadata is what a document says about itself, in a JSON or YAML node
+// that leads it, as front matter, e.g.
+//
+//	<yaml>
+//	title: Naive Set Theory
+//	author: Paul R. Halmos
+//	</yaml>
+//
+// The author may be a list of authors, as may packages and
+// bibliography be lists.
+type metadata struct {
+	Title      string
+	Authors    []string
+	Language   string // e.g., "en"
+	Identifier string // e.g., an ISBN or a URN
+	Date       string // of publication
+	Modified   string // of the last change, as an RFC 3339 time
+
+	// for standalone TeX; see WriteTexDocument
+	DocumentClass string   // e.g., "article" or "[11pt]{book}"
+	Packages      []string // e.g., "amsmath" or "[a5paper]{geometry}"
+	Preamble      string   // TeX, such as macro definitions
+
+	// for citations; see ReadBibliography
+	Bibliography  []string // the BibTeX files, e.g. "refs.bib"
+	CitationStyle string   // "author-year", the default, or "numeric"
+}
+
+// readMetadata reads the metadata of the tree rooted at n, from its
+// first child other than a comment; a JSON or YAML node elsewhere, such
+// as an example in the body, isn't metadata.
+// The zero metadata means the document has none.
+func readMetadata(n *Node) metadata {
+	var fields map[string]interface{}
+	c := n.FirstChild
+	for c != nil && c.Type == CommentNode {
+		c = c.NextSibling
+	}
+	switch {
+	case c == nil:
+	case c.Type == JSONNode && c.JSON != nil:
+		fields = c.JSON
+	case c.Type == YAMLNode && c.YAML != nil:
+		fields = make(map[string]interface{})
+		for k, v := range c.YAML {
+			fields[fmt.Sprint(k)] = v
+		}
+	}
+
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			switch v := fields[k].(type) {
+			case nil:
+			case time.Time: // YAML may have decoded a date as one
+				if v.Equal(v.Truncate(24 * time.Hour)) {
+					return v.Format("2006-01-02")
+				}
+				return v.Format(time.RFC3339)
+			default:
+				return fmt.Sprint(v)
+			}
+		}
+		return ""
+	}
+	strs := func(k string) []string {
+		switch v := fields[k].(type) {
+		case nil:
+			return nil
+		case []interface{}:
+			var ss []string
+			for _, e := range v {
+				ss = append(ss, fmt.Sprint(e))
+			}
+			return ss
+		default:
+			return []string{fmt.Sprint(v)}
+		}
+	}
+	return metadata{
+		Title:         str("title"),
+		Authors:       strs("author"),
+		Language:      str("lang", "language"),
+		Identifier:    str("identifier", "isbn"),
+		Date:          str("date"),
+		Modified:      str("modified"),
+		DocumentClass: str("documentclass"),
+		Packages:      strs("packages"),
+		Preamble:      str("preamble"),
+		Bibliography:  strs("bibliography"),
+		CitationStyle: str("citation-style"),
+	}
+}
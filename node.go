@@ -21,6 +21,7 @@ type Node struct {
 	JSON      map[string]interface{}      // The JSON if Type == JSONNode
 	YAML      map[interface{}]interface{} // The YAML if Type == YAMLNode
 	IsComment bool                        // For JSON and YAML nodes, only if they are comment form
+	Span      Span                        // Where in the source the node came from, if known.
 
 	Parent                   *Node `json:"-"`
 	FirstChild, LastChild    *Node `json:"-"`
@@ -65,7 +66,14 @@ const (
 	PreNode
 	JSONNode
 	YAMLNode
-	OpaqueNode // Any other node type, for extending to lit to arbitrarty HTML
+	OpaqueNode  // Any other node type, for extending to lit to arbitrarty HTML
+	IncludeNode // An <include src='…'/>, which ParseLitFile replaces with the file
+	RefNode     // A <ref to='…'/>, to the element with the id, as ResolveRefs numbers it
+	CiteNode    // A <cite key='…' loc='…'/>, of entries of the Bibliography
+	FigureNode  // A <figure id='…' placement='…'>, of an image or table and its caption
+	CaptionNode // A <figcaption>, of the runs of a figure's caption
+
+	nodeTypeCount // the number of node types; new ones go above
 )
 
 func (t NodeType) String() string {
@@ -106,10 +114,26 @@ func (t NodeType) String() string {
 		return "image"
 	case StatementNode:
 		return "statement"
+	case SubequationsNode:
+		return "subequations"
 	case ProofNode:
-		return "statement"
+		return "proof"
 	case LinkNode:
 		return "link"
+	case TableNode:
+		return "table"
+	case TableHeadNode:
+		return "thead"
+	case TableBodyNode:
+		return "tbody"
+	case TableRowNode:
+		return "tr"
+	case THNode:
+		return "th"
+	case TDNode:
+		return "td"
+	case QuoteNode:
+		return "quote"
 	case DivNode:
 		return "div"
 	case CodeNode:
@@ -122,6 +146,16 @@ func (t NodeType) String() string {
 		return "yaml"
 	case OpaqueNode:
 		return "opaque" // do we need this? or the above? - NCL 1/25/23
+	case IncludeNode:
+		return "include"
+	case RefNode:
+		return "ref"
+	case CiteNode:
+		return "cite"
+	case FigureNode:
+		return "figure"
+	case CaptionNode:
+		return "figcaption"
 	default:
 		panic(fmt.Sprintf("unknown node type: %d", t))
 	}
@@ -223,6 +257,63 @@ func (n *Node) RemoveChild(c *Node) {
 	c.NextSibling = nil
 }
 
+// Clone returns a deep copy of the subtree rooted at n.
+//
+// The copy is detached: it has no parent and no siblings,
+// so it can be inserted anywhere, in this tree or another.
+func (n *Node) Clone() *Node {
+	m := &Node{
+		Type:      n.Type,
+		DataAtom:  n.DataAtom,
+		Data:      n.Data,
+		IsComment: n.IsComment,
+		Span:      n.Span,
+	}
+	if n.Attr != nil {
+		m.Attr = copyAttr(n.Attr)
+	}
+	if n.Token != nil {
+		t := *n.Token
+		m.Token = &t
+	}
+	if n.JSON != nil {
+		m.JSON = clonePayload(n.JSON).(map[string]interface{})
+	}
+	if n.YAML != nil {
+		m.YAML = clonePayload(n.YAML).(map[interface{}]interface{})
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		m.AppendChild(c.Clone())
+	}
+	return m
+}
+
+// clonePayload deep copies the maps and slices of decoded JSON or YAML.
+func clonePayload(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = clonePayload(e)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			m[k] = clonePayload(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = clonePayload(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
 func copyAttr(as []Attribute) []Attribute {
 	var out []Attribute = make([]Attribute, len(as))
 	for i, a := range as {
@@ -241,16 +332,6 @@ func getAttr(as []Attribute, k string) string {
 	return ""
 }
 
-func littypeOf(n *html.Node) string {
-	for _, a := range n.Attr {
-		if a.Key == "data-littype" {
-			return a.Val
-		}
-	}
-
-	return ""
-}
-
 func litlisttypeOf(a []html.Attribute) string {
 	for _, at := range a {
 		if at.Key == "data-litlisttype" {
@@ -311,7 +392,14 @@ func (n *Node) SectionLevel() string {
 
 // Convenient for templates (esp. slides) {{{
 
+// FirstTokenString is FirstTokenStringOpts with the DefaultWriteOpts.
 func (n *Node) FirstTokenString() string {
+	return n.FirstTokenStringOpts(DefaultWriteOpts)
+}
+
+// FirstTokenStringOpts returns the tokens the list item n starts with,
+// as TeX, wrapped to the width of opts.
+func (n *Node) FirstTokenStringOpts(opts *WriteOpts) string {
 	if n.Type != ListItemNode {
 		panic("FirstTokenSTring only for list items")
 	}
@@ -319,7 +407,7 @@ func (n *Node) FirstTokenString() string {
 		return ""
 	}
 	block, _ := tokenBlockStartingAt(n.FirstChild)
-	lines := lineBlocks(block, Tex, new(WriteOpts), true, maxWidth)
+	lines, _ := lineBlocks(block, Tex, new(WriteOpts), true, opts.width()) // not in math, so no error
 	if len(lines) > 1 {
 		return strings.Join(lines, "\n")
 	}
@@ -364,7 +452,7 @@ func (n *Node) headerTokenString() string {
 		panic("TokenString only for tokens")
 	}
 	block, _ := tokenBlockStartingAt(n)
-	lines := lineBlocks(block, Tex, new(WriteOpts), true, maxWidth)
+	lines, _ := lineBlocks(block, Tex, new(WriteOpts), true, maxWidth) // not in math, so no error
 	out := lines[0]
 	if len(lines) > 1 {
 		out = strings.Join(lines, " ")
@@ -24,3 +24,14 @@ func TestSetAttrAppend(t *testing.T) {
 		t.Fatalf("unexpected attrs: %+v", n.Attr)
 	}
 }
+
+func TestFirstTokenStringOpts(t *testing.T) {
+	n, _ := ParseLit("‣ a pack of wolves ⦉")
+	item := n.FirstChild
+	if got := item.FirstTokenStringOpts(&WriteOpts{Width: 10}); got != "a pack\nof wolves" {
+		t.Errorf("at width 10, got %q", got)
+	}
+	if got := item.FirstTokenString(); got != "a pack of wolves" {
+		t.Errorf("at the default width, got %q", got)
+	}
+}
@@ -0,0 +1,1582 @@
+package lit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/atom"
+)
+
+// This file converts to and from pandoc's JSON AST; see
+// https://hackage.haskell.org/package/pandoc-types for its definition.
+//
+// Nodes pandoc has no notion of are written as raw HTML of LitTex, and
+// items outside a list as lists in divs of the class item, which
+// ParsePandoc reads back, so round trips keep them.
+
+var pandocAPIVersion = []int{1, 23, 1}
+
+type pandocDoc struct {
+	APIVersion []int                  `json:"pandoc-api-version"`
+	Meta       map[string]interface{} `json:"meta"`
+	Blocks     []pandocElt            `json:"blocks"`
+}
+
+// pandocElt is a pandoc Block or Inline, with its tag T and contents C.
+type pandocElt struct {
+	T string      `json:"t"`
+	C interface{} `json:"c,omitempty"`
+}
+
+func pandocAttr(id string, classes []string, kvs [][2]string) []interface{} {
+	if classes == nil {
+		classes = []string{}
+	}
+	if kvs == nil {
+		kvs = [][2]string{}
+	}
+	return []interface{}{id, classes, kvs}
+}
+
+var pandocNoAttr = pandocAttr("", nil, nil)
+
+// WritePandoc writes the tree rooted at n as a pandoc JSON document,
+// such as pandoc reads with -f json.
+func WritePandoc(w io.Writer, n *Node, opts *WriteOpts) error {
+	blocks, err := pandocBlocks(new(pandocWriteState), n)
+	if err != nil {
+		return err
+	}
+	doc := pandocDoc{
+		APIVersion: pandocAPIVersion,
+		Meta:       map[string]interface{}{},
+		Blocks:     blocks,
+	}
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	e.SetIndent(opts.Prefix, opts.Indent)
+	if err := e.Encode(doc); err != nil {
+		return &WriteError{Op: "WritePandoc", Type: n.Type, Span: n.Span, Err: err}
+	}
+	return nil
+}
+
+// pandocWriteState is the state of a call of WritePandoc.
+type pandocWriteState struct {
+	labelCache
+}
+
+func pandocError(n *Node, err error) error {
+	if _, ok := err.(*WriteError); ok {
+		return err
+	}
+	return &WriteError{Op: "WritePandoc", Type: n.Type, Span: n.Span, Err: err}
+}
+
+// pandocBlocks returns n as blocks; a fragment is its children.
+func pandocBlocks(s *pandocWriteState, n *Node) ([]pandocElt, error) {
+	if n.Type == FragmentNode {
+		return pandocKidBlocks(s, n)
+	}
+	return pandocBlock(s, n)
+}
+
+func pandocKidBlocks(s *pandocWriteState, n *Node) ([]pandocElt, error) {
+	return pandocMixed(s, n.FirstChild, nil, "Para")
+}
+
+// pandocMixed converts the nodes from c on, up to but not including
+// end, into blocks. Consecutive tokens and inline nodes are gathered
+// into a block of type para, either "Para" or "Plain".
+func pandocMixed(s *pandocWriteState, c, end *Node, para string) ([]pandocElt, error) {
+	var blocks []pandocElt
+	for c != end {
+		if !pandocIsInline(c) {
+			bs, err := pandocBlock(s, c)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, bs...)
+			c = c.NextSibling
+			continue
+		}
+
+		start := c
+		for c != end && pandocIsInline(c) {
+			c = c.NextSibling
+		}
+		ins, err := pandocInlines(s, start, c)
+		if err != nil {
+			return nil, err
+		}
+		if len(ins) > 0 {
+			blocks = append(blocks, pandocElt{T: para, C: ins})
+		}
+	}
+	return blocks, nil
+}
+
+func pandocIsInline(n *Node) bool {
+	switch n.Type {
+	case TokenNode, FootnoteNode, LinkNode, TextNode, RefNode, CiteNode:
+		return true
+	}
+	return false
+}
+
+func pandocBlock(s *pandocWriteState, n *Node) ([]pandocElt, error) {
+	switch n.Type {
+	case FragmentNode:
+		return pandocKidBlocks(s, n)
+	case ParagraphNode:
+		return pandocParagraph(s, n)
+	case RunNode:
+		ins, err := pandocInlines(s, n.FirstChild, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "Para", C: ins}}, nil
+	case DisplayMathNode:
+		m, err := pandocMath(n)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "Para", C: []pandocElt{m}}}, nil
+	case ListNode:
+		var items [][]pandocElt
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			var item []pandocElt
+			var err error
+			if c.Type == ListItemNode {
+				item, err = pandocMixed(s, c.FirstChild, nil, "Plain")
+			} else {
+				item, err = pandocBlock(s, c)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if item == nil {
+				item = []pandocElt{}
+			}
+			items = append(items, item)
+		}
+		if items == nil {
+			items = [][]pandocElt{}
+		}
+		if getAttr(n.Attr, "list-type") == "ordered" {
+			style := []interface{}{1, pandocElt{T: "Decimal"}, pandocElt{T: "Period"}}
+			return []pandocElt{{T: "OrderedList", C: []interface{}{style, items}}}, nil
+		}
+		return []pandocElt{{T: "BulletList", C: items}}, nil
+	case ListItemNode:
+		// an item outside a list, as slides have; pandoc needs the list,
+		// and the div tells ParsePandoc to leave it out
+		item, err := pandocMixed(s, n.FirstChild, nil, "Plain")
+		if err != nil {
+			return nil, err
+		}
+		list := pandocElt{T: "BulletList", C: [][]pandocElt{pandocNonNil(item)}}
+		return []pandocElt{{T: "Div", C: []interface{}{pandocAttr("", []string{"item"}, nil), []pandocElt{list}}}}, nil
+	case SectionNode:
+		level, err := strconv.Atoi(n.SectionLevel())
+		if err != nil {
+			level = 1
+		}
+		var classes []string
+		if !n.SectionNumbered() {
+			classes = []string{"unnumbered"}
+		}
+		ins, err := pandocInlines(s, n.FirstChild, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "Header", C: []interface{}{level, pandocAttr("", classes, nil), ins}}}, nil
+	case QuoteNode:
+		bs, err := pandocKidBlocks(s, n)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "BlockQuote", C: pandocNonNil(bs)}}, nil
+	case StatementNode, ProofNode, CenterAlignNode, RightAlignNode, SubequationsNode:
+		var id string
+		var classes []string
+		var kvs [][2]string
+		switch n.Type {
+		case StatementNode:
+			id = getAttr(n.Attr, "id")
+			classes = []string{"statement"}
+			if t := getAttr(n.Attr, "type"); t != "" {
+				classes = append(classes, t)
+			}
+			if text := getAttr(n.Attr, "text"); text != "" {
+				kvs = [][2]string{{"text", text}}
+			}
+		case ProofNode:
+			classes = []string{"proof"}
+		case CenterAlignNode:
+			classes = []string{"center"}
+		case RightAlignNode:
+			classes = []string{"right"}
+		case SubequationsNode:
+			classes = []string{"subequations"}
+		}
+		bs, err := pandocKidBlocks(s, n)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "Div", C: []interface{}{pandocAttr(id, classes, kvs), pandocNonNil(bs)}}}, nil
+	case EquationNode:
+		m, err := pandocMath(n)
+		if err != nil {
+			return nil, err
+		}
+		attr := pandocAttr(getAttr(n.Attr, "id"), []string{"equation"}, nil)
+		body := []pandocElt{{T: "Para", C: []pandocElt{m}}}
+		return []pandocElt{{T: "Div", C: []interface{}{attr, body}}}, nil
+	case ImageNode:
+		return []pandocElt{{T: "Para", C: []pandocElt{pandocImage(n)}}}, nil
+	case FigureNode:
+		var kvs [][2]string
+		if placement := getAttr(n.Attr, "placement"); placement != "" {
+			kvs = [][2]string{{"placement", placement}}
+		}
+		caption := []pandocElt{}
+		var body []pandocElt
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == CaptionNode {
+				bs, err := pandocMixed(s, c.FirstChild, nil, "Plain")
+				if err != nil {
+					return nil, err
+				}
+				caption = append(caption, bs...)
+				continue
+			}
+			bs, err := pandocBlock(s, c)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, bs...)
+		}
+		attr := pandocAttr(getAttr(n.Attr, "id"), nil, kvs)
+		return []pandocElt{{T: "Figure", C: []interface{}{attr, []interface{}{nil, caption}, pandocNonNil(body)}}}, nil
+	case TableNode:
+		t, err := pandocTable(s, n)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{t}, nil
+	case CodeNode, PreNode:
+		text, err := codeText(n, NoPrefix(DefaultWriteOpts))
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "CodeBlock", C: []interface{}{pandocNoAttr, text}}}, nil
+	case TexOnlyNode:
+		var b bytes.Buffer
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := WriteTex(&b, c, NoPrefix(DefaultWriteOpts)); err != nil {
+				return nil, err
+			}
+		}
+		return []pandocElt{{T: "RawBlock", C: []interface{}{"latex", b.String()}}}, nil
+	case CommentNode, JSONNode, YAMLNode, OpaqueNode, DivNode:
+		var b bytes.Buffer
+		if err := WriteLit(&b, n, NoPrefix(DefaultWriteOpts)); err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "RawBlock", C: []interface{}{"html", b.String()}}}, nil
+	case TokenNode, FootnoteNode, LinkNode, TextNode, RefNode, CiteNode:
+		ins, err := pandocInlines(s, n, n.NextSibling)
+		if err != nil {
+			return nil, err
+		}
+		return []pandocElt{{T: "Plain", C: ins}}, nil
+	default:
+		return nil, pandocError(n, ErrUnsupportedNode)
+	}
+}
+
+func pandocNonNil(bs []pandocElt) []pandocElt {
+	if bs == nil {
+		return []pandocElt{}
+	}
+	return bs
+}
+
+// pandocParagraph writes the runs of a paragraph as one Para, with
+// soft breaks between them and any display math inline, as pandoc has it.
+// The runs share a builder, since formatting may span them.
+func pandocParagraph(s *pandocWriteState, n *Node) ([]pandocElt, error) {
+	var blocks []pandocElt
+	b := newPandocInlineBuilder(s)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case RunNode:
+			// a soft break between runs, so that ParsePandoc can split them
+			b.softBreak()
+			if err := b.nodes(c.FirstChild, nil); err != nil {
+				return nil, err
+			}
+		case DisplayMathNode:
+			m, err := pandocMath(c)
+			if err != nil {
+				return nil, err
+			}
+			b.softBreak()
+			b.add(m)
+		case RefNode, CiteNode:
+			// of the paragraph, rather than a run, but a line all the same
+			b.softBreak()
+			if err := b.nodes(c, c.NextSibling); err != nil {
+				return nil, err
+			}
+		case CommentNode:
+			// raw HTML in the paragraph, so as not to split it
+			b.softBreak()
+			b.add(pandocElt{T: "RawInline", C: []interface{}{"html", "<!--" + c.Data + "-->"}})
+		default:
+			if ins := b.done(); len(ins) > 0 {
+				blocks = append(blocks, pandocElt{T: "Para", C: ins})
+			}
+			b = newPandocInlineBuilder(s)
+			bs, err := pandocBlock(s, c)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, bs...)
+		}
+	}
+	if ins := b.done(); len(ins) > 0 {
+		blocks = append(blocks, pandocElt{T: "Para", C: ins})
+	}
+	return blocks, nil
+}
+
+// pandocMath writes display math or an equation as a DisplayMath inline.
+func pandocMath(n *Node) (pandocElt, error) {
+	tex, err := displayMathTex("WritePandoc", n)
+	if err != nil {
+		return pandocElt{}, err
+	}
+	return pandocElt{T: "Math", C: []interface{}{pandocElt{T: "DisplayMath"}, tex}}, nil
+}
+
+// displayMathTex returns the TeX of display math or an equation,
+// a line for each of its runs, for the writer op.
+func displayMathTex(op string, n *Node) (string, error) {
+	var lines []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		var b bytes.Buffer
+		if c.Type == RunNode {
+			for t := c.FirstChild; t != nil; t = t.NextSibling {
+				if t.Type != TokenNode {
+					return "", &WriteError{Op: op, Type: t.Type, Span: t.Span, Err: ErrUnsupportedNode}
+				}
+				b.WriteString(Tex(t.Token, true))
+			}
+		} else if err := WriteTex(&b, c, InMath(NoPrefix(DefaultWriteOpts))); err != nil {
+			return "", err
+		}
+		lines = append(lines, strings.TrimSpace(b.String()))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func pandocImage(n *Node) pandocElt {
+	var kvs [][2]string
+	if width := getAttr(n.Attr, "width"); width != "" {
+		kvs = [][2]string{{"width", width}}
+	}
+	alt := []pandocElt{}
+	for i, word := range strings.Fields(getAttr(n.Attr, "alt")) {
+		if i > 0 {
+			alt = append(alt, pandocElt{T: "Space"})
+		}
+		alt = append(alt, pandocElt{T: "Str", C: word})
+	}
+	target := []string{getAttr(n.Attr, "src"), ""}
+	return pandocElt{T: "Image", C: []interface{}{pandocAttr("", nil, kvs), alt, target}}
+}
+
+// pandocCite returns the citation n as pandoc's Markdown reader reads
+// [@halmos1960, §3]: a Cite of each key, the locator the suffix of the
+// last, with the Markdown as the text, which pandoc's citeproc replaces.
+func pandocCite(n *Node) pandocElt {
+	keys := citeKeys(n)
+	var cs []map[string]interface{}
+	for i, k := range keys {
+		suffix := []pandocElt{}
+		if loc := getAttr(n.Attr, "loc"); loc != "" && i == len(keys)-1 {
+			suffix = []pandocElt{{T: "Str", C: ","}, {T: "Space"}, {T: "Str", C: loc}}
+		}
+		cs = append(cs, map[string]interface{}{
+			"citationId":      k,
+			"citationPrefix":  []pandocElt{},
+			"citationSuffix":  suffix,
+			"citationMode":    pandocElt{T: "NormalCitation"},
+			"citationNoteNum": 0,
+			"citationHash":    0,
+		})
+	}
+	return pandocElt{T: "Cite", C: []interface{}{cs, []pandocElt{{T: "Str", C: markdownCite(n)}}}}
+}
+
+func pandocTable(s *pandocWriteState, n *Node) (pandocElt, error) {
+	var head, body [][]interface{}
+	var cols int
+
+	var addRow func(r *Node, inHead bool) error
+	addRow = func(r *Node, inHead bool) error {
+		var cells []interface{}
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != THNode && c.Type != TDNode {
+				return pandocError(c, ErrUnsupportedNode)
+			}
+			bs, err := pandocMixed(s, c.FirstChild, nil, "Plain")
+			if err != nil {
+				return err
+			}
+			// runs hold a cell's text; make them plain, as pandoc's are
+			for i := range bs {
+				if bs[i].T == "Para" {
+					bs[i].T = "Plain"
+				}
+			}
+			cells = append(cells, []interface{}{pandocNoAttr, pandocElt{T: "AlignDefault"}, 1, 1, pandocNonNil(bs)})
+		}
+		if cells == nil {
+			cells = []interface{}{}
+		}
+		if len(cells) > cols {
+			cols = len(cells)
+		}
+		row := []interface{}{pandocNoAttr, cells}
+		if inHead {
+			head = append(head, row)
+		} else {
+			body = append(body, row)
+		}
+		return nil
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case TableHeadNode, TableBodyNode:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.Type != TableRowNode {
+					return pandocElt{}, pandocError(r, ErrUnsupportedNode)
+				}
+				if err := addRow(r, c.Type == TableHeadNode); err != nil {
+					return pandocElt{}, err
+				}
+			}
+		case TableRowNode:
+			if err := addRow(c, false); err != nil {
+				return pandocElt{}, err
+			}
+		default:
+			return pandocElt{}, pandocError(c, ErrUnsupportedNode)
+		}
+	}
+
+	var aligns []string
+	for _, r := range tableAligns(n) {
+		switch r {
+		case 'l':
+			aligns = append(aligns, "AlignLeft")
+		case 'c':
+			aligns = append(aligns, "AlignCenter")
+		case 'r':
+			aligns = append(aligns, "AlignRight")
+		}
+	}
+	var specs []interface{}
+	for i := 0; i < cols; i++ {
+		align := "AlignDefault"
+		if i < len(aligns) {
+			align = aligns[i]
+		}
+		specs = append(specs, []interface{}{pandocElt{T: align}, pandocElt{T: "ColWidthDefault"}})
+	}
+	if specs == nil {
+		specs = []interface{}{}
+	}
+	if head == nil {
+		head = [][]interface{}{}
+	}
+	if body == nil {
+		body = [][]interface{}{}
+	}
+
+	return pandocElt{T: "Table", C: []interface{}{
+		pandocNoAttr,
+		[]interface{}{nil, []pandocElt{}}, // caption
+		specs,
+		[]interface{}{pandocNoAttr, head},
+		[]interface{}{[]interface{}{pandocNoAttr, 0, [][]interface{}{}, body}},
+		[]interface{}{pandocNoAttr, [][]interface{}{}}, // foot
+	}}, nil
+}
+
+// pandocInlines converts the nodes from c on, up to but not including
+// end, to inlines. These are tokens, with inline nodes among them.
+func pandocInlines(s *pandocWriteState, c, end *Node) ([]pandocElt, error) {
+	b := newPandocInlineBuilder(s)
+	if err := b.nodes(c, end); err != nil {
+		return nil, err
+	}
+	return b.done(), nil
+}
+
+// pandocInlineBuilder gathers inlines, keeping a stack for the
+// formatting that tokens such as ‹ and › open and close.
+type pandocInlineBuilder struct {
+	s      *pandocWriteState
+	stack  []pandocFrame
+	text   strings.Builder // the text of the Str being built
+	inMath bool
+	math   strings.Builder
+}
+
+type pandocFrame struct {
+	open    string // the token that opened the frame
+	inlines []pandocElt
+}
+
+// pandocFormats maps the tokens that open formatting to the
+// pandoc inline they make, and the token that closes them.
+var pandocFormats = map[string]struct{ elt, close string }{
+	"‹": {"Emph", "›"},
+	"«": {"Strong", "»"},
+	"❮": {"Strong", "❯"},
+	"⸤": {"SmallCaps", "⸥"},
+	"❬": {"Span", "❭"},
+	"⧼": {"Span", "⧽"},
+	"⁅": {"Code", "⁆"},
+}
+
+func newPandocInlineBuilder(s *pandocWriteState) *pandocInlineBuilder {
+	b := &pandocInlineBuilder{s: s}
+	b.push("")
+	return b
+}
+
+// nodes adds the nodes from c on, up to but not including end.
+func (b *pandocInlineBuilder) nodes(c, end *Node) error {
+	for ; c != end; c = c.NextSibling {
+		switch c.Type {
+		case TokenNode:
+			b.token(c.Token)
+		case RunNode:
+			if err := b.nodes(c.FirstChild, nil); err != nil {
+				return err
+			}
+		case FootnoteNode:
+			// its runs are one Para, as pandoc reads a note's sentences
+			bs, err := pandocParagraph(b.s, c)
+			if err != nil {
+				return err
+			}
+			// as pandoc reads a note: right after the text it is on,
+			// with the word space after it
+			b.trimSpace()
+			b.add(pandocElt{T: "Note", C: pandocNonNil(bs)})
+			if spaceAfterNote(c) {
+				b.space()
+			}
+		case LinkNode:
+			ins, err := pandocInlines(b.s, c.FirstChild, nil)
+			if err != nil {
+				return err
+			}
+			target := []string{getAttr(c.Attr, "href"), ""}
+			b.add(pandocElt{T: "Link", C: []interface{}{pandocNoAttr, ins, target}})
+		case RefNode:
+			// the spaces around it are tokens of their own
+			to := getAttr(c.Attr, "to")
+			text := []pandocElt{{T: "Str", C: refText(b.s.labelsOf(c).ids[to])}}
+			b.add(pandocElt{T: "Link", C: []interface{}{pandocNoAttr, text, []string{"#" + to, ""}}})
+		case CiteNode:
+			b.add(pandocCite(c))
+		case ImageNode:
+			b.add(pandocImage(c))
+		case DisplayMathNode:
+			m, err := pandocMath(c)
+			if err != nil {
+				return err
+			}
+			b.add(m)
+		case TextNode:
+			b.str(c.Data)
+		default:
+			var lit bytes.Buffer
+			if err := WriteLit(&lit, c, NoPrefix(DefaultWriteOpts)); err != nil {
+				return err
+			}
+			b.add(pandocElt{T: "RawInline", C: []interface{}{"html", lit.String()}})
+		}
+	}
+	return nil
+}
+
+func (b *pandocInlineBuilder) push(open string) {
+	b.stack = append(b.stack, pandocFrame{open: open})
+}
+
+func (b *pandocInlineBuilder) top() *pandocFrame {
+	return &b.stack[len(b.stack)-1]
+}
+
+func (b *pandocInlineBuilder) flushStr() {
+	if b.text.Len() > 0 {
+		b.top().inlines = append(b.top().inlines, pandocElt{T: "Str", C: b.text.String()})
+		b.text.Reset()
+	}
+}
+
+func (b *pandocInlineBuilder) add(ins ...pandocElt) {
+	b.flushStr()
+	b.top().inlines = append(b.top().inlines, ins...)
+}
+
+func (b *pandocInlineBuilder) str(s string) {
+	b.text.WriteString(s)
+}
+
+func (b *pandocInlineBuilder) space() {
+	b.flushStr()
+	ins := b.top().inlines
+	if len(ins) == 0 || pandocIsSpace(ins[len(ins)-1]) {
+		return
+	}
+	b.top().inlines = append(ins, pandocElt{T: "Space"})
+}
+
+// trimSpace drops any spaces at the end of the innermost frame.
+func (b *pandocInlineBuilder) trimSpace() {
+	b.flushStr()
+	f := b.top()
+	for len(f.inlines) > 0 && pandocIsSpace(f.inlines[len(f.inlines)-1]) {
+		f.inlines = f.inlines[:len(f.inlines)-1]
+	}
+}
+
+// softBreak adds a soft break, in place of any space before it,
+// unless nothing has been added yet.
+func (b *pandocInlineBuilder) softBreak() {
+	b.flushStr()
+	f := b.top()
+	if len(b.stack) == 1 && len(f.inlines) == 0 {
+		return
+	}
+	b.trimSpace()
+	f.inlines = append(f.inlines, pandocElt{T: "SoftBreak"})
+}
+
+func pandocIsSpace(e pandocElt) bool {
+	return e.T == "Space" || e.T == "SoftBreak"
+}
+
+// pop closes the innermost frame, into the one around it.
+func (b *pandocInlineBuilder) pop() {
+	b.flushStr()
+	f := b.stack[len(b.stack)-1]
+	b.stack = b.stack[:len(b.stack)-1]
+	ins := pandocTrimSpace(f.inlines)
+
+	var elt pandocElt
+	switch format := pandocFormats[f.open]; format.elt {
+	case "Code":
+		var text strings.Builder
+		pandocText(&text, ins)
+		elt = pandocElt{T: "Code", C: []interface{}{pandocNoAttr, text.String()}}
+	case "Span":
+		elt = pandocElt{T: "Span", C: []interface{}{pandocAttr("", []string{"term"}, nil), ins}}
+	default:
+		elt = pandocElt{T: format.elt, C: ins}
+	}
+	b.top().inlines = append(b.top().inlines, elt)
+}
+
+func (b *pandocInlineBuilder) token(t *Token) {
+	if b.inMath {
+		if t.Type == SymbolToken && t.Value == "$" {
+			b.inMath = false
+			math := strings.TrimSpace(b.math.String())
+			b.math.Reset()
+			b.add(pandocElt{T: "Math", C: []interface{}{pandocElt{T: "InlineMath"}, math}})
+			return
+		}
+		b.math.WriteString(Tex(t, true))
+		return
+	}
+
+	switch {
+	case isSpace(t):
+		b.space()
+	case t.Type == SymbolToken && t.Value == "$":
+		b.flushStr()
+		b.inMath = true
+	case t.Type == OpaqueToken:
+		b.add(pandocElt{T: "RawInline", C: []interface{}{"latex", t.Value}})
+	case t.Value == "᜶":
+		b.add(pandocElt{T: "LineBreak"})
+	case t.Value == "↦" || t.Value == "↤":
+		// indentation, which pandoc leaves to the output format
+	case t.Value == "＆":
+		b.str("&")
+	default:
+		if _, ok := pandocFormats[t.Value]; ok {
+			b.flushStr()
+			b.push(t.Value)
+			return
+		}
+		if len(b.stack) > 1 && pandocFormats[b.top().open].close == t.Value {
+			b.pop()
+			return
+		}
+		b.str(t.Value)
+	}
+}
+
+func (b *pandocInlineBuilder) done() []pandocElt {
+	if b.inMath {
+		b.token(&Token{Type: SymbolToken, Value: "$"})
+	}
+	for len(b.stack) > 1 {
+		b.pop()
+	}
+	b.flushStr()
+	return pandocNonNil(pandocTrimSpace(b.stack[0].inlines))
+}
+
+func pandocTrimSpace(ins []pandocElt) []pandocElt {
+	for len(ins) > 0 && pandocIsSpace(ins[0]) {
+		ins = ins[1:]
+	}
+	for len(ins) > 0 && pandocIsSpace(ins[len(ins)-1]) {
+		ins = ins[:len(ins)-1]
+	}
+	return ins
+}
+
+// pandocText writes the text of inlines, as for a Code inline.
+func pandocText(b *strings.Builder, ins []pandocElt) {
+	for _, in := range ins {
+		switch in.T {
+		case "Str":
+			b.WriteString(in.C.(string))
+		case "Space", "SoftBreak":
+			b.WriteString(" ")
+		case "Code":
+			b.WriteString(in.C.([]interface{})[1].(string))
+		case "Emph", "Strong", "SmallCaps":
+			pandocText(b, in.C.([]pandocElt))
+		case "Span":
+			pandocText(b, in.C.([]interface{})[1].([]pandocElt))
+		}
+	}
+}
+
+// ParsePandoc parses a pandoc JSON document, such as pandoc writes with
+// -t json. Blocks and inlines lit has no equivalent for are dropped, with
+// a warning, except that their contents are kept if they have any.
+func ParsePandoc(s string) (*Node, Diagnostics) {
+	r := &pandocReader{}
+	root := &Node{Type: FragmentNode}
+
+	var doc struct {
+		APIVersion []int         `json:"pandoc-api-version"`
+		Blocks     []pandocInput `json:"blocks"`
+	}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		r.ds.add(SeverityError, Span{}, CodeBadPandoc, "json: %v", err)
+		return root, r.ds
+	}
+	if len(doc.APIVersion) < 2 || doc.APIVersion[0] != 1 || doc.APIVersion[1] < 22 {
+		r.ds.add(SeverityWarning, Span{}, CodeBadPandoc, "pandoc api version %v; expected 1.22 or later", doc.APIVersion)
+	}
+	r.blocks(doc.Blocks, root)
+	return root, r.ds
+}
+
+// pandocInput is a pandoc Block or Inline, as read.
+type pandocInput struct {
+	T string          `json:"t"`
+	C json.RawMessage `json:"c"`
+}
+
+type pandocReader struct {
+	ds Diagnostics
+}
+
+// decode unmarshals the contents of e into vs, in order; the contents
+// must be an array if there is more than one v.
+func (r *pandocReader) decode(e pandocInput, vs ...interface{}) bool {
+	var err error
+	if len(vs) == 1 {
+		err = json.Unmarshal(e.C, vs[0])
+	} else {
+		var parts []json.RawMessage
+		err = json.Unmarshal(e.C, &parts)
+		if err == nil && len(parts) != len(vs) {
+			err = fmt.Errorf("got %d fields, want %d", len(parts), len(vs))
+		}
+		for i := 0; err == nil && i < len(vs); i++ {
+			if vs[i] != nil {
+				err = json.Unmarshal(parts[i], vs[i])
+			}
+		}
+	}
+	if err != nil {
+		r.ds.add(SeverityError, Span{}, CodeBadPandoc, "pandoc %s: %v", e.T, err)
+		return false
+	}
+	return true
+}
+
+func (r *pandocReader) unsupported(e pandocInput) {
+	r.ds.add(SeverityWarning, Span{}, CodeBadPandoc, "pandoc %s is not supported; dropped", e.T)
+}
+
+// pandocAttrIn is a pandoc Attr: an id, classes and key-value pairs.
+type pandocAttrIn struct {
+	ID      string
+	Classes []string
+	KVs     [][2]string
+}
+
+func (a *pandocAttrIn) UnmarshalJSON(bs []byte) error {
+	return json.Unmarshal(bs, &[]interface{}{&a.ID, &a.Classes, &a.KVs})
+}
+
+func (a pandocAttrIn) has(class string) bool {
+	for _, c := range a.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (a pandocAttrIn) get(k string) string {
+	for _, kv := range a.KVs {
+		if kv[0] == k {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+// blocks adds the blocks to parent.
+//
+// Paragraphs become ¶ at the top level and in block elements; in
+// footnotes, table cells and captions, they become runs.
+func (r *pandocReader) blocks(bs []pandocInput, parent *Node) {
+	for _, b := range bs {
+		r.block(b, parent)
+	}
+}
+
+func (r *pandocReader) block(b pandocInput, parent *Node) {
+	switch b.T {
+	case "Para", "Plain":
+		var ins []pandocInput
+		if r.decode(b, &ins) {
+			r.para(ins, parent)
+		}
+	case "LineBlock":
+		var lines [][]pandocInput
+		if !r.decode(b, &lines) {
+			return
+		}
+		var ins []pandocInput
+		for i, l := range lines {
+			if i > 0 {
+				ins = append(ins, pandocInput{T: "LineBreak"})
+			}
+			ins = append(ins, l...)
+		}
+		r.para(ins, parent)
+	case "Header":
+		var level int
+		var attr pandocAttrIn
+		var ins []pandocInput
+		if !r.decode(b, &level, &attr, &ins) {
+			return
+		}
+		if level < 1 {
+			level = 1
+		}
+		if level > 3 {
+			level = 3
+		}
+		n := &Node{Type: SectionNode}
+		n.setAttr("section-level", strconv.Itoa(level))
+		n.setAttr("section-numbered", strconv.FormatBool(!attr.has("unnumbered")))
+		r.inlines(ins, n)
+		r.fixSpaces(n)
+		parent.AppendChild(n)
+	case "BulletList", "OrderedList":
+		var items [][]pandocInput
+		if b.T == "BulletList" && !r.decode(b, &items) ||
+			b.T == "OrderedList" && !r.decode(b, nil, &items) {
+			return
+		}
+		n := &Node{Type: ListNode}
+		if b.T == "OrderedList" {
+			n.setAttr("list-type", "ordered")
+		} else {
+			n.setAttr("list-type", "unordered")
+		}
+		for _, item := range items {
+			n.AppendChild(r.listItem(item))
+		}
+		parent.AppendChild(n)
+	case "DefinitionList":
+		var raw [][2]json.RawMessage
+		if !r.decode(b, &raw) {
+			return
+		}
+		n := &Node{Type: ListNode}
+		n.setAttr("list-type", "unordered")
+		for _, d := range raw {
+			var term []pandocInput
+			var defs [][]pandocInput
+			if json.Unmarshal(d[0], &term) != nil || json.Unmarshal(d[1], &defs) != nil {
+				r.ds.add(SeverityError, Span{}, CodeBadPandoc, "pandoc %s: bad definition", b.T)
+				continue
+			}
+			var item []pandocInput
+			c, _ := json.Marshal(term)
+			item = append(item, pandocInput{T: "Plain", C: c})
+			for _, def := range defs {
+				item = append(item, def...)
+			}
+			n.AppendChild(r.listItem(item))
+		}
+		parent.AppendChild(n)
+	case "BlockQuote":
+		var bs []pandocInput
+		if !r.decode(b, &bs) {
+			return
+		}
+		n := &Node{Type: QuoteNode}
+		r.blocks(bs, n)
+		parent.AppendChild(n)
+	case "Div":
+		var attr pandocAttrIn
+		var bs []pandocInput
+		if !r.decode(b, &attr, &bs) {
+			return
+		}
+		r.div(attr, bs, parent)
+	case "CodeBlock":
+		var text string
+		if !r.decode(b, nil, &text) {
+			return
+		}
+		n := &Node{Type: CodeNode}
+		run := &Node{Type: RunNode}
+		run.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: OpaqueToken, Value: text}})
+		n.AppendChild(run)
+		parent.AppendChild(n)
+	case "RawBlock":
+		var format, text string
+		if !r.decode(b, &format, &text) {
+			return
+		}
+		switch format {
+		case "html":
+			n, ds := ParseLit(text)
+			for i := range ds {
+				ds[i].Span = Span{}
+			}
+			r.ds = append(r.ds, ds...)
+			for c := n.FirstChild; c != nil; {
+				next := c.NextSibling
+				n.RemoveChild(c)
+				parent.AppendChild(c)
+				c = next
+			}
+		case "latex", "tex":
+			n := &Node{Type: TexOnlyNode}
+			run := &Node{Type: RunNode}
+			run.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: OpaqueToken, Value: text}})
+			n.AppendChild(run)
+			parent.AppendChild(n)
+		default:
+			r.ds.add(SeverityWarning, Span{}, CodeBadPandoc, "pandoc RawBlock in %s is not supported; dropped", format)
+		}
+	case "HorizontalRule":
+		parent.AppendChild(&Node{Type: OpaqueNode, DataAtom: atom.Hr})
+	case "Table":
+		var caption, specs json.RawMessage
+		var head struct {
+			Attr pandocAttrIn
+			Rows []pandocRow
+		}
+		var bodies []json.RawMessage
+		var foot json.RawMessage
+		if !r.decode(b, nil, &caption, &specs, &[]interface{}{&head.Attr, &head.Rows}, &bodies, &foot) {
+			return
+		}
+		r.table(specs, head.Rows, bodies, foot, parent)
+	case "Figure":
+		var attr pandocAttrIn
+		var caption struct {
+			Short json.RawMessage
+			Long  []pandocInput
+		}
+		var bs []pandocInput
+		if !r.decode(b, &attr, &[]interface{}{&caption.Short, &caption.Long}, &bs) {
+			return
+		}
+		n := &Node{Type: FigureNode}
+		n.setAttr("id", attr.ID)
+		if placement := attr.get("placement"); placement != "" {
+			n.setAttr("placement", placement)
+		}
+		for _, b := range bs {
+			// WritePandoc writes an image as a paragraph of it alone
+			var ins []pandocInput
+			if (b.T == "Para" || b.T == "Plain") && r.decode(b, &ins) && len(ins) == 1 && ins[0].T == "Image" {
+				r.inline(ins[0], n)
+				continue
+			}
+			r.block(b, n)
+		}
+		if len(caption.Long) > 0 {
+			c := &Node{Type: CaptionNode}
+			r.blocks(caption.Long, c)
+			n.AppendChild(c)
+		}
+		parent.AppendChild(n)
+	case "Null":
+	default:
+		r.unsupported(b)
+	}
+}
+
+// para adds a paragraph of the inlines to parent. A soft break ends
+// a run, and display math, which pandoc has inline, becomes a ◇
+// between runs if it starts one.
+func (r *pandocReader) para(ins []pandocInput, parent *Node) {
+	container := parent
+	switch parent.Type {
+	case FootnoteNode, THNode, TDNode, RunNode, ListItemNode, CaptionNode:
+	default:
+		container = &Node{Type: ParagraphNode}
+		parent.AppendChild(container)
+	}
+
+	var run []pandocInput
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		n := &Node{Type: RunNode}
+		r.inlines(run, n)
+		r.fixSpaces(n)
+		if n.FirstChild != nil {
+			container.AppendChild(n)
+		}
+		run = nil
+	}
+	for _, in := range ins {
+		if in.T == "SoftBreak" {
+			flush()
+			continue
+		}
+		if c := r.comment(in); c != nil {
+			flush()
+			container.AppendChild(c)
+			continue
+		}
+		if in.T == "Math" {
+			var kind pandocInput
+			var text string
+			// display math after a break is the paragraph's; otherwise, the run's
+			if r.decode(in, &kind, &text) && kind.T == "DisplayMath" && len(run) == 0 {
+				container.AppendChild(r.displayMath(text))
+				continue
+			}
+		}
+		run = append(run, in)
+	}
+	flush()
+
+	if container != parent && container.FirstChild == nil {
+		parent.RemoveChild(container)
+	}
+}
+
+// comment returns the comment that in is, if it is raw HTML of just
+// one, as WritePandoc writes those in paragraphs, or else nil.
+func (r *pandocReader) comment(in pandocInput) *Node {
+	var format, text string
+	if in.T != "RawInline" || !r.decode(in, &format, &text) || format != "html" || !strings.HasPrefix(text, "<!--") {
+		return nil
+	}
+	n, _ := ParseLit(text)
+	c := n.FirstChild
+	if c == nil || c != n.LastChild || c.Type != CommentNode {
+		return nil
+	}
+	n.RemoveChild(c)
+	return c
+}
+
+func (r *pandocReader) displayMath(text string) *Node {
+	n := &Node{Type: DisplayMathNode}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		run := &Node{Type: RunNode}
+		r.text(untexMath(line), run)
+		r.fixSpaces(run)
+		if run.FirstChild != nil {
+			n.AppendChild(run)
+		}
+	}
+	return n
+}
+
+// listItem makes a ‣ of pandoc list item blocks; the first paragraph's
+// tokens are the item's own, and the rest of the blocks follow them.
+func (r *pandocReader) listItem(bs []pandocInput) *Node {
+	n := &Node{Type: ListItemNode}
+	for i, b := range bs {
+		if i == 0 && (b.T == "Plain" || b.T == "Para") {
+			var ins []pandocInput
+			if r.decode(b, &ins) {
+				r.inlines(ins, n)
+				r.fixSpaces(n)
+			}
+			continue
+		}
+		if b.T == "Plain" || b.T == "Para" {
+			var ins []pandocInput
+			if r.decode(b, &ins) {
+				run := &Node{Type: RunNode}
+				r.inlines(ins, run)
+				r.fixSpaces(run)
+				n.AppendChild(run)
+			}
+			continue
+		}
+		r.block(b, n)
+	}
+	return n
+}
+
+func (r *pandocReader) div(attr pandocAttrIn, bs []pandocInput, parent *Node) {
+	var n *Node
+	switch {
+	case attr.has("statement"):
+		n = &Node{Type: StatementNode}
+		n.setAttr("id", attr.ID)
+		var t string
+		for _, c := range attr.Classes {
+			if c != "statement" {
+				t = c
+			}
+		}
+		n.setAttr("type", t)
+		n.setAttr("text", attr.get("text"))
+	case attr.has("proof"):
+		n = &Node{Type: ProofNode}
+	case attr.has("center"):
+		n = &Node{Type: CenterAlignNode}
+	case attr.has("right"):
+		n = &Node{Type: RightAlignNode}
+	case attr.has("subequations"):
+		n = &Node{Type: SubequationsNode}
+	case attr.has("item"):
+		// items outside a list, in the lists pandoc needs
+		for _, b := range bs {
+			var items [][]pandocInput
+			if b.T != "BulletList" || !r.decode(b, &items) {
+				r.block(b, parent)
+				continue
+			}
+			for _, item := range items {
+				parent.AppendChild(r.listItem(item))
+			}
+		}
+		return
+	case attr.has("equation"):
+		n = &Node{Type: EquationNode}
+		n.setAttr("id", attr.ID)
+		m := &Node{Type: FragmentNode}
+		r.blocks(bs, m)
+		if d := Find(m, DisplayMathNode); d != nil {
+			for c := d.FirstChild; c != nil; {
+				next := c.NextSibling
+				d.RemoveChild(c)
+				n.AppendChild(c)
+				c = next
+			}
+		}
+		parent.AppendChild(n)
+		return
+	default:
+		// lit has no use for other divs, so keep only what's in them
+		r.blocks(bs, parent)
+		return
+	}
+	r.blocks(bs, n)
+	parent.AppendChild(n)
+}
+
+type pandocRow struct {
+	Cells []pandocCell
+}
+
+func (row *pandocRow) UnmarshalJSON(bs []byte) error {
+	var attr pandocAttrIn
+	return json.Unmarshal(bs, &[]interface{}{&attr, &row.Cells})
+}
+
+type pandocCell struct {
+	Blocks []pandocInput
+}
+
+func (cell *pandocCell) UnmarshalJSON(bs []byte) error {
+	var attr pandocAttrIn
+	var align pandocInput
+	var rowspan, colspan int
+	return json.Unmarshal(bs, &[]interface{}{&attr, &align, &rowspan, &colspan, &cell.Blocks})
+}
+
+func (r *pandocReader) table(specs json.RawMessage, head []pandocRow, bodies []json.RawMessage, foot json.RawMessage, parent *Node) {
+	n := &Node{Type: TableNode}
+
+	var colspecs [][]pandocInput
+	if err := json.Unmarshal(specs, &colspecs); err != nil {
+		r.ds.add(SeverityError, Span{}, CodeBadPandoc, "pandoc Table: %v", err)
+	}
+	var align strings.Builder
+	for _, spec := range colspecs {
+		if len(spec) == 0 {
+			continue
+		}
+		switch spec[0].T {
+		case "AlignCenter":
+			align.WriteString("c")
+		case "AlignRight":
+			align.WriteString("r")
+		default:
+			align.WriteString("l")
+		}
+	}
+	if align.Len() > 0 {
+		n.setAttr("align", align.String())
+	}
+
+	addRows := func(section *Node, rows []pandocRow, cellType NodeType) {
+		for _, row := range rows {
+			tr := &Node{Type: TableRowNode}
+			for _, cell := range row.Cells {
+				c := &Node{Type: cellType}
+				r.blocks(cell.Blocks, c)
+				tr.AppendChild(c)
+			}
+			section.AppendChild(tr)
+		}
+	}
+
+	if len(head) > 0 {
+		thead := &Node{Type: TableHeadNode}
+		addRows(thead, head, THNode)
+		n.AppendChild(thead)
+	}
+	tbody := &Node{Type: TableBodyNode}
+	for _, raw := range bodies {
+		var attr pandocAttrIn
+		var rowHeads int
+		var headRows, rows []pandocRow
+		if err := json.Unmarshal(raw, &[]interface{}{&attr, &rowHeads, &headRows, &rows}); err != nil {
+			r.ds.add(SeverityError, Span{}, CodeBadPandoc, "pandoc Table body: %v", err)
+			continue
+		}
+		addRows(tbody, headRows, THNode)
+		addRows(tbody, rows, TDNode)
+	}
+	var footAttr pandocAttrIn
+	var footRows []pandocRow
+	if err := json.Unmarshal(foot, &[]interface{}{&footAttr, &footRows}); err == nil {
+		addRows(tbody, footRows, TDNode)
+	}
+	if tbody.FirstChild != nil {
+		n.AppendChild(tbody)
+	}
+	parent.AppendChild(n)
+}
+
+// inlines adds the tokens and nodes for the inlines to parent.
+func (r *pandocReader) inlines(ins []pandocInput, parent *Node) {
+	for _, in := range ins {
+		r.inline(in, parent)
+	}
+}
+
+func (r *pandocReader) symbol(v string, parent *Node) {
+	typ := PunctuationToken
+	if c, _ := utf8.DecodeRuneInString(v); c == '$' || c == '␣' || c == '᜶' {
+		typ = SymbolToken
+	}
+	parent.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: typ, Value: v}})
+}
+
+func (r *pandocReader) space(parent *Node) {
+	parent.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: SymbolToken, Value: "␣", Implicit: true}})
+}
+
+// text adds the tokens of s to parent.
+func (r *pandocReader) text(s string, parent *Node) {
+	ts, ds := lex(s, nil, nil)
+	r.ds = append(r.ds, ds...)
+	for _, t := range ts {
+		parent.AppendChild(&Node{Type: TokenNode, Token: t})
+	}
+}
+
+// plain returns the text of ins, less its formatting, as pandoc's
+// stringify does, for the alt text of an image.
+func (r *pandocReader) plain(ins []pandocInput) string {
+	var b strings.Builder
+	for _, in := range ins {
+		switch in.T {
+		case "Str":
+			var s string
+			if r.decode(in, &s) {
+				b.WriteString(s)
+			}
+		case "Space", "SoftBreak", "LineBreak":
+			b.WriteByte(' ')
+		case "Emph", "Strong", "SmallCaps", "Underline", "Strikeout", "Superscript", "Subscript":
+			var kids []pandocInput
+			if r.decode(in, &kids) {
+				b.WriteString(r.plain(kids))
+			}
+		}
+	}
+	return b.String()
+}
+
+func (r *pandocReader) wrapped(open, close string, ins []pandocInput, parent *Node) {
+	r.symbol(open, parent)
+	r.inlines(ins, parent)
+	r.symbol(close, parent)
+}
+
+func (r *pandocReader) inline(in pandocInput, parent *Node) {
+	switch in.T {
+	case "Str":
+		var s string
+		if r.decode(in, &s) {
+			r.text(s, parent)
+		}
+	case "Space", "SoftBreak":
+		r.space(parent)
+	case "LineBreak":
+		r.symbol("᜶", parent)
+	case "Emph", "Strong", "SmallCaps", "Underline", "Strikeout", "Superscript", "Subscript":
+		var ins []pandocInput
+		if !r.decode(in, &ins) {
+			return
+		}
+		switch in.T {
+		case "Emph":
+			r.wrapped("‹", "›", ins, parent)
+		case "Strong":
+			r.wrapped("«", "»", ins, parent)
+		case "SmallCaps":
+			r.wrapped("⸤", "⸥", ins, parent)
+		default:
+			r.inlines(ins, parent)
+		}
+	case "Span":
+		var attr pandocAttrIn
+		var ins []pandocInput
+		if !r.decode(in, &attr, &ins) {
+			return
+		}
+		if attr.has("term") {
+			r.wrapped("❬", "❭", ins, parent)
+		} else {
+			r.inlines(ins, parent)
+		}
+	case "Quoted":
+		var kind pandocInput
+		var ins []pandocInput
+		if !r.decode(in, &kind, &ins) {
+			return
+		}
+		if kind.T == "SingleQuote" {
+			r.wrapped("‘", "’", ins, parent)
+		} else {
+			r.wrapped("“", "”", ins, parent)
+		}
+	case "Cite":
+		var ins []pandocInput
+		if r.decode(in, nil, &ins) {
+			r.inlines(ins, parent)
+		}
+	case "Code":
+		var text string
+		if r.decode(in, nil, &text) {
+			r.symbol("⁅", parent)
+			r.text(text, parent)
+			r.symbol("⁆", parent)
+		}
+	case "Math":
+		var kind pandocInput
+		var text string
+		if !r.decode(in, &kind, &text) {
+			return
+		}
+		if kind.T == "DisplayMath" {
+			parent.AppendChild(r.displayMath(text))
+			return
+		}
+		r.symbol("$", parent)
+		r.text(untexMath(strings.TrimSpace(text)), parent)
+		r.symbol("$", parent)
+	case "RawInline":
+		var format, text string
+		if r.decode(in, &format, &text) {
+			parent.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: OpaqueToken, Value: text}})
+		}
+	case "Link":
+		var ins []pandocInput
+		var target [2]string
+		if !r.decode(in, nil, &ins, &target) {
+			return
+		}
+		n := &Node{Type: LinkNode}
+		n.setAttr("href", target[0])
+		run := &Node{Type: RunNode}
+		r.inlines(ins, run)
+		r.fixSpaces(run)
+		n.AppendChild(run)
+		parent.AppendChild(n)
+	case "Image":
+		var attr pandocAttrIn
+		var alt []pandocInput
+		var target [2]string
+		if !r.decode(in, &attr, &alt, &target) {
+			return
+		}
+		n := &Node{Type: ImageNode}
+		n.setAttr("src", target[0])
+		if alt := r.plain(alt); alt != "" {
+			n.setAttr("alt", alt)
+		}
+		n.setAttr("width", attr.get("width"))
+		parent.AppendChild(n)
+	case "Note":
+		var bs []pandocInput
+		if !r.decode(in, &bs) {
+			return
+		}
+		n := &Node{Type: FootnoteNode}
+		r.blocks(bs, n)
+		parent.AppendChild(n)
+	default:
+		r.unsupported(in)
+	}
+}
+
+// fixSpaces drops the spaces at the ends of n's tokens, and makes
+// explicit the spaces next to links and images, which WriteLit writes
+// as tags, so that the spaces survive a round trip through lit.
+func (r *pandocReader) fixSpaces(n *Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == TokenNode && isSpace(c.Token) {
+			prev := c.PrevSibling
+			switch {
+			case prev == nil || next == nil,
+				prev.Type == TokenNode && isSpace(prev.Token):
+				n.RemoveChild(c)
+			case pandocIsTag(prev) || pandocIsTag(next):
+				c.Token.Implicit = false
+			}
+		}
+		c = next
+	}
+}
+
+func pandocIsTag(n *Node) bool {
+	return n.Type == LinkNode || n.Type == ImageNode
+}
+
+// pandocMathMacros are the macros of LatexMathReplacements,
+// longest first, so that, e.g., \subseteq is tried before \subset.
+var pandocMathMacros = func() []string {
+	var ms []string
+	for _, m := range LatexMathReplacements {
+		ms = append(ms, strings.TrimSpace(m))
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if len(ms[i]) != len(ms[j]) {
+			return len(ms[i]) > len(ms[j])
+		}
+		return ms[i] < ms[j]
+	})
+	return ms
+}()
+
+var pandocMathRunes = func() map[string]rune {
+	m := make(map[string]rune)
+	for r, macro := range LatexMathReplacements {
+		macro = strings.TrimSpace(macro)
+		if old, ok := m[macro]; !ok || r < old {
+			m[macro] = r
+		}
+	}
+	return m
+}()
+
+// untexMath replaces the macros of LatexMathReplacements in s with
+// their runes, the inverse of what Tex does in math.
+func untexMath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '\\' {
+			if m := pandocMacroAt(s, i); m != "" {
+				b.WriteRune(pandocMathRunes[m])
+				i += len(m)
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// pandocMacroAt returns the macro at s[i:], or "" if there is none.
+func pandocMacroAt(s string, i int) string {
+	for _, m := range pandocMathMacros {
+		if !strings.HasPrefix(s[i:], m) {
+			continue
+		}
+		end := i + len(m)
+		if isLetterByte(m[len(m)-1]) && end < len(s) && isLetterByte(s[end]) {
+			continue // a longer control word, e.g., \inf for \in
+		}
+		return m
+	}
+	return ""
+}
+
+func isLetterByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
@@ -0,0 +1,134 @@
+package lit_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestPandocRoundTrip(t *testing.T) {
+	for _, in := range []string{
+		"¶ ⦊\n  ‖ A ‹pack› of «wolves». ⦉\n\n  ‖ A set $x ∈ A$. ⦉\n⦉",
+		"#§ Sets ⦉\n§§ Unnumbered ⦉",
+		"⁝ ⦊\n  ‣ one ⦉\n\n  ‣ two ⦉\n⦉\n𝍫 ⦊\n  ‣ three ⦉\n⦉",
+		"¶ ⦊\n  ‖ we write ⦉\n  ◇ ⦊\n    ‖ A ⊂ B. ⦉\n  ⦉\n⦉",
+		"¶ ⦊\n  ‖ later.\n    † ⦊\n      ‖ See the end. ⦉\n    ⦉⦉\n⦉",
+		"¶ ⦊\n  ‖ later.\n    † ⦊\n      ‖ See the end. ⦉\n\n      ‖ Or the start. ⦉\n    ⦉⦉\n⦉",
+		"¶ ⦊\n  ‖ by␣\n    <a href='https://example.com'>\n      ‖ us ⦉\n    </a>\n    . ⦉\n⦉",
+		"<statement id='ext' type='axiom' text='Extension'>\n  ¶ ⦊\n    ‖ Equal. ⦉\n  ⦉\n</statement>\n<proof>\n  ¶ ⦊\n    ‖ Trivial. ⦉\n  ⦉\n</proof>",
+		"<figure id='fig:venn' placement='htbp'>\n  <img src='venn.png' width='50%'/>\n  <figcaption>\n    ‖ The sets $A$ and $B$. ⦉\n  </figcaption>\n</figure>",
+		"▦ lc ⦊\n  ═ ‖ Set ⦉ ‖ Size ⦉ ⦉\n  ─ ‖ $A ∪ B$ ⦉ ‖ 3 ⦉ ⦉\n⦉",
+		"¶ ⦊\n  ‖ One. ⦉\n\n  <!-- a note -->\n  ‖ Two. ⦉\n⦉",
+		"#§ Slide ⦉\n‣ one ⦉\n‣ two ⦉",
+	} {
+		n, ds := lit.ParseLit(in)
+		if err := ds.Err(); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		var p bytes.Buffer
+		if err := lit.WritePandoc(&p, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		m, ds := lit.ParsePandoc(p.String())
+		if len(ds) > 0 {
+			t.Fatalf("%q: %v", in, ds)
+		}
+		if !lit.Equal(n, m) {
+			var got bytes.Buffer
+			lit.WriteLit(&got, m, lit.DefaultWriteOpts)
+			t.Errorf("%q: got %q after a round trip through pandoc", in, got.String())
+		}
+	}
+}
+
+func TestWritePandoc(t *testing.T) {
+	n := lit.Must(lit.ParseLit("‖ ‹a› b $x ∈ A$ ⦉"))
+	var b bytes.Buffer
+	if err := lit.WritePandoc(&b, n, &lit.WriteOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"pandoc-api-version":[1,23,1],"meta":{},"blocks":[{"t":"Para","c":[` +
+		`{"t":"Emph","c":[{"t":"Str","c":"a"}]},{"t":"Space"},{"t":"Str","c":"b"},{"t":"Space"},` +
+		`{"t":"Math","c":[{"t":"InlineMath"},"x \\in A"]}]}]}`
+	if got := strings.TrimSpace(b.String()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWritePandocNote(t *testing.T) {
+	// as pandoc reads One two[^1] three.
+	n := lit.Must(lit.ParseLit("‖ One two\n  † ⦊\n    ‖ A note. ⦉\n  ⦉\n  three. ⦉"))
+	var b bytes.Buffer
+	if err := lit.WritePandoc(&b, n, &lit.WriteOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"t":"Str","c":"two"},{"t":"Note","c":[{"t":"Para","c":[{"t":"Str","c":"A"},{"t":"Space"},` +
+		`{"t":"Str","c":"note."}]}]},{"t":"Space"},{"t":"Str","c":"three."}`
+	if got := b.String(); !strings.Contains(got, want) {
+		t.Errorf("got\n%s\nwant it to contain\n%s", got, want)
+	}
+
+	// and a note of sentences is one Para, as pandoc reads it too
+	n = lit.Must(lit.ParseLit("‖ One\n  † ⦊\n    ‖ A note. ⦉\n    ‖ Of two. ⦉\n  ⦉ ⦉"))
+	b.Reset()
+	if err := lit.WritePandoc(&b, n, &lit.WriteOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"t":"Note","c":[{"t":"Para","c":[{"t":"Str","c":"A"},{"t":"Space"},{"t":"Str","c":"note."},` +
+		`{"t":"SoftBreak"},{"t":"Str","c":"Of"},{"t":"Space"},{"t":"Str","c":"two."}]}]}`
+	if got := b.String(); !strings.Contains(got, want) {
+		t.Errorf("got\n%s\nwant it to contain\n%s", got, want)
+	}
+}
+
+func TestWritePandocRefsOfParagraph(t *testing.T) {
+	n := lit.Must(lit.ParseLit("<statement id='ext' type='axiom'>\n  ¶ ⦊\n    ‖ Equal. ⦉\n  ⦉\n</statement>\n¶ ⦊\n  ‖ See ⦉\n  <ref to='ext'/>\n⦉"))
+	var b bytes.Buffer
+	if err := lit.WritePandoc(&b, n, &lit.WriteOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	// a line of the paragraph, as a run would be
+	want := `{"t":"Para","c":[{"t":"Str","c":"See"},{"t":"SoftBreak"},{"t":"Link","c":[["",[],[]],[{"t":"Str","c":"1"}],["#ext",""]]}]}`
+	if got := b.String(); !strings.Contains(got, want) {
+		t.Errorf("got\n%s\nwant it to contain\n%s", got, want)
+	}
+	// and so is a cite
+	n = lit.Must(lit.ParseLit("¶ ⦊\n  ‖ See ⦉\n  <cite key='halmos1960'/>\n⦉"))
+	b.Reset()
+	if err := lit.WritePandoc(&b, n, &lit.WriteOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"t":"Para","c":[{"t":"Str","c":"See"},{"t":"SoftBreak"},{"t":"Cite","c":[[{"citationHash":0,"citationId":"halmos1960"`
+	if got := b.String(); !strings.Contains(got, want) {
+		t.Errorf("got\n%s\nwant it to contain\n%s", got, want)
+	}
+}
+
+func TestParsePandoc(t *testing.T) {
+	// as written by pandoc -t json, from markdown
+	in := `{"pandoc-api-version":[1,23,1],"meta":{},"blocks":[
+{"t":"Header","c":[1,["intro",[],[]],[{"t":"Str","c":"Intro"}]]},
+{"t":"Para","c":[{"t":"Str","c":"Some"},{"t":"Space"},{"t":"Strong","c":[{"t":"Str","c":"bold"}]},
+ {"t":"SoftBreak"},{"t":"Str","c":"text"},{"t":"Space"},{"t":"Code","c":[["",[],[]],"x"]},{"t":"Str","c":"."}]},
+{"t":"BulletList","c":[[{"t":"Plain","c":[{"t":"Str","c":"one"}]}],[{"t":"Plain","c":[{"t":"Str","c":"two"}]}]]},
+{"t":"Underline","c":[]}]}`
+	n, ds := lit.ParsePandoc(in)
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadPandoc || ds[0].Severity != lit.SeverityWarning {
+		t.Errorf("got %v, want one %s warning", ds, lit.CodeBadPandoc)
+	}
+	var got bytes.Buffer
+	if err := lit.WriteLit(&got, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	want := "#§ Intro ⦉\n¶ ⦊\n  ‖ Some «bold» ⦉\n\n  ‖ text ⁅x⁆. ⦉\n⦉\n\n⁝ ⦊\n  ‣ one ⦉\n\n  ‣ two ⦉\n⦉"
+	if got.String() != want {
+		t.Errorf("got %q, want %q", got.String(), want)
+	}
+
+	_, ds = lit.ParsePandoc(`{"blocks": [`)
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadPandoc || !ds.HasErrors() {
+		t.Errorf("got %v, want one %s error", ds, lit.CodeBadPandoc)
+	}
+}
@@ -6,17 +6,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"regexp"
 	"strings"
-	"unicode/utf8"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 	"gopkg.in/yaml.v3"
 )
 
-func ParseHTML(s string) (*Node, error) {
+// ParseHTML parses HTML, as a lit tree.
+//
+// The resulting nodes have no spans; see UnmarshalHTML.
+func ParseHTML(s string) (*Node, Diagnostics) {
 	var fragment html.Node = html.Node{
 		Type:     html.ElementNode,
 		DataAtom: atom.Div,
@@ -28,153 +29,39 @@ func ParseHTML(s string) (*Node, error) {
 		},
 	}
 
+	var ds Diagnostics
 	ns, err := html.ParseFragment(bytes.NewBufferString(s), &fragment)
 	if err != nil {
-		return nil, err
+		ds.add(SeverityError, Span{}, CodeBadHTML, "%v", err)
+		return nil, ds
 	}
 	for _, n := range ns {
 		fragment.AppendChild(n)
 	}
-	nGBA, err := UnmarshalHTML(&fragment)
-	return nGBA, err
+	nGBA := unmarshalHTML(&fragment, nil, &ds)
+	return nGBA, ds
 }
 
 // Use Must like like lit.Must(lit.ParseLit(...))
-// same as template.Must in std lib
-func Must(n *Node, err error) *Node {
-	if err != nil {
+// same as template.Must in std lib; it panics
+// only if there are errors, not warnings.
+func Must(n *Node, ds Diagnostics) *Node {
+	if err := ds.Err(); err != nil {
 		panic(err)
 	}
 	return n
 }
 
-func ParseLit(s string) (*Node, error) {
-	s = litReplace(s)
-	return ParseHTML(s)
-}
-
-func litReplace(s string) string {
-	s = " " + s // to ensure a first character match,
-	// for the picrow etc escapes
-
-	s = strings.Replace(s, "\\<", "&lt;", -1)
-	s = strings.Replace(s, "\\>", "&gt;", -1)
-
-	// runs
-	re := regexp.MustCompile(`[^\\]‖`)
-	s = re.ReplaceAllString(s, "<div data-littype='"+RunClass+"'>")
-	s = strings.Replace(s, "\\‖", "‖", -1)
-
-	// pilcrow
-	re = regexp.MustCompile(`([^\\])¶⦊`)
-	s = re.ReplaceAllString(s, `$1¶ ⦊`)
-	re = regexp.MustCompile(`([^\\])¶ ⦊`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+ParagraphClass+"'>")
-	s = strings.Replace(s, "\\¶", "¶", -1)
-
-	// footnote
-	re = regexp.MustCompile(`([^\\])†⦊`)
-	s = re.ReplaceAllString(s, `$1† ⦊`)
-	re = regexp.MustCompile(`([^\\])† ⦊`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+FootnoteClass+"'>")
-	s = strings.Replace(s, "\\†", "†", -1)
-
-	// display math
-	re = regexp.MustCompile(`([^\\])◇⦊`)
-	s = re.ReplaceAllString(s, `$1◇ ⦊`)
-	re = regexp.MustCompile(`([^\\])◇ ⦊`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+DisplayMathClass+"'>")
-	s = strings.Replace(s, "\\◇", "◇", -1)
-
-	// unordered lists
-	re = regexp.MustCompile(`([^\\])⁝⦊`)
-	s = re.ReplaceAllString(s, `$1⁝ ⦊`)
-	re = regexp.MustCompile(`([^\\])⁝ ⦊`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+ListClass+"' data-litlisttype='unordered'>")
-	s = strings.Replace(s, "\\⁝", "⁝", -1)
-
-	// ordered lists
-	re = regexp.MustCompile(`([^\\])𝍫⦊`)
-	s = re.ReplaceAllString(s, `$1𝍫 ⦊`)
-	re = regexp.MustCompile(`([^\\])𝍫 ⦊`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+ListClass+"' data-litlisttype='ordered'>")
-	s = strings.Replace(s, "\\𝍫", "𝍫", -1)
-
-	// list items
-	re = regexp.MustCompile(`([^\\])‣`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+ListItemClass+"'>")
-	s = strings.Replace(s, "\\‣", "‣", -1)
-
-	// sections
-	// first, replace repeats
-	re = regexp.MustCompile(`[^\\]§+`)
-	s = re.ReplaceAllStringFunc(s, func(og string) string {
-		//		log.Printf("in: %q", og)
-		// drop the first non \ match
-		index := strings.Index(og, "§")
-		in := og[index:len(og)]
-		//log.Printf("slice: %q", og[0:index])
-		//log.Print(utf8.RuneCountInString(in))
-		out := fmt.Sprintf("%s§%d", og[0:index], utf8.RuneCountInString(in))
-		//		log.Printf("out: %q", out)
-		return out
-	})
-	// numbered
-	re = regexp.MustCompile(`#§([[:digit:]]+)`)
-	s = re.ReplaceAllString(s, "<div data-littype='"+SectionClass+"' data-litsectionlevel='$1' data-litsectionnumbered='true'>")
-	// unnumbered
-	re = regexp.MustCompile(`([^\\#])§([[:digit:]]+)`)
-	s = re.ReplaceAllString(s, "$1<div data-littype='"+SectionClass+"' data-litsectionlevel='$2' data-litsectionnumbered='false'>")
-	// section symbol
-	s = strings.Replace(s, "\\§", "§", -1)
-
-	// closes
-	// the naive single match doesn't work, misses some of them
-	// so need this more complicated thing
-	re = regexp.MustCompile(`([^\\])⦉+`)
-	s = re.ReplaceAllStringFunc(s, func(og string) string {
-		// drop the first non \ match
-		index := strings.Index(og, "⦉")
-		in := og[index:len(og)]
-		out := og[0:index]
-		for i := 0; i < utf8.RuneCountInString(in); i++ {
-			out += "</div>"
-		}
-		return out
-	})
-       // all to get the escape functionality
-	s = strings.Replace(s, "\\⦉", "⦉", -1)
-
-	// Update: Unfortunately the below doesn't work
-	// because it will write out the replacements, instead
-	// of the compact form...more to be done here.
-	// -1 hack, this should be improved to only make
-	// the replacement when in math mode, and to think
-	// through edge cases, but I think the gains in
-	// readability for now outweigh the fragileness of
-	// this solution
-	s = strings.Replace(s, "⁻¹", "^{-1}", -1)
-	// the same goes for the below
-	s = strings.Replace(s, "¹", "^{1}", -1)
-	s = strings.Replace(s, "²", "^{2}", -1)
-	s = strings.Replace(s, "₁", "_{1}", -1)
-	s = strings.Replace(s, "₂", "_{2}", -1)
-	s = strings.Replace(s, "ᵢ", "_{i}", -1)
-	s = strings.Replace(s, "ⱼ", "_{j}", -1)
-	s = strings.Replace(s, "ₖ", "_{k}", -1)
-	s = strings.Replace(s, "ₘ", "_{m}", -1)
-	s = strings.Replace(s, "ₙ", "_{n}", -1)
-
-	//	s = strings.Replace(s, "⦉", "</div>", -1)
-
-	//re = regexp.MustCompile(`\[(.+?)\]\((.+?)\)`)
-	//s = re.ReplaceAllString(s, `<a href='$2'> ‖ $1 ⦉</a>`)
-	return s
-}
-
-func ParseTex(s string) (*Node, error) {
-	for _, c := range commentsR.FindAllString(s, -1) {
-		log.Printf("dropping comment: %q", c)
+// ParseTex parses a (small) subset of LaTeX, by translating it to LitTex.
+//
+// The spans of the resulting nodes refer to that translation, not to s,
+// so the diagnostics from parsing the translation carry no spans.
+func ParseTex(s string) (*Node, Diagnostics) {
+	var ds Diagnostics
+	src := newSource(s)
+	for _, loc := range commentsR.FindAllStringIndex(s, -1) {
+		ds.add(SeverityInfo, src.span(loc[0], loc[1]-1), CodeDroppedComment,
+			"dropping comment: %q", s[loc[0]:loc[1]])
 	}
 
 	for _, r := range order {
@@ -223,7 +110,12 @@ func ParseTex(s string) (*Node, error) {
 		fmt.Fprintf(w, "⦉")
 	}
 
-	return ParseLit(b.String())
+	n, lds := ParseLit(b.String())
+	for _, d := range lds {
+		d.Span = Span{}
+		ds = append(ds, d)
+	}
+	return n, ds
 }
 
 var textitR = regexp.MustCompile(`\\textit{((.|\n)*?)}`)
@@ -289,20 +181,24 @@ var order = []*regexp.Regexp{
 
 // func MarshalHTML(n *Node) *html.Node
 
+// UnmarshalHTML converts an HTML tree to a lit tree.
+//
+// The resulting nodes have no spans, since an html.Node does not
+// record where it came from. If there are errors, the error is a
+// Diagnostics listing them.
 func UnmarshalHTML(in *html.Node) (*Node, error) {
-	return unmarshalHTML(in, nil)
+	var ds Diagnostics
+	n := unmarshalHTML(in, nil, &ds)
+	return n, ds.Err()
 }
 
-func unmarshalHTMLText(in *html.Node) (tokens []*Node, err error) {
+func unmarshalHTMLText(in *html.Node, ds *Diagnostics) (tokens []*Node) {
 	if in.Type != html.TextNode {
 		panic("lit.unmarshalHTMLText called on non-text node")
 	}
 
-	var ts []*Token
-	ts, err = Lex(in.Data)
-	if err != nil {
-		return
-	}
+	ts, lds := lex(in.Data, nil, nil)
+	*ds = append(*ds, lds...)
 
 	for _, t := range ts {
 		tn := &Node{Type: TokenNode, Token: t}
@@ -311,185 +207,214 @@ func unmarshalHTMLText(in *html.Node) (tokens []*Node, err error) {
 	return
 }
 
-func unmarshalHTML(in *html.Node, parent *Node) (*Node, error) {
-	var n Node
+// commentNode returns the node for the comment <!--data--> at span,
+// which is a JSON or YAML node if data starts with "json" or "yaml".
+// It returns nil for blank comments.
+func commentNode(data string, span Span, ds *Diagnostics) *Node {
+	if strings.TrimSpace(data) == "" {
+		return nil
+	}
 
-	switch in.Type {
-	case html.CommentNode:
-		if strings.TrimSpace(in.Data) == "" {
-			return nil, nil
+	var n = Node{Span: span}
+	switch {
+	case strings.HasPrefix(data, "yaml"):
+		n.Type = YAMLNode
+		n.IsComment = true
+		n.setPayload(strings.TrimPrefix(data, "yaml"), ds)
+	case strings.HasPrefix(data, "json"):
+		n.Type = JSONNode
+		n.IsComment = true
+		n.setPayload(strings.TrimPrefix(data, "json"), ds)
+	default:
+		n.Type = CommentNode
+		n.Data = data
+	}
+	return &n
+}
+
+// setPayload parses d as the content of a JSON or YAML node.
+//
+// If d doesn't parse, it is kept as the node's Data,
+// and the node's JSON or YAML is left nil.
+func (n *Node) setPayload(d string, ds *Diagnostics) {
+	if n.IsComment {
+		n.Data = d
+	}
+	if strings.TrimSpace(d) == "" {
+		return
+	}
+	n.Data = d // TODO: remove?? - NCL 1/25/23
+	switch n.Type {
+	case JSONNode:
+		n.JSON = make(map[string]interface{})
+		if err := json.Unmarshal([]byte(d), &n.JSON); err != nil {
+			n.JSON = nil
+			ds.add(SeverityError, n.Span, CodeBadJSON, "json: %v", err)
+		}
+	case YAMLNode:
+		n.YAML = make(map[interface{}]interface{})
+		if err := yaml.Unmarshal([]byte(d), &n.YAML); err != nil {
+			n.YAML = nil
+			ds.add(SeverityError, n.Span, CodeBadYAML, "%v", err)
 		}
+	default:
+		panic("setPayload only for JSON and YAML nodes")
+	}
+}
 
-		if strings.HasPrefix(in.Data, "yaml") {
-			d := strings.TrimPrefix(in.Data, "yaml")
-			n.Type = YAMLNode
-			n.Data = d
-			n.IsComment = true
-			if strings.TrimSpace(d) != "" {
-				n.YAML = make(map[interface{}]interface{})
-				if err := yaml.Unmarshal([]byte(d), &n.YAML); err != nil {
-					// TODO: do something else?
-					log.Fatalf("yaml.Unmarshal: %v", err)
-				}
-			}
-			return &n, nil
+// elementNode returns the node, without children, for the
+// HTML element at span with the given atom, name and attributes.
+func elementNode(a atom.Atom, data string, attr []Attribute, span Span, ds *Diagnostics) *Node {
+	var n = Node{Span: span}
+
+	switch a {
+	case atom.Center:
+		n.Type = CenterAlignNode
+	case atom.Img:
+		n.Type = ImageNode
+		n.setAttr("src", getAttr(attr, "src"))
+		n.setAttr("alt", getAttr(attr, "alt"))
+		n.setAttr("width", getAttr(attr, "width"))
+	case atom.A:
+		n.Type = LinkNode
+		n.setAttr("href", getAttr(attr, "href"))
+	case atom.Cite:
+		if getAttr(attr, "key") == "" {
+			// an HTML <cite>, of a title
+			n.Type = OpaqueNode
+			n.Attr = copyAttr(attr)
+			n.DataAtom = a
+			break
+		}
+		n.Type = CiteNode
+		n.setAttr("key", getAttr(attr, "key"))
+		if loc := getAttr(attr, "loc"); loc != "" {
+			n.setAttr("loc", loc)
 		}
-		if strings.HasPrefix(in.Data, "json") {
-			d := strings.TrimPrefix(in.Data, "json")
+	case atom.Figure:
+		n.Type = FigureNode
+		n.setAttr("id", getAttr(attr, "id"))
+		if placement := getAttr(attr, "placement"); placement != "" {
+			n.setAttr("placement", placement)
+		}
+	case atom.Figcaption:
+		n.Type = CaptionNode
+	case atom.Table:
+		n.Type = TableNode
+		n.Attr = copyAttr(attr)
+	case atom.Thead:
+		n.Type = TableHeadNode
+		n.Attr = copyAttr(attr)
+	case atom.Tbody:
+		n.Type = TableBodyNode
+		n.Attr = copyAttr(attr)
+	case atom.Tr:
+		n.Type = TableRowNode
+		n.Attr = copyAttr(attr)
+	case atom.Th:
+		n.Type = THNode
+		n.Attr = copyAttr(attr)
+	case atom.Td:
+		n.Type = TDNode
+		n.Attr = copyAttr(attr)
+	case atom.Code:
+		n.Type = CodeNode
+		n.Attr = copyAttr(attr)
+	case atom.Div:
+		switch c := getAttr(attr, "data-littype"); {
+		case c == ParagraphClass:
+			n.Type = ParagraphNode
+		case c == RunClass:
+			n.Type = RunNode
+		case c == DisplayMathClass:
+			n.Type = DisplayMathNode
+		case c == FootnoteClass:
+			n.Type = FootnoteNode
+		case c == ListClass:
+			n.Type = ListNode
+			n.setAttr("list-type", litlisttypeOf(attr))
+		case c == ListItemClass:
+			n.Type = ListItemNode
+		case c == FragmentClass:
+			n.Type = FragmentNode
+		case c == SectionClass:
+			n.Type = SectionNode
+			n.setAttr("section-level", litsectionlevelOf(attr))
+			n.setAttr("section-numbered", litsectionnumbered(attr))
+		case c == "":
+			n.Type = DivNode
+			n.Attr = copyAttr(attr)
+		default:
+			ds.add(SeverityError, span, CodeUnknownLittype, "unrecognized littype: %q", c)
+			n.Type = DivNode
+			n.Attr = copyAttr(attr)
+		}
+	case 0:
+		switch data {
+		case "tex":
+			n.Type = TexOnlyNode
+		case "right":
+			n.Type = RightAlignNode
+		case "center":
+			n.Type = CenterAlignNode
+		case "equation":
+			n.Type = EquationNode
+			n.setAttr("id", getAttr(attr, "id"))
+		case "subequations":
+			n.Type = SubequationsNode
+		case "statement":
+			n.Type = StatementNode
+			n.setAttr("id", getAttr(attr, "id"))
+			n.setAttr("type", getAttr(attr, "type"))
+			n.setAttr("text", getAttr(attr, "text"))
+		case "proof":
+			n.Type = ProofNode
+		case "quote":
+			n.Type = QuoteNode
+		case "json":
 			n.Type = JSONNode
-			n.Data = d
-			n.IsComment = true
-			if strings.TrimSpace(d) != "" {
-				n.JSON = make(map[string]interface{})
-				if err := json.Unmarshal([]byte(d), &n.JSON); err != nil {
-					// TODO: do something else?
-					log.Fatalf("json.Unmarshal: %v", err)
-				}
-			}
-			return &n, nil
+			n.Attr = copyAttr(attr)
+		case "yaml":
+			n.Type = YAMLNode
+			n.Attr = copyAttr(attr)
+		case "include":
+			n.Type = IncludeNode
+			n.setAttr("src", getAttr(attr, "src"))
+		case "ref":
+			n.Type = RefNode
+			n.setAttr("to", getAttr(attr, "to"))
+		default:
+			n.Type = OpaqueNode
+			n.Attr = copyAttr(attr)
+			n.Data = data
 		}
+	default:
+		n.Type = OpaqueNode
+		n.Attr = copyAttr(attr)
+		n.DataAtom = a
+	}
 
-		n.Type = CommentNode
-		n.Data = in.Data
-		return &n, nil
+	return &n
+}
+
+func unmarshalHTML(in *html.Node, parent *Node, ds *Diagnostics) *Node {
+	switch in.Type {
+	case html.CommentNode:
+		return commentNode(in.Data, Span{}, ds)
 	case html.TextNode:
-		log.Printf("warning, unexpected text node")
 		if strings.TrimSpace(in.Data) == "" {
-			return nil, nil
+			return nil
 		}
-		n.Type = TextNode
-		n.Data = in.Data
-		return &n, nil
+		ds.add(SeverityWarning, Span{}, CodeUnexpectedText, "unexpected text node")
+		return &Node{Type: TextNode, Data: in.Data}
 	case html.ElementNode:
-		switch in.DataAtom {
-		case atom.Center:
-			n.Type = CenterAlignNode
-		case atom.Img:
-			n.Type = ImageNode
-			n.setAttr("src", getAttr(in.Attr, "src"))
-			n.setAttr("width", getAttr(in.Attr, "width"))
-		case atom.A:
-			n.Type = LinkNode
-			n.setAttr("href", getAttr(in.Attr, "href"))
-		case atom.Table:
-			n.Type = TableNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Thead:
-			n.Type = TableHeadNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Tbody:
-			n.Type = TableBodyNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Tr:
-			n.Type = TableRowNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Th:
-			n.Type = THNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Td:
-			n.Type = TDNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Code:
-			n.Type = CodeNode
-			n.Attr = copyAttr(in.Attr)
-		case atom.Div:
-			switch c := littypeOf(in); {
-			case c == ParagraphClass:
-				n.Type = ParagraphNode
-			case c == RunClass:
-				n.Type = RunNode
-			case c == DisplayMathClass:
-				n.Type = DisplayMathNode
-			case c == FootnoteClass:
-				n.Type = FootnoteNode
-			case c == ListClass:
-				n.Type = ListNode
-				n.setAttr("list-type", litlisttypeOf(in.Attr))
-			case c == ListItemClass:
-				n.Type = ListItemNode
-			case c == FragmentClass:
-				n.Type = FragmentNode
-			case c == SectionClass:
-				n.Type = SectionNode
-				n.setAttr("section-level", litsectionlevelOf(in.Attr))
-				n.setAttr("section-numbered", litsectionnumbered(in.Attr))
-			case c == "":
-				n.Type = DivNode
-				n.Attr = copyAttr(in.Attr)
-			default:
-				panic(fmt.Sprintf("unrecognized littype: %q", c))
-			}
-		case 0:
-			switch in.Data {
-			case "tex":
-				n.Type = TexOnlyNode
-			case "right":
-				n.Type = RightAlignNode
-			case "center":
-				n.Type = CenterAlignNode
-			case "equation":
-				n.Type = EquationNode
-				n.setAttr("id", getAttr(in.Attr, "id"))
-			case "subequations":
-				n.Type = SubequationsNode
-			case "statement":
-				n.Type = StatementNode
-				n.setAttr("id", getAttr(in.Attr, "id"))
-				n.setAttr("type", getAttr(in.Attr, "type"))
-				n.setAttr("text", getAttr(in.Attr, "text"))
-			case "proof":
-				n.Type = ProofNode
-			case "quote":
-				n.Type = QuoteNode
-			case "json":
-				n.Type = JSONNode
-				n.Attr = copyAttr(in.Attr)
-
-				c := in.FirstChild
-				if c == nil {
-					return &n, nil
-				}
-				if c.Type != html.TextNode {
-					return &n, nil
-				}
-				if strings.TrimSpace(c.Data) == "" {
-					return &n, nil
-				}
-				n.JSON = make(map[string]interface{})
-				if err := json.Unmarshal([]byte(c.Data), &n.JSON); err != nil {
-					// TODO: do something else?
-					log.Fatalf("json.Unmarshal: %v", err)
-				}
-				n.Data = c.Data // TODO: remove?? - NCL 1/25/23
-			case "yaml":
-				n.Type = YAMLNode
-				n.Attr = copyAttr(in.Attr)
-
-				c := in.FirstChild
-				if c == nil {
-					return &n, nil
-				}
-				if c.Type != html.TextNode {
-					return &n, nil
-				}
-				if strings.TrimSpace(c.Data) == "" {
-					return &n, nil
-				}
-				n.YAML = make(map[interface{}]interface{})
-				if err := yaml.Unmarshal([]byte(c.Data), &n.YAML); err != nil {
-					// TODO: do something else?
-					log.Fatalf("yaml.Unmarshal: %v", err)
-				}
-				n.Data = c.Data // TODO: remove?? - NCL 1/25/23
-			default:
-				n.Type = OpaqueNode
-				n.Attr = copyAttr(in.Attr)
-				n.Data = in.Data
+		n := elementNode(in.DataAtom, in.Data, in.Attr, Span{}, ds)
+
+		if n.Type == JSONNode || n.Type == YAMLNode {
+			if c := in.FirstChild; c != nil && c.Type == html.TextNode {
+				n.setPayload(c.Data, ds)
 			}
-		default:
-			n.Type = OpaqueNode
-			n.Attr = copyAttr(in.Attr)
-			n.DataAtom = in.DataAtom
+			return n
 		}
 
 		for c := in.FirstChild; c != nil; c = c.NextSibling {
@@ -499,42 +424,37 @@ func unmarshalHTML(in *html.Node, parent *Node) (*Node, error) {
 					continue
 				}
 
-				r := &n
+				r := n
 				if r.Type != RunNode && r.Type != ListItemNode && r.Type != SectionNode {
 					r = &Node{}
 					r.Type = RunNode
 					n.AppendChild(r)
 				}
 
-				ts, err := unmarshalHTMLText(c)
-				if err != nil {
-					return nil, err
-				}
-
-				for _, child := range ts {
+				for _, child := range unmarshalHTMLText(c, ds) {
 					r.AppendChild(child)
 				}
 			default:
-				child, err := unmarshalHTML(c, &n)
-				if err != nil {
-					return nil, err
-				}
-				if child != nil {
+				if child := unmarshalHTML(c, n, ds); child != nil {
 					n.AppendChild(child)
 				}
 			}
 		}
+		return n
 	default:
-		return nil, fmt.Errorf("unsupported node type: %d", in.Type)
+		ds.add(SeverityError, Span{}, CodeBadHTML, "unsupported node type: %d", in.Type)
+		return nil
 	}
-	return &n, nil
 }
 
 // super simple
-func ParseCSV(s string) (*Node, error) {
+func ParseCSV(s string) (*Node, Diagnostics) {
 	fragment := Node{Type: FragmentNode}
+	src := newSource(s)
+	var ds Diagnostics
 
 	r := csv.NewReader(strings.NewReader(s))
+	r.FieldsPerRecord = -1
 	for {
 		list := &Node{Type: ListNode}
 		record, err := r.Read()
@@ -542,18 +462,32 @@ func ParseCSV(s string) (*Node, error) {
 			break
 		}
 		if err != nil {
-			return nil, err
+			// the reader picks up again on the next line
+			var span Span
+			if perr, ok := err.(*csv.ParseError); ok && perr.Line > 0 && perr.Line <= len(src.lines) {
+				offset := src.lines[perr.Line-1] + perr.Column - 1
+				span = src.span(offset, offset)
+			}
+			ds.add(SeverityError, span, CodeBadCSV, "%v", err)
+			continue
 		}
-		for _, field := range record {
-			li := &Node{Type: ListItemNode}
-			var ts []*Token
-			ts, err = Lex(field)
-			if err != nil {
-				return nil, err
+		for i, field := range record {
+			line, col := r.FieldPos(i)
+			offset := src.lines[line-1] + col - 1
+			if offset < len(s) && s[offset] == '"' {
+				offset++
 			}
+			m := make(offsetMap, len(field)+1)
+			for j := range m {
+				m[j] = offset + j
+			}
+
+			li := &Node{Type: ListItemNode, Span: src.span(offset, offset+len(field))}
+			ts, lds := lex(field, src, m)
+			ds = append(ds, lds...)
 
 			for _, t := range ts {
-				tn := &Node{Type: TokenNode, Token: t}
+				tn := &Node{Type: TokenNode, Token: t, Span: t.Span}
 				li.AppendChild(tn)
 			}
 			list.AppendChild(li)
@@ -561,5 +495,5 @@ func ParseCSV(s string) (*Node, error) {
 		fragment.AppendChild(list)
 	}
 
-	return &fragment, nil
+	return &fragment, ds
 }
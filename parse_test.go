@@ -0,0 +1,288 @@
+package lit_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestParseLitSpans(t *testing.T) {
+	raw := "¶ ⦊\n  ‖ A \\‖ b. ⦉\n⦉"
+
+	n, ds := lit.ParseLit(raw)
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := n.FirstChild
+	if p == nil || p.Type != lit.ParagraphNode {
+		t.Fatalf("expected a paragraph, got %v", p)
+	}
+	if got, want := raw[p.Span.Start.Offset:p.Span.End.Offset], raw; got != want {
+		t.Errorf("paragraph span covers %q, want %q", got, want)
+	}
+
+	r := p.FirstChild
+	if r == nil || r.Type != lit.RunNode {
+		t.Fatalf("expected a run, got %v", r)
+	}
+	if got, want := raw[r.Span.Start.Offset:r.Span.End.Offset], "‖ A \\‖ b. ⦉"; got != want {
+		t.Errorf("run span covers %q, want %q", got, want)
+	}
+	if got, want := r.Span.Start.String(), "2:3"; got != want {
+		t.Errorf("run starts at %s, want %s", got, want)
+	}
+
+	var got []string
+	for c := r.FirstChild; c != nil; c = c.NextSibling {
+		got = append(got, raw[c.Span.Start.Offset:c.Span.End.Offset])
+	}
+	want := []string{"A", " ", "‖", " ", "b", "."}
+	if len(got) != len(want) {
+		t.Fatalf("got tokens %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("token %d covers %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLexSpans(t *testing.T) {
+	ts, err := lit.Lex("ab ∈\nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1:1-1:3", "1:3-1:4", "1:4-1:5", "2:1-2:2"}
+	if len(ts) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(ts), len(want))
+	}
+	for i, tok := range ts {
+		if got := tok.Span.String(); got != want[i] {
+			t.Errorf("token %v: span %s, want %s", tok, got, want[i])
+		}
+	}
+}
+
+func TestParseLitEdgeCases(t *testing.T) {
+	var cases = []struct {
+		name, raw, want string
+	}{
+		{
+			name: "glyph at start of file",
+			raw:  "‖ Hi. ⦉",
+			want: "‖ Hi. ⦉",
+		},
+		{
+			name: "tex line break before a close",
+			raw:  "◇ ⦊\n  ‖ a \\\\⦉\n⦉",
+			want: "◇ ⦊\n  ‖ a \\\\ ⦉\n⦉",
+		},
+		{
+			name: "escaped glyphs",
+			raw:  "‖ \\¶ and \\‖ and \\⦉ ⦉",
+			want: "‖ \\¶ and \\‖ and \\⦉ ⦉",
+		},
+		{
+			name: "scripts are kept outside math",
+			raw:  "‖ x² and f⁻¹ ⦉",
+			want: "‖ x² and f⁻¹ ⦉",
+		},
+	}
+
+	for _, c := range cases {
+		n, ds := lit.ParseLit(c.raw)
+		if err := ds.Err(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var b bytes.Buffer
+		if err := lit.WriteLit(&b, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := b.String(); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseLitDiagnostics(t *testing.T) {
+	var cases = []struct {
+		raw  string
+		want []string
+	}{
+		{
+			raw:  "¶ ⦊\n  <center>\n    ‖ Hi. ⦉\n⦉",
+			want: []string{"2:3: error: <center> is not closed before ⦉"},
+		},
+		{
+			raw:  "<quote>\n  ‖ Hi. </quote>",
+			want: []string{"2:3: error: ‖ is not closed before </quote>"},
+		},
+		{
+			raw:  "‖ Hi. <!-- oops ⦉",
+			want: []string{"1:7: error: comment is not closed", "1:1: warning: ‖ is not closed"},
+		},
+		{
+			raw:  "‖ Hi. ⦉⦉\n<div data-littype='nope'></div>",
+			want: []string{"1:8: warning: ⦉ closes nothing", `2:1: error: unrecognized littype: "nope"`},
+		},
+		{
+			raw: "<json>{oops}</json>\n<!--yaml\n: :\n-->\n‖ \x00 ⦉",
+			want: []string{
+				"1:1: error: json: invalid character 'o' looking for beginning of object key string",
+				"2:1: error: yaml: line 1: did not find expected key",
+				"5:3: error: unrecognized rune '\\x00'",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		n, ds := lit.ParseLit(c.raw)
+		if n == nil {
+			t.Errorf("%q: expected a tree despite the errors", c.raw)
+		}
+		var got []string
+		for _, d := range ds {
+			got = append(got, d.String())
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%q: got diagnostics %q, want %q", c.raw, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%q: got diagnostic %q, want %q", c.raw, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestParseCSVDiagnostics(t *testing.T) {
+	n, ds := lit.ParseCSV("a,b\n\"c\"d,e\nf,g\n")
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadCSV {
+		t.Fatalf("got diagnostics %v, want one %s", ds, lit.CodeBadCSV)
+	}
+	if got := len(n.Kids()); got != 2 {
+		t.Errorf("got %d rows, want the 2 good ones", got)
+	}
+}
+
+func TestTexScripts(t *testing.T) {
+	n, ds := lit.ParseLit("‖ $f⁻¹(xᵢ)$ and x² ⦉")
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := lit.WriteTex(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), `$f^{-1}(x_{i})$ and x\textsuperscript{2}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"# Sets\n\n## More {-}", "#§ Sets ⦉\n\n§§ More ⦉"},
+		{"Sets\n====", "#§ Sets ⦉"},
+		{"A *pack* of __wolves__. Dr. Who said \"hi\"... Then -- left.",
+			"¶ ⦊\n  ‖ A ‹pack› of «wolves». ⦉\n\n  ‖ Dr. Who said “hi”… ⦉\n\n  ‖ Then – left. ⦉\n⦉"},
+		{"It's `x` and $x \\in A$.", "¶ ⦊\n  ‖ It’s ⁅x⁆ and $x ∈ A$. ⦉\n⦉"},
+		{"We write\n$$\nA \\subset B.\n$$\nThen more.",
+			"¶ ⦊\n  ‖ We write\n    ◇ ⦊\n      ‖ A ⊂ B. ⦉\n    ⦉⦉\n\n  ‖ Then more. ⦉\n⦉"},
+		{"Later.[^n] Done.\n\n[^n]: See the end.",
+			"¶ ⦊\n  ‖ Later.\n    † ⦊\n      ‖ See the end. ⦉\n    ⦉⦉\n\n  ‖ Done. ⦉\n⦉"},
+		{"- one\n- two\n\n1. three", "⁝ ⦊\n  ‣ one ⦉\n\n  ‣ two ⦉\n⦉\n𝍫 ⦊\n  ‣ three ⦉\n⦉"},
+		{"By [us](https://example.com).",
+			"¶ ⦊\n  ‖ By␣\n    <a href='https://example.com'>\n      ‖ us ⦉\n    </a>\n    . ⦉\n⦉"},
+		{"By [us][e].\n\n[e]: https://example.com",
+			"¶ ⦊\n  ‖ By␣\n    <a href='https://example.com'>\n      ‖ us ⦉\n    </a>\n    . ⦉\n⦉"},
+		{"> Quoted.", "<quote>\n  ¶ ⦊\n    ‖ Quoted. ⦉\n  ⦉\n</quote>"},
+	} {
+		n, ds := lit.ParseMarkdown(c.in)
+		if len(ds) > 0 {
+			t.Errorf("ParseMarkdown(%q): %v", c.in, ds)
+		}
+		var got bytes.Buffer
+		if err := lit.WriteLit(&got, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != c.want {
+			t.Errorf("ParseMarkdown(%q) = %q, want %q", c.in, got.String(), c.want)
+		}
+	}
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	for _, in := range []string{
+		"¶ ⦊\n  ‖ A ‹pack› of «wolves». ⦉\n\n  ‖ A set $x ∈ A$. ⦉\n⦉",
+		"#§ Sets ⦉\n#§§ More ⦉",
+		"¶ ⦊\n  ‖ One two\n    † ⦊\n      ‖ A note. ⦉\n    ⦉\n    three four. ⦉\n⦉",
+		"⁝ ⦊\n  ‣ one ⦉\n\n  ‣ two ⦉\n⦉\n𝍫 ⦊\n  ‣ three ⦉\n⦉",
+		"¶ ⦊\n  ‖ later.\n    † ⦊\n      ‖ See the end. ⦉\n    ⦉⦉\n⦉",
+	} {
+		n := lit.Must(lit.ParseLit(in))
+		var md bytes.Buffer
+		if err := lit.WriteMarkdown(&md, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		m, ds := lit.ParseMarkdown(md.String())
+		if len(ds) > 0 {
+			t.Fatalf("%q: %v", in, ds)
+		}
+		if !lit.Equal(n, m) {
+			var got bytes.Buffer
+			lit.WriteLit(&got, m, lit.DefaultWriteOpts)
+			t.Errorf("%q: got %q after a round trip through Markdown", in, got.String())
+		}
+	}
+}
+
+// TestMarkdownToMarkdown checks that Markdown written from the tree
+// ParseMarkdown makes is the Markdown parsed, for the blocks that
+// have no LitTex of their own.
+func TestMarkdownToMarkdown(t *testing.T) {
+	for _, in := range []string{
+		"```\nfmt.Println(\"hi\")\nif a < b {\n}\n```\n",
+		"| a | b |\n| --- | --- |\n| x | y \\| z |\n",
+		"| l | c | r |\n| :-- | :-: | --: |\n| 1 | 2 | 3 |\n",
+		"See ![a small diagram](d.png) here.\n",
+	} {
+		n, ds := lit.ParseMarkdown(in)
+		if len(ds) > 0 {
+			t.Fatalf("%q: %v", in, ds)
+		}
+		var md bytes.Buffer
+		if err := lit.WriteMarkdown(&md, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		if md.String() != in {
+			t.Errorf("%q: got %q after a round trip", in, md.String())
+		}
+	}
+
+	// a code block is its text, not that of a run of an opaque token
+	n, _ := lit.ParseMarkdown("```\na < b\n```")
+	var b bytes.Buffer
+	if err := lit.WriteHTML(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); !strings.Contains(got, "<pre>a &lt; b</pre>") || strings.ContainsAny(got, "‖❲❳⦉") {
+		t.Errorf("WriteHTML of a code block = %q", got)
+	}
+}
+
+func TestParseMarkdownDiagnostics(t *testing.T) {
+	for _, c := range []struct{ in, code string }{
+		{"Undefined.[^x]", lit.CodeBadMarkdown},
+		{"```\nnever closed", lit.CodeUnclosed},
+		{"$$\nx", lit.CodeUnclosed},
+	} {
+		_, ds := lit.ParseMarkdown(c.in)
+		if len(ds) != 1 || ds[0].Code != c.code {
+			t.Errorf("ParseMarkdown(%q): got %v, want one %s", c.in, ds, c.code)
+		}
+	}
+}
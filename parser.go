@@ -0,0 +1,556 @@
+package lit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ParseLit parses LitTex.
+//
+// LitTex is HTML, plus the glyphs:
+//
+//	¶ ⦊ … ⦉   paragraph
+//	‖ … ⦉     run
+//	† ⦊ … ⦉   footnote
+//	◇ ⦊ … ⦉   display math
+//	⁝ ⦊ … ⦉   unordered list
+//	𝍫 ⦊ … ⦉   ordered list
+//	‣ … ⦉     list item
+//	§ … ⦉     section; §§ and §§§ for levels 2 and 3, #§ if numbered
+//	▦ lcr ⦊ … ⦉  table, its columns aligned left, center and right
+//	═ … ⦉     row of the head of a table
+//	─ … ⦉     row of the body of a table
+//
+// The cells of a row are runs, e.g.:
+//
+//	▦ lc ⦊
+//	  ═ ‖ Set ⦉ ‖ Size ⦉ ⦉
+//	  ─ ‖ $A$ ⦉ ‖ 3 ⦉ ⦉
+//	⦉
+//
+// A backslash before a glyph, or before < or >, makes it literal text.
+//
+// An <include src='…'/> is left in the tree, with a warning, as ParseLit
+// has no file path to resolve it by; ParseLitFile resolves it.
+//
+// ParseLit recovers from errors, so it always returns a tree,
+// along with every problem it found.
+func ParseLit(s string) (*Node, Diagnostics) {
+	p := &parser{src: newSource(s), s: s}
+	root := &Node{Type: FragmentNode, Span: p.src.span(0, len(s))}
+	p.parseChildren(&frame{n: root})
+	checkIncludes(root, &p.ds)
+	checkTables(root, &p.ds)
+	return root, p.ds
+}
+
+// parser is a recursive-descent parser for LitTex.
+type parser struct {
+	src  *source
+	s    string
+	i    int      // the offset of the next byte to parse
+	open []*frame // the elements being parsed, innermost last
+	ds   Diagnostics
+}
+
+// frame is an element being parsed.
+type frame struct {
+	n     *Node
+	start int    // the offset of the glyph or tag that opened it
+	tag   string // the tag name, if opened by a tag
+}
+
+// glyph reports whether the frame was opened by a glyph, and so is closed by ⦉.
+func (f *frame) glyph() bool {
+	return f.tag == "" && f.n.Type != FragmentNode
+}
+
+func (f *frame) String() string {
+	if f.tag != "" {
+		return "<" + f.tag + ">"
+	}
+	return f.n.Type.String()
+}
+
+func (p *parser) report(sev Severity, code string, start, end int, format string, args ...interface{}) {
+	p.ds.add(sev, p.src.span(start, end), code, format, args...)
+}
+
+// text is the text pending between two elements.
+type text struct {
+	b []byte
+	m offsetMap // the source offset of each byte of b
+}
+
+func (t *text) add(s string, offset int) {
+	t.b = append(t.b, s...)
+	for i := 0; i < len(s); i++ {
+		t.m = append(t.m, offset)
+	}
+}
+
+// copy adds s[i:j] of the source to t.
+func (t *text) copy(s string, i, j int) {
+	t.b = append(t.b, s[i:j]...)
+	for ; i < j; i++ {
+		t.m = append(t.m, i)
+	}
+}
+
+// trimSpace drops one trailing space from t, as the space
+// before a run glyph is not part of the preceding text.
+func (t *text) trimSpace() {
+	if n := len(t.b); n > 0 && isSpaceByte(t.b[n-1]) {
+		t.b, t.m = t.b[:n-1], t.m[:n-1]
+	}
+}
+
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\t' || b == '\r'
+}
+
+// openers are the glyphs followed by ⦊.
+var openers = map[rune]NodeType{
+	'¶': ParagraphNode,
+	'†': FootnoteNode,
+	'◇': DisplayMathNode,
+	'⁝': ListNode,
+	'𝍫': ListNode,
+}
+
+// escapable are the runes that a backslash makes literal.
+const escapable = "‖¶†◇⁝𝍫‣§▦⦉<>"
+
+// rawTextElements are read verbatim up to their end tag.
+var rawTextElements = map[string]bool{
+	"json": true, "yaml": true,
+	"script": true, "style": true, "textarea": true, "title": true,
+}
+
+// voidElements have no end tag.
+var voidElements = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true,
+	atom.Embed: true, atom.Hr: true, atom.Img: true, atom.Input: true,
+	atom.Keygen: true, atom.Link: true, atom.Meta: true, atom.Param: true,
+	atom.Source: true, atom.Track: true, atom.Wbr: true,
+}
+
+// parseChildren parses the children of f.n,
+// up to and including whatever closes it.
+func (p *parser) parseChildren(f *frame) {
+	p.open = append(p.open, f)
+	defer func() { p.open = p.open[:len(p.open)-1] }()
+
+	t := new(text)
+	flush := func() {
+		p.flush(f.n, t)
+		t = new(text)
+	}
+	close := func() {
+		f.n.Span = p.src.span(f.start, p.i)
+	}
+
+	for p.i < len(p.s) {
+		r, size := utf8.DecodeRuneInString(p.s[p.i:])
+		switch {
+		case r == '\\':
+			next, nsize := utf8.DecodeRuneInString(p.s[p.i+size:])
+			switch {
+			case nsize > 0 && strings.ContainsRune(escapable, next):
+				t.copy(p.s, p.i+size, p.i+size+nsize)
+				p.i += size + nsize
+			case next == '\\': // \\ is a TeX line break
+				t.copy(p.s, p.i, p.i+2*size)
+				p.i += 2 * size
+			default:
+				t.copy(p.s, p.i, p.i+size)
+				p.i += size
+			}
+		case r == '⦉':
+			if f.glyph() {
+				flush()
+				p.i += size
+				close()
+				return
+			}
+			if p.innermostGlyph() != nil {
+				p.report(SeverityError, CodeMismatchedClose, f.start, p.i, "%s is not closed before ⦉", f)
+				flush()
+				close()
+				return
+			}
+			// a stray ⦉ is dropped, as HTML drops a stray end tag
+			p.report(SeverityWarning, CodeStrayClose, p.i, p.i+size, "⦉ closes nothing")
+			p.i += size
+		case r == '‖' && f.n.Type == TableRowNode && f.glyph():
+			t.trimSpace()
+			flush()
+			p.parseCell(f.n, size)
+		case (r == '═' || r == '─') && f.n.Type == TableNode && f.glyph():
+			flush()
+			p.parseRow(f.n, r == '═', size)
+		case r == '‖' || r == '‣':
+			if r == '‖' {
+				t.trimSpace()
+			}
+			flush()
+			typ := RunNode
+			if r == '‣' {
+				typ = ListItemNode
+			}
+			p.parseGlyph(typ, nil, size)
+		case openers[r] != 0 && p.opens(p.i+size) > 0:
+			flush()
+			n := p.opens(p.i + size)
+			var attr []Attribute
+			switch r {
+			case '⁝':
+				attr = []Attribute{{Key: "list-type", Val: "unordered"}}
+			case '𝍫':
+				attr = []Attribute{{Key: "list-type", Val: "ordered"}}
+			}
+			p.parseGlyph(openers[r], attr, size+n)
+		case r == '▦' && p.tableOpens(p.i+size) > 0:
+			flush()
+			var attr []Attribute
+			if align := p.tableAlign(p.i + size); align != "" {
+				attr = []Attribute{{Key: "align", Val: align}}
+			}
+			p.parseGlyph(TableNode, attr, size+p.tableOpens(p.i+size))
+		case r == '§' || r == '#' && strings.HasPrefix(p.s[p.i+size:], "§"):
+			flush()
+			numbered := "false"
+			n := 0
+			if r == '#' {
+				numbered = "true"
+				n += size
+			}
+			level := 0
+			for strings.HasPrefix(p.s[p.i+n:], "§") {
+				level++
+				n += len("§")
+			}
+			p.parseGlyph(SectionNode, []Attribute{
+				{Key: "section-level", Val: fmt.Sprint(level)},
+				{Key: "section-numbered", Val: numbered},
+			}, n)
+		case r == OpaqueOpenRune:
+			j := strings.IndexRune(p.s[p.i:], OpaqueCloseRune)
+			if j < 0 {
+				p.report(SeverityError, CodeUnclosed, p.i, p.i+size, "%c is not closed", OpaqueOpenRune)
+				j = len(p.s) - p.i
+			} else {
+				j += utf8.RuneLen(OpaqueCloseRune)
+			}
+			t.copy(p.s, p.i, p.i+j)
+			p.i += j
+		case r == '&':
+			if j := strings.IndexByte(p.s[p.i:], ';'); j > 0 && j < 32 {
+				ref := p.s[p.i : p.i+j+1]
+				if u := html.UnescapeString(ref); u != ref {
+					t.add(u, p.i)
+					p.i += j + 1
+					continue
+				}
+			}
+			t.copy(p.s, p.i, p.i+size)
+			p.i += size
+		case r == '<' && p.isTag(p.i):
+			if p.parseTag(f, flush) {
+				close()
+				return
+			}
+		default:
+			t.copy(p.s, p.i, p.i+size)
+			p.i += size
+		}
+	}
+
+	// as in HTML, whatever is open at the end is closed
+	flush()
+	close()
+	if f.n.Type != FragmentNode {
+		p.report(SeverityWarning, CodeUnclosed, f.start, p.i, "%s is not closed", f)
+	}
+}
+
+// innermostGlyph returns the innermost frame opened by a glyph, if any.
+func (p *parser) innermostGlyph() *frame {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		if p.open[i].glyph() {
+			return p.open[i]
+		}
+	}
+	return nil
+}
+
+// opens returns the length of the " ⦊" or "⦊" at i, or 0 if there is none.
+func (p *parser) opens(i int) int {
+	switch {
+	case strings.HasPrefix(p.s[i:], "⦊"):
+		return len("⦊")
+	case strings.HasPrefix(p.s[i:], " ⦊"):
+		return len(" ⦊")
+	}
+	return 0
+}
+
+// tableAlign returns the alignments of the columns, such as "lcr",
+// after the ▦ whose end is i, if there are any.
+func (p *parser) tableAlign(i int) string {
+	if !strings.HasPrefix(p.s[i:], " ") {
+		return ""
+	}
+	j := i + 1
+	for j < len(p.s) && strings.IndexByte("lcr", p.s[j]) >= 0 {
+		j++
+	}
+	return p.s[i+1 : j]
+}
+
+// tableOpens returns the length of the alignments and the ⦊ after the ▦
+// whose end is i, or 0 if there is no ⦊.
+func (p *parser) tableOpens(i int) int {
+	j := i
+	if align := p.tableAlign(i); align != "" {
+		j += 1 + len(align)
+	}
+	if n := p.opens(j); n > 0 {
+		return j - i + n
+	}
+	return 0
+}
+
+// parseRow parses a row of table, opened by a glyph n bytes long: ═
+// in its head, if head, or ─ in its body. The row is added to the last
+// section of the table, if it is of the same kind, or to a new one.
+func (p *parser) parseRow(table *Node, head bool, n int) {
+	typ := TableBodyNode
+	if head {
+		typ = TableHeadNode
+	}
+	section := table.LastChild
+	if section == nil || section.Type != typ {
+		section = &Node{Type: typ}
+		table.AppendChild(section)
+	}
+	f := &frame{n: &Node{Type: TableRowNode}, start: p.i}
+	section.AppendChild(f.n)
+	p.i += n
+	p.parseChildren(f)
+	if section.FirstChild == f.n {
+		section.Span.Start = f.n.Span.Start
+	}
+	section.Span.End = f.n.Span.End
+}
+
+// parseCell parses a cell of row, a run opened by a ‖ n bytes long:
+// a header cell in the head of a table, or a data cell in its body.
+func (p *parser) parseCell(row *Node, n int) {
+	typ := TDNode
+	if row.Parent != nil && row.Parent.Type == TableHeadNode {
+		typ = THNode
+	}
+	cell := &Node{Type: typ}
+	row.AppendChild(cell)
+	f := &frame{n: &Node{Type: RunNode}, start: p.i}
+	cell.AppendChild(f.n)
+	p.i += n
+	p.parseChildren(f)
+	cell.Span = f.n.Span
+}
+
+// parseGlyph parses an element opened by a glyph n bytes long.
+func (p *parser) parseGlyph(typ NodeType, attr []Attribute, n int) {
+	f := &frame{n: &Node{Type: typ}, start: p.i}
+	for _, a := range attr {
+		f.n.setAttr(a.Key, a.Val)
+	}
+	p.open[len(p.open)-1].n.AppendChild(f.n)
+	p.i += n
+	p.parseChildren(f)
+}
+
+// holdsTokens reports whether n holds its tokens directly.
+func holdsTokens(n *Node) bool {
+	return n.Type == RunNode || n.Type == ListItemNode || n.Type == SectionNode
+}
+
+// addSpace adds to n an implicit space for the whitespace at offset i.
+//
+// The lexer drops the whitespace at the ends of text, but that
+// between text and a ref or cite is kept, so the writers can write it.
+func (p *parser) addSpace(n *Node, i int) {
+	span := p.src.span(i, i+1)
+	n.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: SymbolToken, Value: "␣", Implicit: true, Span: span}, Span: span})
+}
+
+// flush adds the text t to n, lexed into tokens.
+//
+// Runs, list items and sections hold their tokens directly;
+// in any other element, the text is wrapped in a run.
+func (p *parser) flush(n *Node, t *text) {
+	if strings.TrimSpace(string(t.b)) == "" {
+		return
+	}
+	m := append(t.m, p.i)
+	ts, ds := lex(string(t.b), p.src, m)
+	p.ds = append(p.ds, ds...)
+
+	r := n
+	if !holdsTokens(r) {
+		end := m.end(p.src, len(t.b))
+		r = &Node{Type: RunNode, Span: p.src.span(m.start(0), end)}
+		n.AppendChild(r)
+	}
+	if l := r.LastChild; l != nil && isInlineRef(l) && isSpaceByte(t.b[0]) {
+		p.addSpace(r, m[0])
+	}
+	for _, tok := range ts {
+		r.AppendChild(&Node{Type: TokenNode, Token: tok, Span: tok.Span})
+	}
+}
+
+// isTag reports whether the < at i starts a tag or comment.
+func (p *parser) isTag(i int) bool {
+	if i+1 >= len(p.s) {
+		return false
+	}
+	c := p.s[i+1]
+	if c == '/' && i+2 < len(p.s) {
+		c = p.s[i+2]
+	}
+	return c == '!' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// tagEnd returns the offset just past the > that ends the tag at i,
+// skipping over quoted attribute values; or -1.
+func (p *parser) tagEnd(i int) int {
+	var quote byte
+	for j := i + 1; j < len(p.s); j++ {
+		switch c := p.s[j]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '>':
+			return j + 1
+		}
+	}
+	return -1
+}
+
+// parseTag parses the tag or comment at p.i, within f.
+// It reports whether the tag closed f.
+func (p *parser) parseTag(f *frame, flush func()) (closed bool) {
+	start := p.i
+
+	if strings.HasPrefix(p.s[start:], "<!--") {
+		flush()
+		j := strings.Index(p.s[start+len("<!--"):], "-->")
+		var data string
+		if j < 0 {
+			p.report(SeverityError, CodeUnclosed, start, len(p.s), "comment is not closed")
+			data = p.s[start+len("<!--"):]
+			p.i = len(p.s)
+		} else {
+			data = p.s[start+len("<!--") : start+len("<!--")+j]
+			p.i = start + len("<!--") + j + len("-->")
+		}
+		if c := commentNode(data, p.src.span(start, p.i), &p.ds); c != nil {
+			f.n.AppendChild(c)
+		}
+		return false
+	}
+
+	end := p.tagEnd(start)
+	if end < 0 {
+		p.report(SeverityError, CodeUnclosed, start, len(p.s), "tag is not closed")
+		p.i = len(p.s)
+		return false
+	}
+	flush()
+	p.i = end
+
+	z := html.NewTokenizer(strings.NewReader(p.s[start:end]))
+	tt := z.Next()
+	tok := z.Token()
+	switch tt {
+	case html.StartTagToken, html.SelfClosingTagToken:
+		n := elementNode(tok.DataAtom, tok.Data, tok.Attr, p.src.span(start, end), &p.ds)
+		if l := f.n.LastChild; isInlineRef(n) && holdsTokens(f.n) && l != nil && start > 0 && isSpaceByte(p.s[start-1]) &&
+			(l.Type == TokenNode && !isSpace(l.Token) || isInlineRef(l)) {
+			p.addSpace(f.n, start-1)
+		}
+		f.n.AppendChild(n)
+		switch {
+		case tt == html.SelfClosingTagToken || voidElements[tok.DataAtom]:
+			n.Span = p.src.span(start, p.i)
+		case rawTextElements[tok.Data]:
+			p.parseRawText(n, tok.Data, start)
+		default:
+			p.parseChildren(&frame{n: n, start: start, tag: tok.Data})
+		}
+	case html.EndTagToken:
+		if f.tag == tok.Data {
+			return true
+		}
+		for _, o := range p.open {
+			if o.tag == tok.Data {
+				p.report(SeverityError, CodeMismatchedClose, f.start, start, "%s is not closed before </%s>", f, tok.Data)
+				p.i = start // leave it for o
+				return true
+			}
+		}
+		// a stray end tag is dropped, as in HTML
+		p.report(SeverityWarning, CodeStrayClose, start, end, "</%s> closes nothing", tok.Data)
+	case html.ErrorToken:
+		if z.Err() != io.EOF {
+			p.report(SeverityError, CodeBadHTML, start, end, "%v", z.Err())
+		}
+	}
+	return false
+}
+
+// parseRawText parses the content of a raw text element, such as <json>.
+func (p *parser) parseRawText(n *Node, name string, start int) {
+	j := indexFold(p.s[p.i:], "</"+name)
+	if j < 0 {
+		p.report(SeverityError, CodeUnclosed, start, len(p.s), "<%s> is not closed", name)
+		j = len(p.s) - p.i
+	}
+	content := p.s[p.i : p.i+j]
+
+	switch n.Type {
+	case JSONNode, YAMLNode:
+		n.setPayload(content, &p.ds)
+		p.i += j
+	default:
+		t := new(text)
+		t.copy(p.s, p.i, p.i+j)
+		p.i += j
+		p.flush(n, t)
+	}
+
+	if end := p.tagEnd(p.i); end > 0 {
+		p.i = end
+	}
+	n.Span = p.src.span(start, p.i)
+}
+
+// indexFold is strings.Index, but ignoring ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,129 @@
+package lit
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Pos is a position in a source file.
+//
+// Line and Col are 1-based; Col counts runes, not bytes.
+// Offset is the 0-based byte offset into the source.
+// The zero Pos is invalid, and means "unknown".
+type Pos struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Col    int `json:"col"`
+}
+
+// IsValid reports whether the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Span is the half-open range [Start, End) of source a Token or Node came from.
+//
+// File is the name of the source file, as ParseLitFile sets it for the
+// file and those it includes; the other parsers leave it empty.
+type Span struct {
+	Start Pos    `json:"start"`
+	End   Pos    `json:"end"`
+	File  string `json:"file,omitempty"`
+}
+
+// IsValid reports whether the span is known.
+func (s Span) IsValid() bool {
+	return s.Start.IsValid()
+}
+
+func (s Span) String() string {
+	return s.Start.String() + "-" + s.End.String()
+}
+
+// source indexes the line starts of a text,
+// so that byte offsets can be turned into Pos.
+type source struct {
+	text  string
+	lines []int // the byte offset at which each line starts
+}
+
+func newSource(text string) *source {
+	s := &source{text: text, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			s.lines = append(s.lines, i+1)
+		}
+	}
+	return s
+}
+
+func (s *source) pos(offset int) Pos {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(s.text) {
+		offset = len(s.text)
+	}
+	line := sort.Search(len(s.lines), func(i int) bool { return s.lines[i] > offset }) - 1
+	return Pos{
+		Offset: offset,
+		Line:   line + 1,
+		Col:    utf8.RuneCountInString(s.text[s.lines[line]:offset]) + 1,
+	}
+}
+
+func (s *source) span(start, end int) Span {
+	return Span{Start: s.pos(start), End: s.pos(end)}
+}
+
+// runeEnd returns the offset just past the rune starting at offset.
+func (s *source) runeEnd(offset int) int {
+	if offset >= len(s.text) {
+		return len(s.text)
+	}
+	_, size := utf8.DecodeRuneInString(s.text[offset:])
+	return offset + size
+}
+
+// offsetMap records, for each byte of a rewritten string, the byte offset
+// of the source it was produced from. It has one extra entry, for the end.
+//
+// A nil offsetMap is the identity.
+type offsetMap []int
+
+func (m offsetMap) at(i int) int {
+	if m == nil {
+		return i
+	}
+	if i >= len(m) {
+		return m[len(m)-1]
+	}
+	return m[i]
+}
+
+// start returns the source offset of the rewritten range beginning at i.
+func (m offsetMap) start(i int) int {
+	return m.at(i)
+}
+
+// end returns the source offset just past the rewritten range ending at j.
+//
+// Since a rewritten range may be a replacement for a source glyph, we
+// take the end of the source rune that produced the last byte.
+func (m offsetMap) end(src *source, j int) int {
+	if j <= 0 {
+		return m.at(0)
+	}
+	if m == nil {
+		return j
+	}
+	return src.runeEnd(m.at(j - 1))
+}
@@ -0,0 +1,324 @@
+package lit
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WalkAction tells Walk how to go on after visiting a node.
+type WalkAction int
+
+const (
+	WalkContinue WalkAction = iota // Visit the node's children, then its next sibling.
+	WalkSkip                       // Skip the node's children.
+	WalkStop                       // Stop the walk.
+)
+
+// Walk calls f on n and each of its descendants, in document order.
+//
+// f may remove or replace the node it is called on,
+// but not the nodes after it.
+func Walk(n *Node, f func(*Node) WalkAction) {
+	walk(n, f)
+}
+
+// walk reports whether the walk was stopped.
+func walk(n *Node, f func(*Node) WalkAction) bool {
+	switch f(n) {
+	case WalkStop:
+		return true
+	case WalkSkip:
+		return false
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if walk(c, f) {
+			return true
+		}
+		c = next
+	}
+	return false
+}
+
+// Find returns the first node in document order, n included,
+// of type t and with all the given attributes, or nil if there is none.
+// An attribute with an empty Val matches a missing one.
+func Find(n *Node, t NodeType, attr ...Attribute) *Node {
+	var found *Node
+	Walk(n, func(c *Node) WalkAction {
+		if c.Type == t && hasAttrs(c, attr) {
+			found = c
+			return WalkStop
+		}
+		return WalkContinue
+	})
+	return found
+}
+
+// FindAll returns all nodes, n included, of type t and
+// with all the given attributes, in document order.
+func FindAll(n *Node, t NodeType, attr ...Attribute) (ns []*Node) {
+	Walk(n, func(c *Node) WalkAction {
+		if c.Type == t && hasAttrs(c, attr) {
+			ns = append(ns, c)
+		}
+		return WalkContinue
+	})
+	return
+}
+
+func hasAttrs(n *Node, attr []Attribute) bool {
+	for _, want := range attr {
+		if getAttr(n.Attr, want.Key) != want.Val {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the nodes, n included, matched by the selector sel,
+// in document order. See CompileSelector for the syntax.
+func Select(n *Node, sel string) ([]*Node, error) {
+	s, err := CompileSelector(sel)
+	if err != nil {
+		return nil, err
+	}
+	return s.FindAll(n), nil
+}
+
+// Selector matches nodes, much like a CSS selector matches elements.
+type Selector struct {
+	src    string
+	groups [][]selectorPart // alternatives, separated by commas
+}
+
+// selectorPart is one compound selector, with the combinator
+// that relates it to the part before it.
+type selectorPart struct {
+	child bool // a > combinator; otherwise a descendant combinator
+	typ   NodeType
+	any   bool // the * type, or no type at all
+	attr  []attrTest
+}
+
+type attrTest struct {
+	key, val string
+	hasVal   bool
+}
+
+var nodeTypesByName = func() map[string]NodeType {
+	m := make(map[string]NodeType)
+	for t := ErrorNode; t < nodeTypeCount; t++ {
+		m[t.String()] = t
+	}
+	return m
+}()
+
+// CompileSelector parses a selector.
+//
+// A selector is a comma separated list of alternatives. Each alternative
+// is a list of compound selectors, related by a space (descendant) or
+// a > (child). A compound selector is a node type, as written by
+// NodeType.String, or *, followed by any number of attribute tests,
+// [key] or [key=val]; val may be quoted. As elsewhere, an empty
+// attribute is as good as none, so [key] requires a value. For example,
+//
+//	§ > ¶ ‖
+//	statement[type=theorem], proof
+//	§[section-level="2"] > ‖
+func CompileSelector(sel string) (*Selector, error) {
+	s := &Selector{src: sel}
+	p := selectorParser{s: sel}
+	for {
+		group, err := p.group()
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %v", sel, err)
+		}
+		s.groups = append(s.groups, group)
+		if p.done() {
+			return s, nil
+		}
+		p.i++ // the comma
+	}
+}
+
+// MustCompileSelector is like CompileSelector, but panics on an error.
+func MustCompileSelector(sel string) *Selector {
+	s, err := CompileSelector(sel)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Selector) String() string {
+	return s.src
+}
+
+// Match reports whether the selector matches n.
+func (s *Selector) Match(n *Node) bool {
+	for _, g := range s.groups {
+		if matchParts(g, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindAll returns the nodes, n included, that the selector matches,
+// in document order.
+func (s *Selector) FindAll(n *Node) (ns []*Node) {
+	Walk(n, func(c *Node) WalkAction {
+		if s.Match(c) {
+			ns = append(ns, c)
+		}
+		return WalkContinue
+	})
+	return
+}
+
+// matchParts matches the last of ps against n, and the rest
+// against n's ancestors, right to left.
+func matchParts(ps []selectorPart, n *Node) bool {
+	last := ps[len(ps)-1]
+	if !last.match(n) {
+		return false
+	}
+	if len(ps) == 1 {
+		return true
+	}
+	rest := ps[:len(ps)-1]
+	if last.child {
+		return n.Parent != nil && matchParts(rest, n.Parent)
+	}
+	for a := n.Parent; a != nil; a = a.Parent {
+		if matchParts(rest, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p selectorPart) match(n *Node) bool {
+	if !p.any && n.Type != p.typ {
+		return false
+	}
+	for _, t := range p.attr {
+		v := getAttr(n.Attr, t.key)
+		if t.hasVal && v != t.val || !t.hasVal && v == "" {
+			return false
+		}
+	}
+	return true
+}
+
+type selectorParser struct {
+	s string
+	i int
+}
+
+func (p *selectorParser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *selectorParser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.s[p.i:])
+	return r
+}
+
+func (p *selectorParser) next() {
+	_, size := utf8.DecodeRuneInString(p.s[p.i:])
+	p.i += size
+}
+
+func (p *selectorParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.peek()) {
+		p.next()
+	}
+}
+
+// group parses one alternative, up to a comma or the end.
+func (p *selectorParser) group() ([]selectorPart, error) {
+	var ps []selectorPart
+	p.skipSpace()
+	for {
+		if p.done() || p.peek() == ',' {
+			if len(ps) == 0 {
+				return nil, fmt.Errorf("empty selector")
+			}
+			return ps, nil
+		}
+		var child bool
+		if p.peek() == '>' {
+			if len(ps) == 0 {
+				return nil, fmt.Errorf("> with nothing before it")
+			}
+			child = true
+			p.i++
+			p.skipSpace()
+		}
+		part, err := p.compound()
+		if err != nil {
+			return nil, err
+		}
+		part.child = child
+		ps = append(ps, part)
+		p.skipSpace()
+	}
+}
+
+func (p *selectorParser) compound() (part selectorPart, err error) {
+	start := p.i
+	for !p.done() && !strings.ContainsRune("[]>,=\"'", p.peek()) && !unicode.IsSpace(p.peek()) {
+		p.next()
+	}
+	switch name := p.s[start:p.i]; name {
+	case "", "*":
+		part.any = true
+	default:
+		t, ok := nodeTypesByName[name]
+		if !ok {
+			return part, fmt.Errorf("unknown node type %q", name)
+		}
+		part.typ = t
+	}
+
+	for !p.done() && p.peek() == '[' {
+		p.i++
+		t, err := p.attrTest()
+		if err != nil {
+			return part, err
+		}
+		part.attr = append(part.attr, t)
+	}
+
+	if p.i == start {
+		return part, fmt.Errorf("unexpected %q at offset %d", p.peek(), p.i)
+	}
+	return part, nil
+}
+
+func (p *selectorParser) attrTest() (t attrTest, err error) {
+	end := strings.IndexByte(p.s[p.i:], ']')
+	if end < 0 {
+		return t, fmt.Errorf("[ is not closed")
+	}
+	body := p.s[p.i : p.i+end]
+	p.i += end + 1
+
+	key, val, hasVal := strings.Cut(body, "=")
+	t.key = strings.TrimSpace(key)
+	if t.key == "" {
+		return t, fmt.Errorf("[%s] has no key", body)
+	}
+	if hasVal {
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		t.val, t.hasVal = val, true
+	}
+	return t, nil
+}
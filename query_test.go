@@ -0,0 +1,133 @@
+package lit_test
+
+import (
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+const queryDoc = `§ Intro ⦉
+
+¶ ⦊
+  ‖ One. ⦉
+  † ⦊
+    ‖ A note. ⦉
+  ⦉
+⦉
+
+<statement type='theorem' id='t1'>
+  ¶ ⦊
+    ‖ Two. ⦉
+  ⦉
+</statement>
+
+<statement type='definition'>
+  ¶ ⦊
+    ‖ Three. ⦉
+  ⦉
+</statement>
+
+<proof>
+  ¶ ⦊
+    ‖ Four. ⦉
+    † ⦊
+      ‖ Another note. ⦉
+    ⦉
+  ⦉
+</proof>`
+
+func TestWalk(t *testing.T) {
+	n, ds := lit.ParseLit(queryDoc)
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var paragraphs int
+	lit.Walk(n, func(c *lit.Node) lit.WalkAction {
+		switch c.Type {
+		case lit.StatementNode:
+			return lit.WalkSkip
+		case lit.ProofNode:
+			return lit.WalkStop
+		case lit.ParagraphNode:
+			paragraphs++
+		}
+		return lit.WalkContinue
+	})
+	if paragraphs != 1 {
+		t.Errorf("got %d paragraphs outside statements and before the proof, want 1", paragraphs)
+	}
+
+	// removing the node being visited is allowed
+	lit.Walk(n, func(c *lit.Node) lit.WalkAction {
+		if c.Type == lit.FootnoteNode {
+			c.Parent.RemoveChild(c)
+		}
+		return lit.WalkContinue
+	})
+	if fs := lit.FindAll(n, lit.FootnoteNode); len(fs) != 0 {
+		t.Errorf("got %d footnotes after removing them all", len(fs))
+	}
+}
+
+func TestFind(t *testing.T) {
+	n, ds := lit.ParseLit(queryDoc)
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(lit.FindAll(n, lit.FootnoteNode)); got != 2 {
+		t.Errorf("got %d footnotes, want 2", got)
+	}
+	th := lit.Find(n, lit.StatementNode, lit.Attribute{Key: "type", Val: "theorem"})
+	if th == nil {
+		t.Fatal("didn't find the theorem")
+	}
+	if got := lit.Find(th, lit.RunNode).FirstChild.Token.Value; got != "Two" {
+		t.Errorf("got theorem starting %q, want %q", got, "Two")
+	}
+	if lit.Find(n, lit.StatementNode, lit.Attribute{Key: "type", Val: "lemma"}) != nil {
+		t.Error("found a lemma that isn't there")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	n, ds := lit.ParseLit(queryDoc)
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		sel  string
+		want int
+	}{
+		{"¶", 4},
+		{"†", 2},
+		{"fragment > ¶", 1},
+		{"fragment > ¶ ‖", 2},
+		{"fragment > ¶ > ‖", 1},
+		{"statement[type=theorem]", 1},
+		{"statement[type='definition'] ‖", 1},
+		{"statement[id]", 1},
+		{"statement, proof", 3},
+		{"proof † ‖", 1},
+		{"*[type]", 2},
+		{"§", 1},
+	}
+	for _, c := range cases {
+		ns, err := lit.Select(n, c.sel)
+		if err != nil {
+			t.Errorf("%q: %v", c.sel, err)
+			continue
+		}
+		if len(ns) != c.want {
+			t.Errorf("%q: got %d nodes, want %d", c.sel, len(ns), c.want)
+		}
+	}
+
+	for _, bad := range []string{"", "> ¶", "nope", "¶[x", "¶,", "[=x]"} {
+		if _, err := lit.CompileSelector(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
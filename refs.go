@@ -0,0 +1,214 @@
+package lit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Label is the number of an element that a ref may name by its id.
+type Label struct {
+	Node *Node
+	ID   string // the element's id, if it has one
+	// Kind is "equation", "figure", "section", or the type of a
+	// statement, e.g. "theorem".
+	Kind string
+	// Number is as LaTeX would number the element, e.g. "3", "2.1"
+	// for a subsection, or "4b" for an equation of subequations.
+	Number string
+}
+
+// Refs are the labels of a tree, by id.
+type Refs map[string]Label
+
+// ResolveRefs numbers the elements of the tree rooted at n that a
+// <ref to='id'/> may name, as LaTeX would: equations, figures, the
+// statements of each type on their own, and numbered sections, by
+// level. The id of a section is that of its header, as WriteHTML writes
+// it.
+//
+// It reports the refs that name no element, and the ids of more than
+// one element, as warnings; for those, the writers write ?? as LaTeX does.
+func ResolveRefs(n *Node) (Refs, Diagnostics) {
+	ls := resolveLabels(n)
+	return ls.ids, ls.ds
+}
+
+// labels are the labels of a tree.
+type labels struct {
+	ids   Refs
+	nodes map[*Node]Label // of every element numbered, with an id or not
+	refd  map[string]bool // the ids the refs name
+	ds    Diagnostics
+
+	cites     []string // the keys the citations cite, in the order first cited
+	citeStyle string   // of the metadata, citeAuthorYear or citeNumeric
+}
+
+func resolveLabels(n *Node) *labels {
+	ls := &labels{ids: make(Refs), nodes: make(map[*Node]Label), refd: make(map[string]bool)}
+	ls.citeStyle = readMetadata(n).CitationStyle
+	if ls.citeStyle != citeNumeric {
+		ls.citeStyle = citeAuthorYear
+	}
+	label := func(id string, l Label) {
+		l.ID = id
+		ls.nodes[l.Node] = l
+		if id == "" {
+			return
+		}
+		if o, ok := ls.ids[id]; ok {
+			ls.ds.add(SeverityWarning, l.Node.Span, CodeDuplicateID, "%s id %q is that of the %s at %s too", l.Kind, id, o.Kind, o.Node.Span.Start)
+			return
+		}
+		ls.ids[id] = l
+	}
+
+	var (
+		equations   int
+		figures     int
+		subequation int // of the subequations at hand, if any
+		sections    []int
+		statements  = make(map[string]int)
+		headerIDs   = make(map[string]bool)
+		refs        []*Node
+		cited       = make(map[string]bool)
+	)
+	var walk func(*Node)
+	walk = func(c *Node) {
+		switch c.Type {
+		case EquationNode:
+			var num string
+			if subequation > 0 {
+				num = fmt.Sprintf("%d%c", equations, 'a'+subequation-1)
+				subequation++
+			} else {
+				equations++
+				num = fmt.Sprint(equations)
+			}
+			label(getAttr(c.Attr, "id"), Label{Node: c, Kind: "equation", Number: num})
+		case SubequationsNode:
+			equations++
+			subequation = 1
+			defer func() { subequation = 0 }()
+		case StatementNode:
+			t := getAttr(c.Attr, "type")
+			if t == "" {
+				t = "statement"
+			}
+			if !texEnvironments[t] {
+				statements[t]++
+				label(getAttr(c.Attr, "id"), Label{Node: c, Kind: t, Number: fmt.Sprint(statements[t])})
+			}
+		case FigureNode:
+			figures++
+			label(getAttr(c.Attr, "id"), Label{Node: c, Kind: "figure", Number: fmt.Sprint(figures)})
+		case SectionNode:
+			id := sectionID(c, headerIDs)
+			if !c.SectionNumbered() {
+				break
+			}
+			level := 1
+			fmt.Sscan(getAttr(c.Attr, "section-level"), &level)
+			for len(sections) < level {
+				sections = append(sections, 0)
+			}
+			sections = sections[:level]
+			sections[level-1]++
+			var num []string
+			for _, s := range sections {
+				num = append(num, fmt.Sprint(s))
+			}
+			label(id, Label{Node: c, Kind: "section", Number: strings.Join(num, ".")})
+		case RefNode:
+			refs = append(refs, c)
+			ls.refd[getAttr(c.Attr, "to")] = true
+		case CiteNode:
+			for _, k := range citeKeys(c) {
+				if !cited[k] {
+					cited[k] = true
+					ls.cites = append(ls.cites, k)
+				}
+			}
+		}
+		for k := c.FirstChild; k != nil; k = k.NextSibling {
+			walk(k)
+		}
+	}
+	walk(n)
+
+	for _, r := range refs {
+		if to := getAttr(r.Attr, "to"); ls.ids[to].Node == nil {
+			ls.ds.add(SeverityWarning, r.Span, CodeDanglingRef, "no element has the id %q", to)
+		}
+	}
+	return ls
+}
+
+// sectionID returns the id of the section n, as WriteHTML writes it,
+// given the ids of the sections before it, to which it adds it; or ""
+// if it has none.
+func sectionID(n *Node, ids map[string]bool) string {
+	if n.FirstChild == nil || n.FirstChild.Type != TokenNode {
+		return ""
+	}
+	id := n.FirstChild.headerTokenString()
+	for ids[id] {
+		id = id + "*" // TODO: better option?
+	}
+	ids[id] = true
+	return id
+}
+
+// labelsOf returns the labels of the tree n is in.
+func labelsOf(n *Node) *labels {
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return resolveLabels(n)
+}
+
+// labelCache holds the labels of the tree a writer writes, resolved
+// when first needed, and then once for the whole write.
+type labelCache struct {
+	labels *labels
+}
+
+// labelsOf returns the labels of the tree n is in.
+func (c *labelCache) labelsOf(n *Node) *labels {
+	if c.labels == nil {
+		c.labels = labelsOf(n)
+	}
+	return c.labels
+}
+
+// refText returns the text of a ref to l: its number, in parentheses
+// if it is that of an equation, as \eqref writes it; or ?? if there is
+// no l.
+func refText(l Label) string {
+	switch {
+	case l.Node == nil:
+		return "??"
+	case l.Kind == "equation":
+		return "(" + l.Number + ")"
+	}
+	return l.Number
+}
+
+// isInlineRef reports whether n is a ref or a cite, which are written
+// in the text, with the spaces around them as the source has them.
+func isInlineRef(n *Node) bool {
+	return n.Type == RefNode || n.Type == CiteNode
+}
+
+// refSpaceBefore reports whether a space goes before the ref or cite n:
+// whether there was one in the source, which the parser keeps.
+func refSpaceBefore(n *Node) bool {
+	return n.PrevSibling != nil && isImplicitSpace(n.PrevSibling)
+}
+
+// refSpaceAfter reports whether a space goes after the ref or cite n,
+// unless it goes before another, as refSpaceBefore of that one reports.
+func refSpaceAfter(n *Node) bool {
+	s := n.NextSibling
+	return s != nil && isImplicitSpace(s) && (s.NextSibling == nil || !isInlineRef(s.NextSibling))
+}
@@ -0,0 +1,214 @@
+package lit_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+const refsDoc = `#§ Sets ⦉
+
+<statement type='theorem' id='thm:a'>
+¶ ⦊
+  ‖ All is well. ⦉
+⦉
+</statement>
+
+<equation id='eq:one'>
+‖ x ∈ A ⦉
+</equation>
+
+#§§ Classes ⦉
+
+<statement type='lemma' id='lem:a'>
+¶ ⦊
+  ‖ Some is well. ⦉
+⦉
+</statement>
+
+<statement type='theorem' id='thm:b'>
+¶ ⦊
+  ‖ More is well. ⦉
+⦉
+</statement>
+
+<subequations>
+<equation id='eq:two'>
+‖ x ⦉
+</equation>
+<equation id='eq:three'>
+‖ y ⦉
+</equation>
+</subequations>
+
+#§ Extension ⦉
+
+¶ ⦊
+  ‖ By Theorem <ref to='thm:b'/> and (<ref to='eq:three'/>), see Section <ref to='Classes'/>. ⦉
+  ‖ Also <ref to='nope'/>. ⦉
+⦉
+`
+
+func TestResolveRefs(t *testing.T) {
+	n, ds := lit.ParseLit(refsDoc)
+	if len(ds) > 0 {
+		t.Fatalf("diagnostics: %v", ds)
+	}
+	refs, ds := lit.ResolveRefs(n)
+	for id, want := range map[string]string{
+		"thm:a":     "theorem 1",
+		"thm:b":     "theorem 2",
+		"lem:a":     "lemma 1",
+		"eq:one":    "equation 1",
+		"eq:two":    "equation 2a",
+		"eq:three":  "equation 2b",
+		"Sets":      "section 1",
+		"Classes":   "section 1.1",
+		"Extension": "section 2",
+	} {
+		if l := refs[id]; l.Kind+" "+l.Number != want || l.ID != id {
+			t.Errorf("refs[%q] = %s %s, want %s", id, l.Kind, l.Number, want)
+		}
+	}
+	if len(ds) != 1 || ds[0].Code != lit.CodeDanglingRef || ds[0].Span.Start.Line != 40 {
+		t.Errorf("diagnostics = %v, want a dangling ref at line 40", ds)
+	}
+
+	var b strings.Builder
+	if err := lit.WriteTex(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`\subsection{Classes}\label{Classes}`,
+		`By Theorem~\ref{thm:b} and (\eqref{eq:three}), see Section~\ref{Classes}.`,
+		`Also~\ref{nope}.`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteTex does not have %q:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), `\label{Sets}`) {
+		t.Errorf("WriteTex labels a section that no ref names:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := lit.WriteHTML(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`By Theorem <a class='ref' href='#thm:b'>2</a> and (<a class='ref' href='#eq:three'>(2b)</a>), see Section <a class='ref' href='#Classes'>1.1</a>.`,
+		`Also <a class='ref' href='#nope'>??</a>.`,
+		`<h2 id='Classes' data-number='1.1'>`,
+		`<div class='lemma' id='lem:a' data-number='1'>`,
+		`\tag{2b}`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteHTML does not have %q:\n%s", want, b.String())
+		}
+	}
+
+	n, _ = lit.ParseLit("<equation id='a'>\n‖ x ⦉\n</equation>\n\n<statement id='a'>\n¶ ⦊\n  ‖ x ⦉\n⦉\n</statement>\n")
+	if _, ds := lit.ResolveRefs(n); len(ds) != 1 || ds[0].Code != lit.CodeDuplicateID {
+		t.Errorf("diagnostics = %v, want a duplicate id", ds)
+	}
+}
+
+const figureDoc = `<figure id='fig:venn' placement='htbp'>
+  <img src='venn.png' width='50%'/>
+  <figcaption>
+    ‖ The sets $A$ and $B$. ⦉
+  </figcaption>
+</figure>
+¶ ⦊
+  ‖ See Figure <ref to='fig:venn'/>. ⦉
+⦉`
+
+func TestFigures(t *testing.T) {
+	n, ds := lit.ParseLit(figureDoc)
+	if len(ds) > 0 {
+		t.Fatalf("diagnostics: %v", ds)
+	}
+	if got := writeLit(t, n); !strings.HasPrefix(got, figureDoc[:strings.Index(figureDoc, "¶")]) {
+		t.Errorf("WriteLit = %q, want the figure as it was", got)
+	}
+	refs, ds := lit.ResolveRefs(n)
+	if l := refs["fig:venn"]; len(ds) > 0 || l.Kind != "figure" || l.Number != "1" {
+		t.Errorf(`refs["fig:venn"] = %s %s, want figure 1; diagnostics: %v`, l.Kind, l.Number, ds)
+	}
+
+	var b strings.Builder
+	if err := lit.WriteTex(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	want := "\\begin{figure}[htbp]\n\\centering\n\\includegraphics[width=0.500000\\textwidth]{venn.png}\n\\caption{The sets $A$ and $B$.}\n\\label{fig:venn}\n\\end{figure}\n"
+	if !strings.HasPrefix(b.String(), want) || !strings.Contains(b.String(), `See Figure~\ref{fig:venn}.`) {
+		t.Errorf("WriteTex = %q, want a figure %q and a ref", b.String(), want)
+	}
+
+	b.Reset()
+	if err := lit.WriteHTML(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<figure id='fig:venn' data-number='1'>`,
+		`<figcaption><span class='lit-figure-number'>Figure 1.</span>`,
+		`See Figure <a class='ref' href='#fig:venn'>1</a>.`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteHTML does not have %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestRefSpacesRoundTrip(t *testing.T) {
+	in := `<yaml>
+citation-style: numeric
+</yaml>
+<equation id='eq:a'>
+‖ x ⦉
+</equation>
+¶ ⦊
+  ‖ See <ref to='eq:a'/>, as in (<ref to='eq:a'/>) or <ref to='eq:a'/> <ref to='eq:a'/> and <cite key='halmos1960' loc='§3'/>. ⦉
+
+  ‖ <cite key='halmos1960'/> is first. ⦉
+⦉`
+	writers := map[string]func(io.Writer, *lit.Node, *lit.WriteOpts) error{
+		"WriteTex":      lit.WriteTex,
+		"WriteHTML":     lit.WriteHTML,
+		"WriteMarkdown": lit.WriteMarkdown,
+		"WriteTEI":      lit.WriteTEI,
+		"WritePandoc":   lit.WritePandoc,
+	}
+	n := lit.Must(lit.ParseLit(in))
+	formatted := writeLit(t, n)
+	m := lit.Must(lit.ParseLit(formatted))
+	if !lit.Equal(n, m) {
+		t.Errorf("WriteLit changed the tree:\n%s", formatted)
+	}
+	if again := writeLit(t, m); again != formatted {
+		t.Errorf("WriteLit is not idempotent:\n%s\nthen\n%s", formatted, again)
+	}
+	for name, write := range writers {
+		var before, after strings.Builder
+		if err := write(&before, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := write(&after, m, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if before.String() != after.String() {
+			t.Errorf("%s after WriteLit:\n%s\nwant:\n%s", name, after.String(), before.String())
+		}
+	}
+
+	var b strings.Builder
+	if err := lit.WriteTex(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	want := `See~\eqref{eq:a}, as in (\eqref{eq:a}) or~\eqref{eq:a}~\eqref{eq:a} and~\citep[§3]{halmos1960}.`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("WriteTex does not have %q:\n%s", want, b.String())
+	}
+}
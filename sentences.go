@@ -0,0 +1,133 @@
+package lit
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	sentenceEnds    = map[string]bool{".": true, "?": true, "!": true, "…": true}
+	sentenceClosers = map[string]bool{"”": true, "’": true, ")": true, "]": true, "›": true, "»": true, "⸥": true, "❭": true, "⁆": true}
+	sentenceOpeners = map[string]bool{"“": true, "‘": true, "(": true, "[": true, "‹": true, "«": true, "⸤": true, "❬": true, "⁅": true, "$": true}
+	// abbreviations that end in a period but not a sentence
+	abbreviations = map[string]bool{
+		"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "jr": true, "sr": true,
+		"vs": true, "cf": true, "fig": true, "eq": true, "sec": true, "ch": true, "no": true, "vol": true, "pp": true,
+	}
+)
+
+// splitSentences splits the tokens and nodes of a paragraph into runs,
+// one per sentence, as the readers of formats without runs need to.
+//
+// A sentence ends at a ., ?, ! or …, and whatever closes around it, if
+// a space and then a capital or an opening quote or bracket follow, and
+// it is not in math or emphasis, nor the period of an abbreviation or
+// an initial.
+func splitSentences(nodes []*Node) []*Node {
+	var runs []*Node
+	var cur []*Node
+	end := func() {
+		if len(cur) > 0 {
+			r := &Node{Type: RunNode}
+			for _, n := range cur {
+				r.AppendChild(n)
+			}
+			r.Span = Span{Start: cur[0].Span.Start, End: cur[len(cur)-1].Span.End}
+			runs = append(runs, r)
+		}
+		cur = nil
+	}
+
+	var depth int
+	var inMath bool
+	value := func(n *Node) string {
+		if n.Type != TokenNode || n.Token.Type == OpaqueToken {
+			return ""
+		}
+		return n.Token.Value
+	}
+	track := func(n *Node) {
+		v := value(n)
+		switch {
+		case v == "$":
+			inMath = !inMath
+		case inMath:
+		case strings.Contains("‹«⸤❬⁅❮⧼", v) && v != "":
+			depth++
+		case strings.Contains("›»⸥❭⁆❯⧽", v) && v != "":
+			depth--
+		}
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		n := nodes[i]
+		cur = append(cur, n)
+		track(n)
+		if inMath || !sentenceEnds[value(n)] && !endsInSentenceEnd(n) {
+			continue
+		}
+		if v := value(n); v == "." && i > 0 {
+			if prev := value(nodes[i-1]); isInitial(prev) || abbreviations[strings.ToLower(prev)] {
+				continue
+			}
+		}
+		j := i + 1
+		for ; j < len(nodes) && (sentenceClosers[value(nodes[j])] || nodes[j].Type == FootnoteNode); j++ {
+		}
+		// the next sentence, after the space; there is no space
+		// token after a node, such as the ◇ the sentence ends in
+		k := j
+		if k < len(nodes) && isImplicitSpace(nodes[k]) {
+			k++
+		} else if nodes[j-1].Type == TokenNode {
+			continue
+		}
+		if k >= len(nodes) {
+			continue
+		}
+		next := value(nodes[k])
+		first, _ := utf8.DecodeRuneInString(next)
+		if !unicode.IsUpper(first) && !unicode.IsDigit(first) && !sentenceOpeners[next] {
+			continue
+		}
+		if unicode.IsDigit(first) && i > 0 && isNumeral(value(nodes[i-1])) {
+			continue // a reference, as in X. ii. 1
+		}
+		for _, c := range nodes[i+1 : j] {
+			cur = append(cur, c)
+			track(c)
+		}
+		if depth != 0 {
+			// still in emphasis, as in ‹one. Two›
+			i = j - 1
+			continue
+		}
+		end()
+		i = k - 1
+	}
+	end()
+	return runs
+}
+
+// endsInSentenceEnd reports whether n is display math ending
+// a sentence, as in ◇ ⦊ ‖ x ∈ A. ⦉ ⦉.
+func endsInSentenceEnd(n *Node) bool {
+	if n.Type != DisplayMathNode || n.LastChild == nil || n.LastChild.LastChild == nil {
+		return false
+	}
+	t := n.LastChild.LastChild.Token
+	return t != nil && sentenceEnds[t.Value]
+}
+
+// isNumeral reports whether s is a number, in digits or lowercase
+// roman numerals.
+func isNumeral(s string) bool {
+	return s != "" && (strings.Trim(s, "0123456789") == "" || strings.Trim(s, "ivxlc") == "")
+}
+
+// isInitial reports whether s is a single letter, as in J. R. R. Tolkien.
+func isInitial(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	return size == len(s) && unicode.IsLetter(r)
+}
@@ -0,0 +1,178 @@
+package lit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// tableAligns returns the alignments of the columns of the table n, as
+// the letters l, c and r: those of its align attribute, or else those of
+// its tex attribute, the column spec of LaTeX, e.g. "l|cc".
+func tableAligns(n *Node) string {
+	if align := getAttr(n.Attr, "align"); align != "" {
+		return align
+	}
+	var b strings.Builder
+	for _, r := range getAttr(n.Attr, "tex") {
+		if r == 'l' || r == 'c' || r == 'r' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// cellAlign returns the alignment of the column of the cell c, as
+// tableAligns does, or 0 if it has none.
+func cellAlign(c *Node) byte {
+	table := c.Parent
+	for table != nil && table.Type != TableNode {
+		table = table.Parent
+	}
+	if table == nil {
+		return 0
+	}
+	col := 0
+	for s := c.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == THNode || s.Type == TDNode {
+			col++
+		}
+	}
+	if aligns := tableAligns(table); col < len(aligns) {
+		return aligns[col]
+	}
+	return 0
+}
+
+// tableColumns returns the number of columns of the table n:
+// the most cells of any of its rows.
+func tableColumns(n *Node) int {
+	var cols int
+	Walk(n, func(r *Node) WalkAction {
+		if r.Type != TableRowNode {
+			return WalkContinue
+		}
+		cells := 0
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == THNode || c.Type == TDNode {
+				cells++
+			}
+		}
+		if cells > cols {
+			cols = cells
+		}
+		return WalkSkip
+	})
+	return cols
+}
+
+// texTableSpec returns the column spec of the tabular of the table n:
+// its align attribute, if checkTableAlign passes it, or else its tex
+// attribute, or else l for each of its columns.
+func texTableSpec(n *Node) (string, error) {
+	if align := getAttr(n.Attr, "align"); align != "" {
+		return align, checkTableAlign(n)
+	}
+	if tex := getAttr(n.Attr, "tex"); tex != "" {
+		return tex, nil
+	}
+	return strings.Repeat("l", tableColumns(n)), nil
+}
+
+// checkTableAlign returns an error if the align attribute of the table
+// n is other than l, c or r for each of its columns.
+func checkTableAlign(n *Node) error {
+	align := getAttr(n.Attr, "align")
+	if align == "" {
+		return nil
+	}
+	if cols := tableColumns(n); strings.Trim(align, "lcr") != "" || len(align) < cols {
+		return fmt.Errorf("table align %q: want l, c or r for each of its %d columns", align, cols)
+	}
+	return nil
+}
+
+// checkTables reports the tables of the tree n whose alignments
+// checkTableAlign doesn't pass.
+func checkTables(n *Node, ds *Diagnostics) {
+	Walk(n, func(c *Node) WalkAction {
+		if c.Type == TableNode {
+			if err := checkTableAlign(c); err != nil {
+				ds.add(SeverityError, c.Span, CodeBadTable, "%v", err)
+			}
+		}
+		return WalkContinue
+	})
+}
+
+// isLitTable reports whether WriteLit can write the table n with the
+// glyphs ▦, ═ and ─, which is so if it has no attributes but its
+// alignments, and each of its rows is in a head or body and each of
+// its cells is a run of text, of headers in a head and data in a body.
+func isLitTable(n *Node) bool {
+	for _, a := range n.Attr {
+		if a.Key != "align" || strings.Trim(a.Val, "lcr") != "" {
+			return false
+		}
+	}
+	for s := n.FirstChild; s != nil; s = s.NextSibling {
+		cell := TDNode
+		switch {
+		case s.Type == TableHeadNode:
+			cell = THNode
+		case s.Type != TableBodyNode:
+			return false
+		}
+		// ParseLit adds a row to the section before it, if of its kind
+		if len(s.Attr) > 0 || s.FirstChild == nil || s.PrevSibling != nil && s.PrevSibling.Type == s.Type {
+			return false
+		}
+		for r := s.FirstChild; r != nil; r = r.NextSibling {
+			if r.Type != TableRowNode || len(r.Attr) > 0 {
+				return false
+			}
+			for c := r.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != cell || len(c.Attr) > 0 || c.FirstChild == nil || c.FirstChild != c.LastChild || c.FirstChild.Type != RunNode {
+					return false
+				}
+				for t := c.FirstChild.FirstChild; t != nil; t = t.NextSibling {
+					if t.Type != TokenNode {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// writeLitTable writes the table n with the glyphs, each row a line;
+// see isLitTable.
+func writeLitTable(w io.Writer, n *Node, opts *WriteOpts) error {
+	w.Write([]byte(opts.Prefix + "▦"))
+	if align := getAttr(n.Attr, "align"); align != "" {
+		w.Write([]byte(" " + align))
+	}
+	w.Write([]byte(" ⦊"))
+	for s := n.FirstChild; s != nil; s = s.NextSibling {
+		glyph := "─"
+		if s.Type == TableHeadNode {
+			glyph = "═"
+		}
+		for r := s.FirstChild; r != nil; r = r.NextSibling {
+			w.Write([]byte("\n" + opts.Prefix + opts.Indent + glyph))
+			for c := r.FirstChild; c != nil; c = c.NextSibling {
+				w.Write([]byte(" ‖ "))
+				if run := c.FirstChild; run.FirstChild != nil {
+					block, _ := tokenBlockStartingAt(run.FirstChild)
+					lines, _ := lineBlocks(block, Val, opts, false, noWrap) // Val doesn't fail
+					w.Write([]byte(strings.Join(lines, " ") + " "))
+				}
+				w.Write([]byte("⦉"))
+			}
+			w.Write([]byte(" ⦉"))
+		}
+	}
+	_, err := w.Write([]byte("\n" + opts.Prefix + "⦉"))
+	return err
+}
@@ -0,0 +1,91 @@
+package lit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+const tableDoc = `▦ lcr ⦊
+  ═ ‖ Set ⦉ ‖ Size ⦉ ‖ Note ⦉ ⦉
+  ─ ‖ $A ∪ B$ ⦉ ‖ 3 ⦉ ‖ ⦉ ⦉
+  ─ ‖ ‹∅› ⦉ ‖ 0 ⦉ ‖ empty \⦉ ⦉ ⦉
+⦉`
+
+func TestTable(t *testing.T) {
+	n, ds := lit.ParseLit(tableDoc)
+	if len(ds) > 0 {
+		t.Fatalf("diagnostics: %v", ds)
+	}
+	table := lit.Find(n, lit.TableNode, lit.Attribute{Key: "align", Val: "lcr"})
+	if table == nil {
+		t.Fatal("no table aligned lcr")
+	}
+	if got := len(lit.FindAll(table, lit.THNode)); got != 3 {
+		t.Errorf("header cells = %d, want 3", got)
+	}
+	if got := len(lit.FindAll(table, lit.TDNode)); got != 6 {
+		t.Errorf("data cells = %d, want 6", got)
+	}
+	if body := lit.Find(table, lit.TableBodyNode); body == nil || body.Span.Start.Line != 3 || body.Span.End.Line != 4 {
+		t.Errorf("body = %v, want lines 3 to 4", body)
+	}
+	if got := writeLit(t, n); got != tableDoc {
+		t.Errorf("WriteLit = %q, want %q", got, tableDoc)
+	}
+
+	var out strings.Builder
+	if err := lit.WriteTex(&out, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	want := "\n\\begin{tabular}{lcr}\n\\toprule\nSet & Size & Note \\\\\n\\midrule\n" +
+		"$A \\cup B$ & 3 &  \\\\\n\\textit{\\varnothing} & 0 & empty ⦉ \\\\\n\\bottomrule\n\\end{tabular}\n"
+	if out.String() != want {
+		t.Errorf("WriteTex = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := lit.WriteHTML(&out, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<table><thead><tr><th style='text-align: left'>",
+		"<td style='text-align: right'>",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteHTML does not have %q:\n%s", want, out.String())
+		}
+	}
+
+	// a table that the glyphs can't write is written as HTML
+	html := "<table tex='l|c'>\n  <tbody>\n    <tr>\n      <td>\n        ‖ a ⦉\n      </td>\n    </tr>\n  </tbody>\n</table>"
+	n = lit.Must(lit.ParseLit(html))
+	if got := writeLit(t, n); got != html {
+		t.Errorf("WriteLit = %q, want %q", got, html)
+	}
+	out.Reset()
+	if err := lit.WriteTex(&out, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "\n\\begin{tabular}{l|c}\n\\toprule\na \\\\\n\\bottomrule") {
+		t.Errorf("WriteTex = %q, want a tabular l|c", out.String())
+	}
+}
+
+func TestTableAlignChecked(t *testing.T) {
+	for _, align := range []string{"l;x", "l"} {
+		src := "<table align='" + align + "'><tbody><tr><td>‖ a ⦉</td><td>‖ b ⦉</td></tr></tbody></table>"
+		n, ds := lit.ParseLit(src)
+		if len(ds) != 1 || ds[0].Code != lit.CodeBadTable || ds[0].Span.Start.Line != 1 {
+			t.Errorf("%s: diagnostics = %v, want a bad table", align, ds)
+		}
+		// rather than a tabular LaTeX can't typeset
+		if err := lit.WriteTex(new(strings.Builder), n, lit.DefaultWriteOpts); err == nil {
+			t.Errorf("%s: WriteTex: no error", align)
+		}
+	}
+	if _, ds := lit.ParseLit("<table align='lc'><tbody><tr><td>‖ a ⦉</td><td>‖ b ⦉</td></tr></tbody></table>"); len(ds) > 0 {
+		t.Errorf("lc: diagnostics = %v, want none", ds)
+	}
+}
@@ -0,0 +1,1654 @@
+package lit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file converts to and from TEI P5 XML; see https://tei-c.org/guidelines/p5/.
+//
+// Sections are nested <div>s, with their headings as <head>. A ¶ is a
+// <p>, with an <s> for each run, and a footnote a <note place="foot">.
+// The document's metadata goes in the <teiHeader>. Nodes TEI has no
+// equivalent for are written as the LitTex of them, in an <ab type="lit">
+// or a <seg type="lit">, which ParseTEI reads back.
+
+const teiNamespace = "http://www.tei-c.org/ns/1.0"
+
+// teiElt is an element of the TEI that WriteTEI writes.
+type teiElt struct {
+	name string
+	attr [][2]string
+	kids []interface{} // *teiElt, teiText or teiComment
+}
+
+type (
+	teiText    string
+	teiComment string
+)
+
+func newTEIElt(name string, attr ...string) *teiElt {
+	e := &teiElt{name: name}
+	for i := 0; i+1 < len(attr); i += 2 {
+		e.attr = append(e.attr, [2]string{attr[i], attr[i+1]})
+	}
+	return e
+}
+
+func (e *teiElt) add(kids ...interface{}) {
+	e.kids = append(e.kids, kids...)
+}
+
+// text adds s to the text e ends in, if it ends in text.
+func (e *teiElt) text(s string) {
+	if k := len(e.kids); k > 0 {
+		if t, ok := e.kids[k-1].(teiText); ok {
+			e.kids[k-1] = t + teiText(s)
+			return
+		}
+	}
+	e.kids = append(e.kids, teiText(s))
+}
+
+// endsInSpace reports whether e is empty or ends in a space.
+func (e *teiElt) endsInSpace() bool {
+	if len(e.kids) == 0 {
+		return true
+	}
+	t, ok := e.kids[len(e.kids)-1].(teiText)
+	return ok && strings.HasSuffix(string(t), " ")
+}
+
+func (e *teiElt) trimSpace() {
+	if k := len(e.kids); k > 0 {
+		if t, ok := e.kids[k-1].(teiText); ok {
+			if t = teiText(strings.TrimRight(string(t), " ")); t == "" {
+				e.kids = e.kids[:k-1]
+			} else {
+				e.kids[k-1] = t
+			}
+		}
+	}
+}
+
+// teiInlineElts are written on one line, with all they hold;
+// the others are too, unless they hold only elements.
+var teiInlineElts = map[string]bool{
+	"s": true, "hi": true, "term": true, "ref": true, "head": true, "note": true,
+	"formula": true, "seg": true, "graphic": true, "lb": true,
+	"title": true, "author": true, "idno": true, "date": true, "language": true, "change": true,
+}
+
+// WriteTEI writes the tree rooted at n as a TEI document.
+func WriteTEI(w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteTEI", n)
+	w = ew
+
+	s := new(teiWriteState)
+	body := newTEIElt("body")
+	if n.Type == FragmentNode {
+		err = teiBlocks(s, body, n.FirstChild)
+	} else {
+		err = teiBlocks(s, body, n)
+	}
+	if err != nil {
+		return err
+	}
+	if len(body.kids) == 0 {
+		body.add(newTEIElt("p")) // a body can't be empty
+	}
+	text := newTEIElt("text")
+	text.add(body)
+	tei := newTEIElt("TEI", "xmlns", teiNamespace)
+	tei.add(teiHeader(readMetadata(n)), text)
+
+	w.Write([]byte(opts.Prefix + xml.Header))
+	writeTEIElt(w, tei, opts.Prefix, opts.Indent)
+	w.Write([]byte("\n"))
+	return nil
+}
+
+func teiHeader(m metadata) *teiElt {
+	title := newTEIElt("title")
+	if m.Title != "" {
+		title.text(m.Title)
+	}
+	titleStmt := newTEIElt("titleStmt")
+	titleStmt.add(title)
+	for _, a := range m.Authors {
+		author := newTEIElt("author")
+		author.text(a)
+		titleStmt.add(author)
+	}
+
+	// a <p>, since a publisher or the like would come first otherwise
+	pub := newTEIElt("p")
+	if m.Identifier != "" {
+		idno := newTEIElt("idno")
+		idno.text(m.Identifier)
+		pub.add(idno)
+	}
+	if m.Date != "" {
+		date := newTEIElt("date", "when", m.Date)
+		date.text(m.Date)
+		pub.add(date)
+	}
+	if len(pub.kids) == 0 {
+		pub.text("Unpublished.")
+	}
+	publicationStmt := newTEIElt("publicationStmt")
+	publicationStmt.add(pub)
+
+	source := newTEIElt("p")
+	source.text("Converted from LitTex.")
+	sourceDesc := newTEIElt("sourceDesc")
+	sourceDesc.add(source)
+
+	fileDesc := newTEIElt("fileDesc")
+	fileDesc.add(titleStmt, publicationStmt, sourceDesc)
+	header := newTEIElt("teiHeader")
+	header.add(fileDesc)
+	if m.Language != "" {
+		langUsage := newTEIElt("langUsage")
+		langUsage.add(newTEIElt("language", "ident", m.Language))
+		profileDesc := newTEIElt("profileDesc")
+		profileDesc.add(langUsage)
+		header.add(profileDesc)
+	}
+	if m.Modified != "" {
+		revisionDesc := newTEIElt("revisionDesc")
+		revisionDesc.add(newTEIElt("change", "when", m.Modified))
+		header.add(revisionDesc)
+	}
+	return header
+}
+
+// teiDivTypes are the types of the <div>s of sections, by level.
+var teiDivTypes = map[int]string{1: "section", 2: "subsection", 3: "subsubsection"}
+
+// teiBlocks adds c and its next siblings to parent, nesting the nodes
+// after each section in its <div>, up to the next section of its level
+// or above.
+// teiWriteState is the state of a call of WriteTEI.
+type teiWriteState struct {
+	labelCache
+}
+
+func teiBlocks(s *teiWriteState, parent *teiElt, c *Node) error {
+	type section struct {
+		level int
+		div   *teiElt
+	}
+	var open []section
+	top := func() *teiElt {
+		if len(open) > 0 {
+			return open[len(open)-1].div
+		}
+		return parent
+	}
+	for ; c != nil; c = c.NextSibling {
+		if c.Type != SectionNode {
+			if err := teiBlock(s, top(), c); err != nil {
+				return err
+			}
+			continue
+		}
+		level, err := strconv.Atoi(c.SectionLevel())
+		if err != nil || level < 1 || level > 3 {
+			level = 1
+		}
+		for len(open) > 0 && open[len(open)-1].level >= level {
+			open = open[:len(open)-1]
+		}
+		div := newTEIElt("div", "type", teiDivTypes[level])
+		if !c.SectionNumbered() {
+			div.attr = append(div.attr, [2]string{"rend", "unnumbered"})
+		}
+		head := newTEIElt("head")
+		if err := teiInlines(s, head, c.FirstChild, nil); err != nil {
+			return err
+		}
+		div.add(head)
+		top().add(div)
+		open = append(open, section{level, div})
+	}
+	return nil
+}
+
+func teiBlock(s *teiWriteState, parent *teiElt, n *Node) error {
+	switch n.Type {
+	case FragmentNode:
+		return teiBlocks(s, parent, n.FirstChild)
+	case ParagraphNode:
+		p := newTEIElt("p")
+		if err := teiMixed(s, p, n.FirstChild, nil, true); err != nil {
+			return err
+		}
+		parent.add(p)
+	case RunNode, DisplayMathNode, EquationNode, TokenNode, LinkNode, FootnoteNode, TextNode:
+		// an anonymous block, as TEI has no runs outside paragraphs
+		ab := newTEIElt("ab")
+		if err := teiMixed(s, ab, n, n.NextSibling, true); err != nil {
+			return err
+		}
+		parent.add(ab)
+	case ListNode:
+		rend := "bulleted"
+		if getAttr(n.Attr, "list-type") == "ordered" {
+			rend = "numbered"
+		}
+		list := newTEIElt("list", "rend", rend)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			item := newTEIElt("item")
+			var err error
+			if c.Type == ListItemNode {
+				err = teiMixed(s, item, c.FirstChild, nil, false)
+			} else {
+				err = teiBlock(s, item, c)
+			}
+			if err != nil {
+				return err
+			}
+			list.add(item)
+		}
+		parent.add(list)
+	case ListItemNode:
+		// an item outside a list, as slides have
+		item := newTEIElt("item")
+		if err := teiMixed(s, item, n.FirstChild, nil, false); err != nil {
+			return err
+		}
+		list := newTEIElt("list", "type", "bare")
+		list.add(item)
+		parent.add(list)
+	case SectionNode:
+		return teiBlocks(s, parent, n)
+	case QuoteNode:
+		q := newTEIElt("quote")
+		if err := teiBlocks(s, q, n.FirstChild); err != nil {
+			return err
+		}
+		parent.add(q)
+	case StatementNode, ProofNode, CenterAlignNode, RightAlignNode, SubequationsNode:
+		var div *teiElt
+		switch n.Type {
+		case StatementNode:
+			div = newTEIElt("div", "type", "statement")
+			if t := getAttr(n.Attr, "type"); t != "" {
+				div.attr = append(div.attr, [2]string{"subtype", t})
+			}
+			if id := getAttr(n.Attr, "id"); id != "" {
+				div.attr = append(div.attr, [2]string{"xml:id", id})
+			}
+			if text := getAttr(n.Attr, "text"); text != "" {
+				head := newTEIElt("head")
+				head.text(text)
+				div.add(head)
+			}
+		case ProofNode:
+			div = newTEIElt("div", "type", "proof")
+		case CenterAlignNode:
+			div = newTEIElt("div", "rend", "center")
+		case RightAlignNode:
+			div = newTEIElt("div", "rend", "right")
+		case SubequationsNode:
+			div = newTEIElt("div", "type", "subequations")
+		}
+		if err := teiBlocks(s, div, n.FirstChild); err != nil {
+			return err
+		}
+		parent.add(div)
+	case ImageNode:
+		fig := newTEIElt("figure")
+		fig.add(teiGraphic(n))
+		parent.add(fig)
+	case FigureNode:
+		fig := newTEIElt("figure")
+		if id := getAttr(n.Attr, "id"); id != "" {
+			fig.attr = append(fig.attr, [2]string{"xml:id", id})
+		}
+		if l, ok := s.labelsOf(n).nodes[n]; ok {
+			fig.attr = append(fig.attr, [2]string{"n", l.Number})
+		}
+		if placement := getAttr(n.Attr, "placement"); placement != "" {
+			fig.attr = append(fig.attr, [2]string{"place", placement})
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			var err error
+			switch c.Type {
+			case ImageNode:
+				fig.add(teiGraphic(c))
+			case CaptionNode:
+				head := newTEIElt("head")
+				err = teiMixed(s, head, c.FirstChild, nil, true)
+				fig.add(head)
+			default:
+				err = teiBlock(s, fig, c)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		parent.add(fig)
+	case TableNode:
+		t, err := teiTable(s, n)
+		if err != nil {
+			return err
+		}
+		parent.add(t)
+	case CodeNode, PreNode:
+		text, err := codeText(n, NoPrefix(DefaultWriteOpts))
+		if err != nil {
+			return err
+		}
+		ab := newTEIElt("ab", "type", "code")
+		ab.text(text)
+		parent.add(ab)
+	case CommentNode:
+		parent.add(teiComment(n.Data))
+	case TexOnlyNode, JSONNode, YAMLNode:
+		// TeX is for TeX, and the metadata is in the header
+	default:
+		lit, err := teiLit(n)
+		if err != nil {
+			return err
+		}
+		ab := newTEIElt("ab", "type", "lit")
+		ab.text(lit)
+		parent.add(ab)
+	}
+	return nil
+}
+
+func teiLit(n *Node) (string, error) {
+	var b bytes.Buffer
+	if err := WriteLit(&b, n, NoPrefix(DefaultWriteOpts)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func isTEIInline(n *Node) bool {
+	switch n.Type {
+	case TokenNode, FootnoteNode, LinkNode, ImageNode, TextNode:
+		return true
+	}
+	return false
+}
+
+// teiMixed adds the nodes from c up to end, or on if end is nil, to
+// parent. Runs become <s>, as do the tokens and inline nodes between
+// them if sentences is set; else those are added as they are.
+func teiMixed(s *teiWriteState, parent *teiElt, c, end *Node, sentences bool) error {
+	for c != end {
+		switch {
+		case c.Type == RunNode:
+			sentence := newTEIElt("s")
+			if err := teiInlines(s, sentence, c.FirstChild, nil); err != nil {
+				return err
+			}
+			parent.add(sentence)
+			c = c.NextSibling
+		case c.Type == DisplayMathNode || c.Type == EquationNode:
+			f, err := teiFormula(c)
+			if err != nil {
+				return err
+			}
+			parent.add(f)
+			c = c.NextSibling
+		case isTEIInline(c):
+			start := c
+			for c != end && isTEIInline(c) {
+				c = c.NextSibling
+			}
+			into := parent
+			if sentences {
+				into = newTEIElt("s")
+				parent.add(into)
+			}
+			if err := teiInlines(s, into, start, c); err != nil {
+				return err
+			}
+		default:
+			if err := teiBlock(s, parent, c); err != nil {
+				return err
+			}
+			c = c.NextSibling
+		}
+		if !sentences {
+			sentences = true // only the first tokens are an item's own
+		}
+	}
+	return nil
+}
+
+func teiFormula(n *Node) (*teiElt, error) {
+	tex, err := displayMathTex("WriteTEI", n)
+	if err != nil {
+		return nil, err
+	}
+	f := newTEIElt("formula", "notation", "tex", "rend", "display")
+	if id := getAttr(n.Attr, "id"); id != "" && n.Type == EquationNode {
+		f.attr = append(f.attr, [2]string{"xml:id", id})
+	}
+	f.text(tex)
+	return f, nil
+}
+
+func teiGraphic(n *Node) *teiElt {
+	g := newTEIElt("graphic", "url", getAttr(n.Attr, "src"))
+	if width := getAttr(n.Attr, "width"); width != "" {
+		g.attr = append(g.attr, [2]string{"width", width})
+	}
+	return g
+}
+
+func teiTable(s *teiWriteState, n *Node) (*teiElt, error) {
+	t := newTEIElt("table")
+	if align := tableAligns(n); align != "" {
+		t.attr = append(t.attr, [2]string{"rend", align})
+	}
+	addRow := func(r *Node, inHead bool) error {
+		if r.Type != TableRowNode {
+			return &WriteError{Op: "WriteTEI", Type: r.Type, Span: r.Span, Err: ErrUnsupportedNode}
+		}
+		row := newTEIElt("row")
+		if inHead {
+			row.attr = append(row.attr, [2]string{"role", "label"})
+		}
+		for c := r.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != THNode && c.Type != TDNode {
+				return &WriteError{Op: "WriteTEI", Type: c.Type, Span: c.Span, Err: ErrUnsupportedNode}
+			}
+			cell := newTEIElt("cell")
+			if c.Type == THNode && !inHead {
+				cell.attr = append(cell.attr, [2]string{"role", "label"})
+			}
+			// a cell's runs are its text
+			for k := c.FirstChild; k != nil; k = k.NextSibling {
+				var err error
+				if k.Type == RunNode {
+					if !cell.endsInSpace() {
+						cell.text(" ")
+					}
+					err = teiInlines(s, cell, k.FirstChild, nil)
+				} else {
+					err = teiMixed(s, cell, k, nil, false)
+					k = nil
+				}
+				if err != nil {
+					return err
+				}
+				if k == nil {
+					break
+				}
+			}
+			row.add(cell)
+		}
+		t.add(row)
+		return nil
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case TableHeadNode, TableBodyNode:
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if err := addRow(r, c.Type == TableHeadNode); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			if err := addRow(c, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// teiFormats maps the tokens that open formatting to the element, and
+// its rend, they make, and the token that closes them.
+var teiFormats = map[string]struct{ elt, rend, close string }{
+	"‹": {"hi", "italic", "›"},
+	"«": {"hi", "bold", "»"},
+	"❮": {"hi", "bold", "❯"},
+	"⸤": {"hi", "smallcaps", "⸥"},
+	"❬": {"term", "", "❭"},
+	"⧼": {"term", "", "⧽"},
+	"⁅": {"hi", "monospace", "⁆"},
+}
+
+// teiInlineBuilder adds tokens and inline nodes to an element, keeping
+// a stack of the elements that formatting tokens such as ‹ open.
+type teiInlineBuilder struct {
+	s      *teiWriteState
+	stack  []*teiElt
+	closes []string // the token that closes each element of the stack
+	inMath bool
+	math   strings.Builder
+}
+
+// teiInlines adds the nodes from c on, up to but not including end, to e.
+func teiInlines(s *teiWriteState, e *teiElt, c, end *Node) error {
+	b := &teiInlineBuilder{s: s, stack: []*teiElt{e}, closes: []string{""}}
+	if err := b.nodes(c, end); err != nil {
+		return err
+	}
+	b.done()
+	return nil
+}
+
+func (b *teiInlineBuilder) top() *teiElt {
+	return b.stack[len(b.stack)-1]
+}
+
+func (b *teiInlineBuilder) nodes(c, end *Node) error {
+	for ; c != end; c = c.NextSibling {
+		switch c.Type {
+		case TokenNode:
+			b.token(c.Token)
+		case RunNode:
+			if err := b.nodes(c.FirstChild, nil); err != nil {
+				return err
+			}
+		case FootnoteNode:
+			note := newTEIElt("note", "place", "foot")
+			if err := teiMixed(b.s, note, c.FirstChild, nil, true); err != nil {
+				return err
+			}
+			b.top().trimSpace()
+			b.top().add(note)
+			if spaceAfterNote(c) {
+				b.top().text(" ")
+			}
+		case LinkNode:
+			ref := newTEIElt("ref", "target", getAttr(c.Attr, "href"))
+			if err := teiInlines(b.s, ref, c.FirstChild, nil); err != nil {
+				return err
+			}
+			b.top().add(ref)
+		case ImageNode:
+			b.top().add(teiGraphic(c))
+		case DisplayMathNode, EquationNode:
+			f, err := teiFormula(c)
+			if err != nil {
+				return err
+			}
+			b.top().add(f)
+			b.top().text(" ") // the tokens after it have no space
+		case RefNode:
+			// the spaces around it are tokens of their own
+			to := getAttr(c.Attr, "to")
+			ref := newTEIElt("ref", "target", "#"+to)
+			ref.text(refText(b.s.labelsOf(c).ids[to]))
+			b.top().add(ref)
+		case CiteNode:
+			var targets []string
+			for _, k := range citeKeys(c) {
+				targets = append(targets, "#"+k)
+			}
+			ref := newTEIElt("ref", "type", "bibl", "target", strings.Join(targets, " "))
+			ref.text(newCitation(c, b.s.labelsOf(c), nil).String())
+			b.top().add(ref)
+		case TextNode:
+			b.top().text(c.Data)
+		case CommentNode:
+			b.top().add(teiComment(c.Data))
+		default:
+			lit, err := teiLit(c)
+			if err != nil {
+				return err
+			}
+			seg := newTEIElt("seg", "type", "lit")
+			seg.text(lit)
+			b.top().add(seg)
+		}
+	}
+	return nil
+}
+
+func (b *teiInlineBuilder) token(t *Token) {
+	if b.inMath {
+		if t.Type == SymbolToken && t.Value == "$" {
+			b.inMath = false
+			f := newTEIElt("formula", "notation", "tex")
+			f.text(strings.TrimSpace(b.math.String()))
+			b.math.Reset()
+			b.top().add(f)
+			return
+		}
+		b.math.WriteString(Tex(t, true))
+		return
+	}
+
+	switch {
+	case isSpace(t):
+		if !b.top().endsInSpace() {
+			b.top().text(" ")
+		}
+	case t.Type == SymbolToken && t.Value == "$":
+		b.inMath = true
+	case t.Type == OpaqueToken:
+		seg := newTEIElt("seg", "type", "tex")
+		seg.text(t.Value)
+		b.top().add(seg)
+	case t.Value == "᜶":
+		b.top().add(newTEIElt("lb"))
+	case t.Value == "↦" || t.Value == "↤":
+		// indentation, which TEI leaves to the rendering
+	case t.Value == "＆":
+		b.top().text("&")
+	default:
+		if f, ok := teiFormats[t.Value]; ok {
+			e := newTEIElt(f.elt)
+			if f.rend != "" {
+				e.attr = append(e.attr, [2]string{"rend", f.rend})
+			}
+			b.top().add(e)
+			b.stack = append(b.stack, e)
+			b.closes = append(b.closes, f.close)
+			return
+		}
+		if len(b.stack) > 1 && b.closes[len(b.closes)-1] == t.Value {
+			b.pop()
+			return
+		}
+		b.top().text(t.Value)
+	}
+}
+
+func (b *teiInlineBuilder) pop() {
+	b.top().trimSpace()
+	b.stack = b.stack[:len(b.stack)-1]
+	b.closes = b.closes[:len(b.closes)-1]
+}
+
+func (b *teiInlineBuilder) done() {
+	if b.inMath {
+		b.token(&Token{Type: SymbolToken, Value: "$"})
+	}
+	for len(b.stack) > 1 {
+		b.pop()
+	}
+	b.top().trimSpace()
+}
+
+// teiLineElts are written on lines of their own, even among text.
+var teiLineElts = map[string]bool{
+	"p": true, "ab": true, "list": true, "item": true, "quote": true,
+	"div": true, "table": true, "row": true, "cell": true, "figure": true,
+}
+
+// writeTEIElt writes e, its children indented on lines of their own
+// if it holds only elements, and else on one line, but for its blocks.
+func writeTEIElt(w io.Writer, e *teiElt, prefix, indent string) {
+	w.Write([]byte(prefix))
+	writeTEIStart(w, e)
+	if len(e.kids) == 0 {
+		return
+	}
+	var text, blocks bool
+	for _, k := range e.kids {
+		switch k := k.(type) {
+		case teiText:
+			text = true
+		case *teiElt:
+			blocks = blocks || teiLineElts[k.name]
+		}
+	}
+	if teiInlineElts[e.name] || text && !blocks {
+		for _, k := range e.kids {
+			writeTEIInline(w, k)
+		}
+		w.Write([]byte("</" + e.name + ">"))
+		return
+	}
+	inLine := false // whether a line of text and inline elements is open
+	for _, k := range e.kids {
+		if k, ok := k.(*teiElt); ok && (!text || teiLineElts[k.name]) {
+			w.Write([]byte("\n"))
+			writeTEIElt(w, k, prefix+indent, indent)
+			inLine = false
+			continue
+		}
+		if !inLine {
+			w.Write([]byte("\n" + prefix + indent))
+			inLine = true
+		}
+		writeTEIInline(w, k)
+	}
+	w.Write([]byte("\n" + prefix + "</" + e.name + ">"))
+}
+
+// writeTEIStart writes e's start tag, or its empty-element tag if it
+// has no children.
+func writeTEIStart(w io.Writer, e *teiElt) {
+	w.Write([]byte("<" + e.name))
+	for _, a := range e.attr {
+		w.Write([]byte(" " + a[0] + "=\"" + teiAttrEscaper.Replace(a[1]) + "\""))
+	}
+	if len(e.kids) == 0 {
+		w.Write([]byte("/>"))
+	} else {
+		w.Write([]byte(">"))
+	}
+}
+
+// The escapers escape only what XML requires, unlike xml.EscapeText,
+// so that the text of code keeps its apostrophes and lines.
+var (
+	teiTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	teiAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+)
+
+func writeTEIInline(w io.Writer, k interface{}) {
+	switch k := k.(type) {
+	case *teiElt:
+		writeTEIStart(w, k)
+		if len(k.kids) == 0 {
+			return
+		}
+		for _, kk := range k.kids {
+			writeTEIInline(w, kk)
+		}
+		w.Write([]byte("</" + k.name + ">"))
+	case teiText:
+		w.Write([]byte(teiTextEscaper.Replace(string(k))))
+	case teiComment:
+		// a comment can't have --
+		w.Write([]byte("<!--" + strings.ReplaceAll(string(k), "--", "- -") + "-->"))
+	}
+}
+
+// ParseTEI parses a TEI document, such as WriteTEI writes or the Perseus
+// Digital Library publishes.
+//
+// The paragraphs of texts that don't mark sentences with <s> are split
+// into runs at the ends of their sentences. The elements of names, dates,
+// and the like are read as their text, and those of pages, lines and
+// other references to a printed edition are dropped. Elements lit has
+// no equivalent for are read as their text too, with a warning.
+func ParseTEI(s string) (*Node, Diagnostics) {
+	r := &teiReader{src: newSource(s), warned: make(map[string]bool)}
+	root := &Node{Type: FragmentNode, Span: r.src.span(0, len(s))}
+
+	doc, err := r.decode(s)
+	if err != nil {
+		return root, r.ds
+	}
+	if h := doc.find("teiHeader"); h != nil {
+		if meta := r.header(h); meta != nil {
+			root.AppendChild(meta)
+		}
+	}
+	r.blocks(doc.kids, root)
+	return root, r.ds
+}
+
+// teiElem is an element of the TEI that ParseTEI reads.
+type teiElem struct {
+	name       string // the local name
+	attr       map[string]string
+	kids       []interface{} // *teiElem, *teiChars or *teiXMLComment
+	start, end int
+}
+
+// teiChars is character data, with the source offset of each byte
+// and an extra one for the end.
+type teiChars struct {
+	s string
+	m offsetMap
+}
+
+type teiXMLComment struct {
+	s          string
+	start, end int
+}
+
+// find returns the first element named name, in e or under it.
+func (e *teiElem) find(name string) *teiElem {
+	if e.name == name {
+		return e
+	}
+	for _, k := range e.kids {
+		if ke, ok := k.(*teiElem); ok {
+			if f := ke.find(name); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// text returns the text in e.
+func (e *teiElem) text() string {
+	var b strings.Builder
+	for _, k := range e.kids {
+		switch k := k.(type) {
+		case *teiElem:
+			b.WriteString(k.text())
+		case *teiChars:
+			b.WriteString(k.s)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+type teiReader struct {
+	src    *source
+	ds     Diagnostics
+	warned map[string]bool // the elements warned about
+	depth  int             // the sections open
+}
+
+// decode parses the XML of s, into a document element holding
+// what is at the top level.
+func (r *teiReader) decode(s string) (*teiElem, error) {
+	doc := &teiElem{end: len(s)}
+	stack := []*teiElem{doc}
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		start := int(d.InputOffset())
+		tok, err := d.Token()
+		end := int(d.InputOffset())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.ds.add(SeverityError, r.src.span(end, end), CodeBadTEI, "%v", err)
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			e := &teiElem{name: t.Name.Local, attr: make(map[string]string), start: start}
+			for _, a := range t.Attr {
+				key := a.Name.Local
+				if a.Name.Space == "xml" || a.Name.Space == "http://www.w3.org/XML/1998/namespace" {
+					key = "xml:" + key
+				}
+				e.attr[key] = a.Value
+			}
+			top.kids = append(top.kids, e)
+			stack = append(stack, e)
+		case xml.EndElement:
+			top.end = end
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			// entities and CDATA make the text differ from the
+			// source, and then we know only where it starts
+			c := &teiChars{s: string(t), m: make(offsetMap, len(t)+1)}
+			same := s[start:end] == c.s
+			for i := range c.m {
+				if same {
+					c.m[i] = start + i
+				} else {
+					c.m[i] = start
+				}
+			}
+			top.kids = append(top.kids, c)
+		case xml.Comment:
+			top.kids = append(top.kids, &teiXMLComment{s: string(t), start: start, end: end})
+		}
+	}
+	return doc, nil
+}
+
+func (r *teiReader) span(e *teiElem) Span {
+	return r.src.span(e.start, e.end)
+}
+
+// header reads the metadata in a teiHeader, as a YAML node.
+func (r *teiReader) header(h *teiElem) *Node {
+	m := make(map[interface{}]interface{})
+	if ts := h.find("titleStmt"); ts != nil {
+		var authors []interface{}
+		for _, k := range ts.kids {
+			e, ok := k.(*teiElem)
+			if !ok {
+				continue
+			}
+			switch e.name {
+			case "title":
+				if _, ok := m["title"]; !ok && e.text() != "" {
+					m["title"] = e.text()
+				}
+			case "author", "editor":
+				if e.name == "author" && e.text() != "" {
+					authors = append(authors, e.text())
+				}
+			}
+		}
+		switch len(authors) {
+		case 0:
+		case 1:
+			m["author"] = authors[0]
+		default:
+			m["author"] = authors
+		}
+	}
+	if ps := h.find("publicationStmt"); ps != nil {
+		if idno := ps.find("idno"); idno != nil && idno.text() != "" {
+			m["identifier"] = idno.text()
+		}
+		if date := ps.find("date"); date != nil {
+			if when := date.attr["when"]; when != "" {
+				m["date"] = when
+			} else if date.text() != "" {
+				m["date"] = date.text()
+			}
+		}
+	}
+	if lang := h.find("language"); lang != nil && lang.attr["ident"] != "" {
+		m["lang"] = lang.attr["ident"]
+	}
+	if change := h.find("change"); change != nil && change.attr["when"] != "" {
+		m["modified"] = change.attr["when"]
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return &Node{Type: YAMLNode, YAML: m, Span: r.span(h)}
+}
+
+func (r *teiReader) warn(e *teiElem, format string, args ...interface{}) {
+	if r.warned[e.name] {
+		return
+	}
+	r.warned[e.name] = true
+	r.ds.add(SeverityWarning, r.src.span(e.start, e.start), CodeBadTEI, format, args...)
+}
+
+// teiBlockElts are the elements blocks reads as blocks; the text and
+// other elements between them are read as paragraphs.
+var teiBlockElts = map[string]bool{
+	"TEI": true, "teiCorpus": true, "teiHeader": true, "text": true, "front": true, "body": true,
+	"back": true, "group": true, "div": true, "div1": true, "div2": true, "div3": true, "div4": true,
+	"div5": true, "div6": true, "div7": true, "head": true, "p": true, "ab": true, "lg": true, "l": true,
+	"sp": true, "speaker": true, "list": true, "quote": true, "cit": true, "table": true,
+	"figure": true, "formula": true, "pb": true, "milestone": true, "cb": true, "fw": true,
+}
+
+// teiDropped are the elements that hold nothing for lit, as they mark
+// the pages and lines of a printed edition, or what it left out.
+var teiDropped = map[string]bool{
+	"pb": true, "milestone": true, "cb": true, "fw": true, "gap": true, "del": true,
+	"sic": true, "orig": true, "abbr": true, "figDesc": true, "rdg": true,
+	"teiHeader": true,
+}
+
+// blocks adds the blocks of kids to parent.
+func (r *teiReader) blocks(kids []interface{}, parent *Node) {
+	var pending []interface{} // inline content, to make a paragraph of
+	flush := func() {
+		if teiBlank(pending) {
+			pending = nil
+			return
+		}
+		p := &Node{Type: ParagraphNode}
+		r.paragraph(pending, p, parent)
+		pending = nil
+	}
+	for _, k := range kids {
+		switch k := k.(type) {
+		case *teiXMLComment:
+			flush()
+			parent.AppendChild(&Node{Type: CommentNode, Data: k.s, Span: r.src.span(k.start, k.end)})
+		case *teiElem:
+			if !teiBlockElts[k.name] || k.name == "formula" && k.attr["rend"] != "display" {
+				pending = append(pending, k)
+				continue
+			}
+			flush()
+			r.block(k, parent)
+		default:
+			pending = append(pending, k)
+		}
+	}
+	flush()
+}
+
+// teiBlank reports whether kids are only space.
+func teiBlank(kids []interface{}) bool {
+	for _, k := range kids {
+		if c, ok := k.(*teiChars); !ok || strings.TrimSpace(c.s) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *teiReader) block(e *teiElem, parent *Node) {
+	if teiDropped[e.name] {
+		return
+	}
+	switch e.name {
+	case "TEI", "teiCorpus", "text", "front", "body", "back", "group", "cit":
+		r.blocks(e.kids, parent)
+	case "div", "div1", "div2", "div3", "div4", "div5", "div6", "div7":
+		r.div(e, parent)
+	case "head":
+		// a head not at the start of a div
+		n := &Node{Type: SectionNode, Span: r.span(e)}
+		r.section(n)
+		r.inlineInto(e.kids, n)
+		parent.AppendChild(n)
+	case "p":
+		p := &Node{Type: ParagraphNode, Span: r.span(e)}
+		r.paragraph(e.kids, p, parent)
+	case "ab":
+		switch e.attr["type"] {
+		case "code":
+			n := &Node{Type: CodeNode, Span: r.span(e)}
+			run := &Node{Type: RunNode, Span: n.Span}
+			run.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: OpaqueToken, Value: teiRawText(e), Span: n.Span}, Span: n.Span})
+			n.AppendChild(run)
+			parent.AppendChild(n)
+		case "lit":
+			r.lit(e, parent)
+		default:
+			// the runs of an anonymous block are its parent's
+			for _, n := range r.runs(e.kids) {
+				parent.AppendChild(n)
+			}
+		}
+	case "lg":
+		// a run for each line of verse
+		p := &Node{Type: ParagraphNode, Span: r.span(e)}
+		for _, k := range e.kids {
+			if l, ok := k.(*teiElem); ok && l.name == "l" {
+				run := &Node{Type: RunNode, Span: r.span(l)}
+				r.inlineInto(l.kids, run)
+				p.AppendChild(run)
+			} else if ok && l.name == "lg" {
+				r.block(l, parent)
+			}
+		}
+		if p.FirstChild != nil {
+			parent.AppendChild(p)
+		}
+	case "l":
+		p := &Node{Type: ParagraphNode, Span: r.span(e)}
+		run := &Node{Type: RunNode, Span: r.span(e)}
+		r.inlineInto(e.kids, run)
+		p.AppendChild(run)
+		parent.AppendChild(p)
+	case "sp":
+		r.blocks(e.kids, parent)
+	case "speaker":
+		p := &Node{Type: ParagraphNode, Span: r.span(e)}
+		run := &Node{Type: RunNode, Span: r.span(e)}
+		r.symbol("«", run)
+		r.inlineInto(e.kids, run)
+		r.symbol("»", run)
+		p.AppendChild(run)
+		parent.AppendChild(p)
+	case "list":
+		if e.attr["type"] == "bare" {
+			// items outside a list, as WriteTEI writes them
+			for _, k := range e.kids {
+				if item, ok := k.(*teiElem); ok && item.name == "item" {
+					li := &Node{Type: ListItemNode, Span: r.span(item)}
+					r.item(item.kids, li)
+					parent.AppendChild(li)
+				}
+			}
+			return
+		}
+		n := &Node{Type: ListNode, Span: r.span(e)}
+		switch e.attr["rend"] + " " + e.attr["type"] {
+		case "numbered ", "ordered ", " ordered", " numbered", " gloss":
+			n.setAttr("list-type", "ordered")
+		default:
+			if strings.Contains(e.attr["rend"], "numbered") || strings.Contains(e.attr["type"], "ordered") {
+				n.setAttr("list-type", "ordered")
+			} else {
+				n.setAttr("list-type", "unordered")
+			}
+		}
+		for _, k := range e.kids {
+			if item, ok := k.(*teiElem); ok && item.name == "item" {
+				li := &Node{Type: ListItemNode, Span: r.span(item)}
+				r.item(item.kids, li)
+				n.AppendChild(li)
+			}
+		}
+		parent.AppendChild(n)
+	case "quote":
+		q := &Node{Type: QuoteNode, Span: r.span(e)}
+		r.blocks(e.kids, q)
+		parent.AppendChild(q)
+	case "table":
+		parent.AppendChild(r.table(e))
+	case "figure":
+		parent.AppendChild(r.figure(e))
+	case "formula":
+		parent.AppendChild(r.formula(e))
+	}
+}
+
+// div reads a div, as a section if it has a head, and else as what it
+// holds, with the kinds of divs WriteTEI writes for statements and the
+// like.
+func (r *teiReader) div(e *teiElem, parent *Node) {
+	kids := e.kids
+	var head *teiElem
+	for i, k := range kids {
+		if ke, ok := k.(*teiElem); ok {
+			if ke.name == "head" {
+				head = ke
+				kids = append(append([]interface{}{}, kids[:i]...), kids[i+1:]...)
+			}
+			break
+		}
+	}
+
+	var n *Node
+	switch {
+	case e.attr["type"] == "statement":
+		n = &Node{Type: StatementNode}
+		if id := e.attr["xml:id"]; id != "" {
+			n.setAttr("id", id)
+		}
+		if t := e.attr["subtype"]; t != "" {
+			n.setAttr("type", t)
+		}
+		if head != nil {
+			n.setAttr("text", head.text())
+		}
+	case e.attr["type"] == "proof":
+		n = &Node{Type: ProofNode}
+	case e.attr["type"] == "subequations":
+		n = &Node{Type: SubequationsNode}
+	case e.attr["rend"] == "center":
+		n = &Node{Type: CenterAlignNode}
+	case e.attr["rend"] == "right":
+		n = &Node{Type: RightAlignNode}
+	}
+	if n != nil {
+		n.Span = r.span(e)
+		r.blocks(kids, n)
+		parent.AppendChild(n)
+		return
+	}
+
+	if head == nil {
+		r.blocks(kids, parent)
+		return
+	}
+	s := &Node{Type: SectionNode, Span: r.span(head)}
+	r.depth++
+	r.section(s)
+	if e.attr["rend"] == "unnumbered" {
+		s.setAttr("section-numbered", "false")
+	}
+	r.inlineInto(head.kids, s)
+	parent.AppendChild(s)
+	r.blocks(kids, parent)
+	r.depth--
+}
+
+// section sets the level of a section, by the sections it is in.
+func (r *teiReader) section(n *Node) {
+	level := r.depth
+	if level < 1 {
+		level = 1
+	}
+	if level > 3 {
+		level = 3
+	}
+	n.setAttr("section-level", strconv.Itoa(level))
+	n.setAttr("section-numbered", "true")
+}
+
+// paragraph reads the content of a <p> into p, which it adds to parent,
+// along with the blocks TEI allows in paragraphs, such as lists.
+func (r *teiReader) paragraph(kids []interface{}, p, parent *Node) {
+	var inline []interface{}
+	flush := func() {
+		for _, n := range r.runs(inline) {
+			p.AppendChild(n)
+		}
+		inline = nil
+	}
+	for _, k := range kids {
+		e, ok := k.(*teiElem)
+		if ok && (e.name == "list" || e.name == "table" || e.name == "lg" || e.name == "quote" && teiHasBlocks(e)) {
+			flush()
+			if p.FirstChild != nil {
+				parent.AppendChild(p)
+				p = &Node{Type: ParagraphNode}
+			}
+			r.block(e, parent)
+			continue
+		}
+		inline = append(inline, k)
+	}
+	flush()
+	if p.FirstChild != nil {
+		parent.AppendChild(p)
+	}
+}
+
+// teiHasBlocks reports whether e holds paragraphs or the like.
+func teiHasBlocks(e *teiElem) bool {
+	for _, k := range e.kids {
+		if ke, ok := k.(*teiElem); ok && (ke.name == "p" || ke.name == "lg" || ke.name == "l" || ke.name == "ab") {
+			return true
+		}
+	}
+	return false
+}
+
+// runs reads kids as runs: an <s> is one, and the sentences of the
+// text and elements between them are others.
+func (r *teiReader) runs(kids []interface{}) []*Node {
+	var runs []*Node
+	var inline []interface{}
+	flush := func() {
+		if !teiBlank(inline) {
+			runs = append(runs, splitSentences(r.inline(inline))...)
+		}
+		inline = nil
+	}
+	for _, k := range kids {
+		if c, ok := k.(*teiXMLComment); ok && teiBlank(inline) {
+			// a comment between sentences
+			flush()
+			runs = append(runs, &Node{Type: CommentNode, Data: c.s, Span: r.src.span(c.start, c.end)})
+			continue
+		}
+		e, ok := k.(*teiElem)
+		switch {
+		case ok && e.name == "s":
+			flush()
+			run := &Node{Type: RunNode, Span: r.span(e)}
+			r.inlineInto(e.kids, run)
+			runs = append(runs, run)
+		case ok && (e.name == "p" || e.name == "ab" || e.name == "l"):
+			flush()
+			runs = append(runs, r.runs(e.kids)...)
+		case ok && e.name == "formula" && e.attr["rend"] == "display" && teiBlank(inline):
+			// display math between sentences
+			flush()
+			runs = append(runs, r.formula(e))
+		default:
+			inline = append(inline, k)
+		}
+	}
+	flush()
+	return runs
+}
+
+// item reads the content of a list item into li: the tokens before the
+// first <s> or block are li's own, as lit has them.
+func (r *teiReader) item(kids []interface{}, li *Node) {
+	i := 0
+	for ; i < len(kids); i++ {
+		if e, ok := kids[i].(*teiElem); ok && (e.name == "s" || e.name == "p" || e.name == "list" || e.name == "ab") {
+			break
+		}
+	}
+	r.inlineInto(kids[:i], li)
+	var inline []interface{}
+	flush := func() {
+		for _, n := range r.runs(inline) {
+			li.AppendChild(n)
+		}
+		inline = nil
+	}
+	for _, k := range kids[i:] {
+		if e, ok := k.(*teiElem); ok && (e.name == "list" || e.name == "table" || e.name == "quote" && teiHasBlocks(e)) {
+			flush()
+			r.block(e, li)
+			continue
+		}
+		inline = append(inline, k)
+	}
+	flush()
+}
+
+func (r *teiReader) table(e *teiElem) *Node {
+	t := &Node{Type: TableNode, Span: r.span(e)}
+	if rend := e.attr["rend"]; rend != "" && strings.Trim(rend, "lcr") == "" {
+		t.setAttr("align", rend)
+	}
+	var head, body *Node
+	for _, k := range e.kids {
+		row, ok := k.(*teiElem)
+		if !ok || row.name != "row" {
+			continue
+		}
+		tr := &Node{Type: TableRowNode, Span: r.span(row)}
+		for _, kk := range row.kids {
+			cell, ok := kk.(*teiElem)
+			if !ok || cell.name != "cell" {
+				continue
+			}
+			typ := TDNode
+			if row.attr["role"] == "label" || cell.attr["role"] == "label" {
+				typ = THNode
+			}
+			c := &Node{Type: typ, Span: r.span(cell)}
+			run := &Node{Type: RunNode, Span: c.Span}
+			r.inlineInto(cell.kids, run)
+			if run.FirstChild != nil {
+				c.AppendChild(run)
+			}
+			tr.AppendChild(c)
+		}
+		if row.attr["role"] == "label" && body == nil {
+			if head == nil {
+				head = &Node{Type: TableHeadNode}
+				t.AppendChild(head)
+			}
+			head.AppendChild(tr)
+			continue
+		}
+		if body == nil {
+			body = &Node{Type: TableBodyNode}
+			t.AppendChild(body)
+		}
+		body.AppendChild(tr)
+	}
+	return t
+}
+
+func (r *teiReader) graphic(e *teiElem) *Node {
+	n := &Node{Type: ImageNode, Span: r.span(e)}
+	n.setAttr("src", e.attr["url"])
+	if width := e.attr["width"]; width != "" {
+		n.setAttr("width", width)
+	}
+	return n
+}
+
+// figure reads a figure, of its graphics and tables, with its head
+// as the caption.
+func (r *teiReader) figure(e *teiElem) *Node {
+	n := &Node{Type: FigureNode, Span: r.span(e)}
+	if id := e.attr["xml:id"]; id != "" {
+		n.setAttr("id", id)
+	}
+	if placement := e.attr["place"]; placement != "" {
+		n.setAttr("placement", placement)
+	}
+	for _, k := range e.kids {
+		g, ok := k.(*teiElem)
+		if !ok {
+			continue
+		}
+		switch g.name {
+		case "graphic":
+			n.AppendChild(r.graphic(g))
+		case "table":
+			n.AppendChild(r.table(g))
+		case "head":
+			c := &Node{Type: CaptionNode, Span: r.span(g)}
+			for _, run := range r.runs(g.kids) {
+				c.AppendChild(run)
+			}
+			n.AppendChild(c)
+		}
+	}
+	return n
+}
+
+// formula reads display math, as an equation if it has an id.
+func (r *teiReader) formula(e *teiElem) *Node {
+	n := &Node{Type: DisplayMathNode, Span: r.span(e)}
+	if id := e.attr["xml:id"]; id != "" {
+		n.Type = EquationNode
+		n.setAttr("id", id)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(teiRawText(e)), "\n") {
+		run := &Node{Type: RunNode, Span: n.Span}
+		r.text(untexMath(strings.TrimSpace(line)), n.Span, run)
+		if run.FirstChild != nil {
+			n.AppendChild(run)
+		}
+	}
+	return n
+}
+
+// lit reads the LitTex of an <ab type="lit"> or <seg type="lit">.
+func (r *teiReader) lit(e *teiElem, parent *Node) {
+	n, ds := ParseLit(teiRawText(e))
+	for _, d := range ds {
+		d.Span = r.span(e)
+		r.ds = append(r.ds, d)
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		parent.AppendChild(c)
+		c = next
+	}
+}
+
+// teiRawText returns the text in e, as it is.
+func teiRawText(e *teiElem) string {
+	var b strings.Builder
+	for _, k := range e.kids {
+		switch k := k.(type) {
+		case *teiElem:
+			b.WriteString(teiRawText(k))
+		case *teiChars:
+			b.WriteString(k.s)
+		}
+	}
+	return b.String()
+}
+
+func (r *teiReader) text(s string, span Span, parent *Node) {
+	ts, ds := lex(s, nil, nil)
+	r.ds = append(r.ds, ds...)
+	for _, t := range ts {
+		t.Span = span
+		parent.AppendChild(&Node{Type: TokenNode, Token: t, Span: span})
+	}
+}
+
+func (r *teiReader) symbol(s string, parent *Node) {
+	parent.AppendChild(&Node{Type: TokenNode, Token: &Token{Type: SymbolToken, Value: s}})
+}
+
+// inlineInto adds the tokens and nodes of kids to parent.
+func (r *teiReader) inlineInto(kids []interface{}, parent *Node) {
+	for _, n := range r.inline(kids) {
+		parent.AppendChild(n)
+	}
+}
+
+// inline reads kids as tokens and inline nodes.
+func (r *teiReader) inline(kids []interface{}) []*Node {
+	b := &teiInline{r: r}
+	b.kids(kids)
+	return b.done()
+}
+
+// teiInline gathers the text of inline TEI, with the glyphs of the
+// formatting its elements make, to lex it between the nodes.
+type teiInline struct {
+	r        *teiReader
+	t        text
+	nodes    []*Node
+	afterTag bool // whether the last node is a link or an image
+}
+
+func (b *teiInline) glyph(g string, offset int) {
+	b.t.add(g, offset)
+}
+
+// flush lexes the text so far. The spaces next to links and images are
+// made explicit, since lit drops those next to tags.
+func (b *teiInline) flush(beforeTag bool) {
+	if len(b.t.b) == 0 {
+		return
+	}
+	s := string(b.t.b)
+	m := append(b.t.m, b.t.m[len(b.t.m)-1]+1)
+	ts, ds := lex(s, b.r.src, m)
+	b.r.ds = append(b.r.ds, ds...)
+	if b.afterTag && len(ts) > 0 && isSpaceByte(s[0]) {
+		b.nodes = append(b.nodes, mdSpace(ts[0].Span))
+	}
+	for _, t := range ts {
+		b.nodes = append(b.nodes, &Node{Type: TokenNode, Token: t, Span: t.Span})
+	}
+	if beforeTag && len(ts) > 0 && isSpaceByte(s[len(s)-1]) {
+		b.nodes = append(b.nodes, mdSpace(Span{}))
+	}
+	b.t = text{}
+}
+
+func (b *teiInline) node(n *Node) {
+	isTag := n.Type == LinkNode || n.Type == ImageNode
+	if n.Type == FootnoteNode {
+		// the space before a footnote's mark is not wanted
+		for len(b.t.b) > 0 && isSpaceByte(b.t.b[len(b.t.b)-1]) {
+			b.t.b, b.t.m = b.t.b[:len(b.t.b)-1], b.t.m[:len(b.t.m)-1]
+		}
+	}
+	b.flush(isTag)
+	b.nodes = append(b.nodes, n)
+	b.afterTag = isTag
+}
+
+func (b *teiInline) done() []*Node {
+	b.flush(false)
+	nodes := b.nodes
+	// an explicit space needs a token on each side
+	for len(nodes) > 0 && nodes[len(nodes)-1].Type == TokenNode && isSpace(nodes[len(nodes)-1].Token) {
+		nodes = nodes[:len(nodes)-1]
+	}
+	return nodes
+}
+
+// teiRends are the glyphs of the rends of <hi>.
+var teiRends = map[string][2]string{
+	"italic": {"‹", "›"}, "italics": {"‹", "›"}, "i": {"‹", "›"}, "ital": {"‹", "›"},
+	"bold": {"«", "»"}, "b": {"«", "»"},
+	"smallcaps": {"⸤", "⸥"}, "sc": {"⸤", "⸥"}, "small-caps": {"⸤", "⸥"},
+	"monospace": {"⁅", "⁆"}, "code": {"⁅", "⁆"},
+}
+
+// teiTransparent are the elements read as their text, without a warning.
+var teiTransparent = map[string]bool{
+	"name": true, "persName": true, "placeName": true, "orgName": true, "geogName": true,
+	"rs": true, "date": true, "time": true, "num": true, "measure": true, "add": true,
+	"unclear": true, "supplied": true, "corr": true, "reg": true, "expan": true, "choice": true,
+	"seg": true, "w": true, "c": true, "pc": true, "phr": true, "cl": true, "s": true,
+	"lang": true, "span": true, "label": true, "item": true, "bibl": true, "cit": true,
+	"app": true, "lem": true,
+}
+
+func (b *teiInline) kids(kids []interface{}) {
+	for _, k := range kids {
+		switch k := k.(type) {
+		case *teiChars:
+			b.t.b = append(b.t.b, k.s...)
+			b.t.m = append(b.t.m, k.m[:len(k.s)]...)
+		case *teiXMLComment:
+			// comments in text are dropped, as lit has none
+		case *teiElem:
+			b.elem(k)
+		}
+	}
+}
+
+func (b *teiInline) elem(e *teiElem) {
+	r := b.r
+	if teiDropped[e.name] {
+		return
+	}
+	wrap := func(open, close string) {
+		b.glyph(open, e.start)
+		b.kids(e.kids)
+		b.glyph(close, e.end-1)
+	}
+	switch e.name {
+	case "hi":
+		g, ok := teiRends[strings.Fields(e.attr["rend"] + " italic")[0]]
+		if !ok {
+			b.kids(e.kids) // e.g., superscript, which lit has no glyph for
+			return
+		}
+		wrap(g[0], g[1])
+	case "emph", "foreign", "title", "mentioned":
+		wrap("‹", "›")
+	case "term", "gloss":
+		wrap("❬", "❭")
+	case "q", "quote", "said", "soCalled":
+		wrap("“", "”")
+	case "lb":
+		b.glyph("᜶", e.start)
+	case "note":
+		n := &Node{Type: FootnoteNode, Span: r.span(e)}
+		if teiHasBlocks(e) || e.find("s") != e && e.find("s") != nil {
+			for _, run := range r.runs(e.kids) {
+				n.AppendChild(run)
+			}
+		} else if nodes := r.inline(e.kids); len(nodes) > 0 {
+			for _, run := range splitSentences(nodes) {
+				n.AppendChild(run)
+			}
+		}
+		b.node(n)
+	case "ref", "ptr":
+		n := &Node{Type: LinkNode, Span: r.span(e)}
+		n.setAttr("href", e.attr["target"])
+		run := &Node{Type: RunNode, Span: n.Span}
+		if e.name == "ptr" {
+			r.text(e.attr["target"], n.Span, run)
+		} else {
+			r.inlineInto(e.kids, run)
+		}
+		n.AppendChild(run)
+		b.node(n)
+	case "graphic":
+		b.node(r.graphic(e))
+	case "figure":
+		for _, k := range e.kids {
+			if g, ok := k.(*teiElem); ok && g.name == "graphic" {
+				b.node(r.graphic(g))
+			}
+		}
+	case "formula":
+		if e.attr["rend"] == "display" {
+			b.node(r.formula(e))
+			return
+		}
+		b.glyph("$", e.start)
+		tex := teiRawText(e)
+		b.t.add(untexMath(strings.TrimSpace(tex)), e.start)
+		b.glyph("$", e.end-1)
+	case "seg":
+		switch e.attr["type"] {
+		case "tex":
+			// lexed with the text, for the spaces around it
+			b.glyph(string(OpaqueOpenRune), e.start)
+			b.kids(e.kids)
+			b.glyph(string(OpaqueCloseRune), e.end-1)
+		case "lit":
+			var holder Node
+			r.lit(e, &holder)
+			for c := holder.FirstChild; c != nil; {
+				next := c.NextSibling
+				holder.RemoveChild(c)
+				b.node(c)
+				c = next
+			}
+		default:
+			b.kids(e.kids)
+		}
+	default:
+		if !teiTransparent[e.name] {
+			r.warn(e, "TEI element <%s> is not supported; read as its text", e.name)
+		}
+		b.kids(e.kids)
+	}
+}
@@ -0,0 +1,173 @@
+package lit_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nlandolfi/lit"
+)
+
+func TestTEIRoundTrip(t *testing.T) {
+	for _, in := range []string{
+		"¶ ⦊\n  ‖ A ‹pack› of «wolves». ⦉\n\n  ‖ A set $x ∈ A$. ⦉\n⦉",
+		"#§ Sets ⦉\n#§§ Classes ⦉\n§ Unnumbered ⦉",
+		"⁝ ⦊\n  ‣ one ⦉\n\n  ‣ two\n    𝍫 ⦊\n      ‣ three ⦉\n    ⦉⦉\n⦉",
+		"‣ A slide\n  ⁝ ⦊\n    ‣ a point ⦉\n  ⦉⦉",
+		"¶ ⦊\n  ‖ we write ⦉\n  ◇ ⦊\n    ‖ A ⊂ B. ⦉\n  ⦉\n⦉",
+		"¶ ⦊\n  ‖ later.\n    † ⦊\n      ‖ See the end. ⦉\n    ⦉⦉\n⦉",
+		"¶ ⦊\n  ‖ One two\n    † ⦊\n      ‖ A note. ⦉\n    ⦉\n    three four. ⦉\n⦉",
+		"¶ ⦊\n  ‖ by␣\n    <a href='https://example.com'>\n      ‖ us ⦉\n    </a>\n    . ⦉\n⦉",
+		"¶ ⦊\n  ‖ If ❲$x$❳ belongs to ❲A❳, ⦉\n⦉",
+		"<statement id='ext' type='axiom' text='Extension'>\n  ¶ ⦊\n    ‖ Equal. ⦉\n  ⦉\n</statement>\n<proof>\n  ¶ ⦊\n    ‖ Trivial. ⦉\n  ⦉\n</proof>",
+		"<figure id='fig:venn' placement='htbp'>\n  <img src='venn.png' width='50%'/>\n  <figcaption>\n    ‖ The sets $A$ and $B$. ⦉\n  </figcaption>\n</figure>",
+		"▦ lc ⦊\n  ═ ‖ Set ⦉ ‖ Size ⦉ ⦉\n  ─ ‖ $A ∪ B$ ⦉ ‖ 3 ⦉ ⦉\n⦉",
+	} {
+		n, ds := lit.ParseLit(in)
+		if err := ds.Err(); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		var x bytes.Buffer
+		if err := lit.WriteTEI(&x, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		m, ds := lit.ParseTEI(x.String())
+		if len(ds) > 0 {
+			t.Fatalf("%q: %v", in, ds)
+		}
+		if !lit.Equal(n, m) {
+			var got bytes.Buffer
+			lit.WriteLit(&got, m, lit.DefaultWriteOpts)
+			t.Errorf("%q: got %q after a round trip through TEI:\n%s", in, got.String(), x.String())
+		}
+	}
+}
+
+func TestWriteTEI(t *testing.T) {
+	n := lit.Must(lit.ParseLit(`<yaml>
+title: Naive Set Theory
+author: Paul R. Halmos
+lang: en
+</yaml>
+#§ Sets ⦉
+¶ ⦊
+  ‖ A ‹pack›
+    † ⦊
+      ‖ See the end. ⦉
+    ⦉
+    of wolves. ⦉
+⦉`))
+	var b bytes.Buffer
+	if err := lit.WriteTEI(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<TEI xmlns="http://www.tei-c.org/ns/1.0">`,
+		"<title>Naive Set Theory</title>",
+		"<author>Paul R. Halmos</author>",
+		`<language ident="en"/>`,
+		`<div type="section">`,
+		"<head>Sets</head>",
+		`<s>A <hi rend="italic">pack</hi><note place="foot"><s>See the end.</s></note> of wolves.</s>`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("TEI does not have %s:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestWriteTEITopLevelMath(t *testing.T) {
+	// each block after the math is written once
+	n := lit.Must(lit.ParseLit("◇ ⦊\n  ‖ x ⦉\n⦉\n¶ ⦊\n  ‖ After. ⦉\n⦉\n◇ ⦊\n  ‖ y ⦉\n⦉\n¶ ⦊\n  ‖ End. ⦉\n⦉"))
+	var b bytes.Buffer
+	if err := lit.WriteTEI(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for want, count := range map[string]int{
+		"<formula":      2,
+		"<s>After.</s>": 1,
+		"<s>End.</s>":   1,
+	} {
+		if got := strings.Count(b.String(), want); got != count {
+			t.Errorf("TEI has %s %d times, want %d:\n%s", want, got, count, b.String())
+		}
+	}
+}
+
+// a Perseus-style TEI source, with sections by milestones, pages
+// and references to the printed edition.
+const perseusTEI = `<?xml version="1.0" encoding="UTF-8"?>
+<TEI xmlns="http://www.tei-c.org/ns/1.0">
+<teiHeader>
+<fileDesc>
+<titleStmt><title>Nicomachean Ethics</title><author>Aristotle</author><editor>H. Rackham</editor></titleStmt>
+<publicationStmt><publisher>Perseus</publisher><idno type="filename">aristot.nic.eth_eng.xml</idno></publicationStmt>
+<sourceDesc><p>Aristotle in 23 Volumes.</p></sourceDesc>
+</fileDesc>
+<profileDesc><langUsage><language ident="en">English</language></langUsage></profileDesc>
+</teiHeader>
+<text><body>
+<div type="textpart" subtype="book" n="1">
+<head>Book I</head>
+<div type="textpart" subtype="chapter" n="1">
+<milestone unit="section" n="1"/>
+<p>Every art seems to aim at some good: hence the Good is <q>That at which all things aim.</q><note anchored="true">Probably Eudoxus; see <bibl>X. ii. 1</bibl>.</note> <pb n="5"/>But the ends are numerous. The word <foreign xml:lang="grc">τέλος</foreign> means <unclear>end</unclear>.</p>
+<lg><l>Sing, goddess,</l><l>the wrath</l></lg>
+<p>An <distinct>odd</distinct> element.</p>
+</div>
+</div>
+</body></text>
+</TEI>`
+
+func TestParseTEI(t *testing.T) {
+	n, ds := lit.ParseTEI(perseusTEI)
+	if len(ds) != 1 || ds[0].Code != lit.CodeBadTEI || ds[0].Severity != lit.SeverityWarning || !strings.Contains(ds[0].Message, "<distinct>") {
+		t.Errorf("got diagnostics %v, want a warning about <distinct>", ds)
+	}
+	var b bytes.Buffer
+	if err := lit.WriteLit(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	want := `<yaml>
+author: Aristotle
+identifier: aristot.nic.eth_eng.xml
+lang: en
+title: Nicomachean Ethics
+</yaml>
+
+#§ Book I ⦉
+¶ ⦊
+  ‖ Every art seems to aim at some good: hence the Good is
+    “That at which all things aim.”
+    † ⦊
+      ‖ Probably Eudoxus; see X. ii. 1. ⦉
+    ⦉⦉
+
+  ‖ But the ends are numerous. ⦉
+
+  ‖ The word ‹τέλος› means end. ⦉
+⦉
+
+¶ ⦊
+  ‖ Sing, goddess, ⦉
+
+  ‖ the wrath ⦉
+⦉
+
+¶ ⦊
+  ‖ An odd element. ⦉
+⦉`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestParseTEIBadXML(t *testing.T) {
+	n, ds := lit.ParseTEI("<TEI><text></TEI>")
+	if !ds.HasErrors() || ds[0].Code != lit.CodeBadTEI {
+		t.Errorf("got diagnostics %v, want a bad-tei error", ds)
+	}
+	if n == nil || n.FirstChild != nil {
+		t.Errorf("got %v, want an empty fragment", n)
+	}
+}
@@ -10,7 +10,33 @@ func Tex(t *Token, inMath bool) string {
 	case WordToken:
 		var out = ""
 
+		// consecutive super or subscripts are written as one
+		var script string
+		var sup bool
+		endScript := func() {
+			if script != "" {
+				out += texScript(script, sup, inMath)
+				script = ""
+			}
+		}
+
 		for _, r := range t.Value {
+			if s, ok := superscripts[r]; ok {
+				if !sup {
+					endScript()
+				}
+				script, sup = script+s, true
+				continue
+			}
+			if s, ok := subscripts[r]; ok {
+				if sup {
+					endScript()
+				}
+				script, sup = script+s, false
+				continue
+			}
+			endScript()
+
 			// TODO avoid dictionary lookup if not in math
 			if replacement, ok := LatexMathReplacements[r]; inMath && ok {
 				out += replacement + " " // I think we need the space here.
@@ -18,6 +44,7 @@ func Tex(t *Token, inMath bool) string {
 				out += string(r)
 			}
 		}
+		endScript()
 
 		return out
 	case PunctuationToken:
@@ -75,11 +102,11 @@ func Tex(t *Token, inMath bool) string {
 		case '⸥':
 			return "}"
 		case '⅛':
-			return "$\\nicefrace{1}{8}$"
+			return "$\\nicefrac{1}{8}$"
 		case '½':
-			return "$\\nicefrace{1}{2}$"
+			return "$\\nicefrac{1}{2}$"
 		case '¼':
-			return "$\\nicefrace{1}{4}$"
+			return "$\\nicefrac{1}{4}$"
 		case '_':
 			if !inMath {
 				return "\\_"
@@ -127,6 +154,42 @@ func Tex(t *Token, inMath bool) string {
 	return t.Value
 }
 
+func texScript(s string, sup, inMath bool) string {
+	switch {
+	case sup && inMath:
+		return "^{" + s + "}"
+	case inMath:
+		return "_{" + s + "}"
+	case sup:
+		return "\\textsuperscript{" + s + "}"
+	default:
+		return "\\textsubscript{" + s + "}"
+	}
+}
+
+func isScript(r rune) bool {
+	_, sup := superscripts[r]
+	_, sub := subscripts[r]
+	return sup || sub
+}
+
+// superscripts and subscripts are lexed as part of words,
+// so that, e.g., f⁻¹ is one token, written f^{-1} in math.
+var superscripts = map[rune]string{
+	'⁰': "0", '¹': "1", '²': "2", '³': "3", '⁴': "4",
+	'⁵': "5", '⁶': "6", '⁷': "7", '⁸': "8", '⁹': "9",
+	'⁺': "+", '⁻': "-", 'ⁱ': "i", 'ⁿ': "n",
+}
+
+var subscripts = map[rune]string{
+	'₀': "0", '₁': "1", '₂': "2", '₃': "3", '₄': "4",
+	'₅': "5", '₆': "6", '₇': "7", '₈': "8", '₉': "9",
+	'₊': "+", '₋': "-", 'ₐ': "a", 'ₑ': "e", 'ₒ': "o",
+	'ₓ': "x", 'ₕ': "h", 'ᵢ': "i", 'ⱼ': "j", 'ₖ': "k",
+	'ₗ': "l", 'ₘ': "m", 'ₙ': "n", 'ₚ': "p", 'ₛ': "s",
+	'ₜ': "t",
+}
+
 // TODO: clean up
 var LatexMathReplacements = map[rune]string{
 	'→': "\\to",
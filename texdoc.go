@@ -0,0 +1,245 @@
+package lit
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WriteTexDocument writes the tree rooted at n as a standalone LaTeX
+// document, which compiles with plain pdflatex: the body WriteTex writes,
+// with a preamble loading the packages and defining the macros it uses.
+//
+// The document's metadata sets the \title, \author and \date, and may
+// set the documentclass, further packages, and TeX for the preamble:
+//
+//	<yaml>
+//	title: Naive Set Theory
+//	author: Paul R. Halmos
+//	documentclass: "[11pt]{article}"
+//	packages: ["[a5paper]{geometry}"]
+//	preamble: \newcommand{\CP}{\mathcal{P}}
+//	</yaml>
+//
+// If the document cites anything, it loads natbib, in the
+// citation-style, for its \citep and \bibliography.
+func WriteTexDocument(w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteTexDocument", n)
+	w = ew
+
+	var body bytes.Buffer
+	if err := WriteTex(&body, n, NoPrefix(opts)); err != nil {
+		return err
+	}
+	m := readMetadata(n)
+	used := texCommands(body.String())
+
+	class := m.DocumentClass
+	if class == "" {
+		class = "article"
+	}
+	w.Write([]byte("\\documentclass" + texArgument(class) + "\n"))
+
+	for _, p := range texPackages {
+		if p.always || used[p.command] {
+			w.Write([]byte("\\usepackage{" + p.name + "}\n"))
+		}
+	}
+	if used["bibliography"] || used["citep"] {
+		if m.CitationStyle == citeNumeric {
+			w.Write([]byte("\\usepackage[numbers]{natbib}\n"))
+		} else {
+			w.Write([]byte("\\usepackage[round]{natbib}\n"))
+		}
+	}
+	if lang, ok := babelLanguages[strings.ToLower(m.Language)]; ok {
+		w.Write([]byte("\\usepackage[" + lang + "]{babel}\n"))
+	}
+	for _, p := range m.Packages {
+		w.Write([]byte("\\usepackage" + texArgument(p) + "\n"))
+	}
+	if used["href"] {
+		// last, as hyperref redefines what the others do
+		w.Write([]byte("\\usepackage{hyperref}\n"))
+	}
+
+	for _, mac := range texMacros {
+		for _, c := range mac.commands {
+			if used[c] {
+				w.Write([]byte(mac.def + "\n"))
+				break
+			}
+		}
+	}
+	envs, names := statementTypes(n)
+	for _, env := range envs {
+		w.Write([]byte("\\newtheorem{" + env + "}{" + names[env] + "}\n"))
+	}
+	if m.Preamble != "" {
+		w.Write([]byte(strings.TrimRight(m.Preamble, "\n") + "\n"))
+	}
+
+	if m.Title != "" {
+		w.Write([]byte("\n\\title{" + texEscaper.Replace(m.Title) + "}\n"))
+		var authors []string
+		for _, a := range m.Authors {
+			authors = append(authors, texEscaper.Replace(a))
+		}
+		w.Write([]byte("\\author{" + strings.Join(authors, " \\and ") + "}\n"))
+		w.Write([]byte("\\date{" + texEscaper.Replace(m.Date) + "}\n"))
+	}
+
+	w.Write([]byte("\n\\begin{document}\n"))
+	if m.Title != "" {
+		w.Write([]byte("\\maketitle\n"))
+	}
+	w.Write([]byte("\n"))
+	w.Write(bytes.TrimLeft(body.Bytes(), "\n"))
+	if !bytes.HasSuffix(body.Bytes(), []byte("\n")) {
+		w.Write([]byte("\n"))
+	}
+	w.Write([]byte("\n\\end{document}\n"))
+	return nil
+}
+
+// texArgument returns a documentclass or package, e.g. "article",
+// as the argument of its command, as it is if it has options.
+func texArgument(s string) string {
+	if strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") {
+		return s
+	}
+	return "{" + s + "}"
+}
+
+// texEscaper escapes the characters special to TeX in text.
+var texEscaper = strings.NewReplacer(
+	"\\", "\\textbackslash{}",
+	"&", "\\&", "%", "\\%", "$", "\\$", "#", "\\#", "_", "\\_",
+	"{", "\\{", "}", "\\}",
+	"~", "\\textasciitilde{}", "^", "\\textasciicircum{}",
+	"“", "``", "”", "''", "‘", "`", "’", "'", "–", "--", "—", "---",
+)
+
+// texPackages are the packages WriteTexDocument loads, if the body
+// uses their command, in the order it loads them.
+var texPackages = []struct {
+	name, command string
+	always        bool
+}{
+	{name: "amsmath", always: true},
+	{name: "amssymb", always: true},
+	{name: "amsthm", command: "begin{proof}"},
+	{name: "mathtools", command: "coloneqq"},
+	{name: "graphicx", command: "includegraphics"},
+	{name: "booktabs", command: "toprule"},
+	{name: "nicefrac", command: "nicefrac"},
+}
+
+// texMacros define the macros of LatexMathReplacements and the like
+// that LaTeX and the texPackages do not, each if the body uses one of
+// its commands.
+var texMacros = []struct {
+	commands []string
+	def      string
+}{
+	{[]string{"mathbfsf", "R", "Q", "N", "Z", "C", "F", "E"}, `\providecommand{\mathbfsf}[1]{\text{\sffamily\bfseries #1}}`},
+	{[]string{"R"}, `\providecommand{\R}{\mathbfsf{R}}`},
+	{[]string{"Q"}, `\providecommand{\Q}{\mathbfsf{Q}}`},
+	{[]string{"N"}, `\providecommand{\N}{\mathbfsf{N}}`},
+	{[]string{"Z"}, `\providecommand{\Z}{\mathbfsf{Z}}`},
+	{[]string{"C"}, `\providecommand{\C}{\mathbfsf{C}}`},
+	{[]string{"F"}, `\providecommand{\F}{\mathbfsf{F}}`},
+	{[]string{"E"}, `\providecommand{\E}{\mathbfsf{E}}`},
+	{[]string{"goesto"}, `\providecommand{\goesto}{\longrightarrow}`},
+	{[]string{"symdiff"}, `\providecommand{\symdiff}{\mathbin{\triangle}}`},
+	{[]string{"omicron"}, `\providecommand{\omicron}{o}`},
+	{[]string{"sheetref"}, `\providecommand{\sheetref}[2]{#2}`},
+}
+
+// babelLanguages are the babel names of languages, by code.
+var babelLanguages = map[string]string{
+	"en": "english", "en-us": "american", "en-gb": "british",
+	"de": "ngerman", "fr": "french", "es": "spanish", "it": "italian",
+	"pt": "portuguese", "nl": "dutch", "la": "latin", "el": "greek",
+	"grc": "polutonikogreek", "da": "danish", "sv": "swedish",
+}
+
+// texCommands returns the names of the commands in s, such as "R" for
+// \R, and "begin{proof}" for \begin{proof}.
+func texCommands(s string) map[string]bool {
+	used := make(map[string]bool)
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		j := i + 1
+		for j < len(s) && ('a' <= s[j] && s[j] <= 'z' || 'A' <= s[j] && s[j] <= 'Z') {
+			j++
+		}
+		if j == i+1 {
+			i++ // e.g., \\ or \{
+			continue
+		}
+		name := s[i+1 : j]
+		if name == "begin" && strings.HasPrefix(s[j:], "{") {
+			if k := strings.IndexByte(s[j:], '}'); k > 0 {
+				name += s[j : j+k+1]
+			}
+		}
+		used[name] = true
+		i = j - 1
+	}
+	return used
+}
+
+// statementTypes returns the environments of the statements in the
+// tree rooted at n, e.g. "theorem", which need a \newtheorem, sorted,
+// along with the name each is typeset with, as of its first statement.
+func statementTypes(n *Node) (envs []string, names map[string]string) {
+	names = make(map[string]string)
+	Walk(n, func(c *Node) WalkAction {
+		if c.Type != StatementNode {
+			return WalkContinue
+		}
+		t := getAttr(c.Attr, "type")
+		if t == "" {
+			t = "statement"
+		}
+		env := texStatementEnv(t)
+		if _, ok := names[env]; !ok && !texEnvironments[env] {
+			r, size := utf8.DecodeRuneInString(t)
+			names[env] = texEscaper.Replace(string(unicode.ToUpper(r)) + t[size:])
+			envs = append(envs, env)
+		}
+		return WalkContinue
+	})
+	sort.Strings(envs)
+	return envs, names
+}
+
+// texStatementEnv returns the environment of a statement of type t:
+// its letters, as others can't be in the name of one, such as the -
+// of main-lemma, or "statement" if it has none.
+func texStatementEnv(t string) string {
+	var b strings.Builder
+	for _, r := range t {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "statement"
+	}
+	return b.String()
+}
+
+// texEnvironments are the environments LaTeX defines, which a
+// statement could be but needs no \newtheorem for.
+var texEnvironments = map[string]bool{
+	"quote": true, "quotation": true, "center": true, "proof": true, "verse": true,
+	"abstract": true, "itemize": true, "enumerate": true, "description": true,
+}
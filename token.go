@@ -3,6 +3,7 @@ package lit
 import (
 	"fmt"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -42,18 +43,52 @@ type Token struct {
 	Type     TokenType
 	Value    string
 	Implicit bool
+	Span     Span // Where in the source the token came from.
 }
 
 func (t *Token) String() string {
-	// return fmt.Sprintf("%s(%q)%d:%d", t.Type, t.Value, t.StartLine, t.StartChar)
+	if t.Span.IsValid() {
+		return fmt.Sprintf("%s(%q)%s", t.Type, Val(t, false), t.Span.Start)
+	}
 	return fmt.Sprintf("%s(%q)", t.Type, Val(t, false))
 }
 
+// Lex splits s into tokens.
+//
+// The spans of the tokens are relative to s.
+// Runes that can't be lexed are skipped; if there are any,
+// the error is a Diagnostics listing them.
 func Lex(s string) (tokens []*Token, err error) {
+	tokens, ds := lex(s, newSource(s), nil)
+	return tokens, ds.Err()
+}
+
+// lex is Lex, but for an s that was rewritten from src;
+// m maps the offsets of s back into src. If src is nil,
+// the tokens have no spans.
+func lex(s string, src *source, m offsetMap) (tokens []*Token, ds Diagnostics) {
 	var opaque bool
 
+	span := func(i, j int) Span {
+		if src == nil {
+			return Span{}
+		}
+		return src.span(m.start(i), m.end(src, j))
+	}
+	extend := func(t *Token, j int) {
+		if src == nil {
+			return
+		}
+		t.Span.End = src.pos(m.end(src, j))
+	}
+
 	for i, r := range s {
+		size := utf8.RuneLen(r)
+		if r == utf8.RuneError {
+			size = 1
+		}
 		if opaque {
+			extend(tokens[len(tokens)-1], i+size)
 			if r == OpaqueCloseRune {
 				opaque = false
 				continue
@@ -67,9 +102,10 @@ func Lex(s string) (tokens []*Token, err error) {
 			tokens = append(tokens, &Token{
 				Type:  OpaqueToken,
 				Value: "",
+				Span:  span(i, i+size),
 			})
 			opaque = true
-		case r == ' ':
+		case r == ' ' || r == '\t': // e.g., the indent of a wrapped line
 			if len(tokens) == 0 {
 				continue
 			}
@@ -86,14 +122,28 @@ func Lex(s string) (tokens []*Token, err error) {
 					Type:     SymbolToken,
 					Value:    "␣",
 					Implicit: true,
+					Span:     span(i, i+size),
 				})
 			}
-		case r == '\n' || r == '\r' || r == '\t':
+		case r == '\n' || r == '\r':
 			continue
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || isScript(r):
+			if len(tokens) == 0 || tokens[len(tokens)-1].Type != WordToken { // start a new word
+				tokens = append(tokens, &Token{
+					Type:  WordToken,
+					Value: string(r),
+					Span:  span(i, i+size),
+				})
+				continue
+			}
+			// continue that word
+			tokens[len(tokens)-1].Value += string(r)
+			extend(tokens[len(tokens)-1], i+size)
 		case unicode.IsSymbol(r):
 			tokens = append(tokens, &Token{
 				Type:  SymbolToken,
 				Value: string(r),
+				Span:  span(i, i+size),
 			})
 		case unicode.IsPunct(r):
 			// TODO should we detect that if the previous token was a word
@@ -101,20 +151,10 @@ func Lex(s string) (tokens []*Token, err error) {
 			tokens = append(tokens, &Token{
 				Type:  PunctuationToken,
 				Value: string(r),
+				Span:  span(i, i+size),
 			})
-		case unicode.IsLetter(r) || unicode.IsNumber(r):
-			if len(tokens) == 0 || tokens[len(tokens)-1].Type != WordToken { // start a new word
-				tokens = append(tokens, &Token{
-					Type:  WordToken,
-					Value: string(r),
-				})
-				continue
-			}
-			// continue that word
-			tokens[len(tokens)-1].Value += string(r)
 		default:
-			err = fmt.Errorf("unrecognized rune %q", r)
-			return
+			ds.add(SeverityError, span(i, i+size), CodeUnrecognizedRune, "unrecognized rune %q", r)
 		}
 	}
 
@@ -0,0 +1,26 @@
+// The LitTex extension: it launches lit lsp, the language server of
+// the lit command, over stdio.
+const vscode = require("vscode");
+const { LanguageClient } = require("vscode-languageclient/node");
+
+let client;
+
+function activate(context) {
+  const config = vscode.workspace.getConfiguration("littex");
+  const command = config.get("path") || "lit";
+  const server = { command: command, args: ["lsp"] };
+  client = new LanguageClient(
+    "littex",
+    "LitTex",
+    { run: server, debug: server },
+    { documentSelector: [{ scheme: "file", language: "lit" }, { scheme: "untitled", language: "lit" }] }
+  );
+  context.subscriptions.push(client);
+  return client.start();
+}
+
+function deactivate() {
+  return client ? client.stop() : undefined;
+}
+
+module.exports = { activate, deactivate };
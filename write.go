@@ -3,21 +3,38 @@ package lit
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"log"
+	"math"
 	"path"
 	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
 )
 
 type WriteOpts struct {
-	Prefix, Indent string
+	Prefix, Indent string // Indent may be spaces or a tab
 	InMath         bool
+
+	// Width is the width, in runes, to wrap the lines of runs to.
+	// If it is 0, WriteLit, WriteMarkdown and WriteHTML wrap to 74
+	// and WriteTex doesn't wrap; if it is negative, none wrap.
+	Width int
+
+	// SemanticLineBreaks is whether to never wrap, so that each
+	// run, which is to say each sentence, is a line of its own.
+	SemanticLineBreaks bool
+
+	// Bibliography is the entries the citations cite, as
+	// ReadBibliography reads them. If it is nil, WriteHTML writes
+	// the keys of the citations, and no reference list.
+	Bibliography Bibliography
 }
 
 var DefaultWriteOpts = &WriteOpts{
@@ -26,28 +43,120 @@ var DefaultWriteOpts = &WriteOpts{
 }
 
 func InMath(o *WriteOpts) *WriteOpts {
-	var out WriteOpts = *o
+	out := *o
 	out.InMath = true
 	return &out
 }
 
 func Indented(o *WriteOpts) *WriteOpts {
-	return &WriteOpts{
-		Prefix: o.Prefix + o.Indent,
-		Indent: o.Indent,
-		InMath: o.InMath,
-	}
+	return withPrefix(o, o.Prefix+o.Indent)
 }
 
 func NoPrefix(o *WriteOpts) *WriteOpts {
-	return &WriteOpts{
-		Indent: o.Indent,
-		InMath: o.InMath,
+	return withPrefix(o, "")
+}
+
+func withPrefix(o *WriteOpts, prefix string) *WriteOpts {
+	out := *o
+	out.Prefix = prefix
+	return &out
+}
+
+// noWrap is the width of lines that are never wrapped.
+const noWrap = math.MaxInt32
+
+// width returns the width to wrap lines to, by default maxWidth.
+func (o *WriteOpts) width() int {
+	switch {
+	case o.SemanticLineBreaks || o.Width < 0:
+		return noWrap
+	case o.Width == 0:
+		return maxWidth
+	}
+	return o.Width
+}
+
+// ErrUnsupportedNode is the Err of a WriteError for a node
+// of a type the writer doesn't know how to write.
+var ErrUnsupportedNode = errors.New("unsupported node type")
+
+var errLinkInMath = errors.New("link in math")
+
+var errDollarInMath = errors.New("$ in math")
+
+// WriteError is the error the writers return.
+//
+// It records the node that was being written when the error occurred,
+// whether the node itself couldn't be written or the io.Writer failed.
+type WriteError struct {
+	Op   string   // The writer, e.g., "WriteTex".
+	Type NodeType // The type of the node.
+	Span Span     // The node's source span; may be invalid if unknown.
+	Err  error
+}
+
+func (e *WriteError) Error() string {
+	if !e.Span.IsValid() {
+		return fmt.Sprintf("%s: %s node: %v", e.Op, e.Type, e.Err)
+	}
+	return fmt.Sprintf("%s: %s node at %s: %v", e.Op, e.Type, e.Span.Start, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// errWriter remembers the first error of the io.Writer it wraps,
+// and drops all writes after it, so the writers needn't check each
+// write, only the error at the end of each node.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+// newErrWriter wraps w, unless it is already an errWriter.
+func newErrWriter(w io.Writer) *errWriter {
+	if ew, ok := w.(*errWriter); ok {
+		return ew
 	}
+	return &errWriter{w: w}
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.err = err
+	return n, err
+}
+
+// wrap sets *err to the error, if any, of writing node n,
+// as a *WriteError; errors from n's descendants are kept as is.
+func (ew *errWriter) wrap(err *error, op string, n *Node) {
+	if *err == nil {
+		*err = ew.err
+	}
+	if *err == nil {
+		return
+	}
+	if _, ok := (*err).(*WriteError); ok {
+		return
+	}
+	*err = &WriteError{Op: op, Type: n.Type, Span: n.Span, Err: *err}
+}
+
+func (ew *errWriter) wrapped(op string, n *Node) (err error) {
+	ew.wrap(&err, op, n)
+	return err
 }
 
 // WriteDebug prints the node tree in a pretty format.
-func WriteDebug(w io.Writer, n *Node, opts *WriteOpts) {
+func WriteDebug(w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteDebug", n)
+	w = ew
+
 	fmt.Fprintf(w, opts.Prefix+"%s", n.Type)
 	switch n.Type {
 	case TokenNode:
@@ -63,16 +172,23 @@ func WriteDebug(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 		fmt.Fprintf(w, ")")
 	}
+	if n.Type != TokenNode && n.Span.IsValid() {
+		fmt.Fprintf(w, " %s", n.Span)
+	}
 	fmt.Fprintf(w, "\n")
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		WriteDebug(w, c, &WriteOpts{
-			Prefix: opts.Prefix + opts.Indent,
-			Indent: opts.Indent,
-		})
+		if err := WriteDebug(w, c, Indented(opts)); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
+func WriteLit(w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteLit", n)
+	w = ew
+
 	switch n.Type {
 	case FragmentNode:
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
@@ -119,14 +235,15 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		if _, err := w.Write([]byte("\n" + opts.Prefix + "⦉")); err != nil {
 			return err
 		}
-	// TODO: return errors from all below... 2/25/23; finish someday
 	case FootnoteNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
 		}
 		w.Write([]byte(opts.Prefix + "† ⦊\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "⦉"))
 	case DisplayMathNode:
@@ -135,7 +252,9 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		}
 		w.Write([]byte(opts.Prefix + "◇ ⦊\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "⦉"))
 	case RunNode, ListItemNode, SectionNode:
@@ -188,27 +307,47 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			//log.Printf("RUN NODE: %s", c.Type)
 			switch c.Type {
 			case TokenNode:
-				if c.PrevSibling != nil {
+				inline := c.PrevSibling != nil && isInlineRef(c.PrevSibling)
+				if c.PrevSibling != nil && !inline {
 					w.Write([]byte("\n"))
 				}
 
 				// in case its a token, go a find all tokens to next non-token
 				block, lastTokenNode := tokenBlockStartingAt(c)
-				allowedWidth := maxWidth - offset
-				lines := lineBlocks(block, Val, opts, false, allowedWidth)
+				allowedWidth := opts.width() - offset
+				lines, err := lineBlocks(block, Val, opts, false, allowedWidth)
+				if err != nil {
+					return err
+				}
 				if len(lines) > 0 {
-					writeLines(w, lines, opts.Prefix+opts.Indent, afterFirstLine)
+					if inline && isImplicitSpace(c) {
+						w.Write([]byte(" "))
+					}
+					writeLines(w, lines, opts.Prefix+opts.Indent, afterFirstLine && !inline)
 					afterFirstLine = true
 				}
 
 				c = lastTokenNode.NextSibling
+			case RefNode, CiteNode:
+				// in the text, so that the spaces around it are kept
+				switch p := c.PrevSibling; {
+				case p == nil || isInlineRef(p):
+				case p.Type != TokenNode:
+					w.Write([]byte("\n" + opts.Prefix + opts.Indent))
+				case isImplicitSpace(p):
+					w.Write([]byte(" "))
+				}
+				w.Write([]byte(litRef(c)))
+				c = c.NextSibling
 			default:
-				WriteLit(w, c, Indented(opts))
+				if err := WriteLit(w, c, Indented(opts)); err != nil {
+					return err
+				}
 				c = c.NextSibling
 			}
 		}
 
-		if n.LastChild != nil && n.LastChild.Type == TokenNode {
+		if n.LastChild != nil && (n.LastChild.Type == TokenNode || isInlineRef(n.LastChild)) {
 			w.Write([]byte(" "))
 		}
 		// will need to do overflow check
@@ -226,13 +365,16 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			w.Write([]byte("\n\n"))
 		}
 		w.Write([]byte(opts.Prefix + "<!--" + n.Data + "-->"))
-	case TexOnlyNode, RightAlignNode, CenterAlignNode, TableNode, TableHeadNode, TableBodyNode, TableRowNode, THNode, TDNode, SubequationsNode, QuoteNode, DivNode, CodeNode:
+	case TexOnlyNode, RightAlignNode, CenterAlignNode, TableNode, TableHeadNode, TableBodyNode, TableRowNode, THNode, TDNode, SubequationsNode, QuoteNode, DivNode, CodeNode, CaptionNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
 		}
 		if n.PrevSibling != nil && (n.PrevSibling.Type == ParagraphNode || n.PrevSibling.Type == ListNode) {
 			w.Write([]byte("\n"))
 		}
+		if n.Type == TableNode && isLitTable(n) {
+			return writeLitTable(w, n, opts)
+		}
 		var dataatom string
 		switch n.Type {
 		case RightAlignNode:
@@ -261,6 +403,8 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			dataatom = "div"
 		case CodeNode:
 			dataatom = "code"
+		case CaptionNode:
+			dataatom = "figcaption"
 		default:
 			panic("not reached")
 		}
@@ -276,7 +420,9 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			w.Write([]byte("\n"))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		if n.FirstChild != nil {
 			w.Write([]byte("\n" + opts.Prefix))
@@ -295,7 +441,9 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		}
 		w.Write([]byte(">\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "</equation>"))
 	case ImageNode:
@@ -306,6 +454,9 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			w.Write([]byte("\n"))
 		}
 		w.Write([]byte(opts.Prefix + fmt.Sprintf("<img src='%s'", getAttr(n.Attr, "src"))))
+		if alt := getAttr(n.Attr, "alt"); alt != "" {
+			w.Write([]byte(fmt.Sprintf(" alt='%s'", html.EscapeString(alt))))
+		}
 		if width := getAttr(n.Attr, "width"); width != "" {
 			w.Write([]byte(fmt.Sprintf(" width='%s'", width)))
 		}
@@ -329,9 +480,32 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		}
 		w.Write([]byte(">\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "</statement>"))
+	case FigureNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		if n.PrevSibling != nil && (n.PrevSibling.Type == ParagraphNode || n.PrevSibling.Type == ListNode) {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(opts.Prefix + "<figure"))
+		if id := getAttr(n.Attr, "id"); id != "" {
+			w.Write([]byte(" " + "id='" + id + "'"))
+		}
+		if placement := getAttr(n.Attr, "placement"); placement != "" {
+			w.Write([]byte(" " + "placement='" + placement + "'"))
+		}
+		w.Write([]byte(">\n"))
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
+		}
+		w.Write([]byte("\n" + opts.Prefix + "</figure>"))
 	case ProofNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
@@ -341,7 +515,9 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		}
 		w.Write([]byte(opts.Prefix + "<proof>\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "</proof>"))
 	case LinkNode:
@@ -350,7 +526,9 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		}
 		w.Write([]byte(opts.Prefix + fmt.Sprintf("<a href='%s'>\n", getAttr(n.Attr, "href"))))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "</a>"))
 	case OpaqueNode:
@@ -373,12 +551,27 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			w.Write([]byte("\n"))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(w, c, Indented(opts))
+			if err := WriteLit(w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		if n.FirstChild != nil {
 			w.Write([]byte("\n" + opts.Prefix))
 		}
 		w.Write([]byte("</" + dataatom + ">"))
+	case IncludeNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		if n.PrevSibling != nil && (n.PrevSibling.Type == ParagraphNode || n.PrevSibling.Type == ListNode) {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(opts.Prefix + fmt.Sprintf("<include src='%s'/>", getAttr(n.Attr, "src"))))
+	case RefNode, CiteNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(opts.Prefix + litRef(n)))
 	case JSONNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
@@ -399,14 +592,14 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			e := json.NewEncoder(w)
 			e.SetIndent(opts.Prefix, opts.Indent)
 			if err := e.Encode(n.JSON); err != nil {
-				log.Fatal(err) // TODO- 1/25/23
+				return err
 			}
 			w.Write([]byte(opts.Prefix))
 		}
 		if n.IsComment {
-			w.Write([]byte("</json>"))
-		} else {
 			w.Write([]byte("-->"))
+		} else {
+			w.Write([]byte("</json>"))
 		}
 	case YAMLNode:
 		if n.PrevSibling != nil {
@@ -427,7 +620,7 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 		if n.YAML != nil {
 			e := yaml.NewEncoder(w)
 			if err := e.Encode(n.YAML); err != nil {
-				log.Fatal(err) // TODO- 1/25/23
+				return err
 			}
 		}
 		if n.IsComment {
@@ -436,26 +629,65 @@ func WriteLit(w io.Writer, n *Node, opts *WriteOpts) error {
 			w.Write([]byte("</yaml>"))
 		}
 	default:
-		return fmt.Errorf(`WriteLit:
-		prev: %v; cur: %v; next: %v", )
-		unhandled node type: %s`, n.PrevSibling, n, n.NextSibling, n.Type)
+		return ErrUnsupportedNode
 	}
 	return nil
 }
 
-func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
+// litRef returns the tag of the ref or cite n.
+func litRef(n *Node) string {
+	if n.Type == RefNode {
+		return fmt.Sprintf("<ref to='%s'/>", getAttr(n.Attr, "to"))
+	}
+	tag := fmt.Sprintf("<cite key='%s'", getAttr(n.Attr, "key"))
+	if loc := getAttr(n.Attr, "loc"); loc != "" {
+		tag += fmt.Sprintf(" loc='%s'", loc)
+	}
+	return tag + "/>"
+}
+
+// WriteTex writes the tree rooted at n as LaTeX, for the body of a
+// document. It cites with natbib's \citep, parenthetical as the HTML
+// writers cite, so a preamble of one's own must load natbib, as that
+// of WriteTexDocument does.
+func WriteTex(w io.Writer, n *Node, opts *WriteOpts) error {
+	return writeTex(new(texWriteState), w, n, opts)
+}
+
+// texWriteState is the state of a call of WriteTex.
+type texWriteState struct {
+	labelCache
+}
+
+// write is writeTex, as a nodeWriter.
+func (s *texWriteState) write(w io.Writer, n *Node, opts *WriteOpts) error {
+	return writeTex(s, w, n, opts)
+}
+
+func writeTex(s *texWriteState, w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteTex", n)
+	w = ew
+
 	switch n.Type {
 	case OpaqueNode:
 		w.Write([]byte("% there is an opaque node here\n"))
 	case FragmentNode:
-		writeKids(w, n, opts, WriteTex)
+		if err := writeKids(w, n, opts, s.write); err != nil {
+			return err
+		}
+		if n.Parent == nil {
+			w.Write([]byte(texBibliography(n, s.labelsOf(n))))
+		}
 	case ParagraphNode:
 		// second check here is for text.lit files, to avoid
 		// a new line at beginning in their text.tex files
 		if n.PrevSibling != nil && n.PrevSibling.Type != YAMLNode {
 			w.Write([]byte("\n"))
 		}
-		writeKids(w, n, Indented(opts), WriteTex)
+		if err := writeKids(w, n, Indented(opts), s.write); err != nil {
+			return err
+		}
 		w.Write([]byte("\n"))
 	case ListNode:
 		if n.PrevSibling != nil {
@@ -468,7 +700,9 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		default: // unordered
 			w.Write([]byte(opts.Prefix + "\\begin{itemize}\n"))
 		}
-		writeKids(w, n, Indented(opts), WriteTex)
+		if err := writeKids(w, n, Indented(opts), s.write); err != nil {
+			return err
+		}
 		switch lt {
 		case "ordered":
 			w.Write([]byte("\n" + opts.Prefix + "\\end{enumerate}"))
@@ -477,14 +711,18 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 	case FootnoteNode:
 		w.Write([]byte("\\footnote{"))
-		writeKids(w, n, opts, WriteTex)
+		if err := writeKids(w, n, opts, s.write); err != nil {
+			return err
+		}
 		w.Write([]byte("}"))
 	case DisplayMathNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
 		}
 		w.Write([]byte("\\[\n"))
-		writeKids(w, n, InMath(Indented(opts)), WriteTex)
+		if err := writeKids(w, n, InMath(Indented(opts)), s.write); err != nil {
+			return err
+		}
 		w.Write([]byte("\n" + "\\]"))
 	case RunNode, ListItemNode, SectionNode:
 		if n.PrevSibling != nil && n.PrevSibling.Type != LinkNode {
@@ -520,31 +758,41 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 			//log.Printf("RUN NODE: %s", c.Type)
 			switch c.Type {
 			case TokenNode:
-				if c.PrevSibling != nil && c.PrevSibling.Type != RunNode && c.PrevSibling.Type != LinkNode {
+				if c.PrevSibling != nil && c.PrevSibling.Type != RunNode && c.PrevSibling.Type != LinkNode && c.PrevSibling.Type != RefNode && c.PrevSibling.Type != CiteNode {
 					w.Write([]byte("\n"))
 				}
 
 				// in case its a token, go a find all tokens to next non-token
 				block, lastTokenNode := tokenBlockStartingAt(c)
-				allowedWidth := maxWidth - offset
-				lines := lineBlocks(block, Tex, opts, true, allowedWidth)
+				allowedWidth := opts.width() - offset
+				lines, err := lineBlocks(block, Tex, opts, true, allowedWidth)
+				if err != nil {
+					return err
+				}
 				if len(lines) > 0 {
-					//					writeLines(w, lines, prefix+indent, afterFirstLine)
-					w.Write([]byte(strings.Join(lines, " ")))
-					if afterFirstLine {
-						afterFirstLine = true
+					if opts.Width > 0 {
+						writeLines(w, lines, "", afterFirstLine)
+					} else {
+						// by default, a run is a line
+						w.Write([]byte(strings.Join(lines, " ")))
 					}
 					afterFirstLine = true
 				}
 
 				c = lastTokenNode.NextSibling
 			default:
-				WriteTex(w, c, Indented(opts))
+				if err := writeTex(s, w, c, Indented(opts)); err != nil {
+					return err
+				}
 				c = c.NextSibling
 			}
 		}
 		if n.Type == SectionNode {
-			w.Write([]byte("}\n"))
+			w.Write([]byte("}"))
+			if ls := s.labelsOf(n); ls.refd[ls.nodes[n].ID] {
+				w.Write([]byte("\\label{" + ls.nodes[n].ID + "}"))
+			}
+			w.Write([]byte("\n"))
 		}
 	case CommentNode:
 		for _, line := range strings.Split(n.Data, "\n") {
@@ -559,18 +807,28 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 			w.Write([]byte("\n"))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 	case DivNode:
 		w.Write([]byte("{"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("}"))
 	case CodeNode:
+		if text, ok := verbatimCode(n); ok {
+			w.Write([]byte("\n\\begin{verbatim}\n" + text + "\n\\end{verbatim}\n"))
+			break
+		}
 		w.Write([]byte("\\texttt{"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("}"))
 	case CenterAlignNode:
@@ -579,7 +837,9 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 		w.Write([]byte("\\begin{center}"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\\end{center}"))
 	case RightAlignNode:
@@ -588,7 +848,9 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 		w.Write([]byte("\\begin{flushright}"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\\end{flushright}"))
 	case QuoteNode:
@@ -597,7 +859,9 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 		w.Write([]byte("\\begin{quote}"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\\end{quote}"))
 	case EquationNode:
@@ -609,7 +873,9 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 			w.Write([]byte("\\label{" + id + "}\n"))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, InMath(Indented(opts)))
+			if err := writeTex(s, w, c, InMath(Indented(opts))); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n\\end{equation}"))
 	case SubequationsNode:
@@ -618,7 +884,9 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 		w.Write([]byte("\\begin{subequations}"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, InMath(opts))
+			if err := writeTex(s, w, c, InMath(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\\end{subequations}"))
 	case ImageNode:
@@ -648,7 +916,7 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 		}
 		t := "statement"
 		if tt := getAttr(n.Attr, "type"); tt != "" {
-			t = tt
+			t = texStatementEnv(tt)
 		}
 		w.Write([]byte(fmt.Sprintf("\\begin{%s}", t)))
 		if text := getAttr(n.Attr, "text"); text != "" {
@@ -659,18 +927,77 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 			w.Write([]byte("\n" + opts.Prefix + "\\label{" + id + "}"))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte(fmt.Sprintf("\n\\end{%s}\n", t)))
+	case FigureNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte("\\begin{figure}"))
+		if placement := getAttr(n.Attr, "placement"); placement != "" {
+			w.Write([]byte("[" + placement + "]"))
+		}
+		w.Write([]byte("\n\\centering\n"))
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
+		}
+		if id := getAttr(n.Attr, "id"); id != "" {
+			// after the caption, which sets the number it labels
+			w.Write([]byte("\n\\label{" + id + "}"))
+		}
+		w.Write([]byte("\n\\end{figure}\n"))
+	case CaptionNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		var b bytes.Buffer
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := writeTex(s, &b, c, NoPrefix(opts)); err != nil {
+				return err
+			}
+		}
+		w.Write([]byte("\\caption{" + strings.TrimSpace(b.String()) + "}"))
 	case ProofNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
 		}
 		w.Write([]byte("\\begin{proof}"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\\end{proof}"))
+	case RefNode:
+		if refSpaceBefore(n) {
+			w.Write([]byte("~"))
+		}
+		cmd := "ref"
+		if l := s.labelsOf(n).ids[getAttr(n.Attr, "to")]; l.Kind == "equation" {
+			cmd = "eqref"
+		}
+		w.Write([]byte(fmt.Sprintf("\\%s{%s}", cmd, getAttr(n.Attr, "to"))))
+		if refSpaceAfter(n) {
+			w.Write([]byte(" "))
+		}
+	case CiteNode:
+		if refSpaceBefore(n) {
+			w.Write([]byte("~"))
+		}
+		// parenthetical, as the HTML writers cite, in either style of natbib
+		w.Write([]byte("\\citep"))
+		if loc := getAttr(n.Attr, "loc"); loc != "" {
+			w.Write([]byte("[" + texEscaper.Replace(loc) + "]"))
+		}
+		w.Write([]byte("{" + strings.Join(citeKeys(n), ",") + "}"))
+		if refSpaceAfter(n) {
+			w.Write([]byte(" "))
+		}
 	case LinkNode:
 		href := getAttr(n.Attr, "href")
 		if strings.HasPrefix(href, "/sheets/") {
@@ -681,57 +1008,96 @@ func WriteTex(w io.Writer, n *Node, opts *WriteOpts) {
 			w.Write([]byte(fmt.Sprintf(" \\href{%s}{", href)))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("}"))
 	case TableNode:
-		//		w.Write([]byte(fmt.Sprintf("\\begin{table}\n")))
-		// TODO: one day write table...
-		w.Write([]byte(fmt.Sprintf("\n\\vspace{0.3cm}\n\\begin{tabular}{%s}\n", getAttr(n.Attr, "tex"))))
-		// TODO write the alignments at some point
-
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+		// the rules are those of booktabs, which WriteTexDocument loads
+		spec, err := texTableSpec(n)
+		if err != nil {
+			return err
 		}
-		w.Write([]byte("\\end{tabular}\n\\vspace{0.1cm}\n"))
-		//		w.Write([]byte(fmt.Sprintf("\\end{table}\n")))
-	case TableHeadNode:
-		// TODO write some rules \toprule, \bottomrule, etc
+		w.Write([]byte("\n\\begin{tabular}{" + spec + "}\n\\toprule\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
+		}
+		w.Write([]byte("\\bottomrule\n\\end{tabular}\n"))
+	case TableHeadNode, TableBodyNode:
+		if p := n.PrevSibling; p != nil && (p.Type == TableHeadNode || p.Type == TableBodyNode) {
+			w.Write([]byte("\\midrule\n"))
 		}
-	case TableBodyNode:
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 	case TableRowNode:
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
-		w.Write([]byte("\\\\\n"))
+		w.Write([]byte(" \\\\\n"))
 	case THNode, TDNode:
 		if n.PrevSibling != nil && (n.PrevSibling.Type == THNode || n.PrevSibling.Type == TDNode) {
 			w.Write([]byte(" & "))
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteTex(w, c, opts)
+			if err := writeTex(s, w, c, opts); err != nil {
+				return err
+			}
 		}
 	case JSONNode, YAMLNode:
 		// TODO?? - 1/25/23
 	default:
-		log.Fatalf("unhandled node type: %s", n.Type)
+		return ErrUnsupportedNode
 	}
+	return nil
+}
+
+// verbatimCode returns the text of the code block n, if it is verbatim:
+// an opaque token in a run, as the readers of Markdown, pandoc and TEI
+// make code blocks.
+func verbatimCode(n *Node) (string, bool) {
+	if r := n.FirstChild; r != nil && r == n.LastChild && r.Type == RunNode {
+		if t := r.FirstChild; t != nil && t == r.LastChild && t.Type == TokenNode && t.Token.Type == OpaqueToken {
+			return t.Token.Value, true
+		}
+	}
+	return "", false
 }
 
-type nodeWriter func(w io.Writer, n *Node, opts *WriteOpts)
+// codeText returns the text of the code block n: its verbatim text, if
+// it is verbatim, or else its children, as LitTex.
+func codeText(n *Node, opts *WriteOpts) (string, error) {
+	if text, ok := verbatimCode(n); ok {
+		return text, nil
+	}
+	var b bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := WriteLit(&b, c, opts); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+type nodeWriter func(w io.Writer, n *Node, opts *WriteOpts) error
 
 func writeKids(
 	w io.Writer, n *Node, opts *WriteOpts,
 	write nodeWriter,
-) {
+) error {
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		write(w, c, opts)
+		if err := write(w, c, opts); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 const maxWidth int = 74
@@ -748,7 +1114,7 @@ func Val(t *Token, inMath bool) string {
 		out = " "
 	}
 	switch out {
-	case "¶", "‖", "†", "◇", "⁝", "𝍫", "‣", "§", "⦉":
+	case "¶", "‖", "†", "◇", "⁝", "𝍫", "‣", "§", "▦", "⦉":
 		out = "\\" + out
 		/*
 			case "<", ">":
@@ -802,7 +1168,7 @@ func HTMLVal(t *Token, inMath bool) string {
 	}
 
 	switch t.Value {
-	case "¶", "‖", "◇", "†", "⁝", "‣", "𝍫", "§", "⦉":
+	case "¶", "‖", "◇", "†", "⁝", "‣", "𝍫", "§", "▦", "⦉":
 		return t.Value
 		/*
 			case "<", ">":
@@ -819,7 +1185,10 @@ func isSpace(t *Token) bool {
 
 type tokenStringer func(t *Token, inMath bool) string
 
-func lineBlocks(ts []*Token, v tokenStringer, opts *WriteOpts, shouldEscapeInMath bool, width int) []string {
+// lineBlocks breaks the tokens ts into lines of at most width runes.
+// If shouldEscapeInMath, the tokens in math are written as math, and it
+// is an error for a $ to be in math already, as in display math.
+func lineBlocks(ts []*Token, v tokenStringer, opts *WriteOpts, shouldEscapeInMath bool, width int) ([]string, error) {
 	var pieces = []string{""}
 	var spaces []*Token
 	var inMath bool = opts.InMath
@@ -831,8 +1200,8 @@ func lineBlocks(ts []*Token, v tokenStringer, opts *WriteOpts, shouldEscapeInMat
 			pieces[len(pieces)-1] = pieces[len(pieces)-1] + v(t, inMath && shouldEscapeInMath)
 		}
 		if t.Type == SymbolToken && t.Value == "$" {
-			if opts.InMath {
-				panic("$ in math")
+			if opts.InMath && shouldEscapeInMath {
+				return nil, errDollarInMath
 			}
 			inMath = !inMath
 		}
@@ -881,7 +1250,7 @@ func lineBlocks(ts []*Token, v tokenStringer, opts *WriteOpts, shouldEscapeInMat
 		o = append(o, line)
 	}
 
-	return o
+	return o, nil
 }
 
 func writeLines(w io.Writer, lines []string, prefix string, prefixFirst bool) {
@@ -911,28 +1280,73 @@ func tokenBlockStartingAt(c *Node) (block []*Token, last *Node) {
 	return block, last
 }
 
-func WriteHTMLInBody(w io.Writer, n *Node, opts *WriteOpts) {
-	w.Write([]byte("<!DOCTYPE html>\n"))
-	w.Write([]byte(`<meta charset="utf-8"/>`))
-	w.Write([]byte("\n" + opts.Indent + "<body>\n"))
-	WriteHTML(w, n, Indented(Indented(opts)))
-	w.Write([]byte("\n" + opts.Indent + "</body>\n"))
-	w.Write([]byte("</html>"))
+func WriteHTMLInBody(w io.Writer, n *Node, opts *WriteOpts) error {
+	ew := newErrWriter(w)
+	ew.Write([]byte("<!DOCTYPE html>\n"))
+	ew.Write([]byte(`<meta charset="utf-8"/>`))
+	ew.Write([]byte("\n" + opts.Indent + "<body>\n"))
+	if err := WriteHTML(ew, n, Indented(Indented(opts))); err != nil {
+		return err
+	}
+	ew.Write([]byte("\n" + opts.Indent + "</body>\n"))
+	ew.Write([]byte("</html>"))
+	return ew.wrapped("WriteHTML", n)
 }
 
 func WriteHTML(w io.Writer, n *Node, opts *WriteOpts) error {
+	ew := newErrWriter(w)
+	w = ew
+
 	s := new(htmlWriteState)
 	s.headerIDsAssigned = make(map[string]bool)
-	writeHTML(HTMLVal, s, w, n, opts)
+	if err := writeHTML(HTMLVal, s, w, n, opts); err != nil {
+		return err
+	}
+	if err := writeHTMLFootnotes(w, s, opts); err != nil {
+		return err
+	}
+	writeHTMLReferences(w, s, n, opts)
+	return ew.wrapped("WriteHTML", n)
+}
 
+// spaceAfterNote reports whether a word space goes after the mark of
+// the footnote n, as the line break after it makes in TeX and HTML: if
+// text follows it, other than a space or punctuation that ends a word,
+// such as a period.
+func spaceAfterNote(n *Node) bool {
+	next := n.NextSibling
+	if next == nil {
+		return false
+	}
+	switch next.Type {
+	case TokenNode:
+		if isSpace(next.Token) {
+			return false
+		}
+		if next.Token.Type == PunctuationToken {
+			r, _ := utf8.DecodeRuneInString(next.Token.Value)
+			return unicode.In(r, unicode.Ps, unicode.Pi)
+		}
+		return true
+	case RefNode, CiteNode, LinkNode, ImageNode, TextNode:
+		return true
+	}
+	return false
+}
+
+// writeHTMLFootnotes writes the list of the footnotes s has collected.
+func writeHTMLFootnotes(w io.Writer, s *htmlWriteState, opts *WriteOpts) error {
 	if len(s.footnotes) > 0 {
 
 		fmt.Fprintf(w, "<hr style='margin-top:0.5in'>")
 		fmt.Fprintf(w, "<ol class='footnotes'>")
-		for i, f := range s.footnotes {
+		// footnotes may have footnotes, which s.footnotes grows by
+		for i := 0; i < len(s.footnotes); i++ {
 			fmt.Fprintf(w, "<li id='footnote-%d'>", i+1)
-			for c := f.FirstChild; c != nil; c = c.NextSibling {
-				writeHTML(HTMLVal, nil, w, c, Indented(opts))
+			for c := s.footnotes[i].FirstChild; c != nil; c = c.NextSibling {
+				if err := writeHTML(HTMLVal, s, w, c, Indented(opts)); err != nil {
+					return err
+				}
 			}
 			fmt.Fprintf(w, " <a href='#footnote-%d-reference'>↩︎</a>", i+1)
 			fmt.Fprintf(w, "</li>")
@@ -945,13 +1359,43 @@ func WriteHTML(w io.Writer, n *Node, opts *WriteOpts) error {
 type htmlWriteState struct {
 	footnotes         []*Node
 	headerIDsAssigned map[string]bool
+	headers           []htmlHeader // in order, for a table of contents
+	labels            *labels      // of the tree, once one is needed
+	// files are the files of the nodes, and so of their descendants,
+	// that refs link to, if the document is split, as an EPUB is;
+	// referencesFile is that of the reference list.
+	files          map[*Node]string
+	referencesFile string
 }
 
-func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts *WriteOpts) error {
+// labelsOf returns the labels of the tree n is in.
+func (s *htmlWriteState) labelsOf(n *Node) *labels {
+	if s == nil {
+		return labelsOf(n)
+	}
+	if s.labels == nil {
+		s.labels = labelsOf(n)
+	}
+	return s.labels
+}
+
+// htmlHeader is a section writeHTML has assigned an id.
+type htmlHeader struct {
+	id string
+	n  *Node
+}
+
+func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts *WriteOpts) (err error) {
+	ew := newErrWriter(w)
+	defer ew.wrap(&err, "WriteHTML", n)
+	w = ew
+
 	switch n.Type {
 	case FragmentNode:
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 	case ParagraphNode, ListNode:
 		if n.PrevSibling != nil {
@@ -974,7 +1418,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			panic("not reached")
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, Indented(opts))
+			if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		switch n.Type {
 		case ParagraphNode:
@@ -1002,7 +1448,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		}
 		w.Write([]byte(opts.Prefix + "<p>\\[\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(Tex, s, w, c, InMath(Indented(opts)))
+			if err := writeHTML(Tex, s, w, c, InMath(Indented(opts))); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("\n" + opts.Prefix + "\\]</p>"))
 	case RunNode, ListItemNode, SectionNode:
@@ -1043,14 +1491,15 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			default:
 				w.Write([]byte(opts.Prefix + "<h1"))
 			}
-			if n.FirstChild.Type == TokenNode {
-				id := n.FirstChild.headerTokenString()
-				for s.headerIDsAssigned[id] {
-					id = id + "*" // TODO: better option?
-				}
-				s.headerIDsAssigned[id] = true
+			if id := sectionID(n, s.headerIDsAssigned); id != "" {
+				s.headers = append(s.headers, htmlHeader{id, n})
 				w.Write([]byte(fmt.Sprintf(" id='%s'", html.EscapeString(id))))
 			}
+			if n.SectionNumbered() {
+				if l, ok := s.labelsOf(n).nodes[n]; ok {
+					w.Write([]byte(fmt.Sprintf(" data-number='%s'", l.Number)))
+				}
+			}
 			w.Write([]byte(">"))
 		}
 
@@ -1068,17 +1517,20 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			//log.Printf("RUN NODE: %s", c.Type)
 			switch c.Type {
 			case TokenNode:
-				if c.PrevSibling != nil && c.PrevSibling.Type != LinkNode {
+				if c.PrevSibling != nil && c.PrevSibling.Type != LinkNode && c.PrevSibling.Type != RefNode && c.PrevSibling.Type != CiteNode {
 					w.Write([]byte("\n"))
 				}
 
 				// in case its a token, go a find all tokens to next non-token
 				block, lastTokenNode := tokenBlockStartingAt(c)
-				allowedWidth := maxWidth - offset
-				lines := lineBlocks(block, val, opts, true, allowedWidth)
+				allowedWidth := opts.width() - offset
+				lines, err := lineBlocks(block, val, opts, true, allowedWidth)
+				if err != nil {
+					return err
+				}
 				if len(lines) > 0 {
 					prefix := opts.Prefix + opts.Indent
-					if c.PrevSibling != nil && c.PrevSibling.Type == LinkNode {
+					if c.PrevSibling != nil && (c.PrevSibling.Type == LinkNode || c.PrevSibling.Type == RefNode || c.PrevSibling.Type == CiteNode) {
 						prefix = ""
 					}
 					writeLines(w, lines, prefix, afterFirstLine)
@@ -1087,7 +1539,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 
 				c = lastTokenNode.NextSibling
 			default:
-				writeHTML(val, s, w, c, Indented(opts))
+				if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+					return err
+				}
 				c = c.NextSibling
 			}
 		}
@@ -1146,7 +1600,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		}
 		w.Write([]byte(">"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</div>"))
 	case PreNode:
@@ -1158,11 +1614,11 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			w.Write([]byte(fmt.Sprintf(" %s='%s'", a.Key, a.Val)))
 		}
 		w.Write([]byte("><pre>"))
-		var b bytes.Buffer
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(&b, c, opts)
+		text, err := codeText(n, opts)
+		if err != nil {
+			return err
 		}
-		w.Write([]byte(html.EscapeString(b.String())))
+		w.Write([]byte(html.EscapeString(text)))
 		w.Write([]byte("</pre></code>"))
 	case CodeNode:
 		if n.PrevSibling != nil {
@@ -1173,11 +1629,11 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			w.Write([]byte(fmt.Sprintf(" %s='%s'", a.Key, a.Val)))
 		}
 		w.Write([]byte("><pre>"))
-		var b bytes.Buffer
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			WriteLit(&b, c, opts)
+		text, err := codeText(n, opts)
+		if err != nil {
+			return err
 		}
-		w.Write([]byte(html.EscapeString(b.String())))
+		w.Write([]byte(html.EscapeString(text)))
 		w.Write([]byte("</pre></code>"))
 	case CenterAlignNode:
 		if n.PrevSibling != nil {
@@ -1186,7 +1642,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		// TODO should the br be here
 		w.Write([]byte("<div style='display: flex; flex-direction: row; justify-content: center;text-align:center'><div>"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</div></div>"))
 	case RightAlignNode:
@@ -1195,7 +1653,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		}
 		w.Write([]byte("<div style='display: flex; flex-direction: row; justify-content: right;'><div>"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</div></div>"))
 	case QuoteNode:
@@ -1206,7 +1666,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		// quote class div
 		w.Write([]byte("<blockquote>"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</blockquote>"))
 	case TableNode, TableHeadNode, TableBodyNode, TableRowNode, THNode, TDNode:
@@ -1230,25 +1692,51 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		}
 		w.Write([]byte("<" + dataatom))
 		for _, a := range n.Attr {
+			if n.Type == TableNode && (a.Key == "align" || a.Key == "tex") {
+				continue // the cells are aligned below
+			}
 			w.Write([]byte(fmt.Sprintf(" %s='%s'", a.Key, a.Val)))
 		}
+		if (n.Type == THNode || n.Type == TDNode) && getAttr(n.Attr, "style") == "" {
+			switch cellAlign(n) {
+			case 'l':
+				w.Write([]byte(" style='text-align: left'"))
+			case 'c':
+				w.Write([]byte(" style='text-align: center'"))
+			case 'r':
+				w.Write([]byte(" style='text-align: right'"))
+			}
+		}
 		w.Write([]byte(">"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</" + dataatom + ">"))
 	case EquationNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
 		}
-		w.Write([]byte(opts.Prefix + "<div style='equation'>"))
+		w.Write([]byte(opts.Prefix + "<div style='equation'"))
+		if id := getAttr(n.Attr, "id"); id != "" {
+			w.Write([]byte(fmt.Sprintf(" id='%s'", id)))
+		}
+		w.Write([]byte(">"))
 		w.Write([]byte(opts.Prefix + "\\begin{equation}"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(Tex, s, w, c, Indented(InMath(opts))) // intentionally don't increase indent
+			// intentionally don't increase indent
+			if err := writeHTML(Tex, s, w, c, Indented(InMath(opts))); err != nil {
+				return err
+			}
 		}
 		if id := getAttr(n.Attr, "id"); id != "" {
 			w.Write([]byte(opts.Prefix + opts.Indent + "\\label{" + id + "}"))
 		}
+		if l, ok := s.labelsOf(n).nodes[n]; ok {
+			// as the refs number it, which the typesetter may not
+			w.Write([]byte(opts.Prefix + opts.Indent + "\\tag{" + l.Number + "}"))
+		}
 		w.Write([]byte(opts.Prefix + "\\end{equation}"))
 		w.Write([]byte(opts.Prefix + "</div>"))
 	case ImageNode:
@@ -1259,10 +1747,46 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			w.Write([]byte("\n"))
 		}
 		w.Write([]byte(opts.Prefix + fmt.Sprintf("<img src=\"%s\"", getAttr(n.Attr, "src"))))
+		if alt := getAttr(n.Attr, "alt"); alt != "" {
+			w.Write([]byte(fmt.Sprintf(" alt=\"%s\"", html.EscapeString(alt))))
+		}
 		if width := getAttr(n.Attr, "width"); width != "" {
 			w.Write([]byte(fmt.Sprintf(" width=\"%s\"", width)))
 		}
 		w.Write([]byte("/>"))
+	case FigureNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(opts.Prefix + "<figure"))
+		if id := getAttr(n.Attr, "id"); id != "" {
+			w.Write([]byte(fmt.Sprintf(" id='%s'", id)))
+		}
+		if l, ok := s.labelsOf(n).nodes[n]; ok {
+			w.Write([]byte(fmt.Sprintf(" data-number='%s'", l.Number)))
+		}
+		w.Write([]byte(">\n"))
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+				return err
+			}
+		}
+		w.Write([]byte("\n" + opts.Prefix + "</figure>"))
+	case CaptionNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(opts.Prefix + "<figcaption>"))
+		if l, ok := s.labelsOf(n).nodes[n.Parent]; ok && n.Parent.Type == FigureNode {
+			w.Write([]byte(fmt.Sprintf("<span class='lit-figure-number'>Figure %s.</span>", l.Number)))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			w.Write([]byte("\n"))
+			if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+				return err
+			}
+		}
+		w.Write([]byte("\n" + opts.Prefix + "</figcaption>"))
 	case StatementNode:
 		if n.PrevSibling != nil {
 			w.Write([]byte("\n"))
@@ -1278,9 +1802,14 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		if id := getAttr(n.Attr, "id"); id != "" {
 			w.Write([]byte(fmt.Sprintf(" id='%s'", id)))
 		}
+		if l, ok := s.labelsOf(n).nodes[n]; ok {
+			w.Write([]byte(fmt.Sprintf(" data-number='%s'", l.Number)))
+		}
 		w.Write([]byte(">\n"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, Indented(opts))
+			if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte(opts.Prefix + "</div>"))
 	case ProofNode:
@@ -1289,17 +1818,74 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		}
 		w.Write([]byte(opts.Prefix + "<div class='proof'>"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, Indented(opts))
+			if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte(opts.Prefix + "</div>"))
+	case SubequationsNode:
+		if n.PrevSibling != nil {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte(opts.Prefix + "<div class='subequations'>"))
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := writeHTML(val, s, w, c, Indented(opts)); err != nil {
+				return err
+			}
+		}
+		w.Write([]byte(opts.Prefix + "</div>"))
+	case RefNode:
+		if refSpaceBefore(n) {
+			w.Write([]byte(" "))
+		}
+		to := getAttr(n.Attr, "to")
+		l := s.labelsOf(n).ids[to]
+		href := "#" + to
+		if s != nil {
+			for a := l.Node; a != nil; a = a.Parent {
+				if f, ok := s.files[a]; ok {
+					href = f + href
+					break
+				}
+			}
+		}
+		w.Write([]byte(fmt.Sprintf("<a class='ref' href='%s'>%s</a>", html.EscapeString(href), html.EscapeString(refText(l)))))
+		if refSpaceAfter(n) {
+			w.Write([]byte(" "))
+		}
+	case CiteNode:
+		if refSpaceBefore(n) {
+			w.Write([]byte(" "))
+		}
+		c := newCitation(n, s.labelsOf(n), opts.Bibliography)
+		var refs string
+		if s != nil {
+			refs = s.referencesFile
+		}
+		var parts []string
+		for i, k := range c.keys {
+			if opts.Bibliography[k] == nil {
+				parts = append(parts, html.EscapeString(c.texts[i]))
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("<a href='%s#cite-%s'>%s</a>", html.EscapeString(refs), html.EscapeString(k), html.EscapeString(c.texts[i])))
+		}
+		c.texts = parts
+		c.loc = html.EscapeString(c.loc)
+		w.Write([]byte("<span class='cite'>" + c.String() + "</span>"))
+		if refSpaceAfter(n) {
+			w.Write([]byte(" "))
+		}
 	case LinkNode:
 		if opts.InMath {
-			log.Fatal("can't be in a link node in math")
+			return errLinkInMath
 		}
 		w.Write([]byte(fmt.Sprintf("<a href='%s'", getAttr(n.Attr, "href"))))
 		w.Write([]byte("/>"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, NoPrefix(opts))
+			if err := writeHTML(val, s, w, c, NoPrefix(opts)); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</a>"))
 	case OpaqueNode:
@@ -1316,7 +1902,9 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		}
 		w.Write([]byte(">"))
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			writeHTML(val, s, w, c, opts)
+			if err := writeHTML(val, s, w, c, opts); err != nil {
+				return err
+			}
 		}
 		w.Write([]byte("</" + dataatom + ">"))
 	case JSONNode:
@@ -1335,7 +1923,7 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			e := json.NewEncoder(w)
 			e.SetIndent(opts.Prefix, opts.Indent)
 			if err := e.Encode(n.JSON); err != nil {
-				log.Fatal(err) // TODO- 1/25/23
+				return err
 			}
 			w.Write([]byte(opts.Prefix))
 		}
@@ -1360,7 +1948,7 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 		if n.YAML != nil {
 			e := yaml.NewEncoder(w)
 			if err := e.Encode(n.YAML); err != nil {
-				log.Fatal(err) // TODO- 1/25/23
+				return err
 			}
 			w.Write([]byte(opts.Prefix))
 		}
@@ -1370,7 +1958,7 @@ func writeHTML(val tokenStringer, s *htmlWriteState, w io.Writer, n *Node, opts
 			w.Write([]byte("</pre>"))
 		}
 	default:
-		return fmt.Errorf("unhandled node type %s, prev: %v; cur: %v; next: %v", n.Type, n.PrevSibling, n, n.NextSibling)
+		return ErrUnsupportedNode
 	}
 	return nil
 }
@@ -2,7 +2,9 @@ package lit_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"testing"
 
@@ -24,13 +26,15 @@ func TestEquationWrite(t *testing.T) {
 	`
 
 	// Parse the littex
-	n, err := lit.ParseLit(raw)
-	if err != nil {
+	n, ds := lit.ParseLit(raw)
+	if err := ds.Err(); err != nil {
 		t.Fatal(err)
 	}
 	var opts = lit.DefaultWriteOpts
 	var out bytes.Buffer
-	lit.WriteTex(&out, n, opts)
+	if err := lit.WriteTex(&out, n, opts); err != nil {
+		t.Fatal(err)
+	}
 
 	want := `\begin{equation}\label{eq:numtests}
 T_H(x) = \begin{cases}
@@ -65,13 +69,15 @@ func TestEquationWriteAfterRunAddsNewLine(t *testing.T) {
     ‖ \end{cases} ⦉
   </equation>
 ⦉ `
-	n, err := lit.ParseLit(raw)
-	if err != nil {
+	n, ds := lit.ParseLit(raw)
+	if err := ds.Err(); err != nil {
 		t.Fatal(err)
 	}
 	var opts = lit.DefaultWriteOpts
 	var out bytes.Buffer
-	lit.WriteLit(&out, n, opts)
+	if err := lit.WriteLit(&out, n, opts); err != nil {
+		t.Fatal(err)
+	}
 
 	want := `¶ ⦊
   ‖ Given the statuses $x$ and a group $H ⊂ P$, the number of
@@ -97,3 +103,314 @@ func TestEquationWriteAfterRunAddsNewLine(t *testing.T) {
 		t.Errorf("got %q, want %q", out.String(), want)
 	}
 }
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteErrors(t *testing.T) {
+	n, ds := lit.ParseLit("¶ ⦊\n  ‖ Hi. ⦉\n⦉")
+	if err := ds.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	writers := map[string]func(io.Writer, *lit.Node, *lit.WriteOpts) error{
+		"WriteLit":   lit.WriteLit,
+		"WriteTex":   lit.WriteTex,
+		"WriteHTML":  lit.WriteHTML,
+		"WriteDebug": lit.WriteDebug,
+	}
+	for name, write := range writers {
+		err := write(failWriter{}, n, lit.DefaultWriteOpts)
+		var we *lit.WriteError
+		if !errors.As(err, &we) {
+			t.Fatalf("%s: got %v, want a *WriteError", name, err)
+		}
+		if we.Op != name || we.Err.Error() != "disk full" {
+			t.Errorf("%s: got %v", name, we)
+		}
+	}
+
+	p := n.FirstChild
+	p.AppendChild(&lit.Node{Type: lit.ErrorNode})
+	err := lit.WriteTex(new(bytes.Buffer), n, lit.DefaultWriteOpts)
+	if !errors.Is(err, lit.ErrUnsupportedNode) {
+		t.Fatalf("got %v, want %v", err, lit.ErrUnsupportedNode)
+	}
+	if got, want := err.Error(), "WriteTex: error node: unsupported node type"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// a $ in display math is an error, not a panic, but for WriteLit
+	n = lit.Must(lit.ParseLit("◇ ⦊ ‖ x $ y ⦉ ⦉"))
+	writers["WriteMarkdown"] = lit.WriteMarkdown
+	for name, write := range writers {
+		err := write(new(bytes.Buffer), n, lit.DefaultWriteOpts)
+		var we *lit.WriteError
+		switch {
+		case name == "WriteLit" || name == "WriteDebug":
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		case !errors.As(err, &we) || we.Err.Error() != "$ in math":
+			t.Errorf("%s: got %v, want a *WriteError of $ in math", name, err)
+		}
+	}
+	// and in an equation
+	n = lit.Must(lit.ParseLit("<equation>\n‖ x $ y ⦉\n</equation>"))
+	if err := lit.WriteHTML(new(bytes.Buffer), n, lit.DefaultWriteOpts); err == nil {
+		t.Error("WriteHTML of $ in an equation: no error")
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{
+			in:   "#§ Sets ⦉\n§§ More ⦉",
+			want: "# Sets\n\n## More\n",
+		},
+		{
+			in:   "¶ ⦊\n  ‖ One two\n    † ⦊\n      ‖ A note. ⦉\n    ⦉\n    three four. ⦉\n⦉",
+			want: "One two[^1] three four.\n\n[^1]: A note.\n",
+		},
+		{
+			// a note of sentences is one paragraph, as a ¶ of them
+			in:   "¶ ⦊\n  ‖ One\n    † ⦊\n      ‖ A note. ⦉\n      ‖ Of two. ⦉\n      ‖ Or three. ⦉\n    ⦉\n    two. ⦉\n⦉",
+			want: "One[^1] two.\n\n[^1]: A note.\n    Of two.\n    Or three.\n",
+		},
+		{
+			in:   "⁝ ⦊\n  ‣ «one» ⦉\n  ‣ two\n    𝍫 ⦊\n      ‣ three ⦉\n    ⦉⦉\n⦉",
+			want: "- **one**\n- two\n  1. three\n",
+		},
+		{
+			// a ref of the paragraph, rather than a run
+			in:   "<statement id='ext' type='axiom'>\n  ¶ ⦊\n    ‖ Equal. ⦉\n  ⦉\n</statement>\n¶ ⦊\n  ‖ See ⦉\n  <ref to='ext'/>\n⦉",
+			want: "**Axiom.**\n\nEqual.\n\nSee\n[1](#ext)\n",
+		},
+		{
+			in:   "¶ ⦊\n  ‖ See ⦉\n  <cite key='halmos1960' loc='§3'/>\n⦉\n<cite key='halmos1960'/>",
+			want: "See\n[@halmos1960, §3]\n\n[@halmos1960]\n",
+		},
+		{
+			in:   "<quote>\n  ¶ ⦊\n    ‖ a * b ⦉\n  ⦉\n  ¶ ⦊\n    ‖ c ⦉\n  ⦉\n</quote>",
+			want: "> a \\* b\n>\n> c\n",
+		},
+		{
+			in:   "<table tex='lc'><thead><tr><th>‖ a ⦉</th><th>‖ b ⦉</th></tr></thead><tbody><tr><td>‖ x|y ⦉</td><td>‖ z ⦉</td></tr></tbody></table>",
+			want: "| a | b |\n| :-- | :-: |\n| x\\|y | z |\n",
+		},
+		{
+			in:   "¶ ⦊\n  ‖ see␣\n    <a href='https://example.com'>\n      ‖ here ⦉\n    </a>\n    ␣and␣<img src='x.png'/> ⦉\n⦉",
+			want: "see [here](https://example.com) and ![](x.png)\n",
+		},
+	}
+	for _, c := range cases {
+		n, ds := lit.ParseLit(c.in)
+		if err := ds.Err(); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := lit.WriteMarkdown(&b, n, lit.DefaultWriteOpts); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != c.want {
+			t.Errorf("WriteMarkdown(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestWriteOptsWidth(t *testing.T) {
+	n := lit.Must(lit.ParseLit("¶ ⦊\n  ‖ This is a sentence long enough to wrap at forty. ⦉\n  ‖ Short. ⦉\n⦉"))
+	var cases = []struct {
+		write func(io.Writer, *lit.Node, *lit.WriteOpts) error
+		opts  *lit.WriteOpts
+		want  string
+	}{
+		{
+			write: lit.WriteLit,
+			opts:  &lit.WriteOpts{Indent: "  ", Width: 40},
+			want:  "¶ ⦊\n  ‖ This is a sentence long enough\n    to wrap at forty. ⦉\n\n  ‖ Short. ⦉\n⦉",
+		},
+		{
+			write: lit.WriteLit,
+			opts:  &lit.WriteOpts{Indent: "\t", Width: 40},
+			want:  "¶ ⦊\n\t‖ This is a sentence long enough\n\t\tto wrap at forty. ⦉\n\n\t‖ Short. ⦉\n⦉",
+		},
+		{
+			write: lit.WriteLit,
+			opts:  &lit.WriteOpts{Indent: "  ", Width: 20, SemanticLineBreaks: true},
+			want:  "¶ ⦊\n  ‖ This is a sentence long enough to wrap at forty. ⦉\n\n  ‖ Short. ⦉\n⦉",
+		},
+		{
+			write: lit.WriteTex,
+			opts:  &lit.WriteOpts{Width: 40},
+			want:  "This is a sentence long enough to\nwrap at forty.\nShort.\n",
+		},
+	}
+	for _, c := range cases {
+		var b bytes.Buffer
+		if err := c.write(&b, n, c.opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != c.want {
+			t.Errorf("with %+v, got %q, want %q", *c.opts, got, c.want)
+		}
+		// the lit ones, which have an indent, must parse back
+		if m, ds := lit.ParseLit(b.String()); c.opts.Indent != "" && (ds.HasErrors() || !lit.Equal(n, m)) {
+			t.Errorf("with %+v, %q does not parse back", *c.opts, b.String())
+		}
+	}
+}
+
+func TestWriteTexDocument(t *testing.T) {
+	n := lit.Must(lit.ParseLit(`<yaml>
+title: Sets & Classes
+author: [Paul R. Halmos, Nick Landolfi]
+documentclass: "[11pt]{article}"
+packages: ["[a5paper]{geometry}"]
+</yaml>
+¶ ⦊
+  ‖ We have $x ∈ 𝗥$ and $A ∆ B$. ⦉
+⦉
+<statement type='axiom' text='Extension'>
+  ¶ ⦊
+    ‖ Equal. ⦉
+  ⦉
+</statement>`))
+	var b bytes.Buffer
+	if err := lit.WriteTexDocument(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	want := `\documentclass[11pt]{article}
+\usepackage{amsmath}
+\usepackage{amssymb}
+\usepackage[a5paper]{geometry}
+\providecommand{\mathbfsf}[1]{\text{\sffamily\bfseries #1}}
+\providecommand{\R}{\mathbfsf{R}}
+\providecommand{\symdiff}{\mathbin{\triangle}}
+\newtheorem{axiom}{Axiom}
+
+\title{Sets \& Classes}
+\author{Paul R. Halmos \and Nick Landolfi}
+\date{}
+
+\begin{document}
+\maketitle
+
+We have $x \in \R $ and $A \symdiff B$.
+
+\begin{axiom}[Extension]
+Equal.
+
+\end{axiom}
+
+\end{document}
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteTexDocumentStatementsAndFrontMatter(t *testing.T) {
+	n := lit.Must(lit.ParseLit(`<!-- no front matter -->
+<statement type='main-lemma'>
+  ¶ ⦊
+    ‖ Key. ⦉
+  ⦉
+</statement>
+<yaml>
+title: An Example
+</yaml>`))
+	var b bytes.Buffer
+	if err := lit.WriteTexDocument(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\\newtheorem{mainlemma}{Main-lemma}\n",
+		"\\begin{mainlemma}\n",
+		"\\end{mainlemma}\n",
+	} {
+		if !bytes.Contains(b.Bytes(), []byte(want)) {
+			t.Errorf("document does not have %q:\n%s", want, b.String())
+		}
+	}
+	if bytes.Contains(b.Bytes(), []byte("An Example")) {
+		t.Errorf("document has a YAML node of its body as metadata:\n%s", b.String())
+	}
+}
+
+func TestWriteHTMLDocument(t *testing.T) {
+	n := lit.Must(lit.ParseLit(`<yaml>
+title: Naive Set Theory
+author: Paul R. Halmos
+lang: en
+</yaml>
+#§ Sets ⦉
+¶ ⦊
+  ‖ A set $x ∈ A$. ⦉
+⦉
+#§§ Classes ⦉
+#§ Extension ⦉`))
+
+	var b bytes.Buffer
+	if err := lit.WriteHTMLDocument(&b, n, lit.DefaultWriteOpts, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<html lang=\"en\">",
+		"<title>Naive Set Theory</title>",
+		"<meta name=\"author\" content=\"Paul R. Halmos\"/>",
+		"<style>",
+		"katex.min.js",
+		"<li><a href='#Sets'>Sets</a>\n    <ol>\n      <li><a href='#Classes'>Classes</a>\n      </li>\n    </ol>\n    </li>\n    <li><a href='#Extension'>Extension</a>",
+		"<h1 id='Sets' data-number='1'>Sets</h1>",
+	} {
+		if !bytes.Contains(b.Bytes(), []byte(want)) {
+			t.Errorf("document does not have %q:\n%s", want, b.String())
+		}
+	}
+	if bytes.Contains(b.Bytes(), []byte("lit-yaml")) {
+		t.Errorf("document has the metadata in its body:\n%s", b.String())
+	}
+
+	b.Reset()
+	dopts := &lit.HTMLDocumentOpts{Stylesheet: "style.css", Math: "mathjax", MathURL: "mathjax/"}
+	if err := lit.WriteHTMLDocument(&b, n, lit.DefaultWriteOpts, dopts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<link rel="stylesheet" href="style.css"/>`,
+		`<script defer src="mathjax/tex-chtml.js"></script>`,
+	} {
+		if !bytes.Contains(b.Bytes(), []byte(want)) {
+			t.Errorf("document does not have %q:\n%s", want, b.String())
+		}
+	}
+	if bytes.Contains(b.Bytes(), []byte("<nav")) {
+		t.Errorf("document has a table of contents, though TOC is false")
+	}
+
+	if err := lit.WriteHTMLDocument(io.Discard, n, lit.DefaultWriteOpts, &lit.HTMLDocumentOpts{Math: "jsmath"}); err == nil {
+		t.Errorf("got no error for an unknown math typesetter")
+	}
+}
+
+func TestWriteHTMLNestedFootnotes(t *testing.T) {
+	n := lit.Must(lit.ParseLit("¶ ⦊\n  ‖ One\n    † ⦊\n      ‖ Two\n        † ⦊\n          ‖ Three. ⦉\n        ⦉⦉\n    ⦉⦉\n⦉"))
+	var b bytes.Buffer
+	if err := lit.WriteHTML(&b, n, lit.DefaultWriteOpts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<li id='footnote-1'>",
+		"<a href='#footnote-2' class='lit-footnote-a'>2</a>",
+		"<span class='run'>Three.</span> <a href='#footnote-2-reference'>",
+	} {
+		if !bytes.Contains(b.Bytes(), []byte(want)) {
+			t.Errorf("HTML does not have %q:\n%s", want, b.String())
+		}
+	}
+}